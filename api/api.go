@@ -17,8 +17,12 @@ const (
 	logSender             = "api"
 	activeConnectionsPath = "/api/v1/connection"
 	quotaScanPath         = "/api/v1/quota_scan"
+	folderQuotaPath       = "/api/v1/folder_quota"
 	userPath              = "/api/v1/user"
+	groupPath             = "/api/v1/group"
 	versionPath           = "/api/v1/version"
+	tunnelsPath           = "/api/v1/tunnel"
+	tunnelPath            = "/tunnel"
 )
 
 var (
@@ -25,6 +25,7 @@ import (
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/serv"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 )
 
 const (
@@ -150,6 +151,245 @@ func TestAddUserNoHomeDir(t *testing.T) {
 	}
 }
 
+func TestAddUserInvalidMaxSessionDuration(t *testing.T) {
+	u := getTestUser()
+	u.MaxSessionDuration = -1
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid max session duration: %v", err)
+	}
+}
+
+func TestAddUserInvalidCapability(t *testing.T) {
+	u := getTestUser()
+	u.Capabilities = []string{dataprovider.CapSFTP, "invalidCapability"}
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid capability: %v", err)
+	}
+}
+
+func TestLegacyCapabilities(t *testing.T) {
+	u := getTestUser()
+	u.Permissions = []string{dataprovider.PermAny}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user, _, err = api.GetUserByID(user.ID, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get user: %v", err)
+	}
+	expected := []string{dataprovider.CapSFTP, dataprovider.CapSCP, dataprovider.CapShell, dataprovider.CapExec,
+		dataprovider.CapLocalForward, dataprovider.CapRemoteForward}
+	if len(user.Capabilities) != len(expected) {
+		t.Errorf("unexpected capabilities for a legacy \"*\" user: %v", user.Capabilities)
+	}
+	for _, c := range expected {
+		if !user.HasCapability(c) {
+			t.Errorf("capability %v must be granted to a legacy \"*\" user", c)
+		}
+	}
+	if user.HasCapability(dataprovider.CapAgent) {
+		t.Errorf("agent forwarding must not be granted to a legacy \"*\" user")
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	legacy := dataprovider.User{
+		Permissions: []string{dataprovider.PermListItems, dataprovider.PermShell},
+	}
+	legacy.SetLegacyCapabilities()
+	if legacy.HasCapability(dataprovider.CapLocalForward) || !legacy.HasCapability(dataprovider.CapExec) {
+		t.Errorf("unexpected capabilities for a legacy user with shell permission: %v", legacy.Capabilities)
+	}
+}
+
+func TestUserGroups(t *testing.T) {
+	g := dataprovider.Group{
+		Name:         "testgroup",
+		Capabilities: []string{dataprovider.CapShell, dataprovider.CapExec},
+	}
+	_, _, err := api.AddGroup(dataprovider.Group{Name: "invalidgroup", Capabilities: []string{"invalidCapability"}},
+		http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding group with invalid capability: %v", err)
+	}
+	u := getTestUser()
+	u.Groups = []string{g.Name}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with a missing group: %v", err)
+	}
+	group, _, err := api.AddGroup(g, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add group: %v", err)
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with group: %v", err)
+	}
+	group.Capabilities = append(group.Capabilities, dataprovider.CapLocalForward)
+	group, _, err = api.UpdateGroup(group, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update group: %v", err)
+	}
+	groups, _, err := api.GetGroups(http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get groups: %v", err)
+	}
+	if len(groups) != 1 || groups[0].Name != g.Name {
+		t.Errorf("unexpected groups: %+v", groups)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, err = api.RemoveGroup(group, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove group: %v", err)
+	}
+	_, _, err = api.GetGroupByName(group.Name, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting a removed group: %v", err)
+	}
+	_, err = api.RemoveGroup(group, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error removing a non existent group: %v", err)
+	}
+}
+
+func TestAddUserInvalidFilesystem(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = "invalidProvider"
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid filesystem provider: %v", err)
+	}
+	u.FsConfig.Provider = dataprovider.HDFSFilesystemProvider
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user without hdfs namenodes: %v", err)
+	}
+	u.FsConfig.HDFSConfig.Namenodes = "127.0.0.1:8020"
+	u.FsConfig.HDFSConfig.BasePath = "relative_path"
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid hdfs base path: %v", err)
+	}
+	u.FsConfig.HDFSConfig.BasePath = ""
+	u.FsConfig.HDFSConfig.RealUser = "sftp"
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with hdfs real user and without user: %v", err)
+	}
+	u.FsConfig.HDFSConfig.RealUser = ""
+	u.FsConfig.HDFSConfig.Kerberos = &fsconfig.HdfsKerberosConfig{Principal: "sftp/host@EXAMPLE.COM", Keytab: "sftp.keytab"}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with relative hdfs keytab path: %v", err)
+	}
+}
+
+func TestAddUserInvalidVirtualFolders(t *testing.T) {
+	u := getTestUser()
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "relative_path", MappedPath: "/tmp/mapped"}}
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with relative virtual path: %v", err)
+	}
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "/", MappedPath: "/tmp/mapped"}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with root virtual path: %v", err)
+	}
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "/vdir", MappedPath: filepath.Join(u.HomeDir, "mapped")}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with mapped path inside the home dir: %v", err)
+	}
+	u.VirtualFolders = []fsconfig.VirtualFolder{
+		{VirtualPath: "/vdir", MappedPath: "/tmp/mapped1"},
+		{VirtualPath: "/vdir/sub", MappedPath: "/tmp/mapped2"},
+	}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with overlapped virtual paths: %v", err)
+	}
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "/vdir", MappedPath: "/tmp/mapped", Permissions: []string{"invalidPerm"}}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid virtual folder permissions: %v", err)
+	}
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "/vdir", MappedPath: "/tmp/mapped", QuotaFiles: -1}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid virtual folder quota: %v", err)
+	}
+}
+
+func TestAddUserInvalidDirPermissions(t *testing.T) {
+	u := getTestUser()
+	u.DirPermissions = map[string][]string{"relative_path": {dataprovider.PermAny}}
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with relative dir permissions path: %v", err)
+	}
+	u.DirPermissions = map[string][]string{"/": {dataprovider.PermAny}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with root dir permissions: %v", err)
+	}
+	u.DirPermissions = map[string][]string{"/dir": {}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with empty dir permissions: %v", err)
+	}
+	u.DirPermissions = map[string][]string{"/dir": {"invalidPerm"}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid dir permissions: %v", err)
+	}
+	u.DirPermissions = map[string][]string{"/dir": {dataprovider.PermAny}, "/dir/": {dataprovider.PermListItems}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with duplicated dir permissions: %v", err)
+	}
+}
+
+func TestAddUserInvalidFilePatterns(t *testing.T) {
+	u := getTestUser()
+	u.FilePatterns = []dataprovider.PatternsFilter{{Path: "relative_path", DeniedPatterns: []string{"*.exe"}}}
+	_, _, err := api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with relative file patterns path: %v", err)
+	}
+	u.FilePatterns = []dataprovider.PatternsFilter{{Path: "/dir"}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user without file patterns: %v", err)
+	}
+	u.FilePatterns = []dataprovider.PatternsFilter{{Path: "/dir", AllowedPatterns: []string{"[a-"}}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with invalid allowed pattern: %v", err)
+	}
+	u.FilePatterns = []dataprovider.PatternsFilter{{Path: "/dir", DeniedPatterns: []string{"sub/*.exe"}}}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with a path as denied pattern: %v", err)
+	}
+	u.FilePatterns = []dataprovider.PatternsFilter{
+		{Path: "/dir", DeniedPatterns: []string{"*.exe"}},
+		{Path: "/dir/", AllowedPatterns: []string{"*.csv"}},
+	}
+	_, _, err = api.AddUser(u, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error adding user with duplicated file patterns path: %v", err)
+	}
+}
+
 func TestAddUserInvalidHomeDir(t *testing.T) {
 	u := getTestUser()
 	u.HomeDir = "relative_path"
@@ -231,6 +471,116 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+func TestUserHDFSConfig(t *testing.T) {
+	user, _, err := api.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	user.FsConfig.Provider = dataprovider.HDFSFilesystemProvider
+	user.FsConfig.HDFSConfig.Namenodes = "127.0.0.1:8020,127.0.0.2:8020"
+	user.FsConfig.HDFSConfig.User = "hdfs"
+	user.FsConfig.HDFSConfig.BasePath = "/user/test"
+	user.FsConfig.HDFSConfig.Hosts = map[string]string{"namenode1": "127.0.0.1"}
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user with hdfs filesystem: %v", err)
+	}
+	user.FsConfig.HDFSConfig.RealUser = "sftp"
+	user.FsConfig.HDFSConfig.Kerberos = &fsconfig.HdfsKerberosConfig{
+		Principal:         "sftp/host@EXAMPLE.COM",
+		Keytab:            "/etc/security/keytabs/sftp.keytab",
+		NamenodePrincipal: "nn/_HOST@EXAMPLE.COM",
+	}
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user with hdfs proxy user and kerberos: %v", err)
+	}
+	user.FsConfig.HDFSConfig.Kerberos = nil
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user without kerberos: %v", err)
+	}
+	// the hdfs settings are cleared switching back to the local filesystem
+	user.FsConfig.Provider = dataprovider.LocalFilesystemProvider
+	_, _, err = api.UpdateUser(user, http.StatusOK)
+	if err == nil {
+		t.Errorf("hdfs settings must be cleared for local filesystem users")
+	}
+	user.FsConfig.HDFSConfig = fsconfig.HdfsFsConfig{}
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user with local filesystem: %v", err)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserVirtualFolders(t *testing.T) {
+	u := getTestUser()
+	u.VirtualFolders = []fsconfig.VirtualFolder{
+		{VirtualPath: "/vdir1", MappedPath: filepath.Join(homeBasePath, "mapped1")},
+		{VirtualPath: "/shared/vdir2", MappedPath: filepath.Join(homeBasePath, "mapped2"),
+			Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload}, QuotaFiles: 10},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with virtual folders: %v", err)
+	}
+	user.VirtualFolders = user.VirtualFolders[:1]
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user virtual folders: %v", err)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserDirPermissions(t *testing.T) {
+	u := getTestUser()
+	u.DirPermissions = map[string][]string{
+		"/incoming":     {dataprovider.PermListItems, dataprovider.PermUpload},
+		"/incoming/sub": {dataprovider.PermAny},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with dir permissions: %v", err)
+	}
+	user.DirPermissions = nil
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user dir permissions: %v", err)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
+func TestUserFilePatterns(t *testing.T) {
+	u := getTestUser()
+	u.FilePatterns = []dataprovider.PatternsFilter{
+		{Path: "/", DeniedPatterns: []string{"*.exe"}},
+		{Path: "/data", AllowedPatterns: []string{"*.csv", "*.parquet"}, HideDenied: true},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user with file patterns: %v", err)
+	}
+	user.FilePatterns = nil
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user file patterns: %v", err)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove: %v", err)
+	}
+}
+
 func TestUpdateUserNoCredentials(t *testing.T) {
 	user, _, err := api.AddUser(getTestUser(), http.StatusOK)
 	if err != nil {
@@ -394,6 +744,62 @@ func TestStartQuotaScan(t *testing.T) {
 	}
 }
 
+func TestGetFolderQuotas(t *testing.T) {
+	_, _, err := api.GetFolderQuotas("", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get folder quotas: %v", err)
+	}
+	folders, _, err := api.GetFolderQuotas("/not/scanned", http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to get folder quota: %v", err)
+	}
+	if len(folders) != 0 {
+		t.Errorf("a folder never scanned must not have a stored quota: %+v", folders)
+	}
+}
+
+func TestUserSnapshots(t *testing.T) {
+	user, _, err := api.AddUser(getTestUser(), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	// snapshots are only supported on HDFS
+	_, _, err = api.GetUserSnapshots(user, http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error getting snapshots for a local user: %v", err)
+	}
+	_, _, err = api.CreateUserSnapshot(user, "", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error creating a snapshot for a local user: %v", err)
+	}
+	_, err = api.DeleteUserSnapshot(user, "s1", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error deleting a snapshot for a local user: %v", err)
+	}
+	_, _, err = api.CreateUserSnapshot(user, "dir/s1", http.StatusBadRequest)
+	if err != nil {
+		t.Errorf("unexpected error creating a snapshot with an invalid name: %v", err)
+	}
+	user.FsConfig.Provider = dataprovider.HDFSFilesystemProvider
+	user.FsConfig.HDFSConfig.Namenodes = "127.0.0.1:1"
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, _, err = api.GetUserSnapshots(user, http.StatusInternalServerError)
+	if err != nil {
+		t.Errorf("unexpected error getting snapshots with an unreachable namenode: %v", err)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	_, _, err = api.GetUserSnapshots(user, http.StatusNotFound)
+	if err != nil {
+		t.Errorf("unexpected error getting snapshots for a missing user: %v", err)
+	}
+}
+
 func TestGetVersion(t *testing.T) {
 	_, _, err := api.GetVersion(http.StatusOK)
 	if err != nil {
@@ -16,6 +16,7 @@ import (
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/serv"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
 	"github.com/go-chi/render"
 )
 
@@ -207,6 +208,208 @@ func StartQuotaScan(user dataprovider.User, expectedStatusCode int) ([]byte, err
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// GetFolderQuotas gets the used quota of the scanned virtual folders and checks the received HTTP Status code
+// against expectedStatusCode. If mappedPath is not empty only the folder mapped to this path is returned
+func GetFolderQuotas(mappedPath string, expectedStatusCode int) ([]dataprovider.FolderQuota, []byte, error) {
+	var folders []dataprovider.FolderQuota
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(folderQuotaPath))
+	if err != nil {
+		return folders, body, err
+	}
+	if len(mappedPath) > 0 {
+		q := url.Query()
+		q.Add("mapped_path", mappedPath)
+		url.RawQuery = q.Encode()
+	}
+	resp, err := getHTTPClient().Get(url.String())
+	if err != nil {
+		return folders, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &folders)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return folders, body, err
+}
+
+// GetUserSnapshots returns the snapshots of the user's HDFS home dir and checks the received HTTP Status code
+// against expectedStatusCode.
+func GetUserSnapshots(user dataprovider.User, expectedStatusCode int) ([]vfs.HdfsSnapshot, []byte, error) {
+	var snapshots []vfs.HdfsSnapshot
+	var body []byte
+	resp, err := getHTTPClient().Get(buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10), "snapshot"))
+	if err != nil {
+		return snapshots, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &snapshots)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return snapshots, body, err
+}
+
+// CreateUserSnapshot creates a snapshot of the user's HDFS home dir and checks the received HTTP Status code
+// against expectedStatusCode. If name is empty the snapshot name is generated
+func CreateUserSnapshot(user dataprovider.User, name string, expectedStatusCode int) (vfs.HdfsSnapshot, []byte, error) {
+	var snapshot vfs.HdfsSnapshot
+	var body []byte
+	asJSON, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return snapshot, body, err
+	}
+	resp, err := getHTTPClient().Post(buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10), "snapshot"),
+		"application/json", bytes.NewBuffer(asJSON))
+	if err != nil {
+		return snapshot, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusCreated {
+		err = render.DecodeJSON(resp.Body, &snapshot)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return snapshot, body, err
+}
+
+// DeleteUserSnapshot deletes a snapshot of the user's HDFS home dir and checks the received HTTP Status code
+// against expectedStatusCode.
+func DeleteUserSnapshot(user dataprovider.User, name string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	req, err := http.NewRequest(http.MethodDelete, buildURLRelativeToBase(userPath, strconv.FormatInt(user.ID, 10),
+		"snapshot", url.PathEscape(name)), nil)
+	if err != nil {
+		return body, err
+	}
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// AddGroup adds a new group and checks the received HTTP Status code against expectedStatusCode.
+func AddGroup(group dataprovider.Group, expectedStatusCode int) (dataprovider.Group, []byte, error) {
+	var newGroup dataprovider.Group
+	var body []byte
+	groupAsJSON, err := json.Marshal(group)
+	if err != nil {
+		return newGroup, body, err
+	}
+	resp, err := getHTTPClient().Post(buildURLRelativeToBase(groupPath), "application/json", bytes.NewBuffer(groupAsJSON))
+	if err != nil {
+		return newGroup, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		body, _ = getResponseBody(resp)
+		return newGroup, body, err
+	}
+	if err == nil {
+		err = render.DecodeJSON(resp.Body, &newGroup)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	if err == nil {
+		err = checkGroup(group, newGroup)
+	}
+	return newGroup, body, err
+}
+
+// UpdateGroup updates an existing group and checks the received HTTP Status code against expectedStatusCode.
+func UpdateGroup(group dataprovider.Group, expectedStatusCode int) (dataprovider.Group, []byte, error) {
+	var newGroup dataprovider.Group
+	var body []byte
+	groupAsJSON, err := json.Marshal(group)
+	if err != nil {
+		return group, body, err
+	}
+	req, err := http.NewRequest(http.MethodPut, buildURLRelativeToBase(groupPath, url.PathEscape(group.Name)),
+		bytes.NewBuffer(groupAsJSON))
+	if err != nil {
+		return group, body, err
+	}
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return group, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newGroup, body, err
+	}
+	if err == nil {
+		newGroup, body, err = GetGroupByName(group.Name, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkGroup(group, newGroup)
+	}
+	return newGroup, body, err
+}
+
+// RemoveGroup removes an existing group and checks the received HTTP Status code against expectedStatusCode.
+func RemoveGroup(group dataprovider.Group, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	req, err := http.NewRequest(http.MethodDelete, buildURLRelativeToBase(groupPath, url.PathEscape(group.Name)), nil)
+	if err != nil {
+		return body, err
+	}
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetGroupByName gets a group by name and checks the received HTTP Status code against expectedStatusCode.
+func GetGroupByName(name string, expectedStatusCode int) (dataprovider.Group, []byte, error) {
+	var group dataprovider.Group
+	var body []byte
+	resp, err := getHTTPClient().Get(buildURLRelativeToBase(groupPath, url.PathEscape(name)))
+	if err != nil {
+		return group, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &group)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return group, body, err
+}
+
+// GetGroups returns all the groups and checks the received HTTP Status code against expectedStatusCode.
+func GetGroups(expectedStatusCode int) ([]dataprovider.Group, []byte, error) {
+	var groups []dataprovider.Group
+	var body []byte
+	resp, err := getHTTPClient().Get(buildURLRelativeToBase(groupPath))
+	if err != nil {
+		return groups, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &groups)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return groups, body, err
+}
+
 // GetConnections returns status and stats for active SFTP/SCP connections
 func GetConnections(expectedStatusCode int) ([]serv.ConnectionStatus, []byte, error) {
 	var connections []serv.ConnectionStatus
@@ -242,6 +445,40 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// ReleaseTunnelName releases the given tunnel name and checks the received HTTP Status code against expectedStatusCode.
+func ReleaseTunnelName(name string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	req, err := http.NewRequest(http.MethodDelete, buildURLRelativeToBase(tunnelsPath, url.PathEscape(name)), nil)
+	if err != nil {
+		return body, err
+	}
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetTunnels returns the active named tunnels
+func GetTunnels(expectedStatusCode int) ([]serv.TunnelStatus, []byte, error) {
+	var tunnels []serv.TunnelStatus
+	var body []byte
+	resp, err := getHTTPClient().Get(buildURLRelativeToBase(tunnelsPath))
+	if err != nil {
+		return tunnels, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &tunnels)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return tunnels, body, err
+}
+
 // GetVersion returns version details
 func GetVersion(expectedStatusCode int) (utils.VersionInfo, []byte, error) {
 	var version utils.VersionInfo
@@ -326,5 +563,139 @@ func compareEqualsUserFields(expected dataprovider.User, actual dataprovider.Use
 	if expected.DownloadBandwidth != actual.DownloadBandwidth {
 		return errors.New("DownloadBandwidth mismatch")
 	}
+	if expected.MaxSessionDuration != actual.MaxSessionDuration {
+		return errors.New("MaxSessionDuration mismatch")
+	}
+	if err := compareUserFsConfig(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserVirtualFolders(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserDirPermissions(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserFilePatterns(expected, actual); err != nil {
+		return err
+	}
+	if expected.Capabilities != nil {
+		if len(expected.Capabilities) != len(actual.Capabilities) {
+			return errors.New("Capabilities mismatch")
+		}
+		for _, c := range expected.Capabilities {
+			if !utils.IsStringInSlice(c, actual.Capabilities) {
+				return errors.New("Capabilities content mismatch")
+			}
+		}
+	}
+	if len(expected.Groups) != len(actual.Groups) {
+		return errors.New("Groups mismatch")
+	}
+	for _, g := range expected.Groups {
+		if !utils.IsStringInSlice(g, actual.Groups) {
+			return errors.New("Groups content mismatch")
+		}
+	}
+	return nil
+}
+
+func checkGroup(expected dataprovider.Group, actual dataprovider.Group) error {
+	if expected.Name != actual.Name {
+		return errors.New("Name mismatch")
+	}
+	if len(expected.Capabilities) != len(actual.Capabilities) {
+		return errors.New("Capabilities mismatch")
+	}
+	for _, c := range expected.Capabilities {
+		if !utils.IsStringInSlice(c, actual.Capabilities) {
+			return errors.New("Capabilities content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUserFsConfig(expected dataprovider.User, actual dataprovider.User) error {
+	if expected.FsConfig.Provider != actual.FsConfig.Provider {
+		return errors.New("Filesystem provider mismatch")
+	}
+	if expected.FsConfig.HDFSConfig.Namenodes != actual.FsConfig.HDFSConfig.Namenodes ||
+		expected.FsConfig.HDFSConfig.User != actual.FsConfig.HDFSConfig.User ||
+		expected.FsConfig.HDFSConfig.RealUser != actual.FsConfig.HDFSConfig.RealUser ||
+		expected.FsConfig.HDFSConfig.BasePath != actual.FsConfig.HDFSConfig.BasePath {
+		return errors.New("HDFS config mismatch")
+	}
+	expectedKerberos := expected.FsConfig.HDFSConfig.Kerberos
+	actualKerberos := actual.FsConfig.HDFSConfig.Kerberos
+	if (expectedKerberos == nil) != (actualKerberos == nil) ||
+		(expectedKerberos != nil && *expectedKerberos != *actualKerberos) {
+		return errors.New("HDFS kerberos config mismatch")
+	}
+	if len(expected.FsConfig.HDFSConfig.Hosts) != len(actual.FsConfig.HDFSConfig.Hosts) {
+		return errors.New("HDFS hosts mismatch")
+	}
+	for host, address := range expected.FsConfig.HDFSConfig.Hosts {
+		if actual.FsConfig.HDFSConfig.Hosts[host] != address {
+			return errors.New("HDFS hosts content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUserVirtualFolders(expected dataprovider.User, actual dataprovider.User) error {
+	if len(expected.VirtualFolders) != len(actual.VirtualFolders) {
+		return errors.New("Virtual folders mismatch")
+	}
+	for _, v := range expected.VirtualFolders {
+		found := false
+		for _, v1 := range actual.VirtualFolders {
+			if v.VirtualPath == v1.VirtualPath && v.MappedPath == v1.MappedPath &&
+				v.QuotaSize == v1.QuotaSize && v.QuotaFiles == v1.QuotaFiles &&
+				len(v.Permissions) == len(v1.Permissions) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("Virtual folders content mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUserDirPermissions(expected dataprovider.User, actual dataprovider.User) error {
+	if len(expected.DirPermissions) != len(actual.DirPermissions) {
+		return errors.New("Dir permissions mismatch")
+	}
+	for dir, perms := range expected.DirPermissions {
+		actualPerms, ok := actual.DirPermissions[dir]
+		if !ok || len(perms) != len(actualPerms) {
+			return errors.New("Dir permissions content mismatch")
+		}
+		for _, p := range perms {
+			if !utils.IsStringInSlice(p, actualPerms) {
+				return errors.New("Dir permissions content mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+func compareUserFilePatterns(expected dataprovider.User, actual dataprovider.User) error {
+	if len(expected.FilePatterns) != len(actual.FilePatterns) {
+		return errors.New("File patterns mismatch")
+	}
+	for _, f := range expected.FilePatterns {
+		found := false
+		for _, f1 := range actual.FilePatterns {
+			if f.Path == f1.Path && f.HideDenied == f1.HideDenied &&
+				len(f.AllowedPatterns) == len(f1.AllowedPatterns) && len(f.DeniedPatterns) == len(f1.DeniedPatterns) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("File patterns content mismatch")
+		}
+	}
 	return nil
 }
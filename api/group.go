@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/lulugyf/sshserv/dataprovider"
+)
+
+func getGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := dataprovider.GetGroups(dataProvider)
+	if err == nil {
+		render.JSON(w, r, groups)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func getGroupByName(w http.ResponseWriter, r *http.Request) {
+	group, err := dataprovider.GetGroup(dataProvider, chi.URLParam(r, "name"))
+	if err == nil {
+		render.JSON(w, r, group)
+	} else if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
+
+func addGroup(w http.ResponseWriter, r *http.Request) {
+	var group dataprovider.Group
+	err := render.DecodeJSON(r.Body, &group)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddGroup(dataProvider, group)
+	if err == nil {
+		group, err = dataprovider.GetGroup(dataProvider, group.Name)
+		if err == nil {
+			render.JSON(w, r, group)
+		} else {
+			sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		}
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+}
+
+func updateGroup(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	group, err := dataprovider.GetGroup(dataProvider, name)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	err = render.DecodeJSON(r.Body, &group)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if group.Name != name {
+		sendAPIResponse(w, r, err, "group name in request body does not match group name in path parameter", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.UpdateGroup(dataProvider, group)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Group updated", http.StatusOK)
+	}
+}
+
+func deleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := dataprovider.GetGroup(dataProvider, chi.URLParam(r, "name"))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	err = dataprovider.DeleteGroup(dataProvider, group)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Group deleted", http.StatusOK)
+	}
+}
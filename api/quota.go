@@ -6,7 +6,6 @@ import (
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/serv"
-	"github.com/lulugyf/sshserv/utils"
 	"github.com/go-chi/render"
 )
 
@@ -14,6 +13,29 @@ func getQuotaScans(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, serv.GetQuotaScans())
 }
 
+// getFolderQuotas returns the used quota of the scanned virtual folders, the mapped_path query parameter
+// limits the result to the folder mapped to the given path
+func getFolderQuotas(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["mapped_path"]; ok {
+		folders := []dataprovider.FolderQuota{}
+		folder, err := dataprovider.GetFolderQuota(dataProvider, r.URL.Query().Get("mapped_path"))
+		if err == nil {
+			folders = append(folders, folder)
+		} else if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		render.JSON(w, r, folders)
+		return
+	}
+	folders, err := dataprovider.GetFolderQuotas(dataProvider)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, folders)
+}
+
 func startQuotaScan(w http.ResponseWriter, r *http.Request) {
 	var u dataprovider.User
 	err := render.DecodeJSON(r.Body, &u)
@@ -29,12 +51,16 @@ func startQuotaScan(w http.ResponseWriter, r *http.Request) {
 	if serv.AddQuotaScan(user.Username) {
 		sendAPIResponse(w, r, err, "Scan started", http.StatusCreated)
 		go func() {
-			numFiles, size, _, err := utils.ScanDirContents(user.HomeDir)
+			numFiles, size, err := serv.ScanUserHomeDir(user)
 			if err != nil {
 				logger.Warn(logSender, "error scanning user home dir %v: %v", user.HomeDir, err)
 			} else {
 				err := dataprovider.UpdateUserQuota(dataProvider, user, numFiles, size, true)
 				logger.Debug(logSender, "user dir scanned, user: %v, dir: %v, error: %v", user.Username, user.HomeDir, err)
+				setUserDirQuota(user)
+			}
+			if err := serv.ScanUserFolders(user); err != nil {
+				logger.Warn(logSender, "error scanning the virtual folders of user %v: %v", user.Username, err)
 			}
 			serv.RemoveQuotaScan(user.Username)
 		}()
@@ -42,3 +68,11 @@ func startQuotaScan(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "Another scan is already in progress", http.StatusConflict)
 	}
 }
+
+// setUserDirQuota pushes the user's quota down to HDFS if enabled, an error does not fail the request:
+// the quota is still enforced by this server
+func setUserDirQuota(user dataprovider.User) {
+	if err := serv.SetUserDirQuota(user); err != nil {
+		logger.Warn(logSender, "unable to set HDFS quota for user %#v: %v", user.Username, err)
+	}
+}
@@ -23,9 +23,10 @@ func initializeRouter() {
 	router.Use(logger.NewStructuredLogger(logger.GetLogger()))
 	router.Use(middleware.Recoverer)
 
-	router.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// the requests for a tunnel host are proxied only if they do not match an API route
+	router.NotFound(tunnelHostMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
-	}))
+	})).ServeHTTP)
 
 	router.MethodNotAllowed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, "Method not allowed", http.StatusMethodNotAllowed)
@@ -43,6 +44,22 @@ func initializeRouter() {
 		handleCloseConnection(w, r)
 	})
 
+	router.Get(tunnelsPath, func(w http.ResponseWriter, r *http.Request) {
+		getTunnels(w, r)
+	})
+
+	router.Delete(tunnelsPath+"/{tunnelName}", func(w http.ResponseWriter, r *http.Request) {
+		releaseTunnel(w, r)
+	})
+
+	router.With(tunnelHostMiddleware).HandleFunc(tunnelPath+"/{tunnelName}", func(w http.ResponseWriter, r *http.Request) {
+		handleTunnelPath(w, r)
+	})
+
+	router.With(tunnelHostMiddleware).HandleFunc(tunnelPath+"/{tunnelName}/*", func(w http.ResponseWriter, r *http.Request) {
+		handleTunnelPath(w, r)
+	})
+
 	router.Get(quotaScanPath, func(w http.ResponseWriter, r *http.Request) {
 		getQuotaScans(w, r)
 	})
@@ -51,6 +68,10 @@ func initializeRouter() {
 		startQuotaScan(w, r)
 	})
 
+	router.Get(folderQuotaPath, func(w http.ResponseWriter, r *http.Request) {
+		getFolderQuotas(w, r)
+	})
+
 	router.Get(userPath, func(w http.ResponseWriter, r *http.Request) {
 		getUsers(w, r)
 	})
@@ -70,6 +91,38 @@ func initializeRouter() {
 	router.Delete(userPath+"/{userID}", func(w http.ResponseWriter, r *http.Request) {
 		deleteUser(w, r)
 	})
+
+	router.Get(userPath+"/{userID}/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		getUserSnapshots(w, r)
+	})
+
+	router.Post(userPath+"/{userID}/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		createUserSnapshot(w, r)
+	})
+
+	router.Delete(userPath+"/{userID}/snapshot/{snapshotName}", func(w http.ResponseWriter, r *http.Request) {
+		deleteUserSnapshot(w, r)
+	})
+
+	router.Get(groupPath, func(w http.ResponseWriter, r *http.Request) {
+		getGroups(w, r)
+	})
+
+	router.Post(groupPath, func(w http.ResponseWriter, r *http.Request) {
+		addGroup(w, r)
+	})
+
+	router.Get(groupPath+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		getGroupByName(w, r)
+	})
+
+	router.Put(groupPath+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		updateGroup(w, r)
+	})
+
+	router.Delete(groupPath+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		deleteGroup(w, r)
+	})
 }
 
 func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
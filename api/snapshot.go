@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/serv"
+	"github.com/lulugyf/sshserv/vfs"
+)
+
+type snapshotRequest struct {
+	Name string `json:"name"`
+}
+
+func getUserSnapshots(w http.ResponseWriter, r *http.Request) {
+	user, ok := getSnapshotUser(w, r)
+	if !ok {
+		return
+	}
+	snapshots, err := serv.GetUserSnapshots(user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSnapshotRespStatus(err))
+		return
+	}
+	render.JSON(w, r, snapshots)
+}
+
+func createUserSnapshot(w http.ResponseWriter, r *http.Request) {
+	user, ok := getSnapshotUser(w, r)
+	if !ok {
+		return
+	}
+	var req snapshotRequest
+	if r.ContentLength != 0 {
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	snapshot, err := serv.CreateUserSnapshot(user, req.Name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSnapshotRespStatus(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, snapshot)
+}
+
+func deleteUserSnapshot(w http.ResponseWriter, r *http.Request) {
+	user, ok := getSnapshotUser(w, r)
+	if !ok {
+		return
+	}
+	err := serv.DeleteUserSnapshot(user, chi.URLParam(r, "snapshotName"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getSnapshotRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "Snapshot deleted", http.StatusOK)
+}
+
+func getSnapshotUser(w http.ResponseWriter, r *http.Request) (dataprovider.User, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid userID")
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return dataprovider.User{}, false
+	}
+	user, err := dataprovider.GetUserByID(dataProvider, userID)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+		return user, false
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return user, false
+	}
+	return user, true
+}
+
+func getSnapshotRespStatus(err error) int {
+	switch {
+	case err == serv.ErrSnapshotsUnsupported || err == serv.ErrInvalidSnapshotName:
+		return http.StatusBadRequest
+	case err == vfs.ErrHdfsNotSnapshottable || os.IsPermission(err):
+		return http.StatusForbidden
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsExist(err):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/serv"
+)
+
+type tunnelContextKey struct{}
+
+type tunnelTarget struct {
+	name   string
+	origin string
+}
+
+var tunnelProxy = &httputil.ReverseProxy{
+	Director: func(r *http.Request) {
+		// the host is used as connection key only, the connection is established over the SSH tunnel
+		r.URL.Scheme = "http"
+		r.URL.Host = r.Context().Value(tunnelContextKey{}).(tunnelTarget).name
+	},
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			target := ctx.Value(tunnelContextKey{}).(tunnelTarget)
+			return serv.DialTunnel(target.name, target.origin)
+		},
+		DisableKeepAlives: true,
+	},
+	ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Warn(logSender, "unable to proxy request to tunnel %v: %v", r.URL.Host, err)
+		if errors.Is(err, serv.ErrTunnelNotFound) {
+			sendAPIResponse(w, r, err, "", http.StatusNotFound)
+			return
+		}
+		sendAPIResponse(w, r, err, "", http.StatusBadGateway)
+	},
+}
+
+func getTunnels(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, serv.GetTunnels())
+}
+
+func releaseTunnel(w http.ResponseWriter, r *http.Request) {
+	err := serv.ReleaseTunnelName(chi.URLParam(r, "tunnelName"))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	} else if err == serv.ErrTunnelActive {
+		sendAPIResponse(w, r, err, "", http.StatusConflict)
+	} else if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	} else {
+		sendAPIResponse(w, r, err, "Tunnel name released", http.StatusOK)
+	}
+}
+
+// handleTunnelPath proxies /tunnel/<name>/<path> to <path> inside the named tunnel
+func handleTunnelPath(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tunnelName")
+	prefix := tunnelPath + "/" + name
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+	if !strings.HasPrefix(r.URL.Path, "/") {
+		r.URL.Path = "/" + r.URL.Path
+	}
+	proxyToTunnel(w, r, name)
+}
+
+func proxyToTunnel(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := context.WithValue(r.Context(), tunnelContextKey{}, tunnelTarget{name: name, origin: r.RemoteAddr})
+	tunnelProxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tunnelHostMiddleware proxies the requests whose Host header identifies a named tunnel
+func tunnelHostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := serv.GetTunnelNameForHost(r.Host); name != "" {
+			proxyToTunnel(w, r, name)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -84,6 +84,7 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		user, err = dataprovider.UserExists(dataProvider, user.Username)
 		if err == nil {
+			setUserDirQuota(user)
 			user.Password = ""
 			user.PublicKeys = []string{}
 			render.JSON(w, r, user)
@@ -123,6 +124,7 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	} else {
+		setUserDirQuota(user)
 		sendAPIResponse(w, r, err, "User updated", http.StatusOK)
 	}
 }
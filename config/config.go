@@ -45,9 +45,12 @@ func init() {
 			BindPort:     2022,
 			BindAddress:  "",
 			IdleTimeout:  15,
+			KeepaliveInterval: 60,
+			KeepaliveCountMax: 3,
 			MaxAuthTries: 0,
 			Umask:        "0022",
 			UploadMode:   0,
+			MaxListEntries: 0,
 			Actions: serv.Actions{
 				ExecuteOn:           []string{},
 				Command:             "",
@@ -55,7 +58,22 @@ func init() {
 			},
 			Keys:         []serv.Key{},
 			IsSCPEnabled: false,
-			FullFunc: false,
+			Egress: serv.EgressConf{
+				ConnectTimeout: 15,
+				DefaultAction:  "direct",
+				Proxies:        []serv.EgressProxy{},
+				Rules:          []serv.EgressRule{},
+			},
+			Tunnels: serv.TunnelConf{
+				Enabled:    false,
+				NameSuffix:  ".tunnel",
+				HostDomain:  "",
+				IdleTimeout: 300,
+			},
+			Snapshots: serv.SnapshotPolicy{
+				Interval:  0,
+				Retention: 0,
+			},
 			Ext: &serv.ExtConf{},
 		},
 		ProviderConf: dataprovider.Config{
@@ -131,6 +149,10 @@ func LoadConfig(configDir, configName string) error {
 		logger.Warn(logSender, "Configuration error: %v", err)
 		logger.WarnToConsole("Configuration error: %v", err)
 	}
+	if viper.IsSet("sftpd.full_func") {
+		logger.Warn(logSender, "full_func was removed and it is ignored, please set the user capabilities instead")
+		logger.WarnToConsole("full_func was removed and it is ignored, please set the user capabilities instead")
+	}
 	logger.Debug(logSender, "config file used: '%v', config loaded: %+v", viper.ConfigFileUsed(), globalConf)
 	return err
 }
@@ -16,6 +16,9 @@ import (
 var (
 	usersBucket      = []byte("users")
 	usersIDIdxBucket = []byte("users_id_idx")
+	tunnelsBucket    = []byte("tunnels")
+	foldersBucket    = []byte("folders")
+	groupsBucket     = []byte("groups")
 )
 
 // BoltProvider auth provider for bolt key/value store
@@ -51,6 +54,35 @@ func initializeBoltProvider(basePath string) error {
 			logger.Warn(logSender, "error creating username idx bucket: %v", err)
 			return err
 		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(tunnelsBucket)
+			return e
+		})
+		if err != nil {
+			logger.Warn(logSender, "error creating tunnels bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(foldersBucket)
+			return e
+		})
+		if err != nil {
+			logger.Warn(logSender, "error creating folders bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(groupsBucket)
+			return e
+		})
+		if err != nil {
+			logger.Warn(logSender, "error creating groups bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(setLegacyCapabilities)
+		if err != nil {
+			logger.Warn(logSender, "error setting the capabilities for the users without them: %v", err)
+			return err
+		}
 		provider = BoltProvider{dbHandle: dbHandle}
 	} else {
 		logger.Warn(logSender, "error creating bolt key/value store handler: %v", err)
@@ -102,7 +134,6 @@ func (p BoltProvider) getUserByID(ID int64) (User, error) {
 		}
 		return json.Unmarshal(u, &user)
 	})
-
 	return user, err
 }
 
@@ -229,10 +260,235 @@ func (p BoltProvider) deleteUser(user User) error {
 		if err != nil {
 			return err
 		}
-		return idxBucket.Delete(userIDAsBytes)
+		err = idxBucket.Delete(userIDAsBytes)
+		if err != nil {
+			return err
+		}
+		// the tunnel names owned by the deleted user can be claimed again
+		tunnels := tx.Bucket(tunnelsBucket)
+		if tunnels == nil {
+			return nil
+		}
+		var names [][]byte
+		err = tunnels.ForEach(func(k, v []byte) error {
+			if string(v) == string(userName) {
+				names = append(names, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err = tunnels.Delete(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p BoltProvider) getTunnelOwner(name string) (string, error) {
+	var username string
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tunnelsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the tunnels bucket, bolt database structure not correcly defined")
+		}
+		owner := bucket.Get([]byte(name))
+		if owner == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("tunnel %v does not exist", name)}
+		}
+		username = string(owner)
+		return nil
+	})
+	return username, err
+}
+
+func (p BoltProvider) addTunnelOwner(name string, username string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tunnelsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the tunnels bucket, bolt database structure not correcly defined")
+		}
+		if owner := bucket.Get([]byte(name)); owner != nil {
+			return fmt.Errorf("tunnel '%v' already exists", name)
+		}
+		return bucket.Put([]byte(name), []byte(username))
+	})
+}
+
+func (p BoltProvider) deleteTunnelOwner(name string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tunnelsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the tunnels bucket, bolt database structure not correcly defined")
+		}
+		if owner := bucket.Get([]byte(name)); owner == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("tunnel %v does not exist", name)}
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (p BoltProvider) updateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(foldersBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the folders bucket, bolt database structure not correcly defined")
+		}
+		var folder FolderQuota
+		if f := bucket.Get([]byte(mappedPath)); f != nil {
+			if err := json.Unmarshal(f, &folder); err != nil {
+				return err
+			}
+		} else if !reset {
+			return nil
+		}
+		folder.MappedPath = mappedPath
+		if reset {
+			folder.UsedQuotaSize = sizeAdd
+			folder.UsedQuotaFiles = filesAdd
+		} else {
+			folder.UsedQuotaSize += sizeAdd
+			folder.UsedQuotaFiles += filesAdd
+		}
+		folder.LastQuotaUpdate = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(folder)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(mappedPath), buf)
+	})
+}
+
+func (p BoltProvider) getFolderQuota(mappedPath string) (FolderQuota, error) {
+	var folder FolderQuota
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(foldersBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the folders bucket, bolt database structure not correcly defined")
+		}
+		f := bucket.Get([]byte(mappedPath))
+		if f == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("folder %v does not exist", mappedPath)}
+		}
+		return json.Unmarshal(f, &folder)
+	})
+	return folder, err
+}
+
+func (p BoltProvider) getFolderQuotas() ([]FolderQuota, error) {
+	folders := []FolderQuota{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(foldersBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the folders bucket, bolt database structure not correcly defined")
+		}
+		// keys are sorted, so the folders are ordered by mapped path
+		return bucket.ForEach(func(k, v []byte) error {
+			var folder FolderQuota
+			if err := json.Unmarshal(v, &folder); err != nil {
+				return err
+			}
+			folders = append(folders, folder)
+			return nil
+		})
+	})
+	return folders, err
+}
+
+func (p BoltProvider) addGroup(group Group) error {
+	err := validateGroup(&group)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the groups bucket, bolt database structure not correcly defined")
+		}
+		if g := bucket.Get([]byte(group.Name)); g != nil {
+			return fmt.Errorf("group '%v' already exists", group.Name)
+		}
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(group.Name), buf)
+	})
+}
+
+func (p BoltProvider) updateGroup(group Group) error {
+	err := validateGroup(&group)
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the groups bucket, bolt database structure not correcly defined")
+		}
+		if g := bucket.Get([]byte(group.Name)); g == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("group '%v' does not exist", group.Name)}
+		}
+		buf, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(group.Name), buf)
 	})
 }
 
+func (p BoltProvider) deleteGroup(group Group) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the groups bucket, bolt database structure not correcly defined")
+		}
+		if g := bucket.Get([]byte(group.Name)); g == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("group '%v' does not exist", group.Name)}
+		}
+		return bucket.Delete([]byte(group.Name))
+	})
+}
+
+func (p BoltProvider) getGroup(name string) (Group, error) {
+	var group Group
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the groups bucket, bolt database structure not correcly defined")
+		}
+		g := bucket.Get([]byte(name))
+		if g == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("group %v does not exist", name)}
+		}
+		return json.Unmarshal(g, &group)
+	})
+	return group, err
+}
+
+func (p BoltProvider) getGroups() ([]Group, error) {
+	groups := []Group{}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		if bucket == nil {
+			return fmt.Errorf("Unable to find the groups bucket, bolt database structure not correcly defined")
+		}
+		// keys are sorted, so the groups are ordered by name
+		return bucket.ForEach(func(k, v []byte) error {
+			var group Group
+			if err := json.Unmarshal(v, &group); err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
+
 func (p BoltProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
 	users := []User{}
 	var err error
@@ -297,6 +553,41 @@ func getUserNoCredentials(user *User) User {
 	return *user
 }
 
+// setLegacyCapabilities stores the capabilities mapped from the legacy permissions for the users
+// saved before the capabilities were introduced, the SQL providers do the same in their migration
+func setLegacyCapabilities(tx *bolt.Tx) error {
+	bucket, _, err := getBuckets(tx)
+	if err != nil {
+		return err
+	}
+	users := make(map[string][]byte)
+	err = bucket.ForEach(func(k, v []byte) error {
+		var user User
+		if err := json.Unmarshal(v, &user); err != nil {
+			return err
+		}
+		if user.Capabilities != nil {
+			return nil
+		}
+		user.SetLegacyCapabilities()
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		users[string(k)] = buf
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for username, buf := range users {
+		if err = bucket.Put([]byte(username), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // itob returns an 8-byte big endian representation of v.
 func itob(v int64) []byte {
 	b := make([]byte, 8)
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -24,6 +25,7 @@ import (
 
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 )
 
 const (
@@ -53,7 +55,9 @@ var (
 	provider           Provider
 	sqlPlaceholders    []string
 	validPerms         = []string{PermAny, PermListItems, PermDownload, PermUpload, PermDelete, PermRename,
-		PermCreateDirs, PermCreateSymlinks, "shell", "_expire:"}
+		PermCreateDirs, PermCreateSymlinks, PermCreateLinks, PermChmod, PermChown, PermChtimes, PermXattrs, PermShell, PermTCPForward,
+		"_expire:"}
+	validCapabilities = []string{CapSFTP, CapSCP, CapShell, CapExec, CapLocalForward, CapRemoteForward, CapAgent}
 	hashPwdPrefixes  = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix}
 	pbkdfPwdPrefixes = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix}
 )
@@ -145,6 +149,17 @@ type Provider interface {
 	deleteUser(user User) error
 	getUsers(limit int, offset int, order string, username string) ([]User, error)
 	getUserByID(ID int64) (User, error)
+	getTunnelOwner(name string) (string, error)
+	addTunnelOwner(name string, username string) error
+	deleteTunnelOwner(name string) error
+	updateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool) error
+	getFolderQuota(mappedPath string) (FolderQuota, error)
+	getFolderQuotas() ([]FolderQuota, error)
+	addGroup(group Group) error
+	updateGroup(group Group) error
+	deleteGroup(group Group) error
+	getGroup(name string) (Group, error)
+	getGroups() ([]Group, error)
 }
 
 // Initialize the data provider.
@@ -194,6 +209,30 @@ func GetUsedQuota(p Provider, username string) (int, int64, error) {
 	return p.getUsedQuota(username)
 }
 
+// UpdateFolderQuota updates the used quota for the virtual folder mapped to mappedPath adding filesAdd and sizeAdd.
+// If reset is true filesAdd and sizeAdd indicates the total files and the total size instead of the difference.
+// The used quota is stored by the first reset, until then the updates are ignored: the folder scan counts them
+func UpdateFolderQuota(p Provider, mappedPath string, filesAdd int, sizeAdd int64, reset bool) error {
+	if config.TrackQuota == 0 {
+		return &MethodDisabledError{err: trackQuotaDisabledError}
+	}
+	return p.updateFolderQuota(mappedPath, filesAdd, sizeAdd, reset)
+}
+
+// GetFolderQuota returns the used quota for the virtual folder mapped to mappedPath.
+// A RecordNotFoundError is returned if the folder was never scanned. TrackQuota must be >=1 to enable this method
+func GetFolderQuota(p Provider, mappedPath string) (FolderQuota, error) {
+	if config.TrackQuota == 0 {
+		return FolderQuota{}, &MethodDisabledError{err: trackQuotaDisabledError}
+	}
+	return p.getFolderQuota(mappedPath)
+}
+
+// GetFolderQuotas returns the used quota for all the scanned virtual folders, ordered by mapped path
+func GetFolderQuotas(p Provider) ([]FolderQuota, error) {
+	return p.getFolderQuotas()
+}
+
 // UserExists checks if the given SFTP username exists, returns an error if no match is found
 func UserExists(p Provider, username string) (User, error) {
 	return p.userExists(username)
@@ -254,6 +293,277 @@ func GetUserByID(p Provider, ID int64) (User, error) {
 	return p.getUserByID(ID)
 }
 
+// AddGroup adds a new group.
+// ManageUsers configuration must be set to 1 to enable this method
+func AddGroup(p Provider, group Group) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.addGroup(group)
+}
+
+// UpdateGroup updates an existing group.
+// ManageUsers configuration must be set to 1 to enable this method
+func UpdateGroup(p Provider, group Group) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.updateGroup(group)
+}
+
+// DeleteGroup deletes an existing group, the users that are still members of the group lose its capabilities.
+// ManageUsers configuration must be set to 1 to enable this method
+func DeleteGroup(p Provider, group Group) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.deleteGroup(group)
+}
+
+// GetGroup returns the group with the given name if a match is found or an error
+func GetGroup(p Provider, name string) (Group, error) {
+	return p.getGroup(name)
+}
+
+// GetGroups returns all the groups ordered by name
+func GetGroups(p Provider) ([]Group, error) {
+	return p.getGroups()
+}
+
+// AddGroupCapabilities adds to the user's capabilities the ones granted by the groups the user belongs to.
+// The groups deleted after the user was saved are ignored
+func AddGroupCapabilities(p Provider, user *User) error {
+	for _, name := range user.Groups {
+		group, err := p.getGroup(name)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				logger.Warn(logSender, "group %#v for user %#v does not exist anymore", name, user.Username)
+				continue
+			}
+			return err
+		}
+		for _, c := range group.Capabilities {
+			if !utils.IsStringInSlice(c, user.Capabilities) {
+				user.Capabilities = append(user.Capabilities, c)
+			}
+		}
+	}
+	return nil
+}
+
+// ClaimTunnelName reserves the given named tunnel for username. A name is owned by the first user that
+// registers it and it cannot be used by other users, even when it is not active, until the owner is deleted
+// or the name is released
+func ClaimTunnelName(p Provider, name string, username string) error {
+	owner, err := p.getTunnelOwner(name)
+	if err == nil {
+		if owner != username {
+			return fmt.Errorf("tunnel %#v is owned by another user", name)
+		}
+		return nil
+	}
+	if _, ok := err.(*RecordNotFoundError); !ok {
+		return err
+	}
+	return p.addTunnelOwner(name, username)
+}
+
+// ReleaseTunnelName removes the owner of the given tunnel name, so it can be claimed by any user again
+func ReleaseTunnelName(p Provider, name string) error {
+	if config.ManageUsers == 0 {
+		return &MethodDisabledError{err: manageUsersDisabledError}
+	}
+	return p.deleteTunnelOwner(name)
+}
+
+func validatePermissions(permissions []string) error {
+	for _, p := range permissions {
+		px := strings.Index(p, ":")
+		if px > 0 {
+			p = p[:px+1]
+		}
+		if !utils.IsStringInSlice(p, validPerms) {
+			return &ValidationError{err: fmt.Sprintf("Invalid permission: %v", p)}
+		}
+	}
+	return nil
+}
+
+func validateDirPermissions(user *User) error {
+	if len(user.DirPermissions) == 0 {
+		user.DirPermissions = nil
+		return nil
+	}
+	dirPermissions := make(map[string][]string)
+	for dir, perms := range user.DirPermissions {
+		cleanedDir := path.Clean(dir)
+		if !path.IsAbs(cleanedDir) || cleanedDir == "/" {
+			return &ValidationError{err: fmt.Sprintf("dir_permissions paths must be absolute and cannot be \"/\", "+
+				"use permissions for the root dir, actual value: %v", dir)}
+		}
+		if _, ok := dirPermissions[cleanedDir]; ok {
+			return &ValidationError{err: fmt.Sprintf("duplicated dir_permissions path: %v", dir)}
+		}
+		if len(perms) == 0 {
+			return &ValidationError{err: fmt.Sprintf("please grant some permissions for the dir: %v", dir)}
+		}
+		if err := validatePermissions(perms); err != nil {
+			return err
+		}
+		dirPermissions[cleanedDir] = perms
+	}
+	user.DirPermissions = dirPermissions
+	return nil
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+			return &ValidationError{err: fmt.Sprintf("invalid file pattern: %#v", pattern)}
+		}
+	}
+	return nil
+}
+
+func validateFilePatterns(user *User) error {
+	var filters []PatternsFilter
+	var paths []string
+	for _, f := range user.FilePatterns {
+		cleanedPath := path.Clean(f.Path)
+		if !path.IsAbs(cleanedPath) {
+			return &ValidationError{err: fmt.Sprintf("file_patterns paths must be absolute, actual value: %v", f.Path)}
+		}
+		if utils.IsStringInSlice(cleanedPath, paths) {
+			return &ValidationError{err: fmt.Sprintf("duplicated file_patterns path: %v", f.Path)}
+		}
+		if len(f.AllowedPatterns) == 0 && len(f.DeniedPatterns) == 0 {
+			return &ValidationError{err: fmt.Sprintf("please set allowed or denied patterns for the dir: %v", f.Path)}
+		}
+		if err := validatePatterns(f.AllowedPatterns); err != nil {
+			return err
+		}
+		if err := validatePatterns(f.DeniedPatterns); err != nil {
+			return err
+		}
+		f.Path = cleanedPath
+		paths = append(paths, cleanedPath)
+		filters = append(filters, f)
+	}
+	user.FilePatterns = filters
+	return nil
+}
+
+func isPathOverlapped(path1, path2 string) bool {
+	return fsconfig.IsVirtualPathInside(path1, path2) || fsconfig.IsVirtualPathInside(path2, path1)
+}
+
+func validateVirtualFolders(user *User) error {
+	rootDir := filepath.ToSlash(filepath.Clean(user.HomeDir))
+	if user.FsConfig.Provider == HDFSFilesystemProvider && len(user.FsConfig.HDFSConfig.BasePath) > 0 {
+		rootDir = path.Clean(user.FsConfig.HDFSConfig.BasePath)
+	}
+	var folders []fsconfig.VirtualFolder
+	for _, v := range user.VirtualFolders {
+		if !path.IsAbs(v.VirtualPath) || path.Clean(v.VirtualPath) == "/" {
+			return &ValidationError{err: fmt.Sprintf("invalid virtual folder path: [%v]", v.VirtualPath)}
+		}
+		if !path.IsAbs(filepath.ToSlash(v.MappedPath)) {
+			return &ValidationError{err: fmt.Sprintf("mapped path must be an absolute path, actual value: [%v]",
+				v.MappedPath)}
+		}
+		v.VirtualPath = path.Clean(v.VirtualPath)
+		v.MappedPath = filepath.Clean(v.MappedPath)
+		if isPathOverlapped(filepath.ToSlash(v.MappedPath), rootDir) {
+			return &ValidationError{err: fmt.Sprintf("mapped path [%v] overlaps with the user's root [%v]",
+				v.MappedPath, rootDir)}
+		}
+		for _, f := range folders {
+			if isPathOverlapped(v.VirtualPath, f.VirtualPath) {
+				return &ValidationError{err: fmt.Sprintf("virtual folder [%v] overlaps with [%v]",
+					v.VirtualPath, f.VirtualPath)}
+			}
+			if isPathOverlapped(filepath.ToSlash(v.MappedPath), filepath.ToSlash(f.MappedPath)) {
+				return &ValidationError{err: fmt.Sprintf("mapped path [%v] overlaps with [%v]",
+					v.MappedPath, f.MappedPath)}
+			}
+		}
+		if err := validatePermissions(v.Permissions); err != nil {
+			return err
+		}
+		if v.QuotaSize < 0 || v.QuotaFiles < 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid quota for virtual folder [%v]", v.VirtualPath)}
+		}
+		folders = append(folders, v)
+	}
+	user.VirtualFolders = folders
+	return nil
+}
+
+func validateFilesystemConfig(user *User) error {
+	switch user.FsConfig.Provider {
+	case "", LocalFilesystemProvider:
+		user.FsConfig.HDFSConfig = fsconfig.HdfsFsConfig{}
+	case HDFSFilesystemProvider:
+		config := user.FsConfig.HDFSConfig
+		if len(config.Namenodes) == 0 {
+			return &ValidationError{err: "hdfs namenodes or configuration dir are mandatory"}
+		}
+		if len(config.BasePath) > 0 && !path.IsAbs(config.BasePath) {
+			return &ValidationError{err: fmt.Sprintf("hdfs base_path must be an absolute path, actual value: [%v]",
+				config.BasePath)}
+		}
+		for host, address := range config.Hosts {
+			if len(host) == 0 || len(address) == 0 {
+				return &ValidationError{err: fmt.Sprintf("invalid hdfs host mapping: [%v] -> [%v]", host, address)}
+			}
+		}
+		if len(config.RealUser) > 0 && len(config.User) == 0 {
+			return &ValidationError{err: "hdfs user is mandatory if real_user is set"}
+		}
+		if config.Kerberos != nil {
+			if *config.Kerberos == (fsconfig.HdfsKerberosConfig{}) {
+				user.FsConfig.HDFSConfig.Kerberos = nil
+			} else if len(config.Kerberos.Principal) == 0 || !filepath.IsAbs(config.Kerberos.Keytab) {
+				return &ValidationError{err: "hdfs kerberos principal and absolute keytab path are mandatory"}
+			}
+		}
+	default:
+		return &ValidationError{err: fmt.Sprintf("Invalid filesystem provider: %v", user.FsConfig.Provider)}
+	}
+	return nil
+}
+
+func validateCapabilities(capabilities []string) error {
+	for _, c := range capabilities {
+		if !utils.IsStringInSlice(c, validCapabilities) {
+			return &ValidationError{err: fmt.Sprintf("Invalid capability: %v", c)}
+		}
+	}
+	return nil
+}
+
+func validateGroup(group *Group) error {
+	if len(group.Name) == 0 {
+		return &ValidationError{err: "Mandatory parameters missing"}
+	}
+	return validateCapabilities(group.Capabilities)
+}
+
+func validateUserGroups(user *User) error {
+	for _, name := range user.Groups {
+		if len(name) == 0 {
+			return &ValidationError{err: "Invalid empty group name"}
+		}
+		if _, err := provider.getGroup(name); err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				return &ValidationError{err: fmt.Sprintf("Group %#v does not exist", name)}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 func validateUser(user *User) error {
 	if len(user.Username) == 0 || len(user.HomeDir) == 0 {
 		return &ValidationError{err: "Mandatory parameters missing"}
@@ -267,14 +577,30 @@ func validateUser(user *User) error {
 	if !filepath.IsAbs(user.HomeDir) {
 		return &ValidationError{err: fmt.Sprintf("home_dir must be an absolute path, actual value: [%v]--", user.HomeDir)}
 	}
-	for _, p := range user.Permissions {
-		px := strings.Index(p, ":")
-		if px > 0 {
-			p = p[:px+1]
-		}
-		if !utils.IsStringInSlice(p, validPerms) {
-			return &ValidationError{err: fmt.Sprintf("Invalid permission: %v", p)}
-		}
+	user.SetLegacyCapabilities()
+	if err := validateCapabilities(user.Capabilities); err != nil {
+		return err
+	}
+	if err := validateUserGroups(user); err != nil {
+		return err
+	}
+	if err := validateFilesystemConfig(user); err != nil {
+		return err
+	}
+	if err := validateVirtualFolders(user); err != nil {
+		return err
+	}
+	if user.MaxSessionDuration < 0 {
+		return &ValidationError{err: fmt.Sprintf("max_session_duration must be >= 0, actual value: %v", user.MaxSessionDuration)}
+	}
+	if err := validatePermissions(user.Permissions); err != nil {
+		return err
+	}
+	if err := validateDirPermissions(user); err != nil {
+		return err
+	}
+	if err := validateFilePatterns(user); err != nil {
+		return err
 	}
 	if len(user.Password) > 0 && !utils.IsStringPrefixInSlice(user.Password, hashPwdPrefixes) {
 		pwd, err := argon2id.CreateHash(user.Password, argon2id.DefaultParams)
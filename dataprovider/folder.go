@@ -0,0 +1,14 @@
+package dataprovider
+
+// FolderQuota defines the used quota of a virtual folder with its own quota.
+// A mapped path can be shared by several users, so the used quota is stored by mapped path
+type FolderQuota struct {
+	// backend path mapped by the virtual folder
+	MappedPath string `json:"mapped_path"`
+	// used quota as bytes
+	UsedQuotaSize int64 `json:"used_quota_size"`
+	// used quota as number of files
+	UsedQuotaFiles int `json:"used_quota_files"`
+	// Last quota update as unix timestamp in milliseconds
+	LastQuotaUpdate int64 `json:"last_quota_update"`
+}
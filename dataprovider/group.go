@@ -0,0 +1,19 @@
+package dataprovider
+
+import (
+	"encoding/json"
+)
+
+// Group defines a named set of capability profiles, the users that belong to a group
+// get its capabilities in addition to their own
+type Group struct {
+	// Group name, it is the unique identifier of the group
+	Name string `json:"name"`
+	// Granted capability profiles
+	Capabilities []string `json:"capabilities"`
+}
+
+// GetCapabilitiesAsJSON returns the capabilities as json byte array
+func (g *Group) GetCapabilitiesAsJSON() ([]byte, error) {
+	return json.Marshal(g.Capabilities)
+}
@@ -90,3 +90,47 @@ func (p MySQLProvider) deleteUser(user User) error {
 func (p MySQLProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
+
+func (p MySQLProvider) getTunnelOwner(name string) (string, error) {
+	return sqlCommonGetTunnelOwner(name, p.dbHandle)
+}
+
+func (p MySQLProvider) addTunnelOwner(name string, username string) error {
+	return sqlCommonAddTunnelOwner(name, username, p.dbHandle)
+}
+
+func (p MySQLProvider) deleteTunnelOwner(name string) error {
+	return sqlCommonDeleteTunnelOwner(name, p.dbHandle)
+}
+
+func (p MySQLProvider) updateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(mappedPath, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p MySQLProvider) getFolderQuota(mappedPath string) (FolderQuota, error) {
+	return sqlCommonGetFolderQuota(mappedPath, p.dbHandle)
+}
+
+func (p MySQLProvider) getFolderQuotas() ([]FolderQuota, error) {
+	return sqlCommonGetFolderQuotas(p.dbHandle)
+}
+
+func (p MySQLProvider) addGroup(group Group) error {
+	return sqlCommonAddGroup(group, p.dbHandle)
+}
+
+func (p MySQLProvider) updateGroup(group Group) error {
+	return sqlCommonUpdateGroup(group, p.dbHandle)
+}
+
+func (p MySQLProvider) deleteGroup(group Group) error {
+	return sqlCommonDeleteGroup(group, p.dbHandle)
+}
+
+func (p MySQLProvider) getGroup(name string) (Group, error) {
+	return sqlCommonGetGroup(name, p.dbHandle)
+}
+
+func (p MySQLProvider) getGroups() ([]Group, error) {
+	return sqlCommonGetGroups(p.dbHandle)
+}
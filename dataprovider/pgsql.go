@@ -88,3 +88,47 @@ func (p PGSQLProvider) deleteUser(user User) error {
 func (p PGSQLProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
+
+func (p PGSQLProvider) getTunnelOwner(name string) (string, error) {
+	return sqlCommonGetTunnelOwner(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) addTunnelOwner(name string, username string) error {
+	return sqlCommonAddTunnelOwner(name, username, p.dbHandle)
+}
+
+func (p PGSQLProvider) deleteTunnelOwner(name string) error {
+	return sqlCommonDeleteTunnelOwner(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(mappedPath, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p PGSQLProvider) getFolderQuota(mappedPath string) (FolderQuota, error) {
+	return sqlCommonGetFolderQuota(mappedPath, p.dbHandle)
+}
+
+func (p PGSQLProvider) getFolderQuotas() ([]FolderQuota, error) {
+	return sqlCommonGetFolderQuotas(p.dbHandle)
+}
+
+func (p PGSQLProvider) addGroup(group Group) error {
+	return sqlCommonAddGroup(group, p.dbHandle)
+}
+
+func (p PGSQLProvider) updateGroup(group Group) error {
+	return sqlCommonUpdateGroup(group, p.dbHandle)
+}
+
+func (p PGSQLProvider) deleteGroup(group Group) error {
+	return sqlCommonDeleteGroup(group, p.dbHandle)
+}
+
+func (p PGSQLProvider) getGroup(name string) (Group, error) {
+	return sqlCommonGetGroup(name, p.dbHandle)
+}
+
+func (p PGSQLProvider) getGroups() ([]Group, error) {
+	return sqlCommonGetGroups(p.dbHandle)
+}
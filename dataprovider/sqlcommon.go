@@ -8,6 +8,7 @@ import (
 
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 )
 
 func getUserByUsername(username string, dbHandle *sql.DB) (User, error) {
@@ -134,8 +135,33 @@ func sqlCommonAddUser(user User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	capabilities, err := user.GetCapabilitiesAsJSON()
+	if err != nil {
+		return err
+	}
+	groups, err := user.GetGroupsAsJSON()
+	if err != nil {
+		return err
+	}
+	fsConfig, err := user.GetFsConfigAsJSON()
+	if err != nil {
+		return err
+	}
+	virtualFolders, err := user.GetVirtualFoldersAsJSON()
+	if err != nil {
+		return err
+	}
+	dirPermissions, err := user.GetDirPermissionsAsJSON()
+	if err != nil {
+		return err
+	}
+	filePatterns, err := user.GetFilePatternsAsJSON()
+	if err != nil {
+		return err
+	}
 	_, err = stmt.Exec(user.Username, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
-		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth)
+		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.MaxSessionDuration,
+		string(capabilities), string(groups), string(fsConfig), string(virtualFolders), string(dirPermissions), string(filePatterns))
 	return err
 }
 
@@ -159,8 +185,33 @@ func sqlCommonUpdateUser(user User, dbHandle *sql.DB) error {
 	if err != nil {
 		return err
 	}
+	capabilities, err := user.GetCapabilitiesAsJSON()
+	if err != nil {
+		return err
+	}
+	groups, err := user.GetGroupsAsJSON()
+	if err != nil {
+		return err
+	}
+	fsConfig, err := user.GetFsConfigAsJSON()
+	if err != nil {
+		return err
+	}
+	virtualFolders, err := user.GetVirtualFoldersAsJSON()
+	if err != nil {
+		return err
+	}
+	dirPermissions, err := user.GetDirPermissionsAsJSON()
+	if err != nil {
+		return err
+	}
+	filePatterns, err := user.GetFilePatternsAsJSON()
+	if err != nil {
+		return err
+	}
 	_, err = stmt.Exec(user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
-		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.ID)
+		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.MaxSessionDuration,
+		string(capabilities), string(groups), string(fsConfig), string(virtualFolders), string(dirPermissions), string(filePatterns), user.ID)
 	return err
 }
 
@@ -173,9 +224,146 @@ func sqlCommonDeleteUser(user User, dbHandle *sql.DB) error {
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(user.ID)
+	if err != nil {
+		return err
+	}
+	// the tunnel names owned by the deleted user can be claimed again
+	q = getDeleteUserTunnelsQuery()
+	tunnelsStmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer tunnelsStmt.Close()
+	_, err = tunnelsStmt.Exec(user.Username)
+	return err
+}
+
+func sqlCommonGetTunnelOwner(name string, dbHandle *sql.DB) (string, error) {
+	q := getTunnelOwnerQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return "", err
+	}
+	defer stmt.Close()
+	var username string
+	err = stmt.QueryRow(name).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", &RecordNotFoundError{err: err.Error()}
+	}
+	return username, err
+}
+
+func sqlCommonAddTunnelOwner(name string, username string, dbHandle *sql.DB) error {
+	q := getAddTunnelOwnerQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(name, username, utils.GetTimeAsMsSinceEpoch(time.Now()))
+	return err
+}
+
+func sqlCommonDeleteTunnelOwner(name string, dbHandle *sql.DB) error {
+	q := getDeleteTunnelOwnerQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.Exec(name)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: sql.ErrNoRows.Error()}
+	}
+	return nil
+}
+
+func sqlCommonUpdateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool, dbHandle *sql.DB) error {
+	q := getUpdateFolderQuotaQuery(reset)
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Debug(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	res, err := stmt.Exec(sizeAdd, filesAdd, now, mappedPath)
+	if err == nil && reset {
+		var rows int64
+		if rows, err = res.RowsAffected(); err == nil && rows == 0 {
+			err = sqlCommonAddFolderQuota(mappedPath, filesAdd, sizeAdd, now, dbHandle)
+		}
+	}
+	if err == nil {
+		logger.Debug(logSender, "quota updated for folder %v, files increment: %v size increment: %v is reset? %v",
+			mappedPath, filesAdd, sizeAdd, reset)
+	} else {
+		logger.Warn(logSender, "error updating quota for folder %v: %v", mappedPath, err)
+	}
+	return err
+}
+
+func sqlCommonAddFolderQuota(mappedPath string, files int, size int64, lastUpdate int64, dbHandle *sql.DB) error {
+	q := getAddFolderQuotaQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Debug(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(size, files, lastUpdate, mappedPath)
 	return err
 }
 
+func sqlCommonGetFolderQuota(mappedPath string, dbHandle *sql.DB) (FolderQuota, error) {
+	var folder FolderQuota
+	q := getFolderQuotaQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return folder, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRow(mappedPath).Scan(&folder.MappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
+		&folder.LastQuotaUpdate)
+	if err == sql.ErrNoRows {
+		return folder, &RecordNotFoundError{err: err.Error()}
+	}
+	return folder, err
+}
+
+func sqlCommonGetFolderQuotas(dbHandle *sql.DB) ([]FolderQuota, error) {
+	folders := []FolderQuota{}
+	q := getFolderQuotasQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return folders, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var folder FolderQuota
+		err = rows.Scan(&folder.MappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate)
+		if err != nil {
+			return folders, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
 func sqlCommonGetUsers(limit int, offset int, order string, username string, dbHandle *sql.DB) ([]User, error) {
 	users := []User{}
 	q := getUsersQuery(order, username)
@@ -214,16 +402,24 @@ func getUserFromDbRow(row *sql.Row, rows *sql.Rows) (User, error) {
 	var permissions sql.NullString
 	var password sql.NullString
 	var publicKey sql.NullString
+	var capabilities sql.NullString
+	var groups sql.NullString
+	var fsConfig sql.NullString
+	var virtualFolders sql.NullString
+	var dirPermissions sql.NullString
+	var filePatterns sql.NullString
 	var err error
 	if row != nil {
 		err = row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 			&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
-			&user.UploadBandwidth, &user.DownloadBandwidth)
+			&user.UploadBandwidth, &user.DownloadBandwidth, &user.MaxSessionDuration, &capabilities,
+			&groups, &fsConfig, &virtualFolders, &dirPermissions, &filePatterns)
 
 	} else {
 		err = rows.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 			&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
-			&user.UploadBandwidth, &user.DownloadBandwidth)
+			&user.UploadBandwidth, &user.DownloadBandwidth, &user.MaxSessionDuration, &capabilities,
+			&groups, &fsConfig, &virtualFolders, &dirPermissions, &filePatterns)
 	}
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -248,5 +444,159 @@ func getUserFromDbRow(row *sql.Row, rows *sql.Rows) (User, error) {
 			user.Permissions = list
 		}
 	}
+	if capabilities.Valid {
+		var list []string
+		err = json.Unmarshal([]byte(capabilities.String), &list)
+		if err == nil {
+			user.Capabilities = list
+		}
+	}
+	if groups.Valid {
+		var list []string
+		err = json.Unmarshal([]byte(groups.String), &list)
+		if err == nil {
+			user.Groups = list
+		}
+	}
+	if fsConfig.Valid {
+		var fs Filesystem
+		err = json.Unmarshal([]byte(fsConfig.String), &fs)
+		if err == nil {
+			user.FsConfig = fs
+		}
+	}
+	if virtualFolders.Valid {
+		var list []fsconfig.VirtualFolder
+		err = json.Unmarshal([]byte(virtualFolders.String), &list)
+		if err == nil {
+			user.VirtualFolders = list
+		}
+	}
+	if dirPermissions.Valid {
+		var perms map[string][]string
+		err = json.Unmarshal([]byte(dirPermissions.String), &perms)
+		if err == nil {
+			user.DirPermissions = perms
+		}
+	}
+	if filePatterns.Valid {
+		var list []PatternsFilter
+		err = json.Unmarshal([]byte(filePatterns.String), &list)
+		if err == nil {
+			user.FilePatterns = list
+		}
+	}
 	return user, err
 }
+
+func sqlCommonGetGroup(name string, dbHandle *sql.DB) (Group, error) {
+	q := getGroupQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return Group{}, err
+	}
+	defer stmt.Close()
+	return getGroupFromDbRow(stmt.QueryRow(name), nil)
+}
+
+func sqlCommonGetGroups(dbHandle *sql.DB) ([]Group, error) {
+	groups := []Group{}
+	q := getGroupsQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return groups, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		group, err := getGroupFromDbRow(nil, rows)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func sqlCommonAddGroup(group Group, dbHandle *sql.DB) error {
+	err := validateGroup(&group)
+	if err != nil {
+		return err
+	}
+	q := getAddGroupQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	capabilities, err := group.GetCapabilitiesAsJSON()
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(group.Name, string(capabilities))
+	return err
+}
+
+func sqlCommonUpdateGroup(group Group, dbHandle *sql.DB) error {
+	err := validateGroup(&group)
+	if err != nil {
+		return err
+	}
+	q := getUpdateGroupQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	capabilities, err := group.GetCapabilitiesAsJSON()
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(string(capabilities), group.Name)
+	return err
+}
+
+func sqlCommonDeleteGroup(group Group, dbHandle *sql.DB) error {
+	q := getDeleteGroupQuery()
+	stmt, err := dbHandle.Prepare(q)
+	if err != nil {
+		logger.Warn(logSender, "error preparing database query %v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(group.Name)
+	return err
+}
+
+func getGroupFromDbRow(row *sql.Row, rows *sql.Rows) (Group, error) {
+	var group Group
+	var capabilities sql.NullString
+	var err error
+	if row != nil {
+		err = row.Scan(&group.Name, &capabilities)
+	} else {
+		err = rows.Scan(&group.Name, &capabilities)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return group, &RecordNotFoundError{err: err.Error()}
+		}
+		return group, err
+	}
+	if capabilities.Valid {
+		var list []string
+		err = json.Unmarshal([]byte(capabilities.String), &list)
+		if err == nil {
+			group.Capabilities = list
+		}
+	}
+	return group, err
+}
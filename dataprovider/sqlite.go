@@ -90,3 +90,47 @@ func (p SQLiteProvider) deleteUser(user User) error {
 func (p SQLiteProvider) getUsers(limit int, offset int, order string, username string) ([]User, error) {
 	return sqlCommonGetUsers(limit, offset, order, username, p.dbHandle)
 }
+
+func (p SQLiteProvider) getTunnelOwner(name string) (string, error) {
+	return sqlCommonGetTunnelOwner(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) addTunnelOwner(name string, username string) error {
+	return sqlCommonAddTunnelOwner(name, username, p.dbHandle)
+}
+
+func (p SQLiteProvider) deleteTunnelOwner(name string) error {
+	return sqlCommonDeleteTunnelOwner(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateFolderQuota(mappedPath string, filesAdd int, sizeAdd int64, reset bool) error {
+	return sqlCommonUpdateFolderQuota(mappedPath, filesAdd, sizeAdd, reset, p.dbHandle)
+}
+
+func (p SQLiteProvider) getFolderQuota(mappedPath string) (FolderQuota, error) {
+	return sqlCommonGetFolderQuota(mappedPath, p.dbHandle)
+}
+
+func (p SQLiteProvider) getFolderQuotas() ([]FolderQuota, error) {
+	return sqlCommonGetFolderQuotas(p.dbHandle)
+}
+
+func (p SQLiteProvider) addGroup(group Group) error {
+	return sqlCommonAddGroup(group, p.dbHandle)
+}
+
+func (p SQLiteProvider) updateGroup(group Group) error {
+	return sqlCommonUpdateGroup(group, p.dbHandle)
+}
+
+func (p SQLiteProvider) deleteGroup(group Group) error {
+	return sqlCommonDeleteGroup(group, p.dbHandle)
+}
+
+func (p SQLiteProvider) getGroup(name string) (Group, error) {
+	return sqlCommonGetGroup(name, p.dbHandle)
+}
+
+func (p SQLiteProvider) getGroups() ([]Group, error) {
+	return sqlCommonGetGroups(p.dbHandle)
+}
@@ -3,8 +3,12 @@ package dataprovider
 import "fmt"
 
 const (
+	tunnelsTable     = "tunnels"
+	foldersTable     = "folders"
+	groupsTable      = "user_groups"
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions," +
-		"used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth"
+		"used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,max_session_duration,capabilities," +
+		"member_of,filesystem,virtual_folders,dir_permissions,file_patterns"
 )
 
 func getSQLPlaceholders() []string {
@@ -52,19 +56,83 @@ func getQuotaQuery() string {
 
 func getAddUserQuery() string {
 	return fmt.Sprintf(`INSERT INTO %v (username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,
-		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth) 
-		VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v)`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1],
+		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,max_session_duration,capabilities,member_of,
+		filesystem,virtual_folders,dir_permissions,file_patterns) VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v,%v,%v,%v,%v,%v,%v,%v)`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
-		sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11])
+		sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13],
+		sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18])
 }
 
 func getUpdateUserQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET password=%v,public_keys=%v,home_dir=%v,uid=%v,gid=%v,max_sessions=%v,quota_size=%v,
-		quota_files=%v,permissions=%v,upload_bandwidth=%v,download_bandwidth=%v WHERE id = %v`, config.UsersTable,
-		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
-		sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11])
+		quota_files=%v,permissions=%v,upload_bandwidth=%v,download_bandwidth=%v,max_session_duration=%v,capabilities=%v,
+		member_of=%v,filesystem=%v,virtual_folders=%v,dir_permissions=%v,file_patterns=%v WHERE id = %v`, config.UsersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
+		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14],
+		sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18])
 }
 
 func getDeleteUserQuery() string {
 	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, config.UsersTable, sqlPlaceholders[0])
 }
+
+func getTunnelOwnerQuery() string {
+	return fmt.Sprintf(`SELECT username FROM %v WHERE name = %v`, tunnelsTable, sqlPlaceholders[0])
+}
+
+func getAddTunnelOwnerQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (name,username,created_at) VALUES (%v,%v,%v)`, tunnelsTable, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeleteTunnelOwnerQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE name = %v`, tunnelsTable, sqlPlaceholders[0])
+}
+
+func getDeleteUserTunnelsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE username = %v`, tunnelsTable, sqlPlaceholders[0])
+}
+
+func getUpdateFolderQuotaQuery(reset bool) string {
+	if reset {
+		return fmt.Sprintf(`UPDATE %v SET used_quota_size = %v,used_quota_files = %v,last_quota_update = %v 
+			WHERE mapped_path = %v`, foldersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	}
+	return fmt.Sprintf(`UPDATE %v SET used_quota_size = used_quota_size + %v,used_quota_files = used_quota_files + %v,last_quota_update = %v 
+		WHERE mapped_path = %v`, foldersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getAddFolderQuotaQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (used_quota_size,used_quota_files,last_quota_update,mapped_path) VALUES (%v,%v,%v,%v)`,
+		foldersTable, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getFolderQuotaQuery() string {
+	return fmt.Sprintf(`SELECT mapped_path,used_quota_size,used_quota_files,last_quota_update FROM %v WHERE mapped_path = %v`,
+		foldersTable, sqlPlaceholders[0])
+}
+
+func getFolderQuotasQuery() string {
+	return fmt.Sprintf(`SELECT mapped_path,used_quota_size,used_quota_files,last_quota_update FROM %v ORDER BY mapped_path`,
+		foldersTable)
+}
+
+func getGroupQuery() string {
+	return fmt.Sprintf(`SELECT name,capabilities FROM %v WHERE name = %v`, groupsTable, sqlPlaceholders[0])
+}
+
+func getGroupsQuery() string {
+	return fmt.Sprintf(`SELECT name,capabilities FROM %v ORDER BY name ASC`, groupsTable)
+}
+
+func getAddGroupQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (name,capabilities) VALUES (%v,%v)`, groupsTable, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getUpdateGroupQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET capabilities = %v WHERE name = %v`, groupsTable, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDeleteGroupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE name = %v`, groupsTable, sqlPlaceholders[0])
+}
@@ -2,9 +2,13 @@ package dataprovider
 
 import (
 	"encoding/json"
+	"fmt"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 )
 
 // Available permissions for SFTP users
@@ -25,11 +29,93 @@ const (
 	PermCreateDirs = "create_dirs"
 	// create symbolic links is allowed
 	PermCreateSymlinks = "create_symlinks"
+	// create hard links is allowed
+	PermCreateLinks = "create_links"
+	// changing file or directory permissions is allowed
+	PermChmod = "chmod"
+	// changing file or directory owner and group is allowed
+	PermChown = "chown"
+	// changing file or directory access and modification time is allowed
+	PermChtimes = "chtimes"
+	// reading and changing extended attributes and reading ACLs is allowed
+	PermXattrs = "xattrs"
 
+	// Deprecated: use CapShell and CapExec. Users stored without capabilities get them from this permission
 	PermShell = "shell"
+	// Deprecated: use CapLocalForward and CapRemoteForward. Users stored without capabilities get them
+	// from this permission
 	PermTCPForward = "tcpforward"
 )
 
+// Available capability profiles, they define what an user can do with an SSH connection
+const (
+	// SFTP subsystem is allowed
+	CapSFTP = "sftp"
+	// SCP is allowed, it must be enabled in the server configuration too
+	CapSCP = "scp"
+	// interactive shell is allowed
+	CapShell = "shell"
+	// commands execution is allowed
+	CapExec = "exec"
+	// local port forwarding (ssh -L) is allowed
+	CapLocalForward = "local-forward"
+	// remote port forwarding (ssh -R) is allowed
+	CapRemoteForward = "remote-forward"
+	// agent forwarding (ssh -A) is allowed. Shell and exec sessions run as the server system user,
+	// so the agent socket can be used by the sessions of other users too
+	CapAgent = "agent"
+)
+
+// Available storage backends
+const (
+	// files are stored on the local filesystem
+	LocalFilesystemProvider = "local"
+	// files are stored on HDFS
+	HDFSFilesystemProvider = "hdfs"
+)
+
+// Filesystem defines the storage backend for an user and its settings.
+// If the provider is empty the server default is used
+type Filesystem struct {
+	Provider   string                `json:"provider"`
+	HDFSConfig fsconfig.HdfsFsConfig `json:"hdfsconfig,omitempty"`
+}
+
+// PatternsFilter defines the file names allowed inside a directory and its subdirectories.
+// Patterns use the shell syntax supported by path.Match and they are case insensitive
+type PatternsFilter struct {
+	// absolute SFTP path, the longest path that contains the file is used
+	Path string `json:"path"`
+	// if not empty the file name must match at least one of these patterns
+	AllowedPatterns []string `json:"allowed_patterns,omitempty"`
+	// the file name must not match any of these patterns
+	DeniedPatterns []string `json:"denied_patterns,omitempty"`
+	// if true the files not allowed are hidden in directory listings too
+	HideDenied bool `json:"hide_denied"`
+}
+
+// getFileNameMatch returns the first pattern matching the given file name, or an empty string
+func getFileNameMatch(patterns []string, name string) string {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// IsFileAllowed returns true if the given file name is allowed by the patterns, otherwise the reason is returned too
+func (f *PatternsFilter) IsFileAllowed(name string) (bool, string) {
+	if pattern := getFileNameMatch(f.DeniedPatterns, name); pattern != "" {
+		return false, fmt.Sprintf("file name matches the denied pattern %#v for dir %#v", pattern, f.Path)
+	}
+	if len(f.AllowedPatterns) > 0 && getFileNameMatch(f.AllowedPatterns, name) == "" {
+		return false, fmt.Sprintf("file name does not match the allowed patterns %v for dir %#v", f.AllowedPatterns, f.Path)
+	}
+	return true, ""
+}
+
 // User defines an SFTP user
 type User struct {
 	// Database unique identifier
@@ -68,6 +154,21 @@ type User struct {
 	UploadBandwidth int64 `json:"upload_bandwidth"`
 	// Maximum download bandwidth as KB/s, 0 means unlimited
 	DownloadBandwidth int64 `json:"download_bandwidth"`
+	// Maximum duration of a connection as seconds, after that the connection is closed. 0 means unlimited
+	MaxSessionDuration int64 `json:"max_session_duration"`
+	// Granted capability profiles. If nil they are set from the legacy permissions
+	Capabilities []string `json:"capabilities"`
+	// Groups the user belongs to, their capabilities are added to the user's ones
+	Groups []string `json:"groups"`
+	// Storage backend for the user's files
+	FsConfig Filesystem `json:"filesystem"`
+	// Paths outside the home dir mapped inside the user's namespace
+	VirtualFolders []fsconfig.VirtualFolder `json:"virtual_folders"`
+	// Permissions for specific directories, the key is an SFTP path. The longest matching path
+	// overrides the user's permissions and the virtual folder permissions
+	DirPermissions map[string][]string `json:"dir_permissions"`
+	// File name patterns allowed or denied inside specific directories
+	FilePatterns []PatternsFilter `json:"file_patterns"`
 }
 
 // HasPerm returns true if the user has the given permission or any permission
@@ -78,6 +179,128 @@ func (u *User) HasPerm(permission string) bool {
 	return utils.IsStringInSlice(permission, u.Permissions)
 }
 
+// GetPermissionsForPath returns the permissions granted for the given SFTP path.
+// The longest matching path between the directory permissions and the virtual folders with their
+// own permissions is used, if none matches the user's permissions apply
+func (u *User) GetPermissionsForPath(sftpPath string) []string {
+	sftpPath = fsconfig.CleanSFTPPath(sftpPath)
+	permissions := u.Permissions
+	matchedLen := 0
+	if folder, ok := fsconfig.GetVirtualFolderForPath(u.VirtualFolders, sftpPath); ok && len(folder.Permissions) > 0 {
+		permissions = folder.Permissions
+		matchedLen = len(folder.VirtualPath)
+	}
+	for dir, perms := range u.DirPermissions {
+		if len(dir) >= matchedLen && fsconfig.IsVirtualPathInside(sftpPath, dir) {
+			permissions = perms
+			matchedLen = len(dir)
+		}
+	}
+	return permissions
+}
+
+// HasPermInPath returns true if the user has the given permission, or any permission, for the given SFTP path
+func (u *User) HasPermInPath(permission, sftpPath string) bool {
+	perms := u.GetPermissionsForPath(sftpPath)
+	if utils.IsStringInSlice(PermAny, perms) {
+		return true
+	}
+	return utils.IsStringInSlice(permission, perms)
+}
+
+// GetVirtualFolderForPath returns the virtual folder that contains the given SFTP path, if any
+func (u *User) GetVirtualFolderForPath(sftpPath string) (fsconfig.VirtualFolder, bool) {
+	return fsconfig.GetVirtualFolderForPath(u.VirtualFolders, sftpPath)
+}
+
+// GetVirtualFoldersAsJSON returns the virtual folders as json byte array
+func (u *User) GetVirtualFoldersAsJSON() ([]byte, error) {
+	return json.Marshal(u.VirtualFolders)
+}
+
+// HasCapability returns true if the user has the given capability
+func (u *User) HasCapability(capability string) bool {
+	return utils.IsStringInSlice(capability, u.Capabilities)
+}
+
+// SetLegacyCapabilities sets the capabilities, if they are not set yet, mapping the legacy permissions:
+// sftp and scp are always granted, shell grants shell and exec, tcpforward grants local and remote forwarding.
+// The "*" permission grants what the removed full_func setting allowed, agent forwarding excluded
+func (u *User) SetLegacyCapabilities() {
+	if u.Capabilities != nil {
+		return
+	}
+	u.Capabilities = []string{CapSFTP, CapSCP}
+	if utils.IsStringInSlice(PermAny, u.Permissions) {
+		u.Capabilities = append(u.Capabilities, CapShell, CapExec, CapLocalForward, CapRemoteForward)
+		return
+	}
+	if utils.IsStringInSlice(PermShell, u.Permissions) {
+		u.Capabilities = append(u.Capabilities, CapShell, CapExec)
+	}
+	if utils.IsStringInSlice(PermTCPForward, u.Permissions) {
+		u.Capabilities = append(u.Capabilities, CapLocalForward, CapRemoteForward)
+	}
+}
+
+// GetCapabilitiesAsJSON returns the capabilities as json byte array
+func (u *User) GetCapabilitiesAsJSON() ([]byte, error) {
+	return json.Marshal(u.Capabilities)
+}
+
+// GetGroupsAsJSON returns the groups as json byte array
+func (u *User) GetGroupsAsJSON() ([]byte, error) {
+	return json.Marshal(u.Groups)
+}
+
+// GetFsConfigAsJSON returns the filesystem configuration as json byte array
+func (u *User) GetFsConfigAsJSON() ([]byte, error) {
+	return json.Marshal(u.FsConfig)
+}
+
+// GetPatternsFilterForPath returns the patterns filter that applies to the given SFTP file path:
+// the filter with the longest path that contains the file
+func (u *User) GetPatternsFilterForPath(sftpPath string) (PatternsFilter, bool) {
+	var filter PatternsFilter
+	found := false
+	dir := path.Dir(fsconfig.CleanSFTPPath(sftpPath))
+	for _, f := range u.FilePatterns {
+		if fsconfig.IsVirtualPathInside(dir, f.Path) && (!found || len(f.Path) > len(filter.Path)) {
+			filter = f
+			found = true
+		}
+	}
+	return filter, found
+}
+
+// IsFileAllowed returns true if the file name of the given SFTP path is allowed by the user's file patterns,
+// otherwise the reason is returned too
+func (u *User) IsFileAllowed(sftpPath string) (bool, string) {
+	if filter, ok := u.GetPatternsFilterForPath(sftpPath); ok {
+		return filter.IsFileAllowed(path.Base(sftpPath))
+	}
+	return true, ""
+}
+
+// IsFileHidden returns true if the given SFTP path is not allowed and it must be hidden in directory listings
+func (u *User) IsFileHidden(sftpPath string) bool {
+	if filter, ok := u.GetPatternsFilterForPath(sftpPath); ok && filter.HideDenied {
+		allowed, _ := filter.IsFileAllowed(path.Base(sftpPath))
+		return !allowed
+	}
+	return false
+}
+
+// GetFilePatternsAsJSON returns the file patterns as json byte array
+func (u *User) GetFilePatternsAsJSON() ([]byte, error) {
+	return json.Marshal(u.FilePatterns)
+}
+
+// GetDirPermissionsAsJSON returns the directory permissions as json byte array
+func (u *User) GetDirPermissionsAsJSON() ([]byte, error) {
+	return json.Marshal(u.DirPermissions)
+}
+
 // GetPermissionsAsJSON returns the permissions as json byte array
 func (u *User) GetPermissionsAsJSON() ([]byte, error) {
 	return json.Marshal(u.Permissions)
@@ -109,6 +332,14 @@ func (u *User) GetHomeDir() string {
 	return filepath.Clean(u.HomeDir)
 }
 
+// GetRootDir returns the backend path used as the user's root: the HDFS base path if set, the home dir otherwise
+func (u *User) GetRootDir() string {
+	if u.FsConfig.Provider == HDFSFilesystemProvider && len(u.FsConfig.HDFSConfig.BasePath) > 0 {
+		return path.Clean(u.FsConfig.HDFSConfig.BasePath)
+	}
+	return u.GetHomeDir()
+}
+
 // HasQuotaRestrictions returns true if there is a quota restriction on number of files or size or both
 func (u *User) HasQuotaRestrictions() bool {
 	return u.QuotaFiles > 0 || u.QuotaSize > 0
@@ -0,0 +1,129 @@
+package hdfs
+
+import (
+	"os"
+	"strings"
+
+	hdfs "github.com/lulugyf/sshserv/hdfs/intrnl/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// AclEntry represents an entry of the access control list of a file or
+// directory.
+type AclEntry struct {
+	// Default is true for the default entries of a directory, which are
+	// inherited by the files and directories created inside it.
+	Default bool
+	// Type is one of user, group, mask or other.
+	Type string
+	// Name is the user or group name. It is empty for the entries of the owner,
+	// the owning group, the mask and the others.
+	Name string
+	// Perm contains the read, write and execute bits, from 0 to 7.
+	Perm os.FileMode
+}
+
+// String returns the entry in the format used by getfacl, for example
+// user:alice:rw- or default:group::r-x.
+func (e AclEntry) String() string {
+	s := e.Type + ":" + e.Name + ":" + permString(e.Perm)
+	if e.Default {
+		s = "default:" + s
+	}
+	return s
+}
+
+// AclStatus represents the access control list of a file or directory.
+type AclStatus struct {
+	Owner  string
+	Group  string
+	Sticky bool
+	// Permission contains the permission bits of the file. If the file has an
+	// extended ACL, the group bits are the mask.
+	Permission os.FileMode
+	// Entries contains the extended ACL entries, the entries of the owner, the
+	// owning group and the others are represented by Permission.
+	Entries []AclEntry
+}
+
+// GetAclStatus returns the access control list of the named file or
+// directory.
+func (c *Client) GetAclStatus(name string) (*AclStatus, error) {
+	req := &hdfs.GetAclStatusRequestProto{Src: proto.String(name)}
+	resp := &hdfs.GetAclStatusResponseProto{}
+
+	err := c.namenode.Execute("getAclStatus", req, resp)
+	if err != nil {
+		return nil, &os.PathError{"get acl", name, interpretException(err)}
+	}
+
+	result := resp.GetResult()
+	status := &AclStatus{
+		Owner:  result.GetOwner(),
+		Group:  result.GetGroup(),
+		Sticky: result.GetSticky(),
+	}
+	if result.Permission != nil {
+		status.Permission = os.FileMode(result.GetPermission().GetPerm()).Perm()
+	} else {
+		// older namenodes do not return the permission
+		info, err := c.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		status.Permission = info.Mode().Perm()
+	}
+	for _, entry := range result.GetEntries() {
+		status.Entries = append(status.Entries, AclEntry{
+			Default: entry.GetScope() == hdfs.AclEntryProto_DEFAULT,
+			Type:    strings.ToLower(entry.GetType().String()),
+			Name:    entry.GetName(),
+			Perm:    os.FileMode(entry.GetPermissions()),
+		})
+	}
+
+	return status, nil
+}
+
+// AllEntries returns the full access control list, the entries of the owner,
+// the owning group and the others included, in the order printed by getfacl.
+func (s *AclStatus) AllEntries() []AclEntry {
+	var access, defaults []AclEntry
+	extended := false
+	for _, entry := range s.Entries {
+		if entry.Default {
+			defaults = append(defaults, entry)
+		} else {
+			access = append(access, entry)
+			extended = true
+		}
+	}
+
+	entries := []AclEntry{{Type: "user", Perm: s.Permission >> 6 & 7}}
+	if extended {
+		// the owning group entry is one of the extended entries and the group
+		// bits of the permission are the mask
+		entries = append(entries, access...)
+		entries = append(entries, AclEntry{Type: "mask", Perm: s.Permission >> 3 & 7})
+	} else {
+		entries = append(entries, AclEntry{Type: "group", Perm: s.Permission >> 3 & 7})
+	}
+	entries = append(entries, AclEntry{Type: "other", Perm: s.Permission & 7})
+
+	return append(entries, defaults...)
+}
+
+func permString(perm os.FileMode) string {
+	b := []byte("---")
+	if perm&4 != 0 {
+		b[0] = 'r'
+	}
+	if perm&2 != 0 {
+		b[1] = 'w'
+	}
+	if perm&1 != 0 {
+		b[2] = 'x'
+	}
+
+	return string(b)
+}
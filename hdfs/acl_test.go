@@ -0,0 +1,63 @@
+package hdfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func aclStrings(entries []AclEntry) []string {
+	var s []string
+	for _, entry := range entries {
+		s = append(s, entry.String())
+	}
+
+	return s
+}
+
+func TestAclStatusAllEntries(t *testing.T) {
+	status := &AclStatus{Permission: 0750}
+	assert.Equal(t, []string{"user::rwx", "group::r-x", "other::---"}, aclStrings(status.AllEntries()))
+
+	status = &AclStatus{
+		Permission: 0770,
+		Entries: []AclEntry{
+			{Type: "user", Name: "alice", Perm: 6},
+			{Type: "group", Perm: 5},
+			{Default: true, Type: "user", Perm: 7},
+			{Default: true, Type: "group", Name: "staff", Perm: 4},
+		},
+	}
+	assert.Equal(t, []string{
+		"user::rwx",
+		"user:alice:rw-",
+		"group::r-x",
+		"mask::rwx",
+		"other::---",
+		"default:user::rwx",
+		"default:group:staff:r--",
+	}, aclStrings(status.AllEntries()))
+}
+
+func TestGetAclStatus(t *testing.T) {
+	client := getClient(t)
+
+	baleet(t, "/_test/aclstatus")
+	touch(t, "/_test/aclstatus")
+
+	status, err := client.GetAclStatus("/_test/aclstatus")
+	require.NoError(t, err)
+	assert.Equal(t, "gohdfs1", status.Owner)
+	assert.Equal(t, 0, len(status.Entries))
+	assert.Equal(t, "user::", aclStrings(status.AllEntries())[0][:6])
+}
+
+func TestGetAclStatusNonexistent(t *testing.T) {
+	client := getClient(t)
+
+	status, err := client.GetAclStatus("/_test/nonexistent")
+	assertPathError(t, err, "get acl", "/_test/nonexistent", os.ErrNotExist)
+	assert.Nil(t, status)
+}
@@ -60,6 +60,12 @@ type ClientOptions struct {
 	// unless kerberos authentication is enabled, in which case it will be
 	// determined from the provided credentials if empty.
 	User string
+	// RealUser specifies the user that authenticates and acts on behalf of User,
+	// using the Hadoop proxy user mechanism (doAs). It is only used with simple
+	// authentication: with kerberos the principal of KerberosClient is the real
+	// user, and User, if set, is impersonated. The real user must be allowed to
+	// impersonate User by the hadoop.proxyuser settings of the cluster.
+	RealUser string
 	// UseDatanodeHostname specifies whether the client should connect to the
 	// datanodes via hostname (which is useful in multi-homed setups) or IP
 	// address, which may be required if DNS isn't available.
@@ -184,6 +190,7 @@ func NewClient(options ClientOptions) (*Client, error) {
 		rpc.NamenodeConnectionOptions{
 			Addresses:                    options.Addresses,
 			User:                         options.User,
+			RealUser:                     options.RealUser,
 			DialFunc:                     options.NamenodeDialFunc,
 			KerberosClient:               options.KerberosClient,
 			KerberosServicePrincipleName: options.KerberosServicePrincipleName,
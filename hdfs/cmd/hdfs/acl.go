@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func getfacl(paths []string) {
+	expanded, client, err := getClientAndExpandedPaths(paths)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, p := range expanded {
+		acl, err := client.GetAclStatus(p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+
+		fmt.Println("# file:", p)
+		fmt.Println("# owner:", acl.Owner)
+		fmt.Println("# group:", acl.Group)
+		if acl.Sticky {
+			fmt.Println("# flags: --t")
+		}
+		for _, entry := range acl.AllEntries() {
+			fmt.Println(entry)
+		}
+		fmt.Println()
+	}
+}
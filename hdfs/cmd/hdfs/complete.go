@@ -21,6 +21,9 @@ var knownCommands = []string{
 	"tail",
 	"du",
 	"checksum",
+	"getfattr",
+	"setfattr",
+	"getfacl",
 	"get",
 	"getmerge",
 	"put",
@@ -36,6 +36,9 @@ Valid commands:
   tail [-n LINES | -c BYTES] SOURCE...
   du [-sh] FILE...
   checksum FILE...
+  getfattr [-n NAME] FILE...
+  setfattr -n NAME [-v VALUE] | -x NAME FILE...
+  getfacl FILE...
   get SOURCE [DEST]
   getmerge SOURCE DEST
   put SOURCE DEST
@@ -81,6 +84,14 @@ Valid commands:
 	dfOpts = getopt.New()
 	dfh    = dfOpts.Bool('h')
 
+	getfattrOpts = getopt.New()
+	getfattrn    = getfattrOpts.String('n', "")
+
+	setfattrOpts = getopt.New()
+	setfattrn    = setfattrOpts.String('n', "")
+	setfattrv    = setfattrOpts.String('v', "")
+	setfattrx    = setfattrOpts.String('x', "")
+
 	cachedClients map[string]*hdfs.Client = make(map[string]*hdfs.Client)
 	status                                = 0
 )
@@ -96,6 +107,8 @@ func init() {
 	duOpts.SetUsage(printHelp)
 	getmergeOpts.SetUsage(printHelp)
 	dfOpts.SetUsage(printHelp)
+	getfattrOpts.SetUsage(printHelp)
+	setfattrOpts.SetUsage(printHelp)
 }
 
 func main() {
@@ -139,6 +152,14 @@ func main() {
 		du(duOpts.Args(), *dus, *duh)
 	case "checksum":
 		checksum(argv[1:])
+	case "getfattr":
+		getfattrOpts.Parse(argv)
+		getfattr(getfattrOpts.Args(), *getfattrn)
+	case "setfattr":
+		setfattrOpts.Parse(argv)
+		setfattr(setfattrOpts.Args(), *setfattrn, *setfattrv, *setfattrx)
+	case "getfacl":
+		getfacl(argv[1:])
 	case "get":
 		get(argv[1:])
 	case "getmerge":
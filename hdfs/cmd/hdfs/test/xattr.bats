@@ -0,0 +1,41 @@
+#!/usr/bin/env bats
+
+load helper
+
+setup() {
+  $HDFS mkdir -p /_test_cmd/xattr
+  $HDFS touch /_test_cmd/xattr/a
+}
+
+@test "setfattr and getfattr" {
+  run $HDFS setfattr -n user.foo -v bar /_test_cmd/xattr/a
+  assert_success
+  assert_output ""
+
+  run $HDFS getfattr /_test_cmd/xattr/a
+  assert_success
+  assert_output <<OUT
+# file: /_test_cmd/xattr/a
+user.foo="bar"
+OUT
+
+  run $HDFS setfattr -x user.foo /_test_cmd/xattr/a
+  assert_success
+
+  run $HDFS getfattr -n user.foo /_test_cmd/xattr/a
+  assert_failure
+}
+
+@test "getfacl" {
+  $HDFS chmod 640 /_test_cmd/xattr/a
+
+  run $HDFS getfacl /_test_cmd/xattr/a
+  assert_success
+  assert_line 3 "user::rw-"
+  assert_line 4 "group::r--"
+  assert_line 5 "other::---"
+}
+
+teardown() {
+  $HDFS rm -r /_test_cmd/xattr
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+func getfattr(paths []string, name string) {
+	expanded, client, err := getClientAndExpandedPaths(paths)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, p := range expanded {
+		var xattrs map[string]string
+		if name != "" {
+			xattrs, err = client.GetXAttrs(p, name)
+		} else {
+			xattrs, err = client.ListXAttrs(p)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+
+		keys := make([]string, 0, len(xattrs))
+		for k := range xattrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Println("# file:", p)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, strconv.Quote(xattrs[k]))
+		}
+		fmt.Println()
+	}
+}
+
+func setfattr(paths []string, name, value, remove string) {
+	if (name == "") == (remove == "") {
+		printHelp()
+	}
+
+	expanded, client, err := getClientAndExpandedPaths(paths)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, p := range expanded {
+		if remove != "" {
+			err = client.RemoveXAttr(p, remove)
+		} else {
+			err = client.SetXAttr(p, name, value)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+}
@@ -6,10 +6,11 @@ import (
 )
 
 const (
-	fileNotFoundException      = "java.io.FileNotFoundException"
-	permissionDeniedException  = "org.apache.hadoop.security.AccessControlException"
-	pathIsNotEmptyDirException = "org.apache.hadoop.fs.PathIsNotEmptyDirectoryException"
-	fileAlreadyExistsException = "org.apache.hadoop.fs.fileAlreadyExistsException"
+	fileNotFoundException         = "java.io.FileNotFoundException"
+	permissionDeniedException     = "org.apache.hadoop.security.AccessControlException"
+	pathIsNotEmptyDirException    = "org.apache.hadoop.fs.PathIsNotEmptyDirectoryException"
+	fileAlreadyExistsException    = "org.apache.hadoop.fs.fileAlreadyExistsException"
+	unsupportedOperationException = "java.lang.UnsupportedOperationException"
 )
 
 // Error represents a remote java exception from an HDFS namenode or datanode.
@@ -41,6 +42,8 @@ func interpretException(err error) error {
 		return syscall.ENOTEMPTY
 	case fileAlreadyExistsException:
 		return os.ErrExist
+	case unsupportedOperationException:
+		return syscall.ENOTSUP
 	default:
 		return err
 	}
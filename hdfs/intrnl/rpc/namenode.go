@@ -35,6 +35,7 @@ type NamenodeConnection struct {
 	ClientID   []byte
 	ClientName string
 	User       string
+	RealUser   string
 
 	currentRequestID int32
 
@@ -61,6 +62,11 @@ type NamenodeConnectionOptions struct {
 	// unless kerberos authentication is enabled, in which case it will be
 	// determined from the provided credentials if empty.
 	User string
+	// RealUser specifies the user that authenticates and acts on behalf of User,
+	// using the Hadoop proxy user mechanism (doAs). It is only used with simple
+	// authentication: with kerberos the authenticated principal is the real user
+	// and User is impersonated if it differs from the principal.
+	RealUser string
 	// DialFunc is used to connect to the datanodes. If nil, then
 	// (&net.Dialer{}).DialContext is used.
 	DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
@@ -111,6 +117,7 @@ func NewNamenodeConnection(options NamenodeConnectionOptions) (*NamenodeConnecti
 		ClientID:   clientId,
 		ClientName: "go-hdfs-" + string(clientId),
 		User:       user,
+		RealUser:   options.RealUser,
 
 		kerberosClient:               options.KerberosClient,
 		kerberosServicePrincipleName: options.KerberosServicePrincipleName,
@@ -270,7 +277,11 @@ func (c *NamenodeConnection) doNamenodeHandshake() error {
 	}
 
 	rrh := newRPCRequestHeader(handshakeCallID, c.ClientID)
-	cc := newConnectionContext(c.User, c.kerberosRealm)
+	realUser := c.RealUser
+	if kerberos {
+		realUser = ""
+	}
+	cc := newConnectionContext(c.User, c.kerberosRealm, realUser)
 	packet, err := makeRPCPacket(rrh, cc)
 	if err != nil {
 		return err
@@ -331,15 +342,20 @@ func newRequestHeader(methodName string) *hadoop.RequestHeaderProto {
 	}
 }
 
-func newConnectionContext(user, kerberosRealm string) *hadoop.IpcConnectionContextProto {
+func newConnectionContext(user, kerberosRealm, realUser string) *hadoop.IpcConnectionContextProto {
 	if kerberosRealm != "" {
 		user = user + "@" + kerberosRealm
 	}
 
+	userInfo := &hadoop.UserInformationProto{
+		EffectiveUser: proto.String(user),
+	}
+	if realUser != "" && realUser != user {
+		userInfo.RealUser = proto.String(realUser)
+	}
+
 	return &hadoop.IpcConnectionContextProto{
-		UserInfo: &hadoop.UserInformationProto{
-			EffectiveUser: proto.String(user),
-		},
+		UserInfo: userInfo,
 		Protocol: proto.String(protocolClass),
 	}
 }
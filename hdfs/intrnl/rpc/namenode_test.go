@@ -0,0 +1,23 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionContextProxyUser(t *testing.T) {
+	cc := newConnectionContext("alice", "", "")
+	assert.Equal(t, "alice", cc.GetUserInfo().GetEffectiveUser())
+	assert.Nil(t, cc.GetUserInfo().RealUser)
+
+	cc = newConnectionContext("alice", "", "sftp")
+	assert.Equal(t, "alice", cc.GetUserInfo().GetEffectiveUser())
+	assert.Equal(t, "sftp", cc.GetUserInfo().GetRealUser())
+
+	cc = newConnectionContext("sftp", "", "sftp")
+	assert.Nil(t, cc.GetUserInfo().RealUser)
+
+	cc = newConnectionContext("sftp/host", "EXAMPLE.COM", "")
+	assert.Equal(t, "sftp/host@EXAMPLE.COM", cc.GetUserInfo().GetEffectiveUser())
+}
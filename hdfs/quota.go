@@ -0,0 +1,52 @@
+package hdfs
+
+import (
+	"os"
+
+	hdfs "github.com/lulugyf/sshserv/hdfs/intrnl/protocol/hadoop_hdfs"
+)
+
+// quotaReset is the HDFS value that removes a quota
+const quotaReset = -1
+
+// SetQuota sets the name and space quotas for the named directory. The name
+// quota is a hard limit on the number of files and directories inside the
+// directory, including the directory itself; the space quota is a hard limit
+// on the bytes used by the files, after replication. A quota that is zero or
+// negative is removed.
+//
+// This requires superuser privileges.
+func (c *Client) SetQuota(dir string, nameQuota, spaceQuota int64) error {
+	if nameQuota <= 0 {
+		nameQuota = quotaReset
+	}
+	if spaceQuota <= 0 {
+		spaceQuota = quotaReset
+	}
+	nsQuota := uint64(nameQuota)
+	ssQuota := uint64(spaceQuota)
+	req := &hdfs.SetQuotaRequestProto{
+		Path:              &dir,
+		NamespaceQuota:    &nsQuota,
+		StoragespaceQuota: &ssQuota,
+	}
+	resp := &hdfs.SetQuotaResponseProto{}
+
+	err := c.namenode.Execute("setQuota", req, resp)
+	if err != nil {
+		return &os.PathError{"set quota", dir, interpretException(err)}
+	}
+
+	return nil
+}
+
+// DefaultReplication returns the default replication factor of the cluster,
+// used for the files created without an explicit replication.
+func (c *Client) DefaultReplication() (int, error) {
+	defaults, err := c.fetchDefaults()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(defaults.GetReplication()), nil
+}
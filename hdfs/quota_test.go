@@ -0,0 +1,38 @@
+package hdfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetQuota(t *testing.T) {
+	c := getClientForSuperUser(t)
+	mkdirp(t, "/_test/quota")
+
+	err := c.SetQuota("/_test/quota", 10, 1048576)
+	require.NoError(t, err)
+
+	cs, err := c.GetContentSummary("/_test/quota")
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, cs.NameQuota())
+	assert.EqualValues(t, 1048576, cs.SpaceQuota())
+
+	err = c.SetQuota("/_test/quota", 0, 0)
+	require.NoError(t, err)
+
+	cs, err = c.GetContentSummary("/_test/quota")
+	require.NoError(t, err)
+	assert.EqualValues(t, -1, cs.NameQuota())
+	assert.EqualValues(t, -1, cs.SpaceQuota())
+}
+
+func TestSetQuotaWithoutPermission(t *testing.T) {
+	c := getClient(t)
+	mkdirp(t, "/_test/quota")
+
+	err := c.SetQuota("/_test/quota", 10, 0)
+	assertPathError(t, err, "set quota", "/_test/quota", os.ErrPermission)
+}
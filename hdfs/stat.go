@@ -44,6 +44,25 @@ func (c *Client) getFileInfo(name string) (os.FileInfo, error) {
 	return newFileInfo(resp.GetFs(), name), nil
 }
 
+// Lstat returns an os.FileInfo describing the named file or directory. If the
+// file is a symlink the returned FileInfo describes the symlink, the namenode
+// does not follow it.
+func (c *Client) Lstat(name string) (os.FileInfo, error) {
+	req := &hdfs.GetFileLinkInfoRequestProto{Src: proto.String(name)}
+	resp := &hdfs.GetFileLinkInfoResponseProto{}
+
+	err := c.namenode.Execute("getFileLinkInfo", req, resp)
+	if err != nil {
+		return nil, &os.PathError{"lstat", name, interpretException(err)}
+	}
+
+	if resp.GetFs() == nil {
+		return nil, &os.PathError{"lstat", name, os.ErrNotExist}
+	}
+
+	return newFileInfo(resp.GetFs(), name), nil
+}
+
 func newFileInfo(status *hdfs.HdfsFileStatusProto, name string) *FileInfo {
 	fi := &FileInfo{status: status}
 
@@ -70,6 +89,8 @@ func (fi *FileInfo) Mode() os.FileMode {
 	mode := os.FileMode(fi.status.GetPermission().GetPerm())
 	if fi.IsDir() {
 		mode |= os.ModeDir
+	} else if fi.IsSymlink() {
+		mode |= os.ModeSymlink
 	}
 
 	return mode
@@ -83,6 +104,18 @@ func (fi *FileInfo) IsDir() bool {
 	return fi.status.GetFileType() == hdfs.HdfsFileStatusProto_IS_DIR
 }
 
+// IsSymlink returns true if the file is a symlink, only FileInfo returned by
+// Lstat can describe a symlink. It's not part of the os.FileInfo interface.
+func (fi *FileInfo) IsSymlink() bool {
+	return fi.status.GetFileType() == hdfs.HdfsFileStatusProto_IS_SYMLINK
+}
+
+// SymlinkTarget returns the target of a symlink, or an empty string if the
+// file is not a symlink. It's not part of the os.FileInfo interface.
+func (fi *FileInfo) SymlinkTarget() string {
+	return string(fi.status.GetSymlink())
+}
+
 // Sys returns the raw *hadoop_hdfs.HdfsFileStatusProto message from the
 // namenode.
 func (fi *FileInfo) Sys() interface{} {
@@ -0,0 +1,28 @@
+package hdfs
+
+import (
+	"os"
+
+	hdfs "github.com/lulugyf/sshserv/hdfs/intrnl/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+)
+
+// CreateSymlink creates link as a symbolic link to target. Symlinks are
+// disabled by default on HDFS clusters, in this case the returned error wraps
+// syscall.ENOTSUP.
+func (c *Client) CreateSymlink(target, link string, createParent bool) error {
+	req := &hdfs.CreateSymlinkRequestProto{
+		Target:       proto.String(target),
+		Link:         proto.String(link),
+		DirPerm:      &hdfs.FsPermissionProto{Perm: proto.Uint32(uint32(0755))},
+		CreateParent: proto.Bool(createParent),
+	}
+	resp := &hdfs.CreateSymlinkResponseProto{}
+
+	err := c.namenode.Execute("createSymlink", req, resp)
+	if err != nil {
+		return &os.PathError{"symlink", link, interpretException(err)}
+	}
+
+	return nil
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-var errXAttrKeysNotFound = errors.New("one or more keys not found")
+// ErrXAttrNotFound is returned getting or removing an extended attribute that
+// does not exist.
+var ErrXAttrNotFound = errors.New("one or more keys not found")
 
 const createAndReplace = 3
 
@@ -52,7 +54,7 @@ func (c *Client) GetXAttrs(name string, keys ...string) (map[string]string, erro
 	err := c.namenode.Execute("getXAttrs", req, resp)
 	if err != nil {
 		if isKeyNotFound(err) {
-			return nil, &os.PathError{"get xattrs", name, errXAttrKeysNotFound}
+			return nil, &os.PathError{"get xattrs", name, ErrXAttrNotFound}
 		}
 
 		return nil, &os.PathError{"get xattrs", name, interpretException(err)}
@@ -109,7 +111,7 @@ func (c *Client) RemoveXAttr(name, key string) error {
 	err = c.namenode.Execute("removeXAttr", req, resp)
 	if err != nil {
 		if isKeyNotFound(err) {
-			return &os.PathError{"remove xattr", name, errXAttrKeysNotFound}
+			return &os.PathError{"remove xattr", name, ErrXAttrNotFound}
 		}
 
 		return &os.PathError{"remove xattr", name, interpretException(err)}
@@ -142,7 +142,7 @@ func TestGetXAttrsNonexistentKeys(t *testing.T) {
 	require.NoError(t, err)
 
 	xattrs, err := client.GetXAttrs("/_test/xattributes", "user.baz")
-	assertPathError(t, err, "get xattrs", "/_test/xattributes", errXAttrKeysNotFound)
+	assertPathError(t, err, "get xattrs", "/_test/xattributes", ErrXAttrNotFound)
 	assert.Nil(t, xattrs)
 }
 
@@ -234,7 +234,7 @@ func TestRemoveXAttrsEmpty(t *testing.T) {
 	touch(t, "/_test/xattributes")
 
 	err := client.RemoveXAttr("/_test/xattributes", "user.foo")
-	assertPathError(t, err, "remove xattr", "/_test/xattributes", errXAttrKeysNotFound)
+	assertPathError(t, err, "remove xattr", "/_test/xattributes", ErrXAttrNotFound)
 }
 
 func TestRemoveXAttrsNoMatchingKey(t *testing.T) {
@@ -247,7 +247,7 @@ func TestRemoveXAttrsNoMatchingKey(t *testing.T) {
 	require.NoError(t, err)
 
 	err = client.RemoveXAttr("/_test/xattributes", "user.bar")
-	assertPathError(t, err, "remove xattr", "/_test/xattributes", errXAttrKeysNotFound)
+	assertPathError(t, err, "remove xattr", "/_test/xattributes", ErrXAttrNotFound)
 }
 
 func TestRemoveXAttrsWithoutPermission(t *testing.T) {
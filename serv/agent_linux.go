@@ -0,0 +1,179 @@
+// +build linux
+
+package serv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lulugyf/sshserv/logger"
+	"golang.org/x/crypto/ssh"
+)
+
+// agentPeerTimeout is how long a connection to the agent socket waits for the session process to be
+// registered, a process started by the session can connect before its start is notified
+const agentPeerTimeout = 2 * time.Second
+
+// agentForwarding listens on a unix socket and forwards each connection to the agent
+// of the SSH client using an auth-agent@openssh.com channel.
+// The socket path is exported as SSH_AUTH_SOCK to the shell and exec sessions.
+// Shell and exec sessions have no per-user credentials, they all run as the server user, so the
+// socket permissions cannot keep out the sessions of the other users: the connecting process must
+// belong to the process session of one of the shell or exec processes started for this channel
+type agentForwarding struct {
+	dir      string
+	listener net.Listener
+	sync.Mutex
+	sessions []int
+	added    chan struct{}
+}
+
+func startAgentForwarding(connection Connection) (*agentForwarding, error) {
+	// a new 0700 dir for each channel
+	dir, err := ioutil.TempDir("", "sshserv-agent-")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	a := &agentForwarding{
+		dir:      dir,
+		listener: listener,
+		added:    make(chan struct{}),
+	}
+	logger.Debug(logShell, "agent forwarding started for user %v, socket: %v", connection.User.Username,
+		listener.Addr().String())
+	go a.serve(connection)
+	return a, nil
+}
+
+// addSession allows the processes inside the process session started by pid to use the agent.
+// The shell and exec processes are started with setsid, so their pid is the session id
+func (a *agentForwarding) addSession(pid int) {
+	a.Lock()
+	defer a.Unlock()
+	a.sessions = append(a.sessions, pid)
+	close(a.added)
+	a.added = make(chan struct{})
+}
+
+func (a *agentForwarding) isSessionAllowed(sid int) bool {
+	timeout := time.After(agentPeerTimeout)
+	for {
+		a.Lock()
+		added := a.added
+		for _, s := range a.sessions {
+			if s == sid {
+				a.Unlock()
+				return true
+			}
+		}
+		a.Unlock()
+		select {
+		case <-added:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func (a *agentForwarding) checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix socket")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	sid, err := getProcessSessionID(int(cred.Pid))
+	if err != nil {
+		return err
+	}
+	if !a.isSessionAllowed(sid) {
+		return fmt.Errorf("process %v, session %v, is not started by this channel", cred.Pid, sid)
+	}
+	return nil
+}
+
+func (a *agentForwarding) serve(connection Connection) {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := a.checkPeer(conn); err != nil {
+				logger.Warn(logShell, "agent connection refused for user %v: %v", connection.User.Username, err)
+				conn.Close()
+				return
+			}
+			channel, reqs, err := connection.sshConn.OpenChannel("auth-agent@openssh.com", nil)
+			if err != nil {
+				logger.Warn(logShell, "unable to open agent channel for user %v: %v", connection.User.Username, err)
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			ch := newActiveChannel(channel, connection.ID, channelTypeAgent, "")
+			go func() {
+				defer ch.Close()
+				defer conn.Close()
+				io.Copy(ch, conn)
+			}()
+			go func() {
+				defer ch.Close()
+				defer conn.Close()
+				io.Copy(conn, ch)
+			}()
+		}()
+	}
+}
+
+func (a *agentForwarding) env() string {
+	return "SSH_AUTH_SOCK=" + a.listener.Addr().String()
+}
+
+func (a *agentForwarding) close() {
+	a.listener.Close()
+	os.RemoveAll(a.dir)
+}
+
+// getProcessSessionID returns the session id of the given process reading /proc/<pid>/stat
+func getProcessSessionID(pid int) (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name can contain spaces and parentheses, the fields after it are: state ppid pgrp session
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("unable to parse the stat of process %v", pid)
+	}
+	return strconv.Atoi(fields[3])
+}
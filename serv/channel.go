@@ -0,0 +1,80 @@
+package serv
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	channelTypeSession        = "session"
+	channelTypeSFTP           = "sftp"
+	channelTypeSCP            = "scp"
+	channelTypeShell          = "shell"
+	channelTypeExec           = "exec"
+	channelTypeDirectTCPIP    = "direct-tcpip"
+	channelTypeForwardedTCPIP = "forwarded-tcpip"
+	channelTypeAgent          = "auth-agent"
+)
+
+// activeChannel wraps an SSH channel and tracks its traffic.
+// It is registered as active until it is closed or released
+type activeChannel struct {
+	ssh.Channel
+	connectionID string
+	channelType  string
+	info         string
+	start        time.Time
+	// last read or write as unix nanoseconds, accessed atomically
+	lastActivity int64
+	releaseOnce  sync.Once
+}
+
+func newActiveChannel(channel ssh.Channel, connectionID, channelType, info string) *activeChannel {
+	now := time.Now()
+	c := &activeChannel{
+		Channel:      channel,
+		connectionID: connectionID,
+		channelType:  channelType,
+		info:         info,
+		start:        now,
+		lastActivity: now.UnixNano(),
+	}
+	addChannel(c)
+	return c
+}
+
+func (c *activeChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *activeChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *activeChannel) Close() error {
+	err := c.Channel.Close()
+	c.release()
+	return err
+}
+
+// release removes the channel from the active ones without closing it
+func (c *activeChannel) release() {
+	c.releaseOnce.Do(func() {
+		removeChannel(c)
+	})
+}
+
+func (c *activeChannel) getLastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+}
@@ -0,0 +1,154 @@
+package serv
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+// number of entries read from the backend for each batch
+const dirListBatchSize = 1000
+
+// dirListerAt streams a directory listing: the entries are read from the backend in batches
+// when the client requests them, so a large directory is never loaded in memory.
+// The entries already returned are kept until the next batch is read, so a client can request
+// an offset again, for example after a timeout.
+// The virtual dirs are returned first and the files hidden by the user's file patterns are skipped
+type dirListerAt struct {
+	mu          sync.Mutex
+	conn        Connection
+	fsPath      string
+	sftpPath    string
+	lister      vfs.DirLister
+	virtualDirs []string
+	// buffered entries, the first one is at offset start
+	entries    []os.FileInfo
+	start      int64
+	maxEntries int
+	batchSize  int
+	eof        bool
+}
+
+func newDirListerAt(conn Connection, fsPath string, lister vfs.DirLister, maxEntries int) *dirListerAt {
+	sftpPath := conn.fs.GetRelativePath(fsPath)
+	l := &dirListerAt{
+		conn:        conn,
+		fsPath:      fsPath,
+		sftpPath:    sftpPath,
+		lister:      lister,
+		virtualDirs: fsconfig.GetVirtualDirsForPath(conn.User.VirtualFolders, sftpPath),
+		maxEntries:  maxEntries,
+		batchSize:   dirListBatchSize,
+		eof:         lister == nil,
+	}
+	for _, name := range l.virtualDirs {
+		l.entries = append(l.entries, vfs.NewVirtualDirInfo(name))
+	}
+	return l
+}
+
+// ListAt returns the entries starting at the given offset. The buffered offsets are served
+// from memory, an offset after them is reached reading forward, an offset before them fails
+// since the backend listing cannot seek backwards
+func (l *dirListerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset < l.start {
+		logger.Warn(logSender, "offset %v for dir listing %v was already discarded, first buffered offset: %v",
+			offset, l.fsPath, l.start)
+		return 0, sftp.ErrSshFxFailure
+	}
+	limit := len(f)
+	if l.maxEntries > 0 && offset+int64(limit) > int64(l.maxEntries) {
+		limit = int(int64(l.maxEntries) - offset)
+		if limit < 0 {
+			limit = 0
+		}
+	}
+
+	var err error
+	for limit > 0 && offset+int64(limit) > l.start+int64(len(l.entries)) && !l.eof {
+		if err = l.readBatch(offset); err != nil {
+			break
+		}
+	}
+	n := 0
+	for i := offset - l.start; n < limit && i < int64(len(l.entries)); i++ {
+		f[n] = &sftpFileInfo{l.entries[i]}
+		n++
+	}
+	if err != nil {
+		return n, err
+	}
+	if l.maxEntries > 0 && offset+int64(n) >= int64(l.maxEntries) &&
+		(!l.eof || l.start+int64(len(l.entries)) > int64(l.maxEntries)) {
+		logger.Warn(logSender, "listing for dir %v truncated to %v entries, user: %v", l.fsPath,
+			l.maxEntries, l.conn.User.Username)
+		if keep := int(int64(l.maxEntries) - l.start); keep < len(l.entries) {
+			l.entries = l.entries[:keep]
+		}
+		l.closeLister()
+	}
+	if n < len(f) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readBatch discards the entries before offset and appends the next batch read from the backend
+func (l *dirListerAt) readBatch(offset int64) error {
+	if discard := offset - l.start; discard > 0 {
+		if discard > int64(len(l.entries)) {
+			discard = int64(len(l.entries))
+		}
+		l.entries = append([]os.FileInfo(nil), l.entries[discard:]...)
+		l.start += discard
+	}
+	files, err := l.lister.Readdir(l.batchSize)
+	if err == io.EOF || (err == nil && len(files) == 0) {
+		l.closeLister()
+		return nil
+	}
+	if err != nil {
+		logger.Error(logSender, "error listing directory %v: %v", l.fsPath, err)
+		l.closeLister()
+		return sftp.ErrSshFxFailure
+	}
+	checkHidden := len(l.conn.User.FilePatterns) > 0
+	for _, fi := range files {
+		// a virtual dir hides the backend entry with the same name
+		if utils.IsStringInSlice(fi.Name(), l.virtualDirs) {
+			continue
+		}
+		if checkHidden && !fi.IsDir() && l.conn.User.IsFileHidden(path.Join(l.sftpPath, fi.Name())) {
+			continue
+		}
+		l.entries = append(l.entries, fi)
+	}
+	return nil
+}
+
+func (l *dirListerAt) closeLister() {
+	l.eof = true
+	if l.lister != nil {
+		l.lister.Close()
+		l.lister = nil
+	}
+}
+
+// Close releases the directory handle, it is called when the client closes the listing handle
+func (l *dirListerAt) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+	l.closeLister()
+	return nil
+}
@@ -0,0 +1,86 @@
+package serv
+
+import (
+	"sync"
+	"time"
+)
+
+// dirUsageCacheTTL is how long the usage reported by a backend that implements vfs.DirUsager is used
+// for the quota checks, meanwhile it is updated by the uploads and deletes done by this server.
+// Once expired the backend is asked again, so the changes done without using this server are counted too
+const dirUsageCacheTTL = 30 * time.Second
+
+type cachedDirUsage struct {
+	numFiles int
+	size     int64
+	expires  time.Time
+}
+
+var (
+	dirUsages      = make(map[string]cachedDirUsage)
+	dirUsagesMutex sync.Mutex
+)
+
+// getUserDirUsageKey returns the cache key for the usage counted against the user's quota
+func getUserDirUsageKey(username string) string {
+	return "user:" + username
+}
+
+// getFolderDirUsageKey returns the cache key for the usage of a virtual folder with its own quota,
+// a mapped path can be shared by several users so the usage is cached by mapped path
+func getFolderDirUsageKey(mappedPath string) string {
+	return "folder:" + mappedPath
+}
+
+// getCachedDirUsage returns the cached usage for the given key, getUsage is called if the usage
+// is not cached or if it is expired
+func getCachedDirUsage(key string, getUsage func() (int, int64, error)) (int, int64, error) {
+	now := time.Now()
+	dirUsagesMutex.Lock()
+	usage, ok := dirUsages[key]
+	dirUsagesMutex.Unlock()
+	if ok && now.Before(usage.expires) {
+		return usage.numFiles, usage.size, nil
+	}
+	numFiles, size, err := getUsage()
+	if err != nil {
+		return numFiles, size, err
+	}
+	setCachedDirUsage(key, numFiles, size)
+	return numFiles, size, nil
+}
+
+// setCachedDirUsage stores the usage for the given key, the expired usages are removed
+func setCachedDirUsage(key string, numFiles int, size int64) {
+	now := time.Now()
+	dirUsagesMutex.Lock()
+	defer dirUsagesMutex.Unlock()
+	for k, usage := range dirUsages {
+		if !now.Before(usage.expires) {
+			delete(dirUsages, k)
+		}
+	}
+	dirUsages[key] = cachedDirUsage{
+		numFiles: numFiles,
+		size:     size,
+		expires:  now.Add(dirUsageCacheTTL),
+	}
+}
+
+// updateCachedDirUsage adds filesAdd and sizeAdd to the cached usage for the given key, if any
+func updateCachedDirUsage(key string, filesAdd int, sizeAdd int64) {
+	dirUsagesMutex.Lock()
+	defer dirUsagesMutex.Unlock()
+	if usage, ok := dirUsages[key]; ok {
+		usage.numFiles += filesAdd
+		usage.size += sizeAdd
+		dirUsages[key] = usage
+	}
+}
+
+// removeCachedDirUsage removes the cached usage for the given key, the backend will be asked again
+func removeCachedDirUsage(key string) {
+	dirUsagesMutex.Lock()
+	defer dirUsagesMutex.Unlock()
+	delete(dirUsages, key)
+}
@@ -0,0 +1,432 @@
+package serv
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lulugyf/sshserv/logger"
+)
+
+const (
+	egressActionDirect = "direct"
+	egressActionProxy  = "proxy"
+	egressActionDeny   = "deny"
+	egressProxySOCKS5  = "socks5"
+	egressProxyHTTP    = "http"
+
+	defaultEgressConnectTimeout = 15
+)
+
+var errEgressDenied = errors.New("destination denied by egress rules")
+
+// EgressConf defines how outbound connections for local port forwarding (-L) are made.
+// Each destination is matched against Rules in order, the first matching rule wins.
+// If no rule matches DefaultAction is used
+type EgressConf struct {
+	// Connect timeout as seconds. 0 means the default (15 seconds)
+	ConnectTimeout int `json:"connect_timeout" mapstructure:"connect_timeout"`
+	// Action for destinations not matched by any rule: direct, proxy or deny. Empty means direct
+	DefaultAction string `json:"default_action" mapstructure:"default_action"`
+	// Proxy to use for the default action, if it is proxy
+	DefaultProxy string `json:"default_proxy" mapstructure:"default_proxy"`
+	// Available upstream proxies
+	Proxies []EgressProxy `json:"proxies" mapstructure:"proxies"`
+	// Per destination rules
+	Rules []EgressRule `json:"rules" mapstructure:"rules"`
+}
+
+// EgressProxy defines an upstream SOCKS5 or HTTP CONNECT proxy
+type EgressProxy struct {
+	// Unique name, referenced by rules
+	Name string `json:"name" mapstructure:"name"`
+	// socks5 or http
+	Type string `json:"type" mapstructure:"type"`
+	// Proxy address as host:port
+	Address string `json:"address" mapstructure:"address"`
+	// Optional credentials: username/password authentication for SOCKS5, basic auth for HTTP CONNECT
+	Username string `json:"username" mapstructure:"username"`
+	Password string `json:"password" mapstructure:"password"`
+}
+
+// EgressRule maps destinations to an action.
+// Destination can be "*", a CIDR such as "10.0.0.0/8", an IP or a shell pattern such as "*.example.com".
+// It can be followed by ":port" to restrict the rule to a single port, for example "db.local:5432".
+// CIDR and IP rules are matched against every address a host name resolves to as well, the name is
+// resolved locally only if such a rule comes before the first host name rule matching it
+type EgressRule struct {
+	Destination string `json:"destination" mapstructure:"destination"`
+	// direct, proxy or deny
+	Action string `json:"action" mapstructure:"action"`
+	// Proxy name, required if action is proxy
+	Proxy string `json:"proxy" mapstructure:"proxy"`
+}
+
+type egressDialer struct {
+	conf    EgressConf
+	proxies map[string]EgressProxy
+	timeout time.Duration
+}
+
+func newEgressDialer(conf EgressConf) (*egressDialer, error) {
+	d := &egressDialer{
+		conf:    conf,
+		proxies: make(map[string]EgressProxy),
+		timeout: time.Duration(conf.ConnectTimeout) * time.Second,
+	}
+	if d.timeout <= 0 {
+		d.timeout = defaultEgressConnectTimeout * time.Second
+	}
+	for _, p := range conf.Proxies {
+		if p.Type != egressProxySOCKS5 && p.Type != egressProxyHTTP {
+			return nil, fmt.Errorf("invalid type %#v for egress proxy %#v", p.Type, p.Name)
+		}
+		if _, _, err := net.SplitHostPort(p.Address); err != nil {
+			return nil, fmt.Errorf("invalid address for egress proxy %#v: %v", p.Name, err)
+		}
+		d.proxies[p.Name] = p
+	}
+	if err := d.checkAction(conf.DefaultAction, conf.DefaultProxy); err != nil {
+		return nil, err
+	}
+	for _, r := range conf.Rules {
+		if r.Destination == "" {
+			return nil, errors.New("egress rule with empty destination")
+		}
+		if err := d.checkAction(r.Action, r.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *egressDialer) checkAction(action, proxyName string) error {
+	switch action {
+	case "", egressActionDirect, egressActionDeny:
+		return nil
+	case egressActionProxy:
+		if _, ok := d.proxies[proxyName]; !ok {
+			return fmt.Errorf("egress proxy %#v is not defined", proxyName)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid egress action %#v", action)
+}
+
+// resolve returns the action and the proxy, if any, to use for the given destination.
+// ip is the address host resolves to, nil if the host name was not resolved
+func (d *egressDialer) resolve(host string, ip net.IP, port int) (string, *EgressProxy) {
+	action, proxyName := d.conf.DefaultAction, d.conf.DefaultProxy
+	for _, r := range d.conf.Rules {
+		if matchEgressDestination(r.Destination, host, port) ||
+			ip != nil && matchEgressDestination(r.Destination, ip.String(), port) {
+			action, proxyName = r.Action, r.Proxy
+			break
+		}
+	}
+	if action == "" {
+		action = egressActionDirect
+	}
+	if action == egressActionProxy {
+		p := d.proxies[proxyName]
+		return action, &p
+	}
+	return action, nil
+}
+
+// lookup returns the addresses host resolves to
+func (d *egressDialer) lookup(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// needsAddresses returns true if the addresses host resolves to must be checked: an IP or CIDR
+// rule comes before the first host name rule matching it
+func (d *egressDialer) needsAddresses(host string, port int) bool {
+	if net.ParseIP(host) != nil {
+		return false
+	}
+	for _, r := range d.conf.Rules {
+		if isEgressAddressPattern(r.Destination) {
+			return true
+		}
+		if matchEgressDestination(r.Destination, host, port) {
+			return false
+		}
+	}
+	return false
+}
+
+// Dial connects to the given destination (host:port) according to the configured rules.
+// A host name is resolved locally only if an IP or CIDR rule must be tested: the destination is
+// denied if any of its addresses is denied. The checked addresses are dialed for direct routes,
+// so the name cannot resolve to a different address between the check and the dial, while the
+// proxies get the original host name
+func (d *egressDialer) Dial(dest string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	if !d.needsAddresses(host, port) {
+		action, proxy := d.resolve(host, nil, port)
+		return d.dialAction(action, proxy, []string{dest})
+	}
+	ips, err := d.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	var action string
+	var proxy *EgressProxy
+	var addrs []string
+	for _, ip := range ips {
+		ipAction, ipProxy := d.resolve(host, ip, port)
+		if ipAction == egressActionDeny {
+			logger.Debug(logLforward, "dial %v denied, address %v is denied", dest, ip)
+			return nil, errEgressDenied
+		}
+		if addrs == nil {
+			action, proxy = ipAction, ipProxy
+		} else if ipAction != action || ipProxy != nil && ipProxy.Name != proxy.Name {
+			// addresses with a different route are skipped
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), portStr))
+	}
+	if action == egressActionProxy {
+		addrs = []string{dest}
+	}
+	return d.dialAction(action, proxy, addrs)
+}
+
+// dialAction dials the given addresses, in order, until a connection succeeds
+func (d *egressDialer) dialAction(action string, proxy *EgressProxy, addrs []string) (net.Conn, error) {
+	if action == egressActionDeny {
+		return nil, errEgressDenied
+	}
+	err := errors.New("no address to dial")
+	for _, addr := range addrs {
+		var conn net.Conn
+		if action == egressActionProxy {
+			logger.Debug(logLforward, "dial %v using %v proxy %#v", addr, proxy.Type, proxy.Name)
+			conn, err = d.dialProxy(proxy, addr)
+		} else {
+			conn, err = net.DialTimeout("tcp", addr, d.timeout)
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (d *egressDialer) dialProxy(p *EgressProxy, dest string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.Address, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	// the deadline covers the proxy handshake too
+	conn.SetDeadline(time.Now().Add(d.timeout))
+	if p.Type == egressProxySOCKS5 {
+		err = socks5Connect(conn, p, dest)
+	} else {
+		err = httpConnect(conn, p, dest)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %#v: %v", p.Name, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// isEgressAddressPattern returns true if the rule destination is a CIDR or an IP
+func isEgressAddressPattern(pattern string) bool {
+	if h, _, err := net.SplitHostPort(pattern); err == nil {
+		pattern = h
+	}
+	if _, _, err := net.ParseCIDR(pattern); err == nil {
+		return true
+	}
+	return net.ParseIP(pattern) != nil
+}
+
+func matchEgressDestination(pattern, host string, port int) bool {
+	if pattern == "*" {
+		return true
+	}
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		if p != strconv.Itoa(port) {
+			return false
+		}
+		pattern = h
+	}
+	if pattern == "*" {
+		return true
+	}
+	if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ipNet.Contains(ip)
+	}
+	if ip := net.ParseIP(pattern); ip != nil {
+		return ip.Equal(net.ParseIP(host))
+	}
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(host))
+	return err == nil && matched
+}
+
+// socks5Connect performs a SOCKS5 handshake (RFC 1928, RFC 1929) and a CONNECT request on conn
+func socks5Connect(conn net.Conn, p *EgressProxy, dest string) error {
+	host, portStr, _ := net.SplitHostPort(dest)
+	port, _ := strconv.Atoi(portStr)
+
+	methods := []byte{0x00}
+	if p.Username != "" {
+		methods = []byte{0x02}
+	}
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if resp[0] != 0x05 {
+		return fmt.Errorf("unexpected SOCKS version %v", resp[0])
+	}
+	switch resp[1] {
+	case 0x00:
+	case 0x02:
+		if len(p.Username) > 255 || len(p.Password) > 255 {
+			return errors.New("SOCKS5 credentials too long")
+		}
+		auth := []byte{0x01, byte(len(p.Username))}
+		auth = append(auth, p.Username...)
+		auth = append(auth, byte(len(p.Password)))
+		auth = append(auth, p.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return err
+		}
+		// the username/password subnegotiation has its own version
+		if resp[0] != 0x01 {
+			return fmt.Errorf("unexpected SOCKS5 authentication version %v", resp[0])
+		}
+		if resp[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	default:
+		return errors.New("no acceptable SOCKS5 authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("destination host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
+	req = append(req, portBytes...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 connect failed, reply code: %v", header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return err
+		}
+		addrLen = int(l[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %v", header[3])
+	}
+	// bound address and port, we don't need them
+	_, err := io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// httpConnect issues an HTTP CONNECT request on conn
+func httpConnect(conn net.Conn, p *EgressProxy, dest string) error {
+	req := fmt.Sprintf("CONNECT %v HTTP/1.1\r\nHost: %v\r\n", dest, dest)
+	if p.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return err
+	}
+	// we must not read past the response headers: the tunneled data follows them
+	resp, err := http.ReadResponse(bufio.NewReaderSize(&byteReader{conn}, 1), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP CONNECT failed: %v", resp.Status)
+	}
+	return nil
+}
+
+// byteReader reads a single byte at a time so no data after the HTTP headers is buffered
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
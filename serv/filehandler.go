@@ -1,17 +1,19 @@
 package serv
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 	"github.com/rs/xid"
 
 	"github.com/lulugyf/sshserv/dataprovider"
@@ -38,6 +40,8 @@ type Connection struct {
 	protocol     string
 	lock         *sync.Mutex
 	sshConn      *ssh.ServerConn
+	// filesystem backend used by SFTP and SCP
+	fs vfs.Fs
 }
 
 func (c Connection) ActiveTime() {
@@ -47,24 +51,27 @@ func (c Connection) ActiveTime() {
 func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	updateConnectionActivity(c.ID)
 
-	if !c.User.HasPerm(dataprovider.PermDownload) {
-		return nil, sftp.ErrSshFxPermissionDenied
-	}
-
 	p, err := c.buildPath(request.Filepath)
 	if err != nil {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
+	if !c.hasPerm(dataprovider.PermDownload, p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	st1, err := os.Stat(p)
-	if os.IsNotExist(err) {
+	st1, err := c.fs.Stat(p)
+	if c.fs.IsNotExist(err) {
 		return nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Error(logSender, "error performing file stat %v: %v", p, err)
+		return nil, sftp.ErrSshFxFailure
 	}
 
-	file, err := os.Open(p)
+	file, err := c.fs.Open(p)
 	if err != nil {
 		logger.Error(logSender, "could not open file \"%v\" for reading: %v", p, err)
 		return nil, sftp.ErrSshFxFailure
@@ -75,6 +82,7 @@ func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 
 	transfer := Transfer{
 		file:          file,
+		fs:            c.fs,
 		path:          p,
 		start:         time.Now(),
 		bytesSent:     0,
@@ -93,27 +101,33 @@ func (c Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 // Filewrite handles the write actions for a file on the system.
 func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	updateConnectionActivity(c.ID)
-	if !c.User.HasPerm(dataprovider.PermUpload) {
-		return nil, sftp.ErrSshFxPermissionDenied
-	}
 
 	p, err := c.buildPath(request.Filepath)
 	if err != nil {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
+	if !c.hasPerm(dataprovider.PermUpload, p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	if !c.isFileAllowed(p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
 	filePath := p
 	if uploadMode == uploadModeAtomic {
 		filePath = getUploadTempFilePath(p)
+		c.removeStaleUploadFiles(filepath.Dir(p))
 	}
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	stat, statErr := os.Stat(p)
+	stat, statErr := c.fs.Stat(p)
 	// If the file doesn't exist we need to create it, as well as the directory pathway
 	// leading up to where that file will be created.
-	if os.IsNotExist(statErr) {
+	if c.fs.IsNotExist(statErr) {
 		logger.Debug(logSender, "upload new file to %s, Flags: %x FileMode: %v  stat: %v",
 			filePath, request.Flags, request.Attributes().FileMode().String(), request.AttrFlags())
 		return c.handleSFTPUploadToNewFile(p, filePath)
@@ -133,12 +147,296 @@ func (c Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	return c.handleSFTPUploadToExistingFile(request.Pflags(), p, filePath, stat.Size())
 }
 
+// Filehash computes the hash of a file, it handles the check-file and md5-hash SFTP extensions.
+// The checksum computed by the backend is returned if the client requests its algorithm for the whole file,
+// otherwise the file is read and hashed here
+func (c Connection) Filehash(request *sftp.Request, hashRequest sftp.FileHashRequest) (string, []byte, error) {
+	updateConnectionActivity(c.ID)
+
+	p, err := c.buildPath(request.Filepath)
+	if err != nil {
+		return "", nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	if !c.hasPerm(dataprovider.PermDownload, p) {
+		return "", nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	stat, err := c.fs.Stat(p)
+	if c.fs.IsNotExist(err) {
+		return "", nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Error(logSender, "error performing file stat %v: %v", p, err)
+		return "", nil, sftp.ErrSshFxFailure
+	}
+	if !stat.Mode().IsRegular() {
+		return "", nil, sftp.ErrSshFxFailure
+	}
+
+	for _, algorithm := range hashRequest.Algorithms {
+		if checksummer, ok := c.fs.(vfs.Checksummer); ok && algorithm == checksummer.ChecksumAlgorithm() {
+			if hashRequest.Offset != 0 || hashRequest.Length != 0 || hashRequest.BlockSize != 0 {
+				continue
+			}
+			checksum, err := checksummer.Checksum(p)
+			if err != nil {
+				logger.Warn(logSender, "unable to get checksum for file %v: %v", p, err)
+				return "", nil, sftp.ErrSshFxFailure
+			}
+			return algorithm, checksum, nil
+		}
+		if sftp.IsHashAlgorithmSupported(algorithm) {
+			hashRequest.Algorithms = []string{algorithm}
+			break
+		}
+	}
+
+	file, err := c.fs.Open(p)
+	if err != nil {
+		logger.Error(logSender, "could not open file \"%v\" for hashing: %v", p, err)
+		return "", nil, sftp.ErrSshFxFailure
+	}
+	defer file.Close()
+
+	algorithm, hash, err := sftp.ComputeFileHash(file, stat.Size(), hashRequest)
+	if err != nil {
+		logger.Warn(logSender, "unable to hash file %v, algorithms: %v: %v", p, hashRequest.Algorithms, err)
+		return "", nil, err
+	}
+	logger.Debug(logSender, "file hash requested for path: \"%v\", algorithm: %v, user: %v", p, algorithm, c.User.Username)
+	return algorithm, hash, nil
+}
+
+// Filecopy copies a file on the server, it handles the copy-file SFTP extension.
+// The copy requires the download permission on the source and the upload permission on the target
+// and it is handled as an upload: it is throttled, listed and logged as an active upload, it counts against
+// the quota and triggers the upload actions. It is stopped if the connection is closed
+func (c Connection) Filecopy(request *sftp.Request, overwrite bool) error {
+	updateConnectionActivity(c.ID)
+
+	sourcePath, err := c.buildPath(request.Filepath)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+	targetPath, err := c.buildPath(request.Target)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	if !c.hasPerm(dataprovider.PermDownload, sourcePath) || !c.hasPerm(dataprovider.PermUpload, targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if !c.isFileAllowed(targetPath) || c.isVirtualFolderParent(targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+
+	sourceStat, err := c.fs.Stat(sourcePath)
+	if c.fs.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Error(logSender, "error performing file stat %v: %v", sourcePath, err)
+		return sftp.ErrSshFxFailure
+	}
+	if !sourceStat.Mode().IsRegular() {
+		logger.Warn(logSender, "attempted to copy a non regular file: %v", sourcePath)
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	isNewFile, targetSize, err := c.checkCopyTarget(sourcePath, sourceStat, targetPath, overwrite)
+	if err != nil {
+		return err
+	}
+
+	filePath := targetPath
+	if uploadMode == uploadModeAtomic {
+		filePath = getUploadTempFilePath(targetPath)
+		c.removeStaleUploadFiles(filepath.Dir(targetPath))
+	}
+	src, err := c.fs.Open(sourcePath)
+	if err != nil {
+		logger.Error(logSender, "could not open file \"%v\" for copying: %v", sourcePath, err)
+		return sftp.ErrSshFxFailure
+	}
+	defer src.Close()
+
+	var writer io.WriterAt
+	if isNewFile {
+		writer, err = c.handleSFTPUploadToNewFile(targetPath, filePath)
+	} else {
+		writer, err = c.handleSFTPUploadToExistingFile(sftp.FileOpenFlags{Write: true, Trunc: true}, targetPath,
+			filePath, targetSize)
+	}
+	if err != nil {
+		return err
+	}
+	transfer := writer.(*Transfer)
+	err = c.copyToTransfer(src, transfer)
+	if err != nil {
+		logger.Error(logSender, "failed to copy file, source: %v target: %v: %v", sourcePath, targetPath, err)
+		transfer.TransferError(err)
+	}
+	if closeErr := transfer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return sftp.ErrSshFxFailure
+	}
+	return nil
+}
+
+// checkCopyTarget checks that the copy of sourcePath to targetPath is allowed and that it fits the quota.
+// It returns if the target is a new file and the size of the existing target
+func (c Connection) checkCopyTarget(sourcePath string, sourceStat os.FileInfo, targetPath string,
+	overwrite bool) (bool, int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	isNewFile := true
+	var targetSize int64
+	targetStat, err := c.fs.Stat(targetPath)
+	if err == nil {
+		if targetStat.IsDir() {
+			logger.Warn(logSender, "attempted to copy over a directory: %v", targetPath)
+			return false, 0, sftp.ErrSshFxOpUnsupported
+		}
+		if !overwrite || sourcePath == targetPath || os.SameFile(sourceStat, targetStat) {
+			return false, 0, sftp.ErrSshFxFailure
+		}
+		isNewFile = false
+		targetSize = targetStat.Size()
+	} else if !c.fs.IsNotExist(err) {
+		logger.Error(logSender, "error performing file stat %v: %v", targetPath, err)
+		return false, 0, sftp.ErrSshFxFailure
+	}
+
+	if !c.hasSpaceFor(isNewFile, targetPath, sourceStat.Size()-targetSize) {
+		logger.Info(logSender, "denying file copy due to space limit")
+		return false, 0, sftp.ErrSshFxFailure
+	}
+	return isNewFile, targetSize, nil
+}
+
+// copyToTransfer writes the content of src using the given upload transfer, the copy is stopped
+// if the connection is closed
+func (c Connection) copyToTransfer(src vfs.File, transfer *Transfer) error {
+	buf := make([]byte, copyBufferSize)
+	var offset int64
+	for {
+		if !isConnectionOpen(c.ID) {
+			return errors.New("connection closed")
+		}
+		n, err := src.ReadAt(buf, offset)
+		if n > 0 {
+			if _, writeErr := transfer.WriteAt(buf[:n], offset); writeErr != nil {
+				return writeErr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// StatVFS returns the filesystem statistics for the given path, it handles the statvfs@openssh.com SFTP extension.
+// If the path has a quota, the user quota or the quota of the virtual folder containing it,
+// the returned space and inodes are limited to the quota
+func (c Connection) StatVFS(request *sftp.Request) (*sftp.StatVFS, error) {
+	updateConnectionActivity(c.ID)
+
+	p, err := c.buildPath(request.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	if !c.hasPerm(dataprovider.PermListItems, p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	stat, err := c.fs.Statvfs(p)
+	if c.fs.IsNotExist(err) && c.isVirtualFolderParent(p) {
+		stat, err = c.fs.Statvfs(c.User.GetHomeDir())
+	}
+	if c.fs.IsNotExist(err) {
+		return nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Warn(logSender, "unable to get filesystem statistics for path %v: %v", p, err)
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	var quotaFiles, numFiles int
+	var quotaSize, size int64
+	if folder, ok := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(p)); ok && folder.HasQuota() {
+		quotaFiles, quotaSize = folder.QuotaFiles, folder.QuotaSize
+		numFiles, size, err = getFolderUsage(c.fs, folder)
+	} else if c.User.QuotaFiles > 0 || c.User.QuotaSize > 0 {
+		quotaFiles, quotaSize = c.User.QuotaFiles, c.User.QuotaSize
+		numFiles, size, err = c.getUsedQuota()
+	}
+	if err != nil {
+		logger.Warn(logSender, "unable to get used quota for user %v: %v", c.User.Username, err)
+		return stat, nil
+	}
+	applyQuotaToStatVFS(stat, quotaFiles, numFiles, quotaSize, size)
+	return stat, nil
+}
+
+// applyQuotaToStatVFS limits the filesystem statistics to the given quota and used quota.
+// The free space and inodes are never greater than the ones actually available
+func applyQuotaToStatVFS(stat *sftp.StatVFS, quotaFiles, numFiles int, quotaSize, size int64) {
+	if quotaSize > 0 {
+		if stat.Frsize == 0 {
+			stat.Frsize = 4096
+		}
+		if stat.Bsize == 0 {
+			stat.Bsize = stat.Frsize
+		}
+		stat.Blocks = uint64(quotaSize) / stat.Frsize
+		var free uint64
+		if size < quotaSize {
+			free = uint64(quotaSize-size) / stat.Frsize
+		}
+		if free < stat.Bfree {
+			stat.Bfree = free
+		}
+		if free < stat.Bavail {
+			stat.Bavail = free
+		}
+	}
+	if quotaFiles > 0 {
+		// some backends, for example HDFS, do not report inodes
+		hasInodes := stat.Files > 0
+		stat.Files = uint64(quotaFiles)
+		var free uint64
+		if numFiles < quotaFiles {
+			free = uint64(quotaFiles - numFiles)
+		}
+		if free < stat.Ffree || !hasInodes {
+			stat.Ffree = free
+		}
+		if free < stat.Favail || !hasInodes {
+			stat.Favail = free
+		}
+	}
+}
+
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
 // or writing to those files.
 func (c Connection) Filecmd(request *sftp.Request) error {
 	updateConnectionActivity(c.ID)
 
-	p, err := c.buildPath(request.Filepath)
+	var p string
+	var err error
+	switch request.Method {
+	case "Rename", "Remove":
+		// a symlink pointing outside the user's root can be renamed or removed like a dangling one
+		p, err = c.buildLinkPath(request.Filepath)
+	default:
+		p, err = c.buildPath(request.Filepath)
+	}
 	if err != nil {
 		return sftp.ErrSshFxNoSuchFile
 	}
@@ -181,6 +479,9 @@ func (c Connection) Filecmd(request *sftp.Request) error {
 	case "Remove":
 		return c.handleSFTPRemove(p)
 
+	case "Link":
+		return c.handleSFTPLink(p, target)
+
 	default:
 		return sftp.ErrSshFxOpUnsupported
 	}
@@ -191,7 +492,7 @@ func (c Connection) Filecmd(request *sftp.Request) error {
 	}
 
 	// we return if we remove a file or a dir so source path or target path always exists here
-	utils.SetPathPermissions(fileLocation, c.User.GetUID(), c.User.GetGID())
+	vfs.SetPathPermissions(c.fs, fileLocation, c.User.GetUID(), c.User.GetGID())
 
 	return sftp.ErrSshFxOk
 }
@@ -200,44 +501,100 @@ func (c Connection) Filecmd(request *sftp.Request) error {
 // a directory as well as perform file/folder stat calls.
 func (c Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	updateConnectionActivity(c.ID)
-	p, err := c.buildPath(request.Filepath)
+	var p string
+	var err error
+	if request.Method == "Readlink" {
+		p, err = c.buildLinkPath(request.Filepath)
+	} else {
+		p, err = c.buildPath(request.Filepath)
+	}
 	if err != nil {
 		return nil, sftp.ErrSshFxNoSuchFile
 	}
 
 	switch request.Method {
 	case "List":
-		if !c.User.HasPerm(dataprovider.PermListItems) {
+		if !c.hasPerm(dataprovider.PermListItems, p) {
 			return nil, sftp.ErrSshFxPermissionDenied
 		}
 
 		logger.Debug(logSender, "requested list file for dir: %v user: %v", p, c.User.Username)
 
-		files, err := ioutil.ReadDir(p)
+		lister, err := c.fs.OpenDir(p)
 		if err != nil {
-			logger.Error(logSender, "error listing directory: %v", err)
-			return nil, sftp.ErrSshFxFailure
+			if !c.fs.IsNotExist(err) || !c.isVirtualFolderParent(p) {
+				logger.Error(logSender, "error listing directory: %v", err)
+				return nil, sftp.ErrSshFxFailure
+			}
+			lister = nil
 		}
 
-		return listerAt(files), nil
+		return newDirListerAt(c, p, lister, maxListEntries), nil
 	case "Stat":
-		if !c.User.HasPerm(dataprovider.PermListItems) {
-			return nil, sftp.ErrSshFxPermissionDenied
-		}
+		return c.handleSFTPStat(p, c.fs.Stat)
+	case "Readlink":
+		return c.handleSFTPReadlink(p)
+	default:
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+}
 
-		logger.Debug(logSender, "requested stat for file: %v user: %v", p, c.User.Username)
-		s, err := os.Stat(p)
-		if os.IsNotExist(err) {
-			return nil, sftp.ErrSshFxNoSuchFile
-		} else if err != nil {
-			logger.Error(logSender, "error running STAT on file: %v", err)
-			return nil, sftp.ErrSshFxFailure
-		}
+// Lstat is the handler for SFTP lstat calls, symbolic links are not followed
+func (c Connection) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+	updateConnectionActivity(c.ID)
+	p, err := c.buildLinkPath(request.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+	return c.handleSFTPStat(p, c.fs.Lstat)
+}
 
-		return listerAt([]os.FileInfo{s}), nil
-	default:
+func (c Connection) handleSFTPStat(p string, statFn func(string) (os.FileInfo, error)) (sftp.ListerAt, error) {
+	if !c.hasPerm(dataprovider.PermListItems, p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	logger.Debug(logSender, "requested stat for file: %v user: %v", p, c.User.Username)
+	s, err := statFn(p)
+	if c.fs.IsNotExist(err) && c.isVirtualFolderParent(p) {
+		// intermediate dir leading to a virtual folder, it does not need to exist
+		s, err = vfs.NewVirtualDirInfo(filepath.Base(p)), nil
+	}
+	if c.fs.IsNotExist(err) {
+		return nil, sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Error(logSender, "error running STAT on file: %v", err)
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	return listerAt([]os.FileInfo{s}), nil
+}
+
+// handleSFTPReadlink returns the destination of a symbolic link as an SFTP path.
+// The destination of a link pointing outside the user's root and virtual folders is not returned,
+// the link is handled as a dangling one
+func (c Connection) handleSFTPReadlink(p string) (sftp.ListerAt, error) {
+	if !c.hasPerm(dataprovider.PermListItems, p) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+	target, err := c.fs.Readlink(p)
+	if c.fs.IsNotExist(err) {
+		return nil, sftp.ErrSshFxNoSuchFile
+	} else if err == vfs.ErrVfsUnsupported {
 		return nil, sftp.ErrSshFxOpUnsupported
+	} else if err != nil {
+		logger.Warn(logSender, "error reading link %v: %v", p, err)
+		return nil, sftp.ErrSshFxFailure
 	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(p), target)
+	}
+	sftpTarget := c.fs.GetRelativePath(target)
+	if sftpTarget == "" {
+		logger.Debug(logSender, "link %v points outside the user's root, user: %v", p, c.User.Username)
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+	return listerAt([]os.FileInfo{linkTargetInfo{target: sftpTarget}}), nil
 }
 
 func (c Connection) getSFTPCmdTargetPath(requestTarget string) (string, error) {
@@ -256,10 +613,24 @@ func (c Connection) getSFTPCmdTargetPath(requestTarget string) (string, error) {
 }
 
 func (c Connection) handleSFTPRename(sourcePath string, targetPath string) error {
-	if !c.User.HasPerm(dataprovider.PermRename) {
+	// a rename changes the entries of the source and target dirs, so their permissions apply
+	if !c.hasPerm(dataprovider.PermRename, filepath.Dir(sourcePath)) ||
+		!c.hasPerm(dataprovider.PermRename, filepath.Dir(targetPath)) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if !c.isRenamePermitted(sourcePath, targetPath) {
 		return sftp.ErrSshFxPermissionDenied
 	}
-	if err := os.Rename(sourcePath, targetPath); err != nil {
+	// file patterns apply to file names, a renamed directory must not bring in files denied inside the target
+	if fi, err := c.fs.Lstat(sourcePath); err == nil {
+		if fi.IsDir() && !c.isDirContentAllowed(sourcePath, targetPath, false) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+		if !fi.IsDir() && !c.isFileAllowed(targetPath) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+	}
+	if err := c.fs.Rename(sourcePath, targetPath); err != nil {
 		logger.Error(logSender, "failed to rename file, source: %v target: %v: %v", sourcePath, targetPath, err)
 		return sftp.ErrSshFxFailure
 	}
@@ -269,22 +640,26 @@ func (c Connection) handleSFTPRename(sourcePath string, targetPath string) error
 }
 
 func (c Connection) handleSFTPRmdir(path string) error {
-	if !c.User.HasPerm(dataprovider.PermDelete) {
+	if !c.hasPerm(dataprovider.PermDelete, path) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if c.isVirtualFolderParent(path) {
+		logger.Warn(logSender, "removing a virtual folder or its parent is not allowed: %v", path)
 		return sftp.ErrSshFxPermissionDenied
 	}
 
-	numFiles, size, fileList, err := utils.ScanDirContents(path)
+	numFiles, size, fileList, err := vfs.ScanDirContents(c.fs, path)
 	if err != nil {
 		logger.Error(logSender, "failed to remove directory %v, scanning error: %v", path, err)
 		return sftp.ErrSshFxFailure
 	}
-	if err := os.RemoveAll(path); err != nil {
+	if err := c.fs.RemoveAll(path); err != nil {
 		logger.Error(logSender, "failed to remove directory %v: %v", path, err)
 		return sftp.ErrSshFxFailure
 	}
 
 	logger.CommandLog(rmdirLogSender, path, "", c.User.Username, c.ID, c.protocol)
-	dataprovider.UpdateUserQuota(dataProvider, c.User, -numFiles, -size, false)
+	updateQuota(c.fs, c.User, path, -numFiles, -size)
 	for _, p := range fileList {
 		executeAction(operationDelete, c.User.Username, p, "")
 	}
@@ -292,11 +667,25 @@ func (c Connection) handleSFTPRmdir(path string) error {
 }
 
 func (c Connection) handleSFTPSymlink(sourcePath string, targetPath string) error {
-	if !c.User.HasPerm(dataprovider.PermCreateSymlinks) {
+	if !c.hasPerm(dataprovider.PermCreateSymlinks, targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if !c.isFileAllowed(targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	// the link must not expose a file denied in its source location or below the link path
+	if fi, err := c.fs.Stat(sourcePath); err == nil && fi.IsDir() {
+		if !c.isDirContentAllowed(sourcePath, targetPath, true) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+	} else if !c.isFileAllowed(sourcePath) {
 		return sftp.ErrSshFxPermissionDenied
 	}
-	if err := os.Symlink(sourcePath, targetPath); err != nil {
+	if err := c.fs.Symlink(sourcePath, targetPath); err != nil {
 		logger.Warn(logSender, "failed to create symlink %v -> %v: %v", sourcePath, targetPath, err)
+		if err == vfs.ErrVfsUnsupported {
+			return sftp.ErrSshFxOpUnsupported
+		}
 		return sftp.ErrSshFxFailure
 	}
 
@@ -304,8 +693,51 @@ func (c Connection) handleSFTPSymlink(sourcePath string, targetPath string) erro
 	return nil
 }
 
+func (c Connection) handleSFTPLink(sourcePath string, targetPath string) error {
+	if !c.hasPerm(dataprovider.PermCreateLinks, sourcePath) || !c.hasPerm(dataprovider.PermCreateLinks, targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if !c.isFileAllowed(targetPath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	sourceFolder, _ := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(sourcePath))
+	targetFolder, _ := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(targetPath))
+	if sourceFolder.VirtualPath != targetFolder.VirtualPath || c.isVirtualFolderParent(targetPath) {
+		logger.Warn(logSender, "hard links across virtual folders are not allowed, source: %v target: %v",
+			sourcePath, targetPath)
+		return sftp.ErrSshFxPermissionDenied
+	}
+	fi, err := c.fs.Lstat(sourcePath)
+	if c.fs.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if err != nil {
+		logger.Error(logSender, "error performing file stat %v: %v", sourcePath, err)
+		return sftp.ErrSshFxFailure
+	}
+	if !fi.Mode().IsRegular() {
+		logger.Warn(logSender, "hard links are allowed for regular files only: %v", sourcePath)
+		return sftp.ErrSshFxOpUnsupported
+	}
+	// the link is counted as a new file as it is when the quota is scanned
+	if !c.hasSpaceFor(true, targetPath, fi.Size()) {
+		logger.Info(logSender, "denying hard link due to space limit")
+		return sftp.ErrSshFxFailure
+	}
+	if err := c.fs.Link(sourcePath, targetPath); err != nil {
+		logger.Warn(logSender, "failed to create hard link %v -> %v: %v", sourcePath, targetPath, err)
+		if err == vfs.ErrVfsUnsupported {
+			return sftp.ErrSshFxOpUnsupported
+		}
+		return sftp.ErrSshFxFailure
+	}
+
+	logger.CommandLog(linkLogSender, sourcePath, targetPath, c.User.Username, c.ID, c.protocol)
+	updateQuota(c.fs, c.User, targetPath, 1, fi.Size())
+	return sftp.ErrSshFxOk
+}
+
 func (c Connection) handleSFTPMkdir(path string) error {
-	if !c.User.HasPerm(dataprovider.PermCreateDirs) {
+	if !c.hasPerm(dataprovider.PermCreateDirs, path) {
 		return sftp.ErrSshFxPermissionDenied
 	}
 
@@ -318,26 +750,30 @@ func (c Connection) handleSFTPMkdir(path string) error {
 }
 
 func (c Connection) handleSFTPRemove(path string) error {
-	if !c.User.HasPerm(dataprovider.PermDelete) {
+	if !c.hasPerm(dataprovider.PermDelete, path) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	if c.isVirtualFolderParent(path) {
+		logger.Warn(logSender, "removing a virtual folder or its parent is not allowed: %v", path)
 		return sftp.ErrSshFxPermissionDenied
 	}
 
 	var size int64
 	var fi os.FileInfo
 	var err error
-	if fi, err = os.Lstat(path); err != nil {
+	if fi, err = c.fs.Lstat(path); err != nil {
 		logger.Error(logSender, "failed to remove a file %v: stat error: %v", path, err)
 		return sftp.ErrSshFxFailure
 	}
 	size = fi.Size()
-	if err := os.Remove(path); err != nil {
+	if err := c.fs.Remove(path); err != nil {
 		logger.Error(logSender, "failed to remove a file/symlink %v: %v", path, err)
 		return sftp.ErrSshFxFailure
 	}
 
 	logger.CommandLog(removeLogSender, path, "", c.User.Username, c.ID, c.protocol)
 	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
-		dataprovider.UpdateUserQuota(dataProvider, c.User, -1, -size, false)
+		updateQuota(c.fs, c.User, path, -1, -size)
 	}
 	executeAction(operationDelete, c.User.Username, path, "")
 
@@ -345,13 +781,13 @@ func (c Connection) handleSFTPRemove(path string) error {
 }
 
 func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.WriterAt, error) {
-	if !c.hasSpace(true) {
+	if !c.hasSpace(true, requestPath) {
 		logger.Info(logSender, "denying file write due to space limit")
 		return nil, sftp.ErrSshFxFailure
 	}
 
-	if _, err := os.Stat(filepath.Dir(requestPath)); os.IsNotExist(err) {
-		if !c.User.HasPerm(dataprovider.PermCreateDirs) {
+	if _, err := c.fs.Stat(filepath.Dir(requestPath)); c.fs.IsNotExist(err) {
+		if !c.hasPerm(dataprovider.PermCreateDirs, filepath.Dir(requestPath)) {
 			return nil, sftp.ErrSshFxPermissionDenied
 		}
 	}
@@ -362,16 +798,17 @@ func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.
 		return nil, sftp.ErrSshFxFailure
 	}
 
-	file, err := os.Create(filePath)
+	file, err := c.fs.Create(filePath, 0)
 	if err != nil {
 		logger.Error(logSender, "error creating file %v: %v", requestPath, err)
 		return nil, sftp.ErrSshFxFailure
 	}
 
-	utils.SetPathPermissions(filePath, c.User.GetUID(), c.User.GetGID())
+	vfs.SetPathPermissions(c.fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	transfer := Transfer{
 		file:          file,
+		fs:            c.fs,
 		path:          requestPath,
 		start:         time.Now(),
 		bytesSent:     0,
@@ -390,43 +827,49 @@ func (c Connection) handleSFTPUploadToNewFile(requestPath, filePath string) (io.
 func (c Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, requestPath, filePath string,
 	fileSize int64) (io.WriterAt, error) {
 	var err error
-	if !c.hasSpace(false) {
+	if !c.hasSpace(false, requestPath) {
 		logger.Info(logSender, "denying file write due to space limit")
 		return nil, sftp.ErrSshFxFailure
 	}
 
 	osFlags := getOSOpenFlags(pflags)
+	isResume := osFlags&os.O_TRUNC == 0
 
-	if osFlags&os.O_TRUNC == 0 {
+	if isResume && !vfs.IsUploadResumeSupported(c.fs) {
 		// see https://github.com/pkg/sftp/issues/295
 		logger.Info(logSender, "upload resume is not supported, returning error for file: %v user: %v", requestPath,
 			c.User.Username)
 		return nil, sftp.ErrSshFxOpUnsupported
 	}
 
-	if uploadMode == uploadModeAtomic {
-		err = os.Rename(requestPath, filePath)
+	if isResume {
+		// the existing data is kept, so a resumed upload is never atomic
+		logger.Debug(logSender, "resume upload for file: %v, size: %v, user: %v", requestPath, fileSize, c.User.Username)
+		filePath = requestPath
+	} else if uploadMode == uploadModeAtomic {
+		err = c.fs.Rename(requestPath, filePath)
 		if err != nil {
 			logger.Error(logSender, "error renaming existing file for atomic upload, source: %v, dest: %v, err: %v",
 				requestPath, filePath, err)
 			return nil, sftp.ErrSshFxFailure
 		}
 	}
-	// we use 0666 so the umask is applied
-	file, err := os.OpenFile(filePath, osFlags, 0666)
+	file, err := c.fs.Create(filePath, osFlags)
 	if err != nil {
 		logger.Error(logSender, "error opening existing file, flags: %v, source: %v, err: %v", pflags, filePath, err)
 		return nil, sftp.ErrSshFxFailure
 	}
 
-	// FIXME: this need to be changed when we add upload resume support
-	// the file is truncated so we need to decrease quota size but not quota files
-	dataprovider.UpdateUserQuota(dataProvider, c.User, 0, -fileSize, false)
+	if !isResume {
+		// the file is truncated so we need to decrease quota size but not quota files
+		updateQuota(c.fs, c.User, requestPath, 0, -fileSize)
+	}
 
-	utils.SetPathPermissions(filePath, c.User.GetUID(), c.User.GetGID())
+	vfs.SetPathPermissions(c.fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	transfer := Transfer{
 		file:          file,
+		fs:            c.fs,
 		path:          requestPath,
 		start:         time.Now(),
 		bytesSent:     0,
@@ -442,46 +885,81 @@ func (c Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, re
 	return &transfer, nil
 }
 
+// handleSFTPSetstat applies the attributes requested by the client, a request can change
+// the permissions, the owner and the times together, for example "put -p"
 func (c Connection) handleSFTPSetstat(filePath string, request *sftp.Request) error {
 	attrFlags := request.AttrFlags()
+	attrs := request.Attributes()
+	if attrFlags.Permissions && !c.hasPerm(dataprovider.PermChmod, filePath) ||
+		attrFlags.UidGid && !c.hasPerm(dataprovider.PermChown, filePath) ||
+		attrFlags.Acmodtime && !c.hasPerm(dataprovider.PermChtimes, filePath) {
+		return sftp.ErrSshFxPermissionDenied
+	}
 	if attrFlags.Permissions {
-		fileMode := request.Attributes().FileMode()
-		if err := os.Chmod(filePath, fileMode); err != nil {
+		fileMode := attrs.FileMode()
+		if err := c.fs.Chmod(filePath, fileMode); err != nil {
 			logger.Warn(logSender, "failed to chmod path %#v, mode: %v, err: %+v", filePath, fileMode.String(), err)
-			return sftp.ErrSshFxFailure
+			return c.getSetstatError(err)
 		}
-		//logger.CommandLog(, filePath, "", c.User.Username, fileMode.String(), c.ID, c.protocol, -1, -1, "", "", "")
-		return nil
-	} else if attrFlags.UidGid {
-		uid := int(request.Attributes().UID)
-		gid := int(request.Attributes().GID)
-		if err := os.Chown(filePath, uid, gid); err != nil {
-			logger.Warn( logSender, "failed to chown path %#v, uid: %v, gid: %v, err: %+v", filePath, uid, gid, err)
-			return sftp.ErrSshFxFailure
-		}
-		//logger.CommandLog(chownLogSender, filePath, "", c.User.Username, "", c.ID, c.protocol, uid, gid, "", "", "")
-		return nil
-	} else if attrFlags.Acmodtime {
-		accessTime := time.Unix(int64(request.Attributes().Atime), 0)
-		modificationTime := time.Unix(int64(request.Attributes().Mtime), 0)
-		if err := os.Chtimes(filePath, accessTime, modificationTime); err != nil {
-			logger.Warn( logSender, "failed to chtimes for path %#v, access time: %v, modification time: %v, err: %+v",
+		logger.CommandLog(chmodLogSender, filePath, fileMode.String(), c.User.Username, c.ID, c.protocol)
+	}
+	if attrFlags.UidGid {
+		uid := int(attrs.UID)
+		gid := int(attrs.GID)
+		if err := c.fs.Chown(filePath, uid, gid); err != nil {
+			logger.Warn(logSender, "failed to chown path %#v, uid: %v, gid: %v, err: %+v", filePath, uid, gid, err)
+			return c.getSetstatError(err)
+		}
+		logger.CommandLog(chownLogSender, filePath, fmt.Sprintf("%v:%v", uid, gid), c.User.Username, c.ID, c.protocol)
+	}
+	if attrFlags.Acmodtime {
+		accessTime := time.Unix(int64(attrs.Atime), 0)
+		modificationTime := time.Unix(int64(attrs.Mtime), 0)
+		if err := c.fs.Chtimes(filePath, accessTime, modificationTime); err != nil {
+			logger.Warn(logSender, "failed to chtimes for path %#v, access time: %v, modification time: %v, err: %+v",
 				filePath, accessTime, modificationTime, err)
-			return sftp.ErrSshFxFailure
+			return c.getSetstatError(err)
 		}
-		//dateFormat := "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
-		//accessTimeString := accessTime.Format(dateFormat)
-		//modificationTimeString := modificationTime.Format(dateFormat)
-		//logger.CommandLog(chtimesLogSender, filePath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, accessTimeString,
-		//	modificationTimeString, "")
-		return nil
+		logger.CommandLog(chtimesLogSender, filePath, modificationTime.UTC().Format(time.RFC3339), c.User.Username,
+			c.ID, c.protocol)
 	}
 	return nil
 }
 
-func (c Connection) hasSpace(checkFiles bool) bool {
+func (c Connection) getSetstatError(err error) error {
+	if err == vfs.ErrVfsUnsupported {
+		return sftp.ErrSshFxOpUnsupported
+	} else if c.fs.IsNotExist(err) {
+		return sftp.ErrSshFxNoSuchFile
+	} else if c.fs.IsPermission(err) {
+		return sftp.ErrSshFxPermissionDenied
+	}
+	return sftp.ErrSshFxFailure
+}
+
+// hasSpace returns true if there is space left to write to the given backend path,
+// a virtual folder with its own quota is checked against the folder usage
+func (c Connection) hasSpace(checkFiles bool, fsPath string) bool {
+	return c.hasSpaceFor(checkFiles, fsPath, 0)
+}
+
+// hasSpaceFor returns false if the quota is already exceeded or if adding sizeAdd bytes would exceed it
+func (c Connection) hasSpaceFor(checkFiles bool, fsPath string, sizeAdd int64) bool {
+	if folder, ok := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(fsPath)); ok && folder.HasQuota() {
+		numFile, size, err := getFolderUsage(c.fs, folder)
+		if err != nil {
+			return false
+		}
+		if (checkFiles && folder.QuotaFiles > 0 && numFile >= folder.QuotaFiles) ||
+			(folder.QuotaSize > 0 && (size >= folder.QuotaSize || size+sizeAdd > folder.QuotaSize)) {
+			logger.Debug(logSender, "quota exceed for virtual folder %v, num files: %v/%v, size: %v+%v/%v check files: %v",
+				folder.VirtualPath, numFile, folder.QuotaFiles, size, sizeAdd, folder.QuotaSize, checkFiles)
+			return false
+		}
+		return true
+	}
 	if (checkFiles && c.User.QuotaFiles > 0) || c.User.QuotaSize > 0 {
-		numFile, size, err := dataprovider.GetUsedQuota(dataProvider, c.User.Username)
+		numFile, size, err := c.getUsedQuota()
 		if err != nil {
 			if _, ok := err.(*dataprovider.MethodDisabledError); ok {
 				logger.Warn(logSender, "quota enforcement not possible for user %v: %v", c.User.Username, err)
@@ -491,110 +969,169 @@ func (c Connection) hasSpace(checkFiles bool) bool {
 			return false
 		}
 		if (checkFiles && c.User.QuotaFiles > 0 && numFile >= c.User.QuotaFiles) ||
-			(c.User.QuotaSize > 0 && size >= c.User.QuotaSize) {
-			logger.Debug(logSender, "quota exceed for user %v, num files: %v/%v, size: %v/%v check files: %v",
-				c.User.Username, numFile, c.User.QuotaFiles, size, c.User.QuotaSize, checkFiles)
+			(c.User.QuotaSize > 0 && (size >= c.User.QuotaSize || size+sizeAdd > c.User.QuotaSize)) {
+			logger.Debug(logSender, "quota exceed for user %v, num files: %v/%v, size: %v+%v/%v check files: %v",
+				c.User.Username, numFile, c.User.QuotaFiles, size, sizeAdd, c.User.QuotaSize, checkFiles)
 			return false
 		}
 	}
 	return true
 }
 
-// Normalizes a directory we get from the SFTP request to ensure the user is not able to escape
-// from their data directory. After normalization if the directory is still within their home
-// path it is returned. If they managed to "escape" an error will be returned.
-func (c Connection) buildPath(rawPath string) (string, error) {
-	r := filepath.Clean(filepath.Join(c.User.HomeDir, rawPath))
-	p, err := filepath.EvalSymlinks(r)
-	if err != nil && !os.IsNotExist(err) {
-		return "", err
-	} else if os.IsNotExist(err) {
-		// The requested directory doesn't exist, so at this point we need to iterate up the
-		// path chain until we hit a directory that _does_ exist and can be validated.
-		_, err = c.findFirstExistingDir(r)
-		if err != nil {
-			logger.Warn(logSender, "error resolving not existent path: %v", err)
-		}
-		return r, err
+// getUsedQuota returns the number of files and the size used by the user. Backends that report the usage
+// of a dir tree, such as HDFS, are asked directly so the files added without using this server are counted too,
+// their usage is cached for dirUsageCacheTTL
+func (c Connection) getUsedQuota() (int, int64, error) {
+	if _, ok := c.fs.(vfs.DirUsager); ok {
+		return getCachedDirUsage(getUserDirUsageKey(c.User.Username), func() (int, int64, error) {
+			return getUserDirUsage(c.fs, c.User)
+		})
 	}
+	return dataprovider.GetUsedQuota(dataProvider, c.User.Username)
+}
 
-	err = c.isSubDir(p)
-	if err != nil {
-		logger.Warn(logSender, "Invalid path resolution, dir: %v outside user home: %v err: %v", p, c.User.HomeDir, err)
-	}
-	return r, err
+// hasPerm returns true if the user has the given permission for the given backend path
+func (c Connection) hasPerm(permission, fsPath string) bool {
+	return c.hasPermInSFTPPath(permission, c.fs.GetRelativePath(fsPath))
 }
 
-// iterate up the path chain until we hit a directory that does exist and can be validated.
-// all nonexistent directories will be returned
-func (c Connection) findNonexistentDirs(path string) ([]string, error) {
-	results := []string{}
-	cleanPath := filepath.Clean(path)
-	parent := filepath.Dir(cleanPath)
-	_, err := os.Stat(parent)
+// hasPermInSFTPPath is like hasPerm for an SFTP path. HDFS snapshots are read only,
+// so only list and download are permitted inside them
+func (c Connection) hasPermInSFTPPath(permission, sftpPath string) bool {
+	if permission != dataprovider.PermListItems && permission != dataprovider.PermDownload &&
+		vfs.IsSnapshotPath(c.fs, sftpPath) {
+		logger.Warn(logSender, "permission %#v denied inside the read only snapshot path %#v, user: %v", permission,
+			sftpPath, c.User.Username)
+		return false
+	}
+	return c.User.HasPermInPath(permission, sftpPath)
+}
 
-	for os.IsNotExist(err) {
-		results = append(results, parent)
-		parent = filepath.Dir(parent)
-		_, err = os.Stat(parent)
+// isFileAllowed returns false, and logs the reason, if the file name for the given backend path
+// is not allowed by the user's file patterns
+func (c Connection) isFileAllowed(fsPath string) bool {
+	sftpPath := c.fs.GetRelativePath(fsPath)
+	if allowed, reason := c.User.IsFileAllowed(sftpPath); !allowed {
+		logger.Warn(logSender, "file %#v denied for user %v: %v", sftpPath, c.User.Username, reason)
+		return false
 	}
-	if err != nil {
-		return results, err
+	return true
+}
+
+// errFileDenied stops the directory walk as soon as a file denied by the file patterns is found
+var errFileDenied = errors.New("file denied by the file patterns")
+
+// isDirContentAllowed returns false if a file inside sourceDir would be denied by the file patterns
+// once it is available below targetDir. If checkSource is true the file must be allowed inside sourceDir too
+func (c Connection) isDirContentAllowed(sourceDir, targetDir string, checkSource bool) bool {
+	if len(c.User.FilePatterns) == 0 {
+		return true
 	}
-	p, err := filepath.EvalSymlinks(parent)
+	err := c.fs.Walk(sourceDir, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, walkedPath)
+		if err != nil {
+			return err
+		}
+		if (checkSource && !c.isFileAllowed(walkedPath)) || !c.isFileAllowed(filepath.Join(targetDir, rel)) {
+			return errFileDenied
+		}
+		return nil
+	})
 	if err != nil {
-		return results, err
+		if err != errFileDenied {
+			logger.Warn(logSender, "unable to check the contents of directory %v: %v", sourceDir, err)
+		}
+		return false
 	}
-	err = c.isSubDir(p)
-	if err != nil {
-		logger.Warn(logSender, "Error finding non existing dir: %v", err)
+	return true
+}
+
+// isVirtualFolderParent returns true if the given backend path is a virtual folder or one of its parents
+func (c Connection) isVirtualFolderParent(fsPath string) bool {
+	return fsconfig.IsVirtualFolderParent(c.User.VirtualFolders, c.fs.GetRelativePath(fsPath))
+}
+
+// isRenamePermitted returns false if the rename involves a virtual folder or one of its parents
+// or if source and target are not inside the same virtual folder or both outside them
+func (c Connection) isRenamePermitted(sourcePath, targetPath string) bool {
+	if c.isVirtualFolderParent(sourcePath) || c.isVirtualFolderParent(targetPath) {
+		logger.Warn(logSender, "renaming a virtual folder or its parent is not allowed, source: %v target: %v",
+			sourcePath, targetPath)
+		return false
+	}
+	sourceFolder, _ := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(sourcePath))
+	targetFolder, _ := c.User.GetVirtualFolderForPath(c.fs.GetRelativePath(targetPath))
+	if sourceFolder.VirtualPath != targetFolder.VirtualPath {
+		logger.Warn(logSender, "renaming across virtual folders is not allowed, source: %v target: %v",
+			sourcePath, targetPath)
+		return false
 	}
-	return results, err
+	return true
 }
 
-// iterate up the path chain until we hit a directory that does exist and can be validated.
-func (c Connection) findFirstExistingDir(path string) (string, error) {
-	results, err := c.findNonexistentDirs(path)
+// readDir returns the contents of the given backend dir including the virtual dirs inside it
+// and excluding the files hidden by the user's file patterns.
+// An intermediate dir leading to a virtual folder does not need to exist
+func (c Connection) readDir(fsPath string) ([]os.FileInfo, error) {
+	files, err := c.fs.ReadDir(fsPath)
 	if err != nil {
-		logger.Warn(logSender, "unable to find non existent dirs: %v", err)
-		return "", err
+		if !c.fs.IsNotExist(err) || !c.isVirtualFolderParent(fsPath) {
+			return files, err
+		}
+		files = nil
+	}
+	sftpPath := c.fs.GetRelativePath(fsPath)
+	if len(c.User.FilePatterns) > 0 {
+		visibleFiles := make([]os.FileInfo, 0, len(files))
+		for _, fi := range files {
+			if fi.IsDir() || !c.User.IsFileHidden(path.Join(sftpPath, fi.Name())) {
+				visibleFiles = append(visibleFiles, fi)
+			}
+		}
+		files = visibleFiles
 	}
-	var parent string
-	if len(results) > 0 {
-		lastMissingDir := results[len(results)-1]
-		parent = filepath.Dir(lastMissingDir)
-	} else {
-		parent = c.User.GetHomeDir()
+	return vfs.AddVirtualDirs(c.User.VirtualFolders, sftpPath, files), nil
+}
+
+// Normalizes a path we get from the SFTP request to ensure the user is not able to escape
+// from their data directory. The path resolution is backend specific.
+func (c Connection) buildPath(rawPath string) (string, error) {
+	return c.fs.ResolvePath(rawPath)
+}
+
+// buildLinkPath is like buildPath but the last path component is not resolved,
+// so it can be used for symbolic links that point outside the user's root
+func (c Connection) buildLinkPath(rawPath string) (string, error) {
+	sftpPath := fsconfig.CleanSFTPPath(rawPath)
+	if sftpPath == "/" {
+		return c.buildPath(sftpPath)
 	}
-	p, err := filepath.EvalSymlinks(parent)
-	if err != nil {
-		return "", err
+	if folder, ok := c.User.GetVirtualFolderForPath(sftpPath); ok && folder.VirtualPath == sftpPath {
+		return c.buildPath(sftpPath)
 	}
-	fileInfo, err := os.Stat(p)
+	parent, err := c.buildPath(path.Dir(sftpPath))
 	if err != nil {
 		return "", err
 	}
-	if !fileInfo.IsDir() {
-		return "", fmt.Errorf("resolved path is not a dir: %v", p)
-	}
-	err = c.isSubDir(p)
-	return p, err
+	return filepath.Join(parent, path.Base(sftpPath)), nil
 }
 
-// checks if sub is a subpath of the user home dir.
-// EvalSymlink must be used on sub before calling this method
-func (c Connection) isSubDir(sub string) error {
-	// home dir must exist and it is already a validated absolute path
-	parent, err := filepath.EvalSymlinks(c.User.HomeDir)
-	if err != nil {
-		logger.Warn(logSender, "invalid home dir %v: %v", c.User.HomeDir, err)
-		return err
-	}
-	if !strings.HasPrefix(sub, parent) {
-		logger.Warn(logSender, "dir %v is not inside: %v ", sub, parent)
-		return fmt.Errorf("dir %v is not inside: %v", sub, parent)
+// iterate up the path chain until we hit a directory that does exist.
+// all nonexistent directories will be returned
+func (c Connection) findNonexistentDirs(filePath string) ([]string, error) {
+	results := []string{}
+	parent := filepath.Dir(filepath.Clean(filePath))
+	_, err := c.fs.Stat(parent)
+
+	for c.fs.IsNotExist(err) {
+		results = append(results, parent)
+		parent = filepath.Dir(parent)
+		_, err = c.fs.Stat(parent)
 	}
-	return nil
+	return results, err
 }
 
 func (c Connection) createMissingDirs(filePath string) error {
@@ -605,11 +1142,11 @@ func (c Connection) createMissingDirs(filePath string) error {
 	last := len(dirsToCreate) - 1
 	for i := range dirsToCreate {
 		d := dirsToCreate[last-i]
-		if err := os.Mkdir(d, 0777); err != nil {
+		if err := c.fs.Mkdir(d); err != nil {
 			logger.Error(logSender, "error creating missing dir: %v", d)
 			return err
 		}
-		utils.SetPathPermissions(d, c.User.GetUID(), c.User.GetGID())
+		vfs.SetPathPermissions(c.fs, d, c.User.GetUID(), c.User.GetGID())
 	}
 	return nil
 }
@@ -639,5 +1176,92 @@ func getOSOpenFlags(requestFlags sftp.FileOpenFlags) (flags int) {
 func getUploadTempFilePath(path string) string {
 	dir := filepath.Dir(path)
 	guid := xid.New().String()
-	return filepath.Join(dir, ".sftpgo-upload."+guid+"."+filepath.Base(path))
+	return filepath.Join(dir, uploadTempFilePrefix+guid+"."+filepath.Base(path))
+}
+
+// isStaleUploadTempFile returns true if name is an atomic upload temporary file created
+// more than staleUploadMaxAge ago, the creation time is encoded in the file name
+func isStaleUploadTempFile(name string, now time.Time) bool {
+	if !strings.HasPrefix(name, uploadTempFilePrefix) {
+		return false
+	}
+	guid := strings.SplitN(strings.TrimPrefix(name, uploadTempFilePrefix), ".", 2)[0]
+	id, err := xid.FromString(guid)
+	if err != nil {
+		return false
+	}
+	return now.Sub(id.Time()) > staleUploadMaxAge
+}
+
+// removeStaleUploadFiles removes the atomic upload temporary files left inside dir by a crash.
+// Each dir is checked at most once every staleUploadsCheckInterval and the files
+// uploaded by the active transfers are never removed
+func (c Connection) removeStaleUploadFiles(dir string) {
+	now := time.Now()
+	key := c.fs.Name() + ":" + dir
+	staleUploadsMutex.Lock()
+	if last, ok := staleUploadsChecks[key]; ok && now.Sub(last) < staleUploadsCheckInterval {
+		staleUploadsMutex.Unlock()
+		return
+	}
+	for k, last := range staleUploadsChecks {
+		if now.Sub(last) >= staleUploadsCheckInterval {
+			delete(staleUploadsChecks, k)
+		}
+	}
+	staleUploadsChecks[key] = now
+	staleUploadsMutex.Unlock()
+
+	lister, err := c.fs.OpenDir(dir)
+	if err != nil {
+		logger.Debug(logSender, "unable to check stale uploads inside %v: %v", dir, err)
+		return
+	}
+	defer lister.Close()
+	// the dir is read in batches and the check stops after staleUploadsMaxEntries entries,
+	// so a big dir is never listed as a whole for an upload
+	for checked := 0; checked < staleUploadsMaxEntries; {
+		files, err := lister.Readdir(dirListBatchSize)
+		for _, fi := range files {
+			checked++
+			if fi.IsDir() || !isStaleUploadTempFile(fi.Name(), now) {
+				continue
+			}
+			p := filepath.Join(dir, fi.Name())
+			if isActiveUpload(p) {
+				continue
+			}
+			removeErr := c.fs.Remove(p)
+			logger.Info(logSender, "removed stale upload file %v, user: %v, error: %v", p, c.User.Username, removeErr)
+		}
+		if err != nil || len(files) == 0 {
+			if err != nil && err != io.EOF {
+				logger.Debug(logSender, "unable to check stale uploads inside %v: %v", dir, err)
+			}
+			return
+		}
+	}
+}
+
+// errStorageUnavailable is returned for each SFTP request if the user's storage backend cannot be reached
+var errStorageUnavailable = errors.New("storage backend unavailable, please try again later")
+
+// unavailableHandler serves the SFTP sessions for which the storage backend cannot be reached:
+// each request fails with errStorageUnavailable
+type unavailableHandler struct{}
+
+func (unavailableHandler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	return nil, errStorageUnavailable
+}
+
+func (unavailableHandler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	return nil, errStorageUnavailable
+}
+
+func (unavailableHandler) Filecmd(request *sftp.Request) error {
+	return errStorageUnavailable
+}
+
+func (unavailableHandler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	return nil, errStorageUnavailable
 }
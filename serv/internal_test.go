@@ -1,17 +1,31 @@
 package serv
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+	"github.com/lulugyf/sshserv/vfs/vfstest"
+	"github.com/rs/xid"
 )
 
 type MockChannel struct {
@@ -106,7 +120,9 @@ func TestGetOSOpenFlags(t *testing.T) {
 }
 
 func TestUploadResume(t *testing.T) {
-	c := Connection{}
+	c := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	var flags sftp.FileOpenFlags
 	_, err := c.handleSFTPUploadToExistingFile(flags, "", "", 0)
 	if err != sftp.ErrSshFxOpUnsupported {
@@ -117,7 +133,9 @@ func TestUploadResume(t *testing.T) {
 func TestUploadFiles(t *testing.T) {
 	oldUploadMode := uploadMode
 	uploadMode = uploadModeAtomic
-	c := Connection{}
+	c := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	var flags sftp.FileOpenFlags
 	flags.Write = true
 	flags.Trunc = true
@@ -150,8 +168,9 @@ func TestWithInvalidHome(t *testing.T) {
 	}
 	c := Connection{
 		User: u,
+		fs:   vfs.NewOsFs("", u.HomeDir, nil),
 	}
-	err = c.isSubDir("dir_rel_path")
+	_, err = c.buildPath("dir_rel_path")
 	if err == nil {
 		t.Errorf("tested path is not a home subdir")
 	}
@@ -164,6 +183,7 @@ func TestSFTPCmdTargetPath(t *testing.T) {
 	u.Permissions = []string{"*"}
 	connection := Connection{
 		User: u,
+		fs:   vfs.NewOsFs("", u.HomeDir, nil),
 	}
 	_, err := connection.getSFTPCmdTargetPath("invalid_path")
 	if err != sftp.ErrSshFxOpUnsupported {
@@ -180,8 +200,9 @@ func TestSFTPGetUsedQuota(t *testing.T) {
 	u.Permissions = []string{"*"}
 	connection := Connection{
 		User: u,
+		fs:   vfs.NewOsFs("", u.HomeDir, nil),
 	}
-	res := connection.hasSpace(false)
+	res := connection.hasSpace(false, "")
 	if res != false {
 		t.Errorf("has space must return false if the user is invalid")
 	}
@@ -245,14 +266,16 @@ func TestSCPFileMode(t *testing.T) {
 }
 
 func TestSCPGetNonExistingDirContent(t *testing.T) {
-	_, err := getDirContents("non_existing")
+	_, err := vfs.NewOsFs("", "", nil).ReadDir("non_existing")
 	if err == nil {
 		t.Errorf("get non existing dir contents must fail")
 	}
 }
 
 func TestSCPParseUploadMessage(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	mockSSHChannel := MockChannel{
@@ -284,7 +307,9 @@ func TestSCPParseUploadMessage(t *testing.T) {
 }
 
 func TestSCPProtocolMessages(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	readErr := fmt.Errorf("test read error")
@@ -345,7 +370,9 @@ func TestSCPProtocolMessages(t *testing.T) {
 }
 
 func TestSCPTestDownloadProtocolMessages(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	readErr := fmt.Errorf("test read error")
@@ -409,7 +436,9 @@ func TestSCPTestDownloadProtocolMessages(t *testing.T) {
 }
 
 func TestSCPCommandHandleErrors(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	readErr := fmt.Errorf("test read error")
@@ -437,7 +466,9 @@ func TestSCPCommandHandleErrors(t *testing.T) {
 }
 
 func TestSCPRecursiveDownloadErrors(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	readErr := fmt.Errorf("test read error")
@@ -476,7 +507,9 @@ func TestSCPRecursiveDownloadErrors(t *testing.T) {
 }
 
 func TestSCPRecursiveUploadErrors(t *testing.T) {
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
 	readErr := fmt.Errorf("test read error")
@@ -518,6 +551,7 @@ func TestSCPCreateDirs(t *testing.T) {
 	u.Permissions = []string{"*"}
 	connection := Connection{
 		User: u,
+		fs:   vfs.NewOsFs("", u.HomeDir, nil),
 	}
 	mockSSHChannel := MockChannel{
 		Buffer:       bytes.NewBuffer(buf),
@@ -542,7 +576,9 @@ func TestSCPDownloadFileData(t *testing.T) {
 	readErr := fmt.Errorf("test read error")
 	writeErr := fmt.Errorf("test write error")
 	stdErrBuf := make([]byte, 65535)
-	connection := Connection{}
+	connection := Connection{
+		fs: vfs.NewOsFs("", "", nil),
+	}
 	mockSSHChannelReadErr := MockChannel{
 		Buffer:       bytes.NewBuffer(buf),
 		StdErrBuffer: bytes.NewBuffer(stdErrBuf),
@@ -672,3 +708,956 @@ func TestSCPUploadFiledata(t *testing.T) {
 	}
 	os.Remove(testfile)
 }
+
+func startEchoServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start echo server: %v", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+// startTestSOCKS5Proxy starts a minimal SOCKS5 proxy supporting the CONNECT command with
+// username/password authentication
+func startTestSOCKS5Proxy(t *testing.T, username, password string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start socks5 proxy: %v", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 2)
+				io.ReadFull(c, buf)
+				methods := make([]byte, buf[1])
+				io.ReadFull(c, methods)
+				c.Write([]byte{0x05, 0x02})
+				io.ReadFull(c, buf[:2])
+				u := make([]byte, buf[1])
+				io.ReadFull(c, u)
+				io.ReadFull(c, buf[:1])
+				p := make([]byte, buf[0])
+				io.ReadFull(c, p)
+				if string(u) != username || string(p) != password {
+					c.Write([]byte{0x01, 0x01})
+					return
+				}
+				c.Write([]byte{0x01, 0x00})
+				header := make([]byte, 4)
+				io.ReadFull(c, header)
+				var host string
+				switch header[3] {
+				case 0x01:
+					ip := make([]byte, 4)
+					io.ReadFull(c, ip)
+					host = net.IP(ip).String()
+				case 0x04:
+					ip := make([]byte, 16)
+					io.ReadFull(c, ip)
+					host = net.IP(ip).String()
+				case 0x03:
+					io.ReadFull(c, buf[:1])
+					h := make([]byte, buf[0])
+					io.ReadFull(c, h)
+					host = string(h)
+				}
+				io.ReadFull(c, buf)
+				port := int(buf[0])<<8 | int(buf[1])
+				dest, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+				if err != nil {
+					c.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer dest.Close()
+				c.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+				go io.Copy(dest, c)
+				io.Copy(c, dest)
+			}()
+		}
+	}()
+	return ln
+}
+
+func startTestHTTPProxy(t *testing.T, authorization string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start http proxy: %v", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				reader := bufio.NewReader(c)
+				req, err := http.ReadRequest(reader)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != authorization {
+					c.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				dest, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					c.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer dest.Close()
+				c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(dest, reader)
+				io.Copy(c, dest)
+			}()
+		}
+	}()
+	return ln
+}
+
+func checkEgressEcho(t *testing.T, d *egressDialer, dest string) {
+	conn, err := d.Dial(dest)
+	if err != nil {
+		t.Errorf("unable to dial %v: %v", dest, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := []byte("egress test")
+	conn.Write(msg)
+	resp := make([]byte, len(msg))
+	if _, err = io.ReadFull(conn, resp); err != nil || !bytes.Equal(resp, msg) {
+		t.Errorf("unexpected echo response: %q err: %v", resp, err)
+	}
+}
+
+func TestEgressDestinationMatch(t *testing.T) {
+	if !matchEgressDestination("*", "example.com", 22) {
+		t.Errorf("* must match any destination")
+	}
+	if !matchEgressDestination("10.0.0.0/8", "10.1.2.3", 22) || matchEgressDestination("10.0.0.0/8", "192.168.1.1", 22) {
+		t.Errorf("unexpected CIDR match result")
+	}
+	if !matchEgressDestination("*.Example.com", "host.example.com", 80) || matchEgressDestination("*.example.com", "example.org", 80) {
+		t.Errorf("unexpected pattern match result")
+	}
+	if !matchEgressDestination("db.local:5432", "db.local", 5432) || matchEgressDestination("db.local:5432", "db.local", 5433) {
+		t.Errorf("unexpected port match result")
+	}
+	if !matchEgressDestination("*:443", "any.host", 443) || matchEgressDestination("*:443", "any.host", 80) {
+		t.Errorf("unexpected wildcard port match result")
+	}
+	if !matchEgressDestination("::1", "::1", 22) {
+		t.Errorf("unexpected IPv6 match result")
+	}
+}
+
+func TestEgressInvalidConf(t *testing.T) {
+	_, err := newEgressDialer(EgressConf{DefaultAction: "invalid"})
+	if err == nil {
+		t.Errorf("invalid default action must fail")
+	}
+	_, err = newEgressDialer(EgressConf{DefaultAction: egressActionProxy, DefaultProxy: "missing"})
+	if err == nil {
+		t.Errorf("undefined proxy must fail")
+	}
+	_, err = newEgressDialer(EgressConf{Proxies: []EgressProxy{{Name: "p", Type: "ftp", Address: "127.0.0.1:21"}}})
+	if err == nil {
+		t.Errorf("invalid proxy type must fail")
+	}
+	_, err = newEgressDialer(EgressConf{Proxies: []EgressProxy{{Name: "p", Type: egressProxyHTTP, Address: "127.0.0.1"}}})
+	if err == nil {
+		t.Errorf("invalid proxy address must fail")
+	}
+	_, err = newEgressDialer(EgressConf{Rules: []EgressRule{{Destination: "", Action: egressActionDeny}}})
+	if err == nil {
+		t.Errorf("empty rule destination must fail")
+	}
+}
+
+func TestEgressDial(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	socksProxy := startTestSOCKS5Proxy(t, "user", "pass")
+	defer socksProxy.Close()
+	httpProxy := startTestHTTPProxy(t, "Basic dXNlcjpwYXNz")
+	defer httpProxy.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	d, err := newEgressDialer(EgressConf{
+		ConnectTimeout: 5,
+		DefaultAction:  egressActionDeny,
+		Proxies: []EgressProxy{
+			{Name: "socks", Type: egressProxySOCKS5, Address: socksProxy.Addr().String(), Username: "user", Password: "pass"},
+			{Name: "http", Type: egressProxyHTTP, Address: httpProxy.Addr().String(), Username: "user", Password: "pass"},
+			{Name: "badauth", Type: egressProxySOCKS5, Address: socksProxy.Addr().String(), Username: "user", Password: "wrong"},
+		},
+		Rules: []EgressRule{
+			{Destination: "127.0.0.1:" + echoPort, Action: egressActionDirect},
+			{Destination: "localhost", Action: egressActionProxy, Proxy: "socks"},
+			{Destination: "127.0.0.2/32", Action: egressActionProxy, Proxy: "badauth"},
+			{Destination: "127.0.0.0/8", Action: egressActionProxy, Proxy: "http"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create egress dialer: %v", err)
+	}
+	checkEgressEcho(t, d, echo.Addr().String())
+	checkEgressEcho(t, d, net.JoinHostPort("localhost", echoPort))
+
+	action, proxy := d.resolve("127.0.0.1", nil, 1)
+	if action != egressActionProxy || proxy.Name != "http" {
+		t.Errorf("unexpected resolved action: %v", action)
+	}
+	_, err = d.Dial("127.0.0.2:" + echoPort)
+	if err == nil {
+		t.Errorf("dial with wrong proxy credentials must fail")
+	}
+	_, err = d.Dial("192.0.2.1:22")
+	if err != errEgressDenied {
+		t.Errorf("unmatched destination must be denied, err: %v", err)
+	}
+	_, err = d.Dial("invalid")
+	if err == nil {
+		t.Errorf("dial an invalid destination must fail")
+	}
+
+	d.conf.Proxies[1].Username = ""
+	d.proxies["http"] = d.conf.Proxies[1]
+	_, err = d.dialProxy(&d.conf.Proxies[1], echo.Addr().String())
+	if err == nil {
+		t.Errorf("HTTP CONNECT without credentials must fail")
+	}
+}
+
+func TestEgressDenyResolvedHost(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	d, err := newEgressDialer(EgressConf{
+		ConnectTimeout: 5,
+		Rules: []EgressRule{
+			{Destination: "127.0.0.0/8", Action: egressActionDeny},
+			{Destination: "::1", Action: egressActionDeny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create egress dialer: %v", err)
+	}
+	// localhost resolves into the denied ranges
+	_, err = d.Dial(net.JoinHostPort("localhost", echoPort))
+	if err != errEgressDenied {
+		t.Errorf("a host name resolving to a denied address must be denied, err: %v", err)
+	}
+	_, err = d.Dial("missing.invalid:22")
+	if err == nil {
+		t.Errorf("dial a host name that cannot be resolved must fail")
+	}
+
+	d, err = newEgressDialer(EgressConf{
+		ConnectTimeout: 5,
+		Rules: []EgressRule{
+			{Destination: "10.0.0.0/8", Action: egressActionDeny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create egress dialer: %v", err)
+	}
+	checkEgressEcho(t, d, net.JoinHostPort("localhost", echoPort))
+}
+
+func TestEgressProxyHostName(t *testing.T) {
+	httpProxy := startTestHTTPProxy(t, "")
+	defer httpProxy.Close()
+	d, err := newEgressDialer(EgressConf{
+		ConnectTimeout: 5,
+		Proxies:        []EgressProxy{{Name: "http", Type: egressProxyHTTP, Address: httpProxy.Addr().String()}},
+		Rules: []EgressRule{
+			{Destination: "*.invalid", Action: egressActionProxy, Proxy: "http"},
+			{Destination: "127.0.0.0/8", Action: egressActionDeny},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create egress dialer: %v", err)
+	}
+	if d.needsAddresses("missing.invalid", 22) || !d.needsAddresses("localhost", 22) {
+		t.Errorf("unexpected address check requirement")
+	}
+	// the name cannot be resolved locally, it must be sent to the proxy as is
+	_, err = d.Dial("missing.invalid:22")
+	if err == nil || !strings.Contains(err.Error(), "HTTP CONNECT failed") {
+		t.Errorf("the host name must be sent to the proxy, err: %v", err)
+	}
+}
+
+func TestSOCKS5AuthReplyVersion(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 3)
+		io.ReadFull(server, buf)
+		server.Write([]byte{0x05, 0x02})
+		buf = make([]byte, 11)
+		io.ReadFull(server, buf)
+		// the subnegotiation reply must use version 1
+		server.Write([]byte{0x05, 0x00})
+	}()
+	err := socks5Connect(client, &EgressProxy{Username: "user", Password: "pass"}, "127.0.0.1:22")
+	if err == nil {
+		t.Errorf("an authentication reply with an invalid version must fail")
+	}
+}
+
+func TestTunnelNames(t *testing.T) {
+	savedConf := tunnelConf
+	defer setTunnelConf(savedConf)
+	setTunnelConf(TunnelConf{Enabled: false})
+	if name := getTunnelName("app.tunnel"); name != "" {
+		t.Errorf("tunnels are disabled, unexpected name: %#v", name)
+	}
+	setTunnelConf(TunnelConf{Enabled: true, HostDomain: ".Example.com."})
+	if name := getTunnelName("App.Tunnel"); name != "app" {
+		t.Errorf("unexpected tunnel name: %#v", name)
+	}
+	if name := getTunnelName("localhost"); name != "" {
+		t.Errorf("unexpected tunnel name: %#v", name)
+	}
+	if name := GetTunnelNameForHost("app.example.com:8080"); name != "app" {
+		t.Errorf("unexpected tunnel name for host: %#v", name)
+	}
+	if name := GetTunnelNameForHost("app.example.org"); name != "" {
+		t.Errorf("unexpected tunnel name for host: %#v", name)
+	}
+	err := addTunnel(&namedTunnel{name: "-app", username: "user1", connectionID: "conn1"})
+	if err == nil {
+		t.Errorf("invalid tunnel name must fail")
+	}
+	err = addTunnel(&namedTunnel{name: "testapp", username: "user1", connectionID: "conn1"})
+	if err != nil {
+		t.Errorf("unable to add tunnel: %v", err)
+	}
+	err = addTunnel(&namedTunnel{name: "testapp", username: "user2", connectionID: "conn2"})
+	if err == nil {
+		t.Errorf("tunnel owned by another user must fail")
+	}
+	if removeTunnel("testapp", "conn2") {
+		t.Errorf("tunnel removed by a different connection")
+	}
+	if !removeTunnel("testapp", "conn1") {
+		t.Errorf("unable to remove tunnel")
+	}
+	err = addTunnel(&namedTunnel{name: "testapp", username: "user2", connectionID: "conn2"})
+	if err == nil {
+		t.Errorf("an inactive tunnel owned by another user must fail")
+	}
+	err = addTunnel(&namedTunnel{name: "testapp", username: "user1", connectionID: "conn3"})
+	if err != nil {
+		t.Errorf("unable to add tunnel for its owner: %v", err)
+	}
+	removeTunnel("testapp", "conn3")
+	_, err = DialTunnel("testapp", "127.0.0.1:1234")
+	if err != ErrTunnelNotFound {
+		t.Errorf("unexpected error dialing a missing tunnel: %v", err)
+	}
+}
+
+func TestChannelDeadline(t *testing.T) {
+	var d channelDeadline
+	blockingOp := func(closed chan struct{}) func() (int, error) {
+		return func() (int, error) {
+			<-closed
+			return 0, io.EOF
+		}
+	}
+	closed := make(chan struct{})
+	closeFn := func() error {
+		close(closed)
+		return nil
+	}
+	d.set(time.Now().Add(50 * time.Millisecond))
+	_, err := d.do(0, closeFn, blockingOp(closed))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("a pending operation must fail with a timeout after the deadline, err: %v", err)
+	}
+	_, err = d.do(0, closeFn, func() (int, error) {
+		return 1, nil
+	})
+	if err != errChannelTimeout {
+		t.Errorf("an operation after the deadline must fail, err: %v", err)
+	}
+	d.set(time.Time{})
+	closed = make(chan struct{})
+	_, err = d.do(50*time.Millisecond, closeFn, blockingOp(closed))
+	if err != errChannelTimeout {
+		t.Errorf("a pending operation must fail after the idle timeout, err: %v", err)
+	}
+	n, err := d.do(time.Second, closeFn, func() (int, error) {
+		return 1, nil
+	})
+	if n != 1 || err != nil {
+		t.Errorf("unexpected result: %v, err: %v", n, err)
+	}
+}
+
+func TestApplyQuotaToStatVFS(t *testing.T) {
+	stat := &sftp.StatVFS{
+		Bsize:  4096,
+		Frsize: 4096,
+		Blocks: 1000000,
+		Bfree:  500000,
+		Bavail: 400000,
+		Files:  100000,
+		Ffree:  50000,
+		Favail: 50000,
+	}
+	applyQuotaToStatVFS(stat, 10, 4, 4096*100, 4096*30)
+	if stat.Blocks != 100 || stat.Bfree != 70 || stat.Bavail != 70 {
+		t.Errorf("unexpected blocks: %v free: %v avail: %v", stat.Blocks, stat.Bfree, stat.Bavail)
+	}
+	if stat.Files != 10 || stat.Ffree != 6 || stat.Favail != 6 {
+		t.Errorf("unexpected files: %v free: %v avail: %v", stat.Files, stat.Ffree, stat.Favail)
+	}
+	// the available space is lower than the quota
+	stat = &sftp.StatVFS{Bsize: 4096, Frsize: 4096, Blocks: 1000, Bfree: 20, Bavail: 10}
+	applyQuotaToStatVFS(stat, 0, 0, 4096*100, 4096*200)
+	if stat.Blocks != 100 || stat.Bfree != 0 || stat.Bavail != 0 {
+		t.Errorf("unexpected blocks: %v free: %v avail: %v", stat.Blocks, stat.Bfree, stat.Bavail)
+	}
+	// inodes are not reported by the backend
+	stat = &sftp.StatVFS{Bsize: 4096, Frsize: 4096, Blocks: 1000, Bfree: 20, Bavail: 10}
+	applyQuotaToStatVFS(stat, 10, 3, 0, 0)
+	if stat.Blocks != 1000 || stat.Files != 10 || stat.Ffree != 7 || stat.Favail != 7 {
+		t.Errorf("unexpected stat: %+v", stat)
+	}
+}
+
+func TestDirListerAt(t *testing.T) {
+	homeDir, err := ioutil.TempDir("", "dirlister")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(homeDir)
+	mappedDir, err := ioutil.TempDir("", "dirlistermapped")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(mappedDir)
+	for i := 0; i < 25; i++ {
+		ioutil.WriteFile(filepath.Join(homeDir, fmt.Sprintf("file%v.txt", i)), []byte("data"), 0666)
+	}
+	ioutil.WriteFile(filepath.Join(homeDir, "hidden.log"), []byte("data"), 0666)
+	os.Mkdir(filepath.Join(homeDir, "vdir"), 0777)
+	u := dataprovider.User{}
+	u.HomeDir = homeDir
+	u.Username = "test"
+	u.VirtualFolders = []fsconfig.VirtualFolder{{VirtualPath: "/vdir", MappedPath: mappedDir}}
+	u.FilePatterns = []dataprovider.PatternsFilter{{Path: "/", DeniedPatterns: []string{"*.log"}, HideDenied: true}}
+	c := Connection{
+		User: u,
+		fs:   vfs.NewOsFs("", homeDir, u.VirtualFolders),
+	}
+	listAll := func(maxEntries int) []string {
+		lister, err := c.fs.OpenDir(homeDir)
+		if err != nil {
+			t.Fatalf("unable to open dir: %v", err)
+		}
+		l := newDirListerAt(c, homeDir, lister, maxEntries)
+		l.batchSize = 7
+		defer l.Close()
+		var names []string
+		var offset int64
+		for {
+			f := make([]os.FileInfo, 4)
+			n, err := l.ListAt(f, offset)
+			for _, fi := range f[:n] {
+				names = append(names, fi.Name())
+			}
+			offset += int64(n)
+			if err == io.EOF {
+				return names
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	names := listAll(0)
+	if len(names) != 26 {
+		t.Errorf("unexpected number of entries: %v", len(names))
+	}
+	if names[0] != "vdir" {
+		t.Errorf("virtual dirs must be listed first, got: %v", names[0])
+	}
+	for _, name := range names[1:] {
+		if name == "vdir" || name == "hidden.log" {
+			t.Errorf("unexpected entry: %v", name)
+		}
+	}
+	names = listAll(10)
+	if len(names) != 10 {
+		t.Errorf("listing must be truncated to 10 entries, got: %v", len(names))
+	}
+	lister, _ := c.fs.OpenDir(homeDir)
+	l := newDirListerAt(c, homeDir, lister, 0)
+	l.batchSize = 7
+	f := make([]os.FileInfo, 4)
+	// a client can skip ahead and request again the offsets still buffered
+	names = listAll(0)
+	n, err := l.ListAt(f, 10)
+	if n != 4 || err != nil || names[10] != f[0].Name() {
+		t.Errorf("unexpected result listing ahead: %v, %v", n, err)
+	}
+	n, err = l.ListAt(f, 8)
+	if n != 4 || err != nil || names[8] != f[0].Name() || names[11] != f[3].Name() {
+		t.Errorf("unexpected result listing a buffered offset: %v, %v", n, err)
+	}
+	n, err = l.ListAt(f, 20)
+	if n != 4 || err != nil || names[20] != f[0].Name() {
+		t.Errorf("unexpected result listing ahead: %v, %v", n, err)
+	}
+	if _, err = l.ListAt(f, 8); err == nil {
+		t.Errorf("listing from a discarded offset must fail")
+	}
+	l.Close()
+	// the parent of a virtual folder does not need to exist
+	l = newDirListerAt(c, homeDir, nil, 0)
+	n, err = l.ListAt(f, 0)
+	if n != 1 || err != io.EOF {
+		t.Errorf("unexpected result for missing dir: %v, %v", n, err)
+	}
+}
+
+func TestAtomicUploadCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicupload")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	c := Connection{
+		User: dataprovider.User{Username: "test"},
+		fs:   vfs.NewOsFs("", dir, nil),
+	}
+	target := filepath.Join(dir, "file.txt")
+	newTransfer := func() *Transfer {
+		file, err := c.fs.Create(getUploadTempFilePath(target), 0)
+		if err != nil {
+			t.Fatalf("unable to create temporary file: %v", err)
+		}
+		transfer := &Transfer{
+			file:         file,
+			fs:           c.fs,
+			path:         target,
+			start:        time.Now(),
+			user:         c.User,
+			transferType: transferUpload,
+			lastActivity: time.Now(),
+			isNewFile:    true,
+			protocol:     protocolSFTP,
+		}
+		addTransfer(transfer)
+		transfer.WriteAt([]byte("data"), 0)
+		return transfer
+	}
+	transfer := newTransfer()
+	transfer.TransferError(io.ErrUnexpectedEOF)
+	if err := transfer.Close(); err != io.ErrUnexpectedEOF {
+		t.Errorf("closing a failed atomic upload must return the transfer error, got: %v", err)
+	}
+	if _, err := os.Stat(transfer.file.Name()); !os.IsNotExist(err) {
+		t.Errorf("the temporary file of a failed atomic upload must be removed")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("a failed atomic upload must not create the target file")
+	}
+	transfer = newTransfer()
+	if err := transfer.Close(); err != nil {
+		t.Errorf("unexpected error closing atomic upload: %v", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("the target file must exist after a completed atomic upload: %v", err)
+	}
+
+	old := xid.New()
+	binary.BigEndian.PutUint32(old[:4], uint32(time.Now().Add(-2*staleUploadMaxAge).Unix()))
+	staleFile := filepath.Join(dir, uploadTempFilePrefix+old.String()+".file.txt")
+	recentFile := getUploadTempFilePath(target)
+	for _, name := range []string{staleFile, recentFile} {
+		if err := ioutil.WriteFile(name, []byte("data"), 0666); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+	}
+	c.removeStaleUploadFiles(dir)
+	if _, err := os.Stat(staleFile); !os.IsNotExist(err) {
+		t.Errorf("stale upload file must be removed")
+	}
+	if _, err := os.Stat(recentFile); err != nil {
+		t.Errorf("recent upload file must not be removed: %v", err)
+	}
+	// the same dir is checked at most once for each interval
+	ioutil.WriteFile(staleFile, []byte("data"), 0666)
+	c.removeStaleUploadFiles(dir)
+	if _, err := os.Stat(staleFile); err != nil {
+		t.Errorf("stale upload files must not be checked again so soon: %v", err)
+	}
+}
+
+func TestScheduledSnapshotsRetention(t *testing.T) {
+	snapshots := []vfs.HdfsSnapshot{
+		{Name: "scheduled-20260101-000000.000"},
+		{Name: "manual"},
+		{Name: "scheduled-20260102-000000.000"},
+		{Name: "scheduled-20260103-000000.000"},
+	}
+	expired := getExpiredSnapshots(snapshots, 2)
+	if len(expired) != 1 || expired[0] != "scheduled-20260101-000000.000" {
+		t.Errorf("unexpected expired snapshots: %v", expired)
+	}
+	if len(getExpiredSnapshots(snapshots, 3)) != 0 || len(getExpiredSnapshots(snapshots, 0)) != 0 {
+		t.Errorf("no snapshot must expire within the retention")
+	}
+	for _, name := range []string{"", ".", "..", "a/b"} {
+		if isValidSnapshotName(name) {
+			t.Errorf("snapshot name %#v must be invalid", name)
+		}
+	}
+	if !isValidSnapshotName("s20260101-000000.000") {
+		t.Errorf("valid snapshot name rejected")
+	}
+}
+
+func TestUserDirUsage(t *testing.T) {
+	homeDir, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(homeDir)
+	folderDir, err := ioutil.TempDir("", "folder")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(folderDir)
+	for _, name := range []string{filepath.Join(homeDir, "file1"), filepath.Join(folderDir, "file2")} {
+		if err = ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatalf("unable to write file: %v", err)
+		}
+	}
+	u := dataprovider.User{HomeDir: homeDir}
+	u.VirtualFolders = []fsconfig.VirtualFolder{
+		{VirtualPath: "/shared", MappedPath: folderDir},
+		{VirtualPath: "/missing", MappedPath: filepath.Join(folderDir, "missing")},
+	}
+	fs := vfs.NewOsFs("", homeDir, u.VirtualFolders)
+	numFiles, size, err := getUserDirUsage(fs, u)
+	if err != nil || numFiles != 2 || size != 8 {
+		t.Errorf("unexpected usage: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+	// the files inside a folder with its own quota do not count against the user's quota
+	u.VirtualFolders[0].QuotaFiles = 10
+	numFiles, size, err = getUserDirUsage(fs, u)
+	if err != nil || numFiles != 1 || size != 4 {
+		t.Errorf("unexpected usage: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+}
+
+func TestCreateHomeDir(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "test_home_"+xid.New().String())
+	defer os.RemoveAll(homeDir)
+	user := dataprovider.User{Username: "test_home_user", HomeDir: homeDir}
+	conf := &Configuration{Ext: &ExtConf{HDFS: "test@127.0.0.1:1"}}
+	// the HDFS home dir is not created at login
+	if _, err := loginUser(user, conf); err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+	if _, err := os.Stat(homeDir); !os.IsNotExist(err) {
+		t.Errorf("the local home dir must not be created for HDFS users, err: %v", err)
+	}
+	client := newFakeHdfsClient("/hdfs")
+	createHomeDir(vfs.NewHdfsFsWithClient("", homeDir, nil, client), user)
+	if fi, err := client.Stat(homeDir); err != nil || !fi.IsDir() {
+		t.Errorf("the home dir must be created on HDFS, err: %v", err)
+	}
+	conf.Ext = nil
+	if _, err := loginUser(user, conf); err != nil {
+		t.Fatalf("unexpected login error: %v", err)
+	}
+	if fi, err := os.Stat(homeDir); err != nil || !fi.IsDir() {
+		t.Errorf("the local home dir must be created at login, err: %v", err)
+	}
+	conf.Ext = &ExtConf{HDFS: "invalid"}
+	if _, err := conf.getFsConfig(user); err == nil {
+		t.Errorf("an invalid HDFS configuration must fail")
+	}
+}
+
+// fakeHdfsClient adapts the in memory client shared with the vfs tests to vfs.HdfsClient
+type fakeHdfsClient struct {
+	*vfstest.HdfsClient
+}
+
+func newFakeHdfsClient(dirs ...string) fakeHdfsClient {
+	return fakeHdfsClient{HdfsClient: vfstest.NewHdfsClient(dirs...)}
+}
+
+func (c fakeHdfsClient) Open(name string) (vfs.HdfsFileReader, error) {
+	r, err := c.HdfsClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c fakeHdfsClient) Create(name string) (vfs.HdfsFileWriter, error) {
+	w, err := c.HdfsClient.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c fakeHdfsClient) Append(name string) (vfs.HdfsFileWriter, error) {
+	w, err := c.HdfsClient.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c fakeHdfsClient) GetContentSummary(name string) (vfs.HdfsContentSummary, error) {
+	cs, err := c.HdfsClient.GetContentSummary(name)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// waitAction waits for the HTTP notification of the given action and returns its path
+func waitAction(t *testing.T, notifications chan url.Values, username, action string) string {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case q := <-notifications:
+			if q.Get("username") == username && q.Get("action") == action {
+				return q.Get("path")
+			}
+		case <-timeout:
+			t.Errorf("no %v action notified for user %v", action, username)
+			return ""
+		}
+	}
+}
+
+func getTransferStats(connectionID string) []connectionTransfer {
+	for _, stats := range GetConnectionsStats() {
+		if stats.ConnectionID == connectionID {
+			return stats.Transfers
+		}
+	}
+	return nil
+}
+
+func TestHdfsTransfers(t *testing.T) {
+	notifications := make(chan url.Values, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications <- r.URL.Query()
+	}))
+	defer server.Close()
+	oldActions := actions
+	actions = Actions{
+		ExecuteOn:           []string{operationDownload, operationUpload, operationDelete},
+		HTTPNotificationURL: server.URL,
+	}
+	defer func() { actions = oldActions }()
+
+	u := dataprovider.User{
+		Username:    "test_hdfs_user",
+		Password:    "test_hdfs_password",
+		HomeDir:     "/hdfs/test_hdfs_user",
+		Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload},
+		QuotaFiles:  1,
+	}
+	if err := dataprovider.AddUser(dataProvider, u); err != nil {
+		t.Fatalf("unable to add user: %v", err)
+	}
+	user, err := dataprovider.UserExists(dataProvider, u.Username)
+	if err != nil {
+		t.Fatalf("unable to get user: %v", err)
+	}
+	defer dataprovider.DeleteUser(dataProvider, user)
+
+	client := newFakeHdfsClient(user.HomeDir)
+	c := Connection{
+		ID:         xid.New().String(),
+		User:       user,
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222},
+		StartTime:  time.Now(),
+		protocol:   protocolSFTP,
+		lock:       new(sync.Mutex),
+		fs:         vfs.NewHdfsFsWithClient(xid.New().String(), user.HomeDir, nil, client),
+	}
+	addConnection(c.ID, c)
+	defer removeConnection(c.ID)
+	testFilePath := path.Join(user.HomeDir, "file.dat")
+	data := []byte("test data for the HDFS backend")
+
+	writer, err := c.Filewrite(sftp.NewRequest("Put", "/file.dat"))
+	if err != nil {
+		t.Fatalf("unable to upload file: %v", err)
+	}
+	if _, err = writer.WriteAt(data, 0); err != nil {
+		t.Errorf("unable to write data: %v", err)
+	}
+	transfers := getTransferStats(c.ID)
+	if len(transfers) != 1 || transfers[0].OperationType != operationUpload || transfers[0].Size != int64(len(data)) ||
+		transfers[0].Path != "/file.dat" {
+		t.Errorf("unexpected upload stats: %+v", transfers)
+	}
+	if err = writer.(io.Closer).Close(); err != nil {
+		t.Errorf("unable to close uploaded file: %v", err)
+	}
+	if transfers = getTransferStats(c.ID); len(transfers) != 0 {
+		t.Errorf("the upload must be removed from the active transfers: %+v", transfers)
+	}
+	if fi, err := client.Stat(testFilePath); err != nil || !bytes.Equal(fi.(vfstest.HdfsFileInfo).Data(), data) {
+		t.Errorf("unexpected uploaded file, err: %v", err)
+	}
+	if p := waitAction(t, notifications, user.Username, operationUpload); p != testFilePath {
+		t.Errorf("unexpected upload action path: %#v", p)
+	}
+	numFiles, size, err := dataprovider.GetUsedQuota(dataProvider, user.Username)
+	if err != nil || numFiles != 1 || size != int64(len(data)) {
+		t.Errorf("unexpected quota after upload: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+
+	reader, err := c.Fileread(sftp.NewRequest("Get", "/file.dat"))
+	if err != nil {
+		t.Fatalf("unable to download file: %v", err)
+	}
+	buf := make([]byte, len(data))
+	if n, err := reader.ReadAt(buf, 0); err != nil || n != len(data) || !bytes.Equal(buf, data) {
+		t.Errorf("unexpected downloaded data: %#v, err: %v", string(buf[:n]), err)
+	}
+	transfers = getTransferStats(c.ID)
+	if len(transfers) != 1 || transfers[0].OperationType != operationDownload || transfers[0].Size != int64(len(data)) {
+		t.Errorf("unexpected download stats: %+v", transfers)
+	}
+	if err = reader.(io.Closer).Close(); err != nil {
+		t.Errorf("unable to close downloaded file: %v", err)
+	}
+	if transfers = getTransferStats(c.ID); len(transfers) != 0 {
+		t.Errorf("the download must be removed from the active transfers: %+v", transfers)
+	}
+	if p := waitAction(t, notifications, user.Username, operationDownload); p != testFilePath {
+		t.Errorf("unexpected download action path: %#v", p)
+	}
+
+	// the HDFS usage is used for the quota check, the user can store a single file
+	if _, err = c.Filewrite(sftp.NewRequest("Put", "/file1.dat")); err != sftp.ErrSshFxFailure {
+		t.Errorf("upload over quota must fail, error: %v", err)
+	}
+	if _, err := client.Stat(path.Join(user.HomeDir, "file1.dat")); err == nil {
+		t.Errorf("the file denied by quota must not be created")
+	}
+	if client.Summaries == 0 {
+		t.Errorf("the used quota must be read from the HDFS content summary")
+	}
+
+	if err = c.Filecmd(sftp.NewRequest("Remove", "/file.dat")); err != sftp.ErrSshFxPermissionDenied {
+		t.Errorf("remove without the delete permission must fail, error: %v", err)
+	}
+	if _, err := client.Stat(testFilePath); err != nil {
+		t.Errorf("the file must not be removed without the delete permission")
+	}
+	numFiles, size, err = dataprovider.GetUsedQuota(dataProvider, user.Username)
+	if err != nil || numFiles != 1 || size != int64(len(data)) {
+		t.Errorf("a denied remove must not update the quota: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+	select {
+	case q := <-notifications:
+		if q.Get("username") == user.Username {
+			t.Errorf("unexpected action for a denied remove: %v", q)
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestHdfsDirUsageCache(t *testing.T) {
+	client := newFakeHdfsClient("/hdfs/test_usage_user", "/hdfs/shared")
+	client.AddFile("/hdfs/test_usage_user/file", []byte("data"))
+	folder := fsconfig.VirtualFolder{VirtualPath: "/shared", MappedPath: "/hdfs/shared", QuotaFiles: 10}
+	c := Connection{
+		User: dataprovider.User{
+			Username:       "test_usage_user",
+			HomeDir:        "/hdfs/test_usage_user",
+			QuotaFiles:     10,
+			VirtualFolders: []fsconfig.VirtualFolder{folder},
+		},
+		fs: vfs.NewHdfsFsWithClient("", "/hdfs/test_usage_user", []fsconfig.VirtualFolder{folder}, client),
+	}
+	userKey := getUserDirUsageKey(c.User.Username)
+	folderKey := getFolderDirUsageKey(folder.MappedPath)
+	defer removeCachedDirUsage(userKey)
+	defer removeCachedDirUsage(folderKey)
+
+	for i := 0; i < 2; i++ {
+		numFiles, size, err := c.getUsedQuota()
+		if err != nil || numFiles != 1 || size != 4 {
+			t.Errorf("unexpected usage: %v files, %v bytes, err: %v", numFiles, size, err)
+		}
+	}
+	if client.Summaries != 1 {
+		t.Errorf("the cached usage must be used, content summaries: %v", client.Summaries)
+	}
+	// the uploads done by this server update the cached usage
+	updateQuota(c.fs, c.User, "/hdfs/test_usage_user/file1", 1, 6)
+	numFiles, size, err := c.getUsedQuota()
+	if err != nil || numFiles != 2 || size != 10 || client.Summaries != 1 {
+		t.Errorf("unexpected updated usage: %v files, %v bytes, err: %v, content summaries: %v",
+			numFiles, size, err, client.Summaries)
+	}
+	// once expired the namenode is asked again
+	dirUsagesMutex.Lock()
+	usage := dirUsages[userKey]
+	usage.expires = time.Now().Add(-time.Second)
+	dirUsages[userKey] = usage
+	dirUsagesMutex.Unlock()
+	numFiles, size, err = c.getUsedQuota()
+	if err != nil || numFiles != 1 || size != 4 || client.Summaries != 2 {
+		t.Errorf("unexpected usage after expiration: %v files, %v bytes, err: %v, content summaries: %v",
+			numFiles, size, err, client.Summaries)
+	}
+
+	numFiles, size, err = getFolderUsage(c.fs, folder)
+	if err != nil || numFiles != 0 || size != 0 || client.Summaries != 3 {
+		t.Errorf("unexpected folder usage: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+	updateQuota(c.fs, c.User, "/hdfs/shared/file", 1, 4)
+	numFiles, size, err = getFolderUsage(c.fs, folder)
+	if err != nil || numFiles != 1 || size != 4 || client.Summaries != 3 {
+		t.Errorf("unexpected updated folder usage: %v files, %v bytes, err: %v, content summaries: %v",
+			numFiles, size, err, client.Summaries)
+	}
+	// the user's usage does not include the folder with its own quota
+	numFiles, _, _ = c.getUsedQuota()
+	if numFiles != 1 {
+		t.Errorf("unexpected user usage: %v files", numFiles)
+	}
+}
@@ -55,3 +55,27 @@ func (fi sftpFileInfo)ModTime() time.Time {
 func (fi sftpFileInfo)IsDir() bool {
 	return fi.h.IsDir()
 }
+
+// linkTargetInfo is returned for Readlink requests, the name is the link destination
+type linkTargetInfo struct {
+	target string
+}
+
+func (fi linkTargetInfo) Name() string {
+	return fi.target
+}
+func (fi linkTargetInfo) Size() int64 {
+	return int64(len(fi.target))
+}
+func (fi linkTargetInfo) Mode() os.FileMode {
+	return os.ModeSymlink | 0777
+}
+func (fi linkTargetInfo) ModTime() time.Time {
+	return time.Time{}
+}
+func (fi linkTargetInfo) IsDir() bool {
+	return false
+}
+func (fi linkTargetInfo) Sys() interface{} {
+	return nil
+}
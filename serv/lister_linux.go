@@ -5,8 +5,66 @@ package serv
 import (
 	"github.com/lulugyf/sshserv/sftp"
 	"os/user"
+	"strconv"
+	"sync"
 	"syscall"
-	"fmt"
+	"time"
+)
+
+// how long a resolved user or group name is cached
+const idNameCacheTTL = 5 * time.Minute
+
+type idNameEntry struct {
+	name    string
+	expires time.Time
+}
+
+// idNameCache caches the uid/gid to name lookups, a listing with many files owned by
+// the same user would otherwise query the user database once for each entry
+type idNameCache struct {
+	sync.Mutex
+	names  map[uint32]idNameEntry
+	lookup func(id string) (string, error)
+}
+
+func newIDNameCache(lookup func(id string) (string, error)) *idNameCache {
+	return &idNameCache{
+		names:  make(map[uint32]idNameEntry),
+		lookup: lookup,
+	}
+}
+
+// get returns the name for the given id, unknown ids are cached too
+func (c *idNameCache) get(id uint32) string {
+	now := time.Now()
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.names[id]; ok && now.Before(e.expires) {
+		return e.name
+	}
+	name, err := c.lookup(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = "[unknown]"
+	}
+	c.names[id] = idNameEntry{name: name, expires: now.Add(idNameCacheTTL)}
+	return name
+}
+
+var (
+	userNames = newIDNameCache(func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	groupNames = newIDNameCache(func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
 )
 
 /*
@@ -14,22 +72,16 @@ import (
    (ls -l)'s return format code position: sftp/request.go: filelist() -> sftp/server_unix.go: runLs()
 */
 func (fi sftpFileInfo) Sys() interface{} {
-	st := fi.h.Sys().(*syscall.Stat_t)
-	uname := "[unknown]"
-	gname := "[unknown]"
-	u, err := user.LookupId(fmt.Sprintf("%d", st.Uid) )
-	if err == nil {
-		uname = u.Username
-	}
-	g, err := user.LookupGroupId(fmt.Sprintf("%d", st.Gid))
-	if err == nil {
-		gname = g.Name
+	st, ok := fi.h.Sys().(*syscall.Stat_t)
+	if !ok {
+		// virtual dirs and non local backends already provide the attributes
+		return fi.h.Sys()
 	}
 	return &sftp.SftpFileAttr{
-		Uid: st.Uid,
-		Gid: st.Gid,
+		Uid:   st.Uid,
+		Gid:   st.Gid,
 		Nlink: st.Nlink,
-		Uname: uname, //st.GetOwner(),
-		Gname: gname, // st.GetGroup(),
+		Uname: userNames.get(st.Uid),
+		Gname: groupNames.get(st.Gid),
 	}
-}
\ No newline at end of file
+}
@@ -1,18 +1,24 @@
 package serv
 
 import (
+	"encoding/hex"
 	"github.com/lulugyf/sshserv/logger"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"net"
 	"strconv"
 	"sync"
+	"time"
 )
 
 ///////////////////////// Remote Port Forward  /////////////
 
 type ForwardedTCPHandler struct {
 	forwards map[string]net.Listener
+	// named tunnels registered by this connection, bind address -> tunnel name
+	tunnels      map[string]string
+	username     string
+	connectionID string
 	sync.Mutex
 }
 
@@ -24,6 +30,9 @@ func (h *ForwardedTCPHandler) handlePortforward(conn *ssh.ServerConn, req *ssh.R
 	if h.forwards == nil {
 		h.forwards = make(map[string]net.Listener)
 	}
+	if h.tunnels == nil {
+		h.tunnels = make(map[string]string)
+	}
 	h.Unlock()
 
 	switch req.Type {
@@ -33,6 +42,9 @@ func (h *ForwardedTCPHandler) handlePortforward(conn *ssh.ServerConn, req *ssh.R
 			logger.Error(logSender,"R Unmarshal failed %v", err)
 			return false, []byte{}, ""
 		}
+		if name := getTunnelName(reqPayload.BindAddr); name != "" {
+			return h.handleTunnelForward(conn, name, reqPayload)
+		}
 		addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
 		logger.Debug(logLforward, "bind addr: [%s]\n", addr)
 		ln, err := net.Listen("tcp", addr)
@@ -62,13 +74,15 @@ func (h *ForwardedTCPHandler) handlePortforward(conn *ssh.ServerConn, req *ssh.R
 					OriginPort: uint32(originPort),
 				})
 				go func() {
-					ch, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+					channel, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
 					if err != nil {
 						logger.Error(logSender, "open forwarded-tcpip channel failed, %v", err)
 						c.Close()
 						return
 					}
 					go ssh.DiscardRequests(reqs)
+					ch := newActiveChannel(channel, h.connectionID, channelTypeForwardedTCPIP,
+						c.RemoteAddr().String()+" -> "+addr)
 					go func() {
 						defer ch.Close()
 						defer c.Close()
@@ -96,16 +110,62 @@ func (h *ForwardedTCPHandler) handlePortforward(conn *ssh.ServerConn, req *ssh.R
 		addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
 		h.Lock()
 		ln, ok := h.forwards[addr]
+		name, isTunnel := h.tunnels[addr]
+		delete(h.tunnels, addr)
 		h.Unlock()
 		if ok {
 			ln.Close()
 		}
+		if isTunnel {
+			removeTunnel(name, h.connectionID)
+		}
 		return true, nil, ""
 	default:
 		return false, nil, ""
 	}
 }
 
+// handleTunnelForward registers a named tunnel instead of opening a TCP listener
+func (h *ForwardedTCPHandler) handleTunnelForward(conn *ssh.ServerConn, name string,
+	reqPayload remoteForwardRequest) (bool, []byte, string) {
+	bindPort := reqPayload.BindPort
+	if bindPort == 0 {
+		bindPort = 80
+	}
+	err := addTunnel(&namedTunnel{
+		name:         name,
+		username:     h.username,
+		connectionID: h.connectionID,
+		bindAddr:     reqPayload.BindAddr,
+		bindPort:     bindPort,
+		start:        time.Now(),
+		sshConn:      conn,
+	})
+	if err != nil {
+		logger.Warn(logTunnel, "unable to register tunnel for user %v: %v", h.username, err)
+		return false, []byte{}, ""
+	}
+	addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
+	h.Lock()
+	h.tunnels[addr] = name
+	h.Unlock()
+	logger.Info(logTunnel, "tunnel %#v registered, user: %v connection id: %v", name, h.username, h.connectionID)
+	return true, ssh.Marshal(&remoteForwardSuccess{bindPort}), addr
+}
+
+// closeAll closes the listeners and unregisters the named tunnels
+func (h *ForwardedTCPHandler) closeAll() {
+	h.Lock()
+	defer h.Unlock()
+	for lnAddr, ln := range h.forwards {
+		ln.Close()
+		logger.Debug(logRforward, "   R ln_addr [%s] closed\n", lnAddr)
+	}
+	for _, name := range h.tunnels {
+		removeTunnel(name, h.connectionID)
+	}
+}
+
 
 
 
@@ -150,19 +210,24 @@ func HandleDirectTCPIP( conn *ssh.ServerConn, newChan ssh.NewChannel) {
 	dest := net.JoinHostPort(d.DestAddr, strconv.FormatInt(int64(d.DestPort), 10))
 	logger.Debug(logLforward, "forward to dest: %s", dest)
 
-	var dialer net.Dialer
-	dconn, err := dialer.Dial("tcp", dest)
-	if err != nil {
+	dconn, err := egress.Dial(dest)
+	if err == errEgressDenied {
+		logger.Warn(logLforward, "forward to dest %s denied for user %s", dest, conn.User())
+		newChan.Reject(ssh.Prohibited, err.Error())
+		return
+	} else if err != nil {
+		logger.Warn(logLforward, "forward to dest %s failed: %v", dest, err)
 		newChan.Reject(ssh.ConnectionFailed, err.Error())
 		return
 	}
 
-	ch, reqs, err := newChan.Accept()
+	channel, reqs, err := newChan.Accept()
 	if err != nil {
 		dconn.Close()
 		return
 	}
 	go ssh.DiscardRequests(reqs)
+	ch := newActiveChannel(channel, hex.EncodeToString(conn.SessionID()), channelTypeDirectTCPIP, dest)
 
 	go func() {
 		defer ch.Close()
@@ -14,6 +14,7 @@ import (
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -40,8 +41,6 @@ type scpCommand struct {
 
 func (c *scpCommand) handle() error {
 	var err error
-	addConnection(c.connection.ID, c.connection)
-	defer removeConnection(c.connection.ID)
 	destPath := c.getDestPath()
 	commandType := c.getCommandType()
 	logger.Debug(logSenderSCP, "handle scp command, args: %v user: %v command type: %v, dest path: %v",
@@ -125,7 +124,7 @@ func (c *scpCommand) handleRecursiveUpload() error {
 
 func (c *scpCommand) handleCreateDir(dirPath string) error {
 	updateConnectionActivity(c.connection.ID)
-	if !c.connection.User.HasPerm(dataprovider.PermCreateDirs) {
+	if !c.connection.hasPermInSFTPPath(dataprovider.PermCreateDirs, dirPath) {
 		err := fmt.Errorf("Permission denied")
 		logger.Warn(logSenderSCP, "error creating dir: %v, permission denied", dirPath)
 		c.sendErrorMessage(err.Error())
@@ -151,6 +150,7 @@ func (c *scpCommand) handleCreateDir(dirPath string) error {
 func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *Transfer) error {
 	err := c.sendConfirmationMessage()
 	if err != nil {
+		transfer.TransferError(err)
 		transfer.Close()
 		return err
 	}
@@ -162,6 +162,7 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *Transfer) err
 			n, err := c.channel.Read(buf)
 			if err != nil {
 				c.sendErrorMessage(err.Error())
+				transfer.TransferError(err)
 				transfer.Close()
 				return err
 			}
@@ -177,6 +178,7 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *Transfer) err
 	}
 	err = c.readConfirmationMessage()
 	if err != nil {
+		transfer.TransferError(err)
 		transfer.Close()
 		return err
 	}
@@ -190,15 +192,15 @@ func (c *scpCommand) getUploadFileData(sizeToRead int64, transfer *Transfer) err
 
 func (c *scpCommand) handleUploadFile(requestPath, filePath string, sizeToRead int64) error {
 	logger.Debug(logSenderSCP, "upload to new file: %v", filePath)
-	if !c.connection.hasSpace(true) {
+	if !c.connection.hasSpace(true, requestPath) {
 		err := fmt.Errorf("denying file write due to space limit")
 		logger.Warn(logSenderSCP, "error uploading file: %v, err: %v", filePath, err)
 		c.sendErrorMessage(err.Error())
 		return err
 	}
 
-	if _, err := os.Stat(filepath.Dir(requestPath)); os.IsNotExist(err) {
-		if !c.connection.User.HasPerm(dataprovider.PermCreateDirs) {
+	if _, err := c.connection.fs.Stat(filepath.Dir(requestPath)); c.connection.fs.IsNotExist(err) {
+		if !c.connection.hasPerm(dataprovider.PermCreateDirs, filepath.Dir(requestPath)) {
 			err := fmt.Errorf("Permission denied")
 			logger.Warn(logSenderSCP, "error uploading file: %v, permission denied", requestPath)
 			c.sendErrorMessage(err.Error())
@@ -206,17 +208,18 @@ func (c *scpCommand) handleUploadFile(requestPath, filePath string, sizeToRead i
 		}
 	}
 
-	file, err := os.Create(filePath)
+	file, err := c.connection.fs.Create(filePath, 0)
 	if err != nil {
 		logger.Error(logSenderSCP, "error creating file %v: %v", requestPath, err)
 		c.sendErrorMessage(err.Error())
 		return err
 	}
 
-	utils.SetPathPermissions(filePath, c.connection.User.GetUID(), c.connection.User.GetGID())
+	vfs.SetPathPermissions(c.connection.fs, filePath, c.connection.User.GetUID(), c.connection.User.GetGID())
 
 	transfer := Transfer{
 		file:          file,
+		fs:            c.connection.fs,
 		path:          requestPath,
 		start:         time.Now(),
 		bytesSent:     0,
@@ -237,7 +240,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 	var err error
 
 	updateConnectionActivity(c.connection.ID)
-	if !c.connection.User.HasPerm(dataprovider.PermUpload) {
+	if !c.connection.hasPermInSFTPPath(dataprovider.PermUpload, uploadFilePath) {
 		err := fmt.Errorf("Permission denied")
 		logger.Warn(logSenderSCP, "error uploading file: %v, permission denied", uploadFilePath)
 		c.sendErrorMessage(err.Error())
@@ -250,12 +253,19 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		c.sendErrorMessage(err.Error())
 		return err
 	}
+	if !c.connection.isFileAllowed(p) {
+		err := fmt.Errorf("Permission denied")
+		logger.Warn(logSenderSCP, "error uploading file: %v, file name not allowed", uploadFilePath)
+		c.sendErrorMessage(err.Error())
+		return err
+	}
 	filePath := p
 	if uploadMode == uploadModeAtomic {
 		filePath = getUploadTempFilePath(p)
+		c.connection.removeStaleUploadFiles(filepath.Dir(p))
 	}
-	stat, statErr := os.Stat(p)
-	if os.IsNotExist(statErr) {
+	stat, statErr := c.connection.fs.Stat(p)
+	if c.connection.fs.IsNotExist(statErr) {
 		return c.handleUploadFile(p, filePath, sizeToRead)
 	}
 
@@ -273,7 +283,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 	}
 
 	if uploadMode == uploadModeAtomic {
-		err = os.Rename(p, filePath)
+		err = c.connection.fs.Rename(p, filePath)
 		if err != nil {
 			logger.Error(logSenderSCP, "error renaming existing file for atomic upload, source: %v, dest: %v, err: %v",
 				p, filePath, err)
@@ -282,7 +292,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		}
 	}
 
-	dataprovider.UpdateUserQuota(dataProvider, c.connection.User, 0, -stat.Size(), false)
+	updateQuota(c.connection.fs, c.connection.User, p, 0, -stat.Size())
 
 	return c.handleUploadFile(p, filePath, sizeToRead)
 }
@@ -321,14 +331,14 @@ func (c *scpCommand) handleRecursiveDownload(dirPath string, stat os.FileInfo) e
 		if err != nil {
 			return err
 		}
-		files, err := getDirContents(dirPath)
+		files, err := c.connection.readDir(dirPath)
 		if err != nil {
 			c.sendErrorMessage(err.Error())
 			return err
 		}
 		var dirs []string
 		for _, file := range files {
-			filePath := c.connection.User.GetRelativePath(filepath.Join(dirPath, file.Name()))
+			filePath := c.connection.fs.GetRelativePath(filepath.Join(dirPath, file.Name()))
 			if file.Mode().IsRegular() || file.Mode()&os.ModeSymlink == os.ModeSymlink {
 				err = c.handleDownload(filePath)
 				if err != nil {
@@ -424,7 +434,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 
 	updateConnectionActivity(c.connection.ID)
 
-	if !c.connection.User.HasPerm(dataprovider.PermDownload) {
+	if !c.connection.hasPermInSFTPPath(dataprovider.PermDownload, filePath) {
 		err := fmt.Errorf("Permission denied")
 		logger.Warn(logSenderSCP, "error downloading file: %v, permission denied", filePath)
 		c.sendErrorMessage(err.Error())
@@ -439,7 +449,11 @@ func (c *scpCommand) handleDownload(filePath string) error {
 	}
 
 	var stat os.FileInfo
-	if stat, err = os.Stat(p); os.IsNotExist(err) {
+	stat, err = c.connection.fs.Stat(p)
+	if c.connection.fs.IsNotExist(err) && c.connection.isVirtualFolderParent(p) {
+		stat, err = vfs.NewVirtualDirInfo(filepath.Base(p)), nil
+	}
+	if err != nil {
 		logger.Warn(logSenderSCP, "error downloading file: %v, err: %v", p, err)
 		c.sendErrorMessage(err.Error())
 		return err
@@ -450,7 +464,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		return err
 	}
 
-	file, err := os.Open(p)
+	file, err := c.connection.fs.Open(p)
 	if err != nil {
 		logger.Error(logSenderSCP, "could not open file \"%v\" for reading: %v", p, err)
 		c.sendErrorMessage(err.Error())
@@ -459,6 +473,7 @@ func (c *scpCommand) handleDownload(filePath string) error {
 
 	transfer := Transfer{
 		file:          file,
+		fs:            c.connection.fs,
 		path:          p,
 		start:         time.Now(),
 		bytesSent:     0,
@@ -628,12 +643,12 @@ func (c *scpCommand) getNextUploadProtocolMessage() (string, error) {
 
 func (c *scpCommand) createDir(dirPath string) error {
 	var err error
-	if err = os.Mkdir(dirPath, 0777); err != nil {
+	if err = c.connection.fs.Mkdir(dirPath); err != nil {
 		logger.Error(logSenderSCP, "error creating dir: %v", dirPath)
 		c.sendErrorMessage(err.Error())
 		return err
 	}
-	utils.SetPathPermissions(dirPath, c.connection.User.GetUID(), c.connection.User.GetGID())
+	vfs.SetPathPermissions(c.connection.fs, dirPath, c.connection.User.GetUID(), c.connection.User.GetGID())
 	return err
 }
 
@@ -689,7 +704,7 @@ func (c *scpCommand) getFileUploadDestPath(scpDestPath, fileName string) string
 			// inside that directory this is as scp command works, for example:
 			// scp fileName.txt user@127.0.0.1:/existing_dir
 			if p, err := c.connection.buildPath(scpDestPath); err == nil {
-				if stat, err := os.Stat(p); err == nil {
+				if stat, err := c.connection.fs.Stat(p); err == nil {
 					if stat.IsDir() {
 						return path.Join(scpDestPath, fileName)
 					}
@@ -758,14 +773,3 @@ func getFileModeAsString(fileMode os.FileMode, isDir bool) string {
 	}
 	return fmt.Sprintf("%v%v%v%v", s, u, g, o)
 }
-
-func getDirContents(path string) ([]os.FileInfo, error) {
-	var files []os.FileInfo
-	f, err := os.Open(path)
-	if err != nil {
-		return files, err
-	}
-	files, err = f.Readdir(-1)
-	f.Close()
-	return files, err
-}
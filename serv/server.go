@@ -20,15 +20,24 @@ import (
 	"time"
 
 	"github.com/lulugyf/sshserv/dataprovider"
-	"github.com/lulugyf/sshserv/hh"
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/sftp"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 	"golang.org/x/crypto/ssh"
 )
 
 
-const defaultPrivateKeyName = "id_rsa"
+const (
+	defaultPrivateKeyName    = "id_rsa"
+	defaultKeepaliveCountMax = 3
+	keepaliveRequest         = "keepalive@openssh.com"
+	// reason sent to the clients when a connection is closed after the user's maximum session duration
+	maxSessionDurationReason = "maximum session duration reached"
+	// maximum time to wait for the transfers to end after closing the channels of a connection
+	closeChannelsTimeout = 10 * time.Second
+)
 
 
 // Configuration for the SFTP server
@@ -41,6 +50,11 @@ type Configuration struct {
 	BindAddress string `json:"bind_address" mapstructure:"bind_address"`
 	// Maximum idle timeout as minutes. If a client is idle for a time that exceeds this setting it will be disconnected
 	IdleTimeout int `json:"idle_timeout" mapstructure:"idle_timeout"`
+	// Interval as seconds between the keepalive@openssh.com requests sent to the client. 0 disables keepalives
+	KeepaliveInterval int `json:"keepalive_interval" mapstructure:"keepalive_interval"`
+	// Maximum number of keepalive requests without a reply, after that the client is disconnected.
+	// 0 means the default (3)
+	KeepaliveCountMax int `json:"keepalive_count_max" mapstructure:"keepalive_count_max"`
 	// Maximum number of authentication attempts permitted per connection.
 	// If set to a negative number, the number of attempts are unlimited.
 	// If set to zero, the number of attempts are limited to 6.
@@ -52,6 +66,9 @@ type Configuration struct {
 	// when the client ends the upload. Atomic mode avoid problems such as a web server that
 	// serves partial files when the files are being uploaded.
 	UploadMode int `json:"upload_mode" mapstructure:"upload_mode"`
+	// Maximum number of entries returned for a directory listing, the remaining entries are not listed.
+	// 0 means unlimited
+	MaxListEntries int `json:"max_list_entries" mapstructure:"max_list_entries"`
 	// Actions to execute on SFTP create, download, delete and rename
 	Actions Actions `json:"actions" mapstructure:"actions"`
 	// Keys are a list of host keys
@@ -66,8 +83,12 @@ type Configuration struct {
 	// if something does not work as expected for your use cases
 	IsSCPEnabled bool `json:"enable_scp" mapstructure:"enable_scp"`
 
-	// If Default open full functions ? (shell / LocalPortForward / RemotePortForward)
-	FullFunc bool `json:"full_func" mapstructure:"full_func"`
+	// Egress defines how outbound connections for local port forwarding are made
+	Egress EgressConf `json:"egress" mapstructure:"egress"`
+	// Tunnels defines the named tunnels mode for remote port forwarding
+	Tunnels TunnelConf `json:"tunnels" mapstructure:"tunnels"`
+	// Snapshots defines the scheduled snapshots of the users' HDFS home dirs
+	Snapshots SnapshotPolicy `json:"snapshots" mapstructure:"snapshots"`
 
 	Ext *ExtConf  `json:"ext_conf" mapstructure:"ext_conf"`
 }
@@ -77,6 +98,14 @@ type ExtConf struct {
 	HDFS string `json:"hdfs" mapstructure:"hdfs"`
 	// hosts list:  host-172-18-231-22,172.18.231.22 host-172-18-231-25,172.18.231.25 host-172-18-231-27,172.18.231.27 host-172-18-231-19,172.18.231.19 host-172-18-231-20,172.18.231.20
 	HDFSHosts string `json:"hdfs_hosts" mapstructure:"hdfs_hosts"`
+	// if true each SFTP user accesses HDFS as the HDFS user with the same name: the {user} in hdfs,
+	// or the Kerberos principal, impersonates it using the Hadoop proxy user mechanism
+	HDFSProxyUser bool `json:"hdfs_proxy_user" mapstructure:"hdfs_proxy_user"`
+	// Kerberos credentials used to access a kerberized cluster
+	HDFSKerberos fsconfig.HdfsKerberosConfig `json:"hdfs_kerberos" mapstructure:"hdfs_kerberos"`
+	// if true the users' quotas are also set as HDFS directory quotas on their root dirs and on the
+	// virtual folders with their own quota, so the namenode enforces them for every HDFS client
+	HDFSDirQuotas bool `json:"hdfs_dir_quotas" mapstructure:"hdfs_dir_quotas"`
 
 	BasePubkey string `json:"_base_pubkey" mapstructure:"_base_pubkey"`
 	BaseUser string `json:"_base_user" mapstructure:"_base_user"`
@@ -155,12 +184,25 @@ func (c *Configuration) Initialize(configDir string) error {
 		return err
 	}
 
+	egress, err = newEgressDialer(c.Egress)
+	if err != nil {
+		logger.Warn(logSender, "invalid egress configuration: %v", err)
+		listener.Close()
+		return err
+	}
+
+	setTunnelConf(c.Tunnels)
 	actions = c.Actions
 	uploadMode = c.UploadMode
+	maxListEntries = c.MaxListEntries
+	serverConf = c
 	logger.Info(logSender, "server listener registered address: %v", listener.Addr().String())
 	if c.IdleTimeout > 0 {
 		startIdleTimer(time.Duration(c.IdleTimeout) * time.Minute)
 	}
+	if c.Snapshots.Interval > 0 {
+		startSnapshotScheduler(c.Snapshots)
+	}
 
 	c._initBaseKey()
 	for {
@@ -176,7 +218,8 @@ func (c *Configuration) _initBaseKey() {
 		if c.Ext.BaseUser == "" {
 			c.Ext.BaseUser = `{"id":1,"username":"_base_","permissions":["*"],"password":"","home_dir":"/","uid":0,"gid":0,
             "max_sessions":0, "quota_size":0,"quota_files":0,"used_quota_size":0,"used_quota_files":0,
-            "last_quota_update":0,"upload_bandwidth":0,"download_bandwidth":0}`
+            "last_quota_update":0,"upload_bandwidth":0,"download_bandwidth":0,
+            "capabilities":["sftp","scp","shell","exec","local-forward","remote-forward"]}`
 		}
 		var user dataprovider.User
 		err := json.Unmarshal([]byte(c.Ext.BaseUser), &user)
@@ -230,6 +273,13 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		logger.Warn(logSender, "Unable to deserialize user info, cannot serv connection: %v", err)
 		return
 	}
+	// the base user is not stored inside the data provider, its capabilities are mapped from its permissions
+	user.SetLegacyCapabilities()
+	err = dataprovider.AddGroupCapabilities(dataProvider, &user)
+	if err != nil {
+		logger.Warn(logSender, "Unable to get the groups for user %#v, cannot serv connection: %v", user.Username, err)
+		return
+	}
 
 	connectionID := hex.EncodeToString(sconn.SessionID())
 
@@ -240,9 +290,30 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		RemoteAddr:    conn.RemoteAddr(),
 		StartTime:     time.Now(),
 		lastActivity:  time.Now(),
+		protocol:      protocolSSH,
 		lock:          new(sync.Mutex),
 		sshConn:       sconn,
 	}
+	// the connection is tracked for its whole lifetime, whatever channels it opens
+	addConnection(connectionID, connection)
+	defer removeConnection(connectionID)
+
+	done := make(chan struct{})
+	defer close(done)
+	if c.KeepaliveInterval > 0 {
+		go c.keepalive(sconn, connectionID, done)
+	}
+	if user.MaxSessionDuration > 0 {
+		maxDuration := time.Duration(user.MaxSessionDuration) * time.Second
+		sessionTimer := time.AfterFunc(maxDuration, func() {
+			logger.Info(logSender, "closing connection id: %v user: %v, maximum session duration %v reached",
+				connectionID, user.Username, maxDuration)
+			// the channels are closed first, so the open transfers are finalised
+			closeConnectionChannels(connectionID, maxSessionDurationReason, closeChannelsTimeout)
+			sconn.Close()
+		})
+		defer sessionTimer.Stop()
+	}
 
 	//go ssh.DiscardRequests(reqs)
 
@@ -260,12 +331,17 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 			}
 			logger.Debug(logRforward, "   reqs .. req.Type=%s\n", r.Type)
 			switch (r.Type) {
-			case "tcpip-forward":
+			case "tcpip-forward", "cancel-tcpip-forward":
 				var payload []byte = nil
 				ok := false
-				if c.FullFunc && connection.User.HasPerm(dataprovider.PermTCPForward) {
+				if connection.User.HasCapability(dataprovider.CapRemoteForward) {
 					if forwardHandler == nil {
-						forwardHandler = &ForwardedTCPHandler{forwards: make(map[string]net.Listener)}
+						forwardHandler = &ForwardedTCPHandler{
+							forwards:     make(map[string]net.Listener),
+							tunnels:      make(map[string]string),
+							username:     connection.User.Username,
+							connectionID: connection.ID,
+						}
 					}
 					ok, payload, _ = forwardHandler.handlePortforward(sconn, r)
 				}
@@ -286,26 +362,68 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	//Done close all port forwarding
 	if forwardHandler != nil {
-		for ln_addr, ln := range forwardHandler.forwards {
-			ln.Close()
-			logger.Debug(logRforward,"   R ln_addr [%s] closed\n", ln_addr)
-		}
+		forwardHandler.closeAll()
 	}
 	logger.Debug(logSender, "   ---------AcceptInboundConnection done \n")
 }
 
+// keepalive sends keepalive@openssh.com requests to the client and closes the connection
+// if KeepaliveCountMax consecutive requests get no reply. Any reply, success or failure, counts.
+// A single request is in flight at a time
+func (c *Configuration) keepalive(sconn *ssh.ServerConn, connectionID string, done <-chan struct{}) {
+	countMax := c.KeepaliveCountMax
+	if countMax <= 0 {
+		countMax = defaultKeepaliveCountMax
+	}
+	ticker := time.NewTicker(time.Duration(c.KeepaliveInterval) * time.Second)
+	defer ticker.Stop()
+	replies := make(chan error, 1)
+	waitingReply := false
+	missed := 0
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-replies:
+			waitingReply = false
+			if err != nil {
+				// the connection is closed
+				return
+			}
+			missed = 0
+		case <-ticker.C:
+			if waitingReply {
+				missed++
+				logger.Debug(logSender, "no keepalive reply, connection id: %v missed: %v", connectionID, missed)
+				if missed >= countMax {
+					logger.Info(logSender, "closing connection id: %v, no reply to %v keepalive requests",
+						connectionID, missed)
+					sconn.Close()
+					return
+				}
+				continue
+			}
+			waitingReply = true
+			go func() {
+				_, _, err := sconn.SendRequest(keepaliveRequest, true, nil)
+				replies <- err
+			}()
+		}
+	}
+}
+
 func (c *Configuration) iterChans(newChannel ssh.NewChannel, sconn *ssh.ServerConn, connection Connection) bool {
 	// If its not a session channel we just move on because its not something we
 	// know how to handle at this point.
 	logger.Debug(logSender,"  --- newChannel.ChannelType(): [%s] \n", newChannel.ChannelType())
 	if newChannel.ChannelType() == "direct-tcpip" {
-		if c.FullFunc && connection.User.HasPerm(dataprovider.PermTCPForward) {
+		if connection.User.HasCapability(dataprovider.CapLocalForward) {
 			go HandleDirectTCPIP(sconn, newChannel)
-			return true
 		}else{
 			logger.Warn(logLforward, "Denied -L port-forwarding of user %s", connection.User.Username)
-			return false;
+			newChannel.Reject(ssh.Prohibited, "local port forwarding is not allowed")
 		}
+		return true
 	}
 
 	if newChannel.ChannelType() != "session" {
@@ -322,61 +440,122 @@ func (c *Configuration) iterChans(newChannel ssh.NewChannel, sconn *ssh.ServerCo
 
 	// Channels have a type that is dependent on the protocol. For SFTP this is "subsystem"
 	// with a payload that (should) be "sftp". Discard anything else we receive ("pty", "shell", etc)
-	go handleSSHRequest(requests, channel, connection, c)
+	go handleSSHRequest(requests, newActiveChannel(channel, connection.ID, channelTypeSession, ""), connection, c)
 
 	return true
 }
 
 
+// getFsConfig returns the filesystem configuration for the given user.
+// Users without a filesystem provider use the server default, HDFS if ext_conf.hdfs is set
+func (c *Configuration) getFsConfig(user dataprovider.User) (dataprovider.Filesystem, error) {
+	fsConfig := user.FsConfig
+	if fsConfig.Provider == "" && c.Ext != nil && c.Ext.HDFS != "" {
+		// the format is {user}@{namenodes or conf_dir}
+		cc := strings.SplitN(c.Ext.HDFS, "@", 2)
+		if len(cc) != 2 {
+			return fsConfig, fmt.Errorf("invalid hdfs configuration: %#v", c.Ext.HDFS)
+		}
+		fsConfig.Provider = dataprovider.HDFSFilesystemProvider
+		fsConfig.HDFSConfig = fsconfig.HdfsFsConfig{
+			Namenodes: cc[1],
+			User:      cc[0],
+			Hosts:     parseHDFSHosts(c.Ext.HDFSHosts),
+		}
+		if c.Ext.HDFSKerberos.IsEnabled() {
+			kerberos := c.Ext.HDFSKerberos
+			fsConfig.HDFSConfig.Kerberos = &kerberos
+		}
+		if c.Ext.HDFSProxyUser {
+			fsConfig.HDFSConfig.RealUser = cc[0]
+			fsConfig.HDFSConfig.User = user.Username
+		}
+	}
+	return fsConfig, nil
+}
+
+// newFs returns the filesystem backend for the given connection
+func (c *Configuration) newFs(connection Connection) (vfs.Fs, error) {
+	fsConfig, err := c.getFsConfig(connection.User)
+	if err != nil {
+		return nil, err
+	}
+	if fsConfig.Provider == dataprovider.HDFSFilesystemProvider {
+		return vfs.NewHdfsFs(connection.ID, connection.User.GetHomeDir(), connection.User.VirtualFolders,
+			fsConfig.HDFSConfig)
+	}
+	return vfs.NewOsFs(connection.ID, connection.User.GetHomeDir(), connection.User.VirtualFolders), nil
+}
+
+// parseHDFSHosts parses a space separated list of "host,address" items
+func parseHDFSHosts(hosts string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range strings.Fields(hosts) {
+		parts := strings.Split(item, ",")
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result
+}
+
 func (c *Configuration) handleSftpConnection(channel io.ReadWriteCloser, connection Connection) {
-	addConnection(connection.ID, connection)
-	// Create a new handler for the currently logged in user's server.
-	var handler *sftp.Handlers = nil
-	//fmt.Printf("------hdfs[%s] full_func[%v]\n", c.HDFS, c.FullFunc)
-	var hdfsHandler *hh.HConnection = nil
-	if c.Ext.HDFS == "" {
-		handler = &sftp.Handlers{
+	var handler sftp.Handlers
+	fs, err := c.newFs(connection)
+	if err != nil {
+		logger.Error(logSender, "unable to create filesystem for user %v, connection id %v: %v",
+			connection.User.Username, connection.ID, err)
+		// the client gets an error for each request instead of a closed channel
+		unavailable := unavailableHandler{}
+		handler = sftp.Handlers{
+			FileGet:  unavailable,
+			FilePut:  unavailable,
+			FileCmd:  unavailable,
+			FileList: unavailable,
+		}
+	} else {
+		defer fs.Close()
+		connection.fs = fs
+		createHomeDir(fs, connection.User)
+
+		// Create a new handler for the currently logged in user's server.
+		handler = sftp.Handlers{
 			FileGet:  connection,
 			FilePut:  connection,
 			FileCmd:  connection,
 			FileList: connection,
 		}
-	}else{
-		hdfsHandler = hh.NewHandler(connection, connection.User, connection.ID, connection.protocol)
-		cc := strings.Split(c.Ext.HDFS, "@")
-		err := hdfsHandler.MkHdfsClient(cc[1], cc[0], c.Ext.HDFSHosts)
-		if err != nil {
-			fmt.Printf("---connect hdfs failed: %v\n", err)
-		}else {
-			handler = &sftp.Handlers{
-				FileGet:  hdfsHandler,
-				FilePut:  hdfsHandler,
-				FileCmd:  hdfsHandler,
-				FileList: hdfsHandler,
-			}
-		}
 	}
 
-	if handler != nil {
-		// Create the server instance for the channel using the handler we created above.
-		server := sftp.NewRequestServer(channel, *handler)
+	// Create the server instance for the channel using the handler we created above.
+	server := sftp.NewRequestServer(channel, handler)
 
-		if err := server.Serve(); err == io.EOF {
-			logger.Debug(logSender, "connection closed, id: %v", connection.ID)
-			server.Close()
-		} else if err != nil {
-			logger.Error(logSender, "sftp connection closed with error id %v: %v", connection.ID, err)
-		}
-	}else{
-		fmt.Printf("can not handle sftp!!!\n")
-		channel.Close()
+	if err := server.Serve(); err == io.EOF {
+		logger.Debug(logSender, "connection closed, id: %v", connection.ID)
+		server.Close()
+	} else if err != nil {
+		logger.Error(logSender, "sftp connection closed with error id %v: %v", connection.ID, err)
 	}
+}
 
-	removeConnection(connection.ID)
-	//fmt.Printf("handle sftp finished.\n")
-	if hdfsHandler != nil {
-		hdfsHandler.Close()
+func (c *Configuration) handleSCPCommand(channel ssh.Channel, connection Connection, args []string) {
+	scpCommand := scpCommand{
+		connection: connection,
+		args:       args,
+		channel:    channel,
 	}
+	fs, err := c.newFs(connection)
+	if err != nil {
+		logger.Error(logSenderSCP, "unable to create filesystem for user %v, connection id %v: %v",
+			connection.User.Username, connection.ID, err)
+		scpCommand.sendErrorMessage(err.Error())
+		scpCommand.sendExitStatus(err)
+		return
+	}
+	defer fs.Close()
+	scpCommand.connection.fs = fs
+	createHomeDir(fs, connection.User)
+	scpCommand.handle()
 }
 
 func loginUser(user dataprovider.User, c *Configuration) (*ssh.Permissions, error) {
@@ -385,12 +564,10 @@ func loginUser(user dataprovider.User, c *Configuration) (*ssh.Permissions, erro
 			user.Username, user.HomeDir)
 		return nil, fmt.Errorf("Cannot login user with invalid home dir: %v", user.HomeDir)
 	}
-	if _, err := os.Stat(user.HomeDir); os.IsNotExist(err) {
-		logger.Debug(logSender, "home directory \"%v\" for user %v does not exist, try to create", user.HomeDir, user.Username)
-		err := os.MkdirAll(user.HomeDir, 0777)
-		if err == nil {
-			utils.SetPathPermissions(user.HomeDir, user.GetUID(), user.GetGID())
-		}
+	// the HDFS home dir is created when a SFTP or SCP session uses it, so a login does not
+	// contact the namenode. The local home dir is also the working dir of the shell sessions
+	if fsConfig, err := c.getFsConfig(user); err == nil && fsConfig.Provider != dataprovider.HDFSFilesystemProvider {
+		createHomeDir(vfs.NewOsFs("home_dir", user.GetHomeDir(), user.VirtualFolders), user)
 	}
 
 	if user.MaxSessions > 0 {
@@ -413,6 +590,22 @@ func loginUser(user dataprovider.User, c *Configuration) (*ssh.Permissions, erro
 	return p, nil
 }
 
+// createHomeDir creates the user's root dir, if missing, using the given filesystem:
+// for HDFS users it is created on HDFS and nothing is created on the local filesystem
+func createHomeDir(fs vfs.Fs, user dataprovider.User) {
+	rootDir := user.GetRootDir()
+	_, err := fs.Stat(rootDir)
+	if !fs.IsNotExist(err) {
+		return
+	}
+	logger.Debug(logSender, "home directory \"%v\" for user %v does not exist, try to create", rootDir, user.Username)
+	if err = vfs.MkdirAll(fs, rootDir); err != nil {
+		logger.Warn(logSender, "unable to create the home directory for user %v: %v", user.Username, err)
+		return
+	}
+	vfs.SetPathPermissions(fs, rootDir, user.GetUID(), user.GetGID())
+}
+
 // If no host keys are defined we try to use or generate the default one.
 func (c *Configuration) checkHostKeys(configDir string) error {
 	var err error
@@ -16,6 +16,7 @@ import (
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs"
 )
 
 const (
@@ -29,6 +30,12 @@ const (
 	rmdirLogSender    = "Rmdir"
 	mkdirLogSender    = "Mkdir"
 	symlinkLogSender  = "Symlink"
+	linkLogSender     = "Link"
+	chmodLogSender    = "Chmod"
+	chownLogSender    = "Chown"
+	chtimesLogSender  = "Chtimes"
+	setxattrLogSender = "Setxattr"
+	rmxattrLogSender  = "Removexattr"
 	removeLogSender   = "Remove"
 	operationDownload = "download"
 	operationUpload   = "upload"
@@ -36,18 +43,23 @@ const (
 	operationRename   = "rename"
 	protocolSFTP      = "SFTP"
 	protocolSCP       = "SCP"
+	protocolSSH       = "SSH"
 )
 
 var (
 	mutex                sync.RWMutex
 	openConnections      map[string]Connection
 	activeTransfers      []*Transfer
+	activeChannels       []*activeChannel
 	idleConnectionTicker *time.Ticker
 	idleTimeout          time.Duration
 	activeQuotaScans     []ActiveQuotaScan
 	dataProvider         dataprovider.Provider
 	actions              Actions
 	uploadMode           int
+	maxListEntries       int
+	serverConf           *Configuration
+	egress               *egressDialer
 )
 
 type connectionTransfer struct {
@@ -58,6 +70,14 @@ type connectionTransfer struct {
 	Path          string `json:"path"`
 }
 
+type connectionChannel struct {
+	ChannelType  string `json:"channel_type"`
+	StartTime    int64  `json:"start_time"`
+	LastActivity int64  `json:"last_activity"`
+	// command for exec and scp channels, forward target for port forwarding channels
+	Info string `json:"info"`
+}
+
 // ActiveQuotaScan defines an active quota scan
 type ActiveQuotaScan struct {
 	// Username to which the quota scan refers
@@ -91,15 +111,18 @@ type ConnectionStatus struct {
 	ConnectionTime int64 `json:"connection_time"`
 	// Last activity as unix timestamp in milliseconds
 	LastActivity int64 `json:"last_activity"`
-	// Protocol for this connection: SFTP or SCP
+	// Protocol for this connection: SFTP or SCP if a file transfer channel was opened, SSH otherwise
 	Protocol string `json:"protocol"`
 	// active uploads/downloads
 	Transfers []connectionTransfer `json:"active_transfers"`
+	// active SSH channels: sessions and port forwardings
+	Channels []connectionChannel `json:"active_channels"`
 }
 
 func init() {
 	openConnections = make(map[string]Connection)
 	idleConnectionTicker = time.NewTicker(5 * time.Minute)
+	egress, _ = newEgressDialer(EgressConf{})
 }
 
 // SetDataProvider sets the data provider to use to authenticate users and to get/update their disk quota
@@ -145,6 +168,78 @@ func AddQuotaScan(username string) bool {
 	return true
 }
 
+// ScanUserHomeDir returns the number of files and their size inside the user's home dir and inside
+// the virtual folders without their own quota. The home dir is scanned using the user's storage
+// backend, for HDFS users the usage is read from the namenode content summary and it replaces
+// the cached usage used for the quota checks
+func ScanUserHomeDir(user dataprovider.User) (int, int64, error) {
+	c := serverConf
+	if c == nil {
+		c = &Configuration{}
+	}
+	fs, err := c.newFs(Connection{ID: "quota_scan", User: user})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fs.Close()
+	numFiles, size, err := getUserDirUsage(fs, user)
+	if _, ok := fs.(vfs.DirUsager); ok && err == nil {
+		setCachedDirUsage(getUserDirUsageKey(user.Username), numFiles, size)
+	}
+	return numFiles, size, err
+}
+
+// SetUserDirQuota sets the user's quota as HDFS directory quota on the user's root dir, and the quota of
+// each virtual folder with its own quota on its mapped path. A zero quota removes the HDFS quota.
+// It does nothing if HDFS directory quotas are disabled or if the user is not stored on HDFS
+func SetUserDirQuota(user dataprovider.User) error {
+	if serverConf == nil || !serverConf.Ext.HDFSDirQuotas {
+		return nil
+	}
+	fs, err := serverConf.newFs(Connection{ID: "dir_quota", User: user})
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	hdfsFs, ok := fs.(*vfs.HdfsFs)
+	if !ok {
+		return nil
+	}
+	if err = hdfsFs.SetDirQuota(user.GetRootDir(), user.QuotaFiles, user.QuotaSize); err != nil {
+		return err
+	}
+	for _, folder := range user.VirtualFolders {
+		if !folder.HasQuota() {
+			continue
+		}
+		if err = hdfsFs.SetDirQuota(folder.MappedPath, folder.QuotaFiles, folder.QuotaSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getUserDirUsage returns the number of files and their size inside the user's root dir and inside the
+// virtual folders that count against the user's quota. Missing virtual folders are ignored
+func getUserDirUsage(fs vfs.Fs, user dataprovider.User) (int, int64, error) {
+	numFiles, size, err := vfs.GetDirUsage(fs, user.GetRootDir())
+	if err != nil {
+		return numFiles, size, err
+	}
+	for _, folder := range user.VirtualFolders {
+		if folder.HasQuota() {
+			continue
+		}
+		folderFiles, folderSize, err := vfs.GetDirUsage(fs, folder.MappedPath)
+		if err != nil && !fs.IsNotExist(err) {
+			return numFiles, size, err
+		}
+		numFiles += folderFiles
+		size += folderSize
+	}
+	return numFiles, size, nil
+}
+
 // RemoveQuotaScan removes an user from the ones with active quota scans
 func RemoveQuotaScan(username string) error {
 	mutex.Lock()
@@ -167,7 +262,7 @@ func RemoveQuotaScan(username string) error {
 	return err
 }
 
-// CloseActiveConnection closes an active SFTP connection.
+// CloseActiveConnection closes an active SSH connection.
 // It returns true on success
 func CloseActiveConnection(connectionID string) bool {
 	result := false
@@ -199,6 +294,7 @@ func GetConnectionsStats() []ConnectionStatus {
 			LastActivity:   utils.GetTimeAsMsSinceEpoch(c.lastActivity),
 			Protocol:       c.protocol,
 			Transfers:      []connectionTransfer{},
+			Channels:       []connectionChannel{},
 		}
 		for _, t := range activeTransfers {
 			if t.connectionID == c.ID {
@@ -219,11 +315,25 @@ func GetConnectionsStats() []ConnectionStatus {
 					StartTime:     utils.GetTimeAsMsSinceEpoch(t.start),
 					Size:          size,
 					LastActivity:  utils.GetTimeAsMsSinceEpoch(t.lastActivity),
-					Path:          c.User.GetRelativePath(t.path),
+					Path:          t.fs.GetRelativePath(t.path),
 				}
 				conn.Transfers = append(conn.Transfers, connTransfer)
 			}
 		}
+		for _, ch := range activeChannels {
+			if ch.connectionID == c.ID {
+				lastActivity := utils.GetTimeAsMsSinceEpoch(ch.getLastActivity())
+				if lastActivity > conn.LastActivity {
+					conn.LastActivity = lastActivity
+				}
+				conn.Channels = append(conn.Channels, connectionChannel{
+					ChannelType:  ch.channelType,
+					StartTime:    utils.GetTimeAsMsSinceEpoch(ch.start),
+					LastActivity: lastActivity,
+					Info:         ch.info,
+				})
+			}
+		}
 		stats = append(stats, conn)
 	}
 	return stats
@@ -255,6 +365,16 @@ func CheckIdleConnections() {
 				}
 			}
 		}
+		for _, ch := range activeChannels {
+			if ch.connectionID == c.ID {
+				channelIdleTime := time.Since(ch.getLastActivity())
+				if channelIdleTime < idleTime {
+					logger.Debug(logSender, "idle time: %v setted to %v channel idle time: %v connection id: %v",
+						idleTime, ch.channelType, channelIdleTime, c.ID)
+					idleTime = channelIdleTime
+				}
+			}
+		}
 		if idleTime > idleTimeout {
 			logger.Debug(logSender, "close idle connection id: %v idle time: %v", c.ID, idleTime)
 			err := c.sshConn.Close()
@@ -275,15 +395,85 @@ func removeConnection(id string) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	delete(openConnections, id)
+	channels := activeChannels[:0]
+	for _, ch := range activeChannels {
+		if ch.connectionID != id {
+			channels = append(channels, ch)
+		}
+	}
+	for i := len(channels); i < len(activeChannels); i++ {
+		activeChannels[i] = nil
+	}
+	activeChannels = channels
 	logger.Debug(logSender, "connection removed, num open connections: %v", len(openConnections))
 }
 
+func addChannel(channel *activeChannel) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	activeChannels = append(activeChannels, channel)
+}
+
+func removeChannel(channel *activeChannel) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, v := range activeChannels {
+		if v == channel {
+			activeChannels[i] = activeChannels[len(activeChannels)-1]
+			activeChannels[len(activeChannels)-1] = nil
+			activeChannels = activeChannels[:len(activeChannels)-1]
+			return
+		}
+	}
+}
+
+// setChannelType sets the channel type once it is known, for session channels
+// it is known after the subsystem, exec or shell request.
+// Opening an SFTP or SCP channel sets the connection protocol too
+func setChannelType(channel *activeChannel, channelType, info string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	channel.channelType = channelType
+	channel.info = info
+	if c, ok := openConnections[channel.connectionID]; ok {
+		switch channelType {
+		case channelTypeSFTP:
+			c.protocol = protocolSFTP
+		case channelTypeSCP:
+			c.protocol = protocolSCP
+		default:
+			return
+		}
+		openConnections[channel.connectionID] = c
+	}
+}
+
+// isSessionStarted returns true if a subsystem, a command or a shell was already started
+// on the session channel. Like OpenSSH, only one of them is allowed per channel
+func isSessionStarted(channel *activeChannel) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return channel.channelType != channelTypeSession
+}
+
 func addTransfer(transfer *Transfer) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	activeTransfers = append(activeTransfers, transfer)
 }
 
+// isActiveUpload returns true if an active transfer is writing the given file
+func isActiveUpload(filePath string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, t := range activeTransfers {
+		if t.transferType == transferUpload && t.file.Name() == filePath {
+			return true
+		}
+	}
+	return false
+}
+
 func removeTransfer(transfer *Transfer) error {
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -305,6 +495,65 @@ func removeTransfer(transfer *Transfer) error {
 	return err
 }
 
+// hasActiveTransfers returns true if the connection with the given id has some active transfers
+func hasActiveTransfers(connectionID string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, t := range activeTransfers {
+		if t.connectionID == connectionID {
+			return true
+		}
+	}
+	return false
+}
+
+// closeConnectionChannels writes reason to the session channels of the given connection and closes
+// all its channels, then it waits up to timeout for the session handlers to finalise the transfers.
+// The SSH library cannot send a disconnect message with a reason so the clients get it on stderr
+func closeConnectionChannels(connectionID, reason string, timeout time.Duration) {
+	var channels, sessions []*activeChannel
+	mutex.RLock()
+	for _, ch := range activeChannels {
+		if ch.connectionID != connectionID {
+			continue
+		}
+		switch ch.channelType {
+		case channelTypeSession, channelTypeSFTP, channelTypeSCP, channelTypeShell, channelTypeExec:
+			sessions = append(sessions, ch)
+		}
+		channels = append(channels, ch)
+	}
+	mutex.RUnlock()
+	deadline := time.Now().Add(timeout)
+	closed := make(chan struct{})
+	// a write blocks if the client does not adjust the channel window, the connection close unblocks it
+	go func() {
+		for _, ch := range sessions {
+			ch.Stderr().Write([]byte(reason + "\r\n"))
+		}
+		for _, ch := range channels {
+			ch.Close()
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		return
+	}
+	for hasActiveTransfers(connectionID) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// isConnectionOpen returns false once the connection with the given id is closed
+func isConnectionOpen(id string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	_, ok := openConnections[id]
+	return ok
+}
+
 func updateConnectionActivity(id string) {
 	mutex.Lock()
 	defer mutex.Unlock()
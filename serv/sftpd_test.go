@@ -1,7 +1,10 @@
 package serv_test
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,6 +15,8 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,6 +25,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/lulugyf/sshserv/api"
 	"github.com/lulugyf/sshserv/config"
@@ -27,6 +33,7 @@ import (
 	"github.com/lulugyf/sshserv/logger"
 	"github.com/lulugyf/sshserv/serv"
 	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
 	"github.com/rs/zerolog"
 )
 
@@ -108,6 +115,11 @@ func TestMain(m *testing.M) {
 	// simply does not execute some code so if it works in atomic mode will
 	// work in non atomic mode too
 	sftpdConf.UploadMode = 1
+	sftpdConf.Tunnels.Enabled = true
+	// short keepalives to test the disconnection of clients that don't reply
+	sftpdConf.KeepaliveInterval = 1
+	sftpdConf.KeepaliveCountMax = 2
+	sftpdConf.Tunnels.HostDomain = "tunnels.local"
 	if runtime.GOOS == "windows" {
 		homeBasePath = "C:\\"
 	} else {
@@ -338,150 +350,1513 @@ func TestSymlink(t *testing.T) {
 		if err != nil {
 			t.Errorf("error creating symlink: %v", err)
 		}
-		_, err = client.ReadLink(testFileName + ".link")
+		linkTarget, err := client.ReadLink(testFileName + ".link")
+		if err != nil {
+			t.Errorf("readlink error: %v", err)
+		} else if linkTarget != "/"+testFileName {
+			t.Errorf("unexpected link target: %v", linkTarget)
+		}
+		err = client.Symlink(testFileName, testFileName+".link")
+		if err == nil {
+			t.Errorf("creating a symlink to an existing one must fail")
+		}
+		err = client.Remove(testFileName + ".link")
+		if err != nil {
+			t.Errorf("error removing symlink: %v", err)
+		}
+		err = client.Remove(testFileName)
+		if err != nil {
+			t.Errorf("error removing uploaded file: %v", err)
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	usePubKey := false
+	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		fi, err := client.Lstat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		}
+		err = client.Chown(testFileName, 1000, 1000)
+		if err != nil {
+			t.Errorf("chown error: %v", err)
+		}
+		err = client.Chmod(testFileName, 0600)
+		if err != nil {
+			t.Errorf("chmod error: %v", err)
+		}
+		newFi, err := client.Lstat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		}
+		if fi.Mode().Perm() != newFi.Mode().Perm() {
+			t.Errorf("stat must remain unchanged")
+		}
+		_, err = client.ReadLink(testFileName)
+		if err == nil {
+			t.Errorf("readlink is not supported and must fail")
+		}
+		err = client.Remove(testFileName)
+		if err != nil {
+			t.Errorf("error removing uploaded file: %v", err)
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+// basic tests to verify virtual chroot, should be improved to cover more cases ...
+func TestEscapeHomeDir(t *testing.T) {
+	usePubKey := true
+	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err := client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+		testDir := "testDir"
+		linkPath := filepath.Join(homeBasePath, defaultUsername, testDir)
+		err = os.Symlink(homeBasePath, linkPath)
+		if err != nil {
+			t.Errorf("error making local symlink: %v", err)
+		}
+		_, err = client.ReadDir(testDir)
+		if err == nil {
+			t.Errorf("reading a symbolic link outside home dir should not succeeded")
+		}
+		os.Remove(linkPath)
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		remoteDestPath := filepath.Join("..", "..", testFileName)
+		err = sftpUploadFile(testFilePath, remoteDestPath, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		_, err = client.Lstat(testFileName)
+		if err != nil {
+			t.Errorf("file stat error: %v the file was created outside the user dir!", err)
+		}
+		err = client.Remove(testFileName)
+		if err != nil {
+			t.Errorf("error removing uploaded file: %v", err)
+		}
+		linkPath = filepath.Join(homeBasePath, defaultUsername, testFileName)
+		err = os.Symlink(homeBasePath, linkPath)
+		if err != nil {
+			t.Errorf("error making local symlink: %v", err)
+		}
+		err = sftpDownloadFile(testFileName, testFilePath, 0, client)
+		if err == nil {
+			t.Errorf("download file outside home dir must fail")
+		}
+		err = sftpUploadFile(testFilePath, remoteDestPath, testFileSize, client)
+		if err == nil {
+			t.Errorf("overwrite a file outside home dir must fail")
+		}
+		err = client.Chmod(remoteDestPath, 0644)
+		if err == nil {
+			t.Errorf("setstat on a file outside home dir must fail")
+		}
+		os.Remove(linkPath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestHomeSpecialChars(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.HomeDir = filepath.Join(homeBasePath, "abc açà#&%lk")
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err := client.Getwd()
+		if err != nil {
+			t.Errorf("unable to get working dir: %v", err)
+		}
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		files, err := client.ReadDir(".")
+		if err != nil {
+			t.Errorf("unable to read remote dir: %v", err)
+		}
+		if len(files) < 1 {
+			t.Errorf("expected at least 1 file in this dir")
+		}
+		err = client.Remove(testFileName)
+		if err != nil {
+			t.Errorf("error removing uploaded file: %v", err)
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestNamedTunnel(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Capabilities = []string{dataprovider.CapRemoteForward}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	u.Username += "1"
+	user1, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		err = requestNamedTunnel(client, "myapp.tunnel")
+		if err != nil {
+			t.Errorf("unable to request tunnel: %v", err)
+		}
+		err = requestNamedTunnel(client, "myapp2.tunnel")
+		if err != nil {
+			t.Errorf("unable to request tunnel: %v", err)
+		}
+		err = requestNamedTunnel(client, "invalid_name.tunnel")
+		if err == nil {
+			t.Errorf("tunnel with invalid name must fail")
+		}
+		tunnels, _, err := api.GetTunnels(http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to get tunnels: %v", err)
+		}
+		if len(tunnels) != 2 || tunnels[0].Name != "myapp" || tunnels[0].Username != user.Username {
+			t.Errorf("unexpected tunnels: %+v", tunnels)
+		}
+		_, err = api.ReleaseTunnelName("myapp", http.StatusConflict)
+		if err != nil {
+			t.Errorf("an active tunnel name must not be released: %v", err)
+		}
+		resp, err := http.Get("http://127.0.0.1:8080/tunnel/myapp/sub/path")
+		if err != nil {
+			t.Errorf("unable to get tunnel path: %v", err)
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "tunnel path: /sub/path" {
+				t.Errorf("unexpected tunnel response, status: %v body: %v", resp.StatusCode, string(body))
+			}
+		}
+		req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:8080/index.html", nil)
+		req.Host = "myapp.tunnels.local:8080"
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("unable to get tunnel using host header: %v", err)
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != "tunnel path: /index.html" {
+				t.Errorf("unexpected tunnel response, status: %v body: %v", resp.StatusCode, string(body))
+			}
+		}
+		// the API paths are not proxied to the tunnels
+		req, _ = http.NewRequest(http.MethodGet, "http://127.0.0.1:8080/api/v1/version", nil)
+		req.Host = "myapp.tunnels.local:8080"
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("unable to get version using a tunnel host header: %v", err)
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || strings.HasPrefix(string(body), "tunnel path") {
+				t.Errorf("unexpected version response, status: %v body: %v", resp.StatusCode, string(body))
+			}
+		}
+		resp, err = http.Get("http://127.0.0.1:8080/tunnel/missing/")
+		if err != nil {
+			t.Errorf("unable to get missing tunnel: %v", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNotFound {
+				t.Errorf("unexpected status code for missing tunnel: %v", resp.StatusCode)
+			}
+		}
+		client1, err := getSSHClient(user1, usePubKey)
+		if err != nil {
+			t.Errorf("unable to create ssh client: %v", err)
+		} else {
+			err = requestNamedTunnel(client1, "myapp.tunnel")
+			if err == nil {
+				t.Errorf("a tunnel name owned by another user must not be available")
+			}
+			client1.Close()
+		}
+		client.Close()
+		for i := 0; i < 50; i++ {
+			tunnels, _, _ = api.GetTunnels(http.StatusOK)
+			if len(tunnels) == 0 {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if len(tunnels) != 0 {
+			t.Errorf("tunnels must be removed when the connection is closed: %+v", tunnels)
+		}
+		client1, err = getSSHClient(user1, usePubKey)
+		if err != nil {
+			t.Errorf("unable to create ssh client: %v", err)
+		} else {
+			err = requestNamedTunnel(client1, "myapp.tunnel")
+			if err == nil {
+				t.Errorf("an inactive tunnel name owned by another user must not be available")
+			}
+			client1.Close()
+		}
+		_, err = api.ReleaseTunnelName("myapp", http.StatusOK)
+		if err != nil {
+			t.Errorf("unable to release tunnel name: %v", err)
+		}
+		_, err = api.ReleaseTunnelName("myapp", http.StatusNotFound)
+		if err != nil {
+			t.Errorf("release a tunnel name without owner must fail: %v", err)
+		}
+		client1, err = getSSHClient(user1, usePubKey)
+		if err != nil {
+			t.Errorf("unable to create ssh client: %v", err)
+		} else {
+			err = requestNamedTunnel(client1, "myapp.tunnel")
+			if err != nil {
+				t.Errorf("unable to request a released tunnel name: %v", err)
+			}
+			client1.Close()
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	// the names owned by a deleted user can be claimed by other users
+	client1, err := getSSHClient(user1, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		err = requestNamedTunnel(client1, "myapp2.tunnel")
+		if err != nil {
+			t.Errorf("unable to request a tunnel name released by a deleted user: %v", err)
+		}
+		client1.Close()
+	}
+	_, err = api.RemoveUser(user1, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestPortForwardingConnection(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Capabilities = []string{dataprovider.CapLocalForward}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		conn, err := client.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Errorf("unable to forward connection: %v", err)
+		} else {
+			_, err = conn.Write([]byte("ping"))
+			if err != nil {
+				t.Errorf("unable to write forwarded data: %v", err)
+			}
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			if err != nil || string(buf) != "ping" {
+				t.Errorf("unexpected forwarded data: %v, err: %v", string(buf), err)
+			}
+		}
+		var stat serv.ConnectionStatus
+		for _, s := range serv.GetConnectionsStats() {
+			if s.Username == user.Username {
+				stat = s
+			}
+		}
+		if stat.ConnectionID == "" {
+			t.Errorf("port forwarding connection must be tracked")
+		} else {
+			if stat.Protocol != "SSH" {
+				t.Errorf("unexpected protocol: %v", stat.Protocol)
+			}
+			if len(stat.Channels) != 1 || stat.Channels[0].ChannelType != "direct-tcpip" ||
+				stat.Channels[0].Info != listener.Addr().String() {
+				t.Errorf("unexpected channels: %+v", stat.Channels)
+			}
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		_, err = api.CloseConnection(stat.ConnectionID, http.StatusOK)
+		if err != nil {
+			t.Errorf("unexpected error closing the connection: %v", err)
+		}
+		done := make(chan error)
+		go func() {
+			done <- client.Wait()
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Errorf("the connection must be closed")
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if len(serv.GetConnectionsStats()) == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(serv.GetConnectionsStats()) != 0 {
+		t.Errorf("closed connections must not be tracked")
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.MaxSessionDuration = 1
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("unable to create session: %v", err)
+		}
+		var stderr bytes.Buffer
+		session.Stderr = &stderr
+		// exec sessions end when stdin is closed
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatalf("unable to get stdin: %v", err)
+		}
+		defer stdin.Close()
+		err = session.Start("cat")
+		if err != nil {
+			t.Errorf("unable to start command: %v", err)
+		}
+		done := make(chan error)
+		go func() {
+			done <- client.Wait()
+		}()
+		select {
+		case <-done:
+			session.Wait()
+			if !strings.Contains(stderr.String(), "maximum session duration reached") {
+				t.Errorf("the reason must be sent to the client, stderr: %q", stderr.String())
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("the connection must be closed after the maximum session duration")
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestKeepaliveNoReply(t *testing.T) {
+	usePubKey := false
+	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	netConn, err := net.Dial("tcp", sftpServerAddr)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	conn := &freezableConn{Conn: netConn, unfreeze: make(chan struct{})}
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth: []ssh.AuthMethod{ssh.Password(defaultPassword)},
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, sftpServerAddr, config)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		client := ssh.NewClient(sshConn, chans, reqs)
+		if len(serv.GetConnectionsStats()) != 1 {
+			t.Errorf("the connection must be tracked")
+		}
+		// the client does not read anymore, so the keepalives get no reply
+		conn.freeze()
+		for i := 0; i < 100; i++ {
+			if len(serv.GetConnectionsStats()) == 0 {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if len(serv.GetConnectionsStats()) != 0 {
+			t.Errorf("the connection must be closed if the keepalives get no reply")
+		}
+		close(conn.unfreeze)
+		client.Close()
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestHDFSUnreachable(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = dataprovider.HDFSFilesystemProvider
+	u.FsConfig.HDFSConfig.Namenodes = "127.0.0.1:1"
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ReadDir(".")
+		if err == nil || !strings.Contains(err.Error(), "storage backend unavailable") {
+			t.Errorf("sftp requests must fail if the hdfs namenode is not reachable, err: %v", err)
+		}
+		_, err = client.Stat("file.txt")
+		if err == nil {
+			t.Errorf("sftp requests must fail if the hdfs namenode is not reachable")
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestVirtualFolders(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	mappedPath := filepath.Join(homeBasePath, "vfolder_mapped")
+	u.VirtualFolders = []fsconfig.VirtualFolder{
+		{
+			VirtualPath: "/shared/vdir",
+			MappedPath:  mappedPath,
+			Permissions: []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload},
+			QuotaFiles:  1,
+		},
+	}
+	err := os.MkdirAll(mappedPath, 0777)
+	if err != nil {
+		t.Errorf("unable to create mapped dir: %v", err)
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/shared/vdir", testFileName), testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload in virtual folder error: %v", err)
+		}
+		_, err = os.Stat(filepath.Join(mappedPath, testFileName))
+		if err != nil {
+			t.Errorf("the uploaded file must be inside the mapped path: %v", err)
+		}
+		files, err := client.ReadDir("/shared")
+		if err != nil {
+			t.Errorf("unable to list the virtual folder parent: %v", err)
+		} else if len(files) != 1 || files[0].Name() != "vdir" || !files[0].IsDir() {
+			t.Errorf("unexpected listing for the virtual folder parent: %v", files)
+		}
+		_, err = client.Stat("/shared")
+		if err != nil {
+			t.Errorf("unable to stat the virtual folder parent: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/shared/vdir", testFileName+"1"), testFileSize, client)
+		if err == nil {
+			t.Errorf("virtual folder quota exceeded, file upload must fail")
+		}
+		err = client.Rename(path.Join("/shared/vdir", testFileName), testFileName)
+		if err == nil {
+			t.Errorf("rename across virtual folders must fail")
+		}
+		err = client.Remove(path.Join("/shared/vdir", testFileName))
+		if err == nil {
+			t.Errorf("remove without delete permission inside the virtual folder must fail")
+		}
+		err = client.RemoveDirectory("/shared")
+		if err == nil {
+			t.Errorf("removing a virtual folder parent must fail")
+		}
+		user, _, err = api.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 0 || user.UsedQuotaSize != 0 {
+			t.Errorf("virtual folder uploads must not update the user quota, files: %v size: %v",
+				user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		folders, _, err := api.GetFolderQuotas(mappedPath, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting folder quota: %v", err)
+		} else if len(folders) != 1 || folders[0].UsedQuotaFiles != 1 || folders[0].UsedQuotaSize != testFileSize {
+			t.Errorf("the virtual folder usage must be stored, folders: %+v", folders)
+		}
+		// files removed without using SFTP are accounted for after a quota scan
+		err = os.Remove(filepath.Join(mappedPath, testFileName))
+		if err != nil {
+			t.Errorf("unable to remove the uploaded file: %v", err)
+		}
+		_, err = api.StartQuotaScan(user, http.StatusCreated)
+		if err != nil {
+			t.Errorf("error starting quota scan: %v", err)
+		}
+		scans, _, err := api.GetQuotaScans(http.StatusOK)
+		for err == nil && len(scans) > 0 {
+			scans, _, err = api.GetQuotaScans(http.StatusOK)
+		}
+		folders, _, err = api.GetFolderQuotas(mappedPath, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting folder quota: %v", err)
+		} else if len(folders) != 1 || folders[0].UsedQuotaFiles != 0 || folders[0].UsedQuotaSize != 0 {
+			t.Errorf("the virtual folder usage must be updated by the quota scan, folders: %+v", folders)
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(mappedPath)
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestCheckFile(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.DirPermissions = map[string][]string{
+		"/upload": {dataprovider.PermListItems, dataprovider.PermUpload},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		content, err := ioutil.ReadFile(testFilePath)
+		if err != nil {
+			t.Errorf("unable to read test file: %v", err)
+		}
+		expected := sha256.Sum256(content)
+		algorithm, hash, err := client.CheckFile(testFileName, "sha256,md5", 0, 0, 0)
+		if err != nil {
+			t.Errorf("check-file error: %v", err)
+		}
+		if algorithm != "sha256" || !bytes.Equal(hash, expected[:]) {
+			t.Errorf("unexpected check-file result, algorithm: %v", algorithm)
+		}
+		_, _, err = client.CheckFile(testFileName, "unsupported", 0, 0, 0)
+		if err == nil {
+			t.Errorf("check-file with an unsupported algorithm must fail")
+		}
+		err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "upload"), 0777)
+		if err != nil {
+			t.Errorf("unable to create upload dir: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/upload", testFileName), testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		_, _, err = client.CheckFile(path.Join("/upload", testFileName), "sha256", 0, 0, 0)
+		if err == nil {
+			t.Errorf("check-file without download permission must fail")
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestCopyFile(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	// room for four copies of the test file
+	u.QuotaSize = 5*65535 - 1
+	u.DirPermissions = map[string][]string{
+		"/upload": {dataprovider.PermListItems, dataprovider.PermUpload},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "upload"), 0777)
+	if err != nil {
+		t.Errorf("unable to create upload dir: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = client.CopyFile(testFileName, testFileName+".copy", false)
+		if err != nil {
+			t.Errorf("copy-file error: %v", err)
+		}
+		content, err := ioutil.ReadFile(testFilePath)
+		if err != nil {
+			t.Errorf("unable to read test file: %v", err)
+		}
+		copied, err := ioutil.ReadFile(filepath.Join(user.GetHomeDir(), testFileName+".copy"))
+		if err != nil {
+			t.Errorf("unable to read copied file: %v", err)
+		}
+		if !bytes.Equal(content, copied) {
+			t.Errorf("copied file content mismatch")
+		}
+		err = client.CopyFile(testFileName, testFileName+".copy", false)
+		if err == nil {
+			t.Errorf("copy-file over an existing file without overwrite must fail")
+		}
+		err = client.CopyFile(testFileName, testFileName+".copy", true)
+		if err != nil {
+			t.Errorf("copy-file with overwrite error: %v", err)
+		}
+		err = client.CopyFile(testFileName, path.Join("/upload", testFileName), false)
+		if err != nil {
+			t.Errorf("copy-file to the upload dir error: %v", err)
+		}
+		err = client.CopyFile(path.Join("/upload", testFileName), testFileName+".copy1", false)
+		if err == nil {
+			t.Errorf("copy-file without download permission on the source must fail")
+		}
+		src, err := client.Open(testFileName)
+		if err != nil {
+			t.Errorf("unable to open source file: %v", err)
+		} else {
+			dst, err := client.Create(testFileName + ".data")
+			if err != nil {
+				t.Errorf("unable to create target file: %v", err)
+			} else {
+				err = client.CopyData(src, 0, 0, dst, 0)
+				if err != nil {
+					t.Errorf("copy-data error: %v", err)
+				}
+				dst.Close()
+			}
+			src.Close()
+		}
+		fi, err := client.Stat(testFileName + ".data")
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if fi.Size() != testFileSize {
+			t.Errorf("unexpected copy-data size: %v", fi.Size())
+		}
+		user, _, err = api.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 4 || user.UsedQuotaSize != 4*testFileSize {
+			t.Errorf("copies must update the quota, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		err = client.CopyFile(testFileName, testFileName+".copy2", false)
+		if err == nil {
+			t.Errorf("copy-file exceeding the quota must fail")
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+
+func TestCopyFileInterrupted(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.UploadBandwidth = 32
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	testFileName := "test_file.dat"
+	testFileSize := int64(131072)
+	err = createTestFile(filepath.Join(user.GetHomeDir(), testFileName), testFileSize)
+	if err != nil {
+		t.Errorf("unable to create test file: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		c := make(chan error)
+		go func() {
+			c <- client.CopyFile(testFileName, testFileName+".copy", false)
+		}()
+		waitForActiveTransfer()
+		stats := serv.GetConnectionsStats()
+		if len(stats) != 1 || len(stats[0].Transfers) != 1 || stats[0].Transfers[0].OperationType != "upload" {
+			t.Errorf("the copy must be listed as an active upload: %+v", stats)
+		}
+		for _, stat := range stats {
+			serv.CloseActiveConnection(stat.ConnectionID)
+		}
+		err = <-c
+		if err == nil {
+			t.Errorf("an interrupted copy must fail")
+		}
+		// the copy stops at the next chunk, it is throttled so it can take a while
+		var files []os.FileInfo
+		for i := 0; i < 50; i++ {
+			files, err = ioutil.ReadDir(user.GetHomeDir())
+			if err != nil || len(files) == 1 {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			t.Errorf("unable to read home dir: %v", err)
+		}
+		for _, f := range files {
+			if f.Name() != testFileName {
+				t.Errorf("an interrupted copy must not leave files behind, found: %v", f.Name())
+			}
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+func TestStatVFS(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 10
+	u.QuotaSize = 1048576
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65536)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		stat, err := client.StatVFS("/")
+		if err != nil {
+			t.Errorf("statvfs error: %v", err)
+		} else {
+			if stat.Files != 10 || stat.Ffree != 9 || stat.Favail != 9 {
+				t.Errorf("unexpected inodes, files: %v free: %v", stat.Files, stat.Ffree)
+			}
+			if stat.TotalSpace() > uint64(user.QuotaSize) || stat.TotalSpace() < uint64(user.QuotaSize)-stat.Frsize {
+				t.Errorf("unexpected total space: %v", stat.TotalSpace())
+			}
+			if stat.FreeSpace() > uint64(user.QuotaSize-testFileSize) {
+				t.Errorf("unexpected free space: %v", stat.FreeSpace())
+			}
+		}
+		_, err = client.StatVFS("/missing")
 		if err == nil {
-			t.Errorf("readlink is currently not implemented so must fail")
+			t.Errorf("statvfs for a missing path must fail")
+		}
+		limits, err := client.Limits()
+		if err != nil {
+			t.Errorf("limits error: %v", err)
+		} else if limits.PacketLength == 0 || limits.ReadLength == 0 || limits.WriteLength == 0 {
+			t.Errorf("unexpected limits: %+v", limits)
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestLinks(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
 		}
 		err = client.Symlink(testFileName, testFileName+".link")
+		if err != nil {
+			t.Errorf("error creating symlink: %v", err)
+		}
+		fi, err := client.Lstat(testFileName + ".link")
+		if err != nil {
+			t.Errorf("lstat error: %v", err)
+		} else if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("lstat must not follow symlinks, mode: %v", fi.Mode())
+		}
+		target, err := client.ReadLink(testFileName + ".link")
+		if err != nil {
+			t.Errorf("readlink error: %v", err)
+		} else if target != "/"+testFileName {
+			t.Errorf("unexpected link target: %v", target)
+		}
+		// a symlink pointing outside the home dir is handled as a dangling one
+		err = os.Symlink(os.TempDir(), filepath.Join(user.GetHomeDir(), "outside"))
+		if err != nil {
+			t.Errorf("unable to create symlink: %v", err)
+		}
+		_, err = client.Lstat("outside")
+		if err != nil {
+			t.Errorf("lstat for a symlink pointing outside the home dir must succeed: %v", err)
+		}
+		_, err = client.Stat("outside")
 		if err == nil {
-			t.Errorf("creating a symlink to an existing one must fail")
+			t.Errorf("stat for a symlink pointing outside the home dir must fail")
+		}
+		_, err = client.ReadLink("outside")
+		if err == nil {
+			t.Errorf("readlink for a symlink pointing outside the home dir must fail")
+		}
+		err = client.Remove("outside")
+		if err != nil {
+			t.Errorf("unable to remove a symlink pointing outside the home dir: %v", err)
+		}
+		err = client.Link(testFileName, testFileName+".hardlink")
+		if err != nil {
+			t.Errorf("error creating hard link: %v", err)
+		}
+		fi, err = client.Stat(testFileName + ".hardlink")
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if fi.Size() != testFileSize {
+			t.Errorf("unexpected hard link size: %v", fi.Size())
+		}
+		err = client.Link(testFileName+".link", testFileName+".hardlink1")
+		if err == nil {
+			t.Errorf("hard links to symlinks must fail")
+		}
+		user, _, err = api.GetUserByID(user.ID, http.StatusOK)
+		if err != nil {
+			t.Errorf("error getting user: %v", err)
+		}
+		if user.UsedQuotaFiles != 2 || user.UsedQuotaSize != 2*testFileSize {
+			t.Errorf("hard links must update the quota, files: %v size: %v", user.UsedQuotaFiles, user.UsedQuotaSize)
+		}
+		f, err := client.Create(testFileName + ".sync")
+		if err != nil {
+			t.Errorf("unable to create file: %v", err)
+		} else {
+			_, err = f.Write([]byte("data"))
+			if err != nil {
+				t.Errorf("write error: %v", err)
+			}
+			err = f.Sync()
+			if err != nil {
+				t.Errorf("fsync error: %v", err)
+			}
+			f.Close()
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestSetstatPermissions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Permissions = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload,
+		dataprovider.PermChtimes}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = client.Chmod(testFileName, 0600)
+		if err == nil {
+			t.Errorf("chmod without chmod permission must fail")
+		}
+		err = client.Chown(testFileName, os.Getuid(), os.Getgid())
+		if err == nil {
+			t.Errorf("chown without chown permission must fail")
+		}
+		mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		err = client.Chtimes(testFileName, mtime, mtime)
+		if err != nil {
+			t.Errorf("chtimes error: %v", err)
+		}
+		fi, err := client.Stat(testFileName)
+		if err != nil {
+			t.Errorf("stat error: %v", err)
+		} else if !fi.ModTime().Equal(mtime) {
+			t.Errorf("unexpected modification time: %v, expected: %v", fi.ModTime(), mtime)
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestXattrs(t *testing.T) {
+	usePubKey := false
+	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = client.SetXattr(testFileName, "user.origin", "test")
+		if err != nil {
+			// the filesystem of the home dir may not support user extended attributes
+			t.Logf("unable to set xattr: %v", err)
+		} else {
+			names, err := client.ListXattrs(testFileName)
+			if err != nil || len(names) != 1 || names[0] != "user.origin" {
+				t.Errorf("unexpected xattrs: %v, err: %v", names, err)
+			}
+			value, err := client.GetXattr(testFileName, "user.origin")
+			if err != nil || value != "test" {
+				t.Errorf("unexpected xattr value: %#v, err: %v", value, err)
+			}
+			err = client.SetXattr(testFileName, "trusted.origin", "test")
+			if err == nil {
+				t.Errorf("setting an xattr outside the user namespace must fail")
+			}
+			err = client.RemoveXattr(testFileName, "user.origin")
+			if err != nil {
+				t.Errorf("unable to remove xattr: %v", err)
+			}
+			_, err = client.GetXattr(testFileName, "user.origin")
+			if !os.IsNotExist(err) {
+				t.Errorf("getting a removed xattr must fail with not exist, err: %v", err)
+			}
+			entries, err := client.GetACL(testFileName)
+			if err != nil || len(entries) < 3 || !strings.HasPrefix(entries[0], "user::") {
+				t.Errorf("unexpected ACL: %v, err: %v", entries, err)
+			}
+			_, err = client.ListXattrs("missing")
+			if !os.IsNotExist(err) {
+				t.Errorf("listing the xattrs of a missing file must fail with not exist, err: %v", err)
+			}
+		}
+		os.Remove(testFilePath)
+	}
+	user.Permissions = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload}
+	_, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	client, err = getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		_, err = client.ListXattrs("/")
+		if err == nil {
+			t.Errorf("listing xattrs without xattrs permission must fail")
+		}
+		err = client.SetXattr("/", "user.origin", "test")
+		if err == nil {
+			t.Errorf("setting xattrs without xattrs permission must fail")
+		}
+		_, err = client.GetACL("/")
+		if err == nil {
+			t.Errorf("getting the ACL without xattrs permission must fail")
+		}
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestLinkPermission(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Permissions = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		testFileName := "test_file.dat"
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload error: %v", err)
+		}
+		err = client.Link(testFileName, testFileName+".hardlink")
+		if err == nil {
+			t.Errorf("hard link without create_links permission must fail")
+		}
+		os.Remove(testFilePath)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestDirPermissions(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Permissions = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	u.DirPermissions = map[string][]string{
+		"/incoming": {dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermRename},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "incoming"), 0777)
+	if err != nil {
+		t.Errorf("unable to create upload dir: %v", err)
+	}
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	client, err := getSftpClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create sftp client: %v", err)
+	} else {
+		defer client.Close()
+		err = createTestFile(testFilePath, testFileSize)
+		if err != nil {
+			t.Errorf("unable to create test file: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		if err == nil {
+			t.Errorf("upload outside the upload dir must fail")
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/incoming", testFileName), testFileSize, client)
+		if err != nil {
+			t.Errorf("file upload inside the upload dir error: %v", err)
+		}
+		localDownloadPath := filepath.Join(homeBasePath, "test_download.dat")
+		err = sftpDownloadFile(path.Join("/incoming", testFileName), localDownloadPath, testFileSize, client)
+		if err == nil {
+			t.Errorf("download without download permission inside the upload dir must fail")
 		}
-		err = client.Remove(testFileName + ".link")
-		if err != nil {
-			t.Errorf("error removing symlink: %v", err)
+		err = client.Rename(path.Join("/incoming", testFileName), testFileName)
+		if err == nil {
+			t.Errorf("rename without rename permission on the target must fail")
 		}
-		err = client.Remove(testFileName)
+		err = client.Rename(path.Join("/incoming", testFileName), path.Join("/incoming", testFileName+".renamed"))
 		if err != nil {
-			t.Errorf("error removing uploaded file: %v", err)
+			t.Errorf("rename inside the upload dir error: %v", err)
 		}
+		os.Remove(localDownloadPath)
 	}
 	_, err = api.RemoveUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to remove user: %v", err)
 	}
+	os.RemoveAll(user.GetHomeDir())
 }
 
-func TestStat(t *testing.T) {
+func TestDirPermissionsRenameOverride(t *testing.T) {
 	usePubKey := false
-	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+	u := getTestUser(usePubKey)
+	u.Permissions = []string{dataprovider.PermAny}
+	u.DirPermissions = map[string][]string{
+		"/parent":       {dataprovider.PermListItems, dataprovider.PermUpload, dataprovider.PermCreateDirs},
+		"/parent/child": {dataprovider.PermAny},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
+	err = os.MkdirAll(filepath.Join(user.GetHomeDir(), "parent", "child"), 0777)
+	if err != nil {
+		t.Errorf("unable to create child dir: %v", err)
+	}
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
 		t.Errorf("unable to create sftp client: %v", err)
 	} else {
 		defer client.Close()
-		testFileName := "test_file.dat"
-		testFilePath := filepath.Join(homeBasePath, testFileName)
-		testFileSize := int64(65535)
 		err = createTestFile(testFilePath, testFileSize)
 		if err != nil {
 			t.Errorf("unable to create test file: %v", err)
 		}
-		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
-		if err != nil {
-			t.Errorf("file upload error: %v", err)
-		}
-		fi, err := client.Lstat(testFileName)
-		if err != nil {
-			t.Errorf("stat error: %v", err)
-		}
-		err = client.Chown(testFileName, 1000, 1000)
-		if err != nil {
-			t.Errorf("chown error: %v", err)
-		}
-		err = client.Chmod(testFileName, 0600)
+		err = sftpUploadFile(testFilePath, path.Join("/parent/child", testFileName), testFileSize, client)
 		if err != nil {
-			t.Errorf("chmod error: %v", err)
+			t.Errorf("file upload inside the child dir error: %v", err)
 		}
-		newFi, err := client.Lstat(testFileName)
+		err = client.Rename(path.Join("/parent/child", testFileName), path.Join("/parent/child", testFileName+".renamed"))
 		if err != nil {
-			t.Errorf("stat error: %v", err)
-		}
-		if fi.Mode().Perm() != newFi.Mode().Perm() {
-			t.Errorf("stat must remain unchanged")
+			t.Errorf("rename inside the child dir must be allowed by its override: %v", err)
 		}
-		_, err = client.ReadLink(testFileName)
+		err = client.Rename("/parent/child", "/parent/child.renamed")
 		if err == nil {
-			t.Errorf("readlink is not supported and must fail")
+			t.Errorf("rename of the child dir must fail, its parent denies rename")
 		}
-		err = client.Remove(testFileName)
-		if err != nil {
-			t.Errorf("error removing uploaded file: %v", err)
+		err = client.Rename(path.Join("/parent/child", testFileName+".renamed"), path.Join("/parent", testFileName))
+		if err == nil {
+			t.Errorf("rename to a dir that denies rename must fail")
 		}
+		os.Remove(testFilePath)
 	}
 	_, err = api.RemoveUser(user, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to remove user: %v", err)
 	}
+	os.RemoveAll(user.GetHomeDir())
 }
 
-// basic tests to verify virtual chroot, should be improved to cover more cases ...
-func TestEscapeHomeDir(t *testing.T) {
-	usePubKey := true
-	user, _, err := api.AddUser(getTestUser(usePubKey), http.StatusOK)
+func TestFilePatterns(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.FilePatterns = []dataprovider.PatternsFilter{
+		{Path: "/", DeniedPatterns: []string{"*.exe"}},
+		{Path: "/data", AllowedPatterns: []string{"*.csv"}, HideDenied: true},
+	}
+	user, _, err := api.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
+	testFileSize := int64(65535)
+	testFileName := "test_file.dat"
+	testFilePath := filepath.Join(homeBasePath, testFileName)
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
 		t.Errorf("unable to create sftp client: %v", err)
 	} else {
 		defer client.Close()
-		_, err := client.Getwd()
+		err = createTestFile(testFilePath, testFileSize)
 		if err != nil {
-			t.Errorf("unable to get working dir: %v", err)
+			t.Errorf("unable to create test file: %v", err)
 		}
-		testDir := "testDir"
-		linkPath := filepath.Join(homeBasePath, defaultUsername, testDir)
-		err = os.Symlink(homeBasePath, linkPath)
+		err = sftpUploadFile(testFilePath, "test.EXE", testFileSize, client)
+		if err == nil {
+			t.Errorf("upload of a denied file name must fail")
+		}
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
 		if err != nil {
-			t.Errorf("error making local symlink: %v", err)
+			t.Errorf("file upload error: %v", err)
 		}
-		_, err = client.ReadDir(testDir)
+		err = client.Mkdir("/data")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/data", testFileName), testFileSize, client)
 		if err == nil {
-			t.Errorf("reading a symbolic link outside home dir should not succeeded")
+			t.Errorf("upload of a file name not allowed must fail")
 		}
-		os.Remove(linkPath)
-		testFileName := "test_file.dat"
-		testFilePath := filepath.Join(homeBasePath, testFileName)
-		testFileSize := int64(65535)
-		err = createTestFile(testFilePath, testFileSize)
+		err = sftpUploadFile(testFilePath, "/data/test.csv", testFileSize, client)
 		if err != nil {
-			t.Errorf("unable to create test file: %v", err)
+			t.Errorf("file upload error: %v", err)
 		}
-		remoteDestPath := filepath.Join("..", "..", testFileName)
-		err = sftpUploadFile(testFilePath, remoteDestPath, testFileSize, client)
+		err = client.Rename(testFileName, "test.exe")
+		if err == nil {
+			t.Errorf("rename to a denied file name must fail")
+		}
+		err = client.Symlink(testFileName, "/data/link.dat")
+		if err == nil {
+			t.Errorf("symlink with a file name not allowed must fail")
+		}
+		err = client.Mkdir("/dir")
+		if err != nil {
+			t.Errorf("unable to create dir: %v", err)
+		}
+		err = sftpUploadFile(testFilePath, path.Join("/dir", testFileName), testFileSize, client)
 		if err != nil {
 			t.Errorf("file upload error: %v", err)
 		}
-		_, err = client.Lstat(testFileName)
+		err = client.Rename("/dir", "/data/dir")
+		if err == nil {
+			t.Errorf("rename of a dir with files not allowed inside the target must fail")
+		}
+		err = client.Symlink("/dir", "/data/dirlink")
+		if err == nil {
+			t.Errorf("symlink to a dir with files not allowed inside the link path must fail")
+		}
+		err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "denied.exe"), []byte("data"), 0666)
 		if err != nil {
-			t.Errorf("file stat error: %v the file was created outside the user dir!", err)
+			t.Errorf("unable to create file: %v", err)
 		}
-		err = client.Remove(testFileName)
+		err = client.Symlink("/denied.exe", "/link.dat")
+		if err == nil {
+			t.Errorf("symlink to a denied file must fail")
+		}
+		err = client.Rename("/dir", "/dir1")
 		if err != nil {
-			t.Errorf("error removing uploaded file: %v", err)
+			t.Errorf("rename of a dir with allowed files must succeed: %v", err)
 		}
-		linkPath = filepath.Join(homeBasePath, defaultUsername, testFileName)
-		err = os.Symlink(homeBasePath, linkPath)
+		err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "data", "hidden.dat"), []byte("data"), 0666)
 		if err != nil {
-			t.Errorf("error making local symlink: %v", err)
+			t.Errorf("unable to create file: %v", err)
 		}
-		err = sftpDownloadFile(testFileName, testFilePath, 0, client)
-		if err == nil {
-			t.Errorf("download file outside home dir must fail")
+		files, err := client.ReadDir("/data")
+		if err != nil {
+			t.Errorf("unable to list dir: %v", err)
+		} else if len(files) != 1 || files[0].Name() != "test.csv" {
+			t.Errorf("files not allowed must be hidden, listing: %v", files)
 		}
-		err = sftpUploadFile(testFilePath, remoteDestPath, testFileSize, client)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+	os.RemoveAll(user.GetHomeDir())
+}
+
+func TestCapabilities(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Capabilities = []string{dataprovider.CapSFTP}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		sftpClient, err := sftp.NewClient(client)
+		if err != nil {
+			t.Errorf("sftp must be allowed: %v", err)
+		} else {
+			_, err = sftpClient.ReadDir(".")
+			if err != nil {
+				t.Errorf("unable to read remote dir: %v", err)
+			}
+			sftpClient.Close()
+		}
+		_, err = client.Dial("tcp", "127.0.0.1:8080")
 		if err == nil {
-			t.Errorf("overwrite a file outside home dir must fail")
+			t.Errorf("local forwarding must be denied")
 		}
-		err = client.Chmod(remoteDestPath, 0644)
+		_, err = client.Listen("tcp", "127.0.0.1:0")
 		if err == nil {
-			t.Errorf("setstat on a file outside home dir must fail")
+			t.Errorf("remote forwarding must be denied")
 		}
-		os.Remove(linkPath)
+		session, err := client.NewSession()
+		if err != nil {
+			t.Errorf("the connection must be still usable after a denied request: %v", err)
+		} else {
+			err = session.Start("ls")
+			if err == nil {
+				t.Errorf("exec must be denied")
+			}
+			session.Close()
+		}
+	}
+	user.Capabilities = []string{dataprovider.CapLocalForward}
+	user, _, err = api.UpdateUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to update user: %v", err)
+	}
+	_, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("sftp must be denied")
 	}
 	_, err = api.RemoveUser(user, http.StatusOK)
 	if err != nil {
@@ -489,44 +1864,172 @@ func TestEscapeHomeDir(t *testing.T) {
 	}
 }
 
-func TestHomeSpecialChars(t *testing.T) {
-	usePubKey := true
+func TestGroupCapabilities(t *testing.T) {
+	usePubKey := false
+	group, _, err := api.AddGroup(dataprovider.Group{Name: "sftpgroup", Capabilities: []string{dataprovider.CapSFTP}},
+		http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add group: %v", err)
+	}
 	u := getTestUser(usePubKey)
-	u.HomeDir = filepath.Join(homeBasePath, "abc açà#&%lk")
+	u.Capabilities = []string{dataprovider.CapLocalForward}
+	u.Groups = []string{group.Name}
 	user, _, err := api.AddUser(u, http.StatusOK)
 	if err != nil {
 		t.Errorf("unable to add user: %v", err)
 	}
 	client, err := getSftpClient(user, usePubKey)
 	if err != nil {
-		t.Errorf("unable to create sftp client: %v", err)
+		t.Errorf("sftp must be allowed by the user's group: %v", err)
 	} else {
-		defer client.Close()
-		_, err := client.Getwd()
+		_, err = client.ReadDir(".")
 		if err != nil {
-			t.Errorf("unable to get working dir: %v", err)
+			t.Errorf("unable to read remote dir: %v", err)
 		}
-		testFileName := "test_file.dat"
-		testFilePath := filepath.Join(homeBasePath, testFileName)
-		testFileSize := int64(65535)
-		err = createTestFile(testFilePath, testFileSize)
+		client.Close()
+	}
+	_, err = api.RemoveGroup(group, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove group: %v", err)
+	}
+	_, err = getSftpClient(user, usePubKey)
+	if err == nil {
+		t.Errorf("sftp must be denied once the group is removed")
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestAgentForwarding(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("agent forwarding is supported on Linux only")
+	}
+	key, err := ssh.ParseRawPrivateKey([]byte(testPrivateKey))
+	if err != nil {
+		t.Fatalf("unable to parse private key: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: key})
+	if err != nil {
+		t.Fatalf("unable to add key to the agent: %v", err)
+	}
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Capabilities = []string{dataprovider.CapExec, dataprovider.CapAgent}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		err = agent.ForwardToAgent(client, keyring)
 		if err != nil {
-			t.Errorf("unable to create test file: %v", err)
+			t.Errorf("unable to forward to agent: %v", err)
 		}
-		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		session, err := client.NewSession()
 		if err != nil {
-			t.Errorf("file upload error: %v", err)
+			t.Errorf("unable to create session: %v", err)
+		} else {
+			err = agent.RequestAgentForwarding(session)
+			if err != nil {
+				t.Errorf("agent forwarding must be allowed: %v", err)
+			}
+			out, _ := session.Output("sh -c 'test -S \"$SSH_AUTH_SOCK\" && echo agent_ok'")
+			if !strings.Contains(string(out), "agent_ok") {
+				t.Errorf("SSH_AUTH_SOCK must be a socket, output: %v", string(out))
+			}
+			session.Close()
+		}
+		if _, err = exec.LookPath("ssh-add"); err == nil {
+			session, err = client.NewSession()
+			if err != nil {
+				t.Errorf("unable to create session: %v", err)
+			} else {
+				agent.RequestAgentForwarding(session)
+				// stdin must stay open, the exec session ends when it is closed
+				stdin, _ := session.StdinPipe()
+				stdout, _ := session.StdoutPipe()
+				err = session.Start("sh -c 'ssh-add -l && echo agent_listed'")
+				if err != nil {
+					t.Errorf("unable to start command: %v", err)
+				} else {
+					out, _ := ioutil.ReadAll(stdout)
+					if !strings.Contains(string(out), "agent_listed") {
+						t.Errorf("the agent must be usable inside the session, output: %v", string(out))
+					}
+				}
+				stdin.Close()
+				session.Close()
+			}
 		}
-		files, err := client.ReadDir(".")
+		session, err = client.NewSession()
 		if err != nil {
-			t.Errorf("unable to read remote dir: %v", err)
-		}
-		if len(files) < 1 {
-			t.Errorf("expected at least 1 file in this dir")
+			t.Errorf("unable to create session: %v", err)
+		} else {
+			agent.RequestAgentForwarding(session)
+			stdin, _ := session.StdinPipe()
+			defer stdin.Close()
+			stdout, _ := session.StdoutPipe()
+			err = session.Start("sh -c 'echo $SSH_AUTH_SOCK; sleep 5'")
+			if err != nil {
+				t.Errorf("unable to start command: %v", err)
+			} else {
+				sockPath, _ := bufio.NewReader(stdout).ReadString('\n')
+				conn, err := net.Dial("unix", strings.TrimSpace(sockPath))
+				if err != nil {
+					t.Errorf("unable to connect to the agent socket %#v: %v", sockPath, err)
+				} else {
+					_, err = agent.NewClient(conn).List()
+					if err == nil {
+						t.Errorf("the agent must not be usable outside the session")
+					}
+					conn.Close()
+				}
+			}
+			session.Close()
 		}
-		err = client.Remove(testFileName)
+	}
+	_, err = api.RemoveUser(user, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to remove user: %v", err)
+	}
+}
+
+func TestExecOnStartedSession(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Capabilities = []string{dataprovider.CapSFTP, dataprovider.CapExec}
+	user, _, err := api.AddUser(u, http.StatusOK)
+	if err != nil {
+		t.Errorf("unable to add user: %v", err)
+	}
+	client, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		t.Errorf("unable to create ssh client: %v", err)
+	} else {
+		defer client.Close()
+		session, err := client.NewSession()
 		if err != nil {
-			t.Errorf("error removing uploaded file: %v", err)
+			t.Errorf("unable to create session: %v", err)
+		} else {
+			err = session.RequestSubsystem("sftp")
+			if err != nil {
+				t.Errorf("sftp must be allowed: %v", err)
+			}
+			ok, err := session.SendRequest("exec", true, ssh.Marshal(struct{ Command string }{"sleep 1"}))
+			if err != nil || ok {
+				t.Errorf("exec must be denied on a session with the sftp subsystem, ok: %v, err: %v", ok, err)
+			}
+			ok, err = session.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{"sftp"}))
+			if err != nil || ok {
+				t.Errorf("a second subsystem must be denied, ok: %v, err: %v", ok, err)
+			}
+			session.Close()
 		}
 	}
 	_, err = api.RemoveUser(user, http.StatusOK)
@@ -2037,8 +3540,7 @@ func doSSH(user dataprovider.User, usePubKey bool) error {
 	return err
 }
 
-func getSftpClient(user dataprovider.User, usePubKey bool) (*sftp.Client, error) {
-	var sftpClient *sftp.Client
+func getSSHClient(user dataprovider.User, usePubKey bool) (*ssh.Client, error) {
 	config := &ssh.ClientConfig{
 		User: user.Username,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
@@ -2058,14 +3560,107 @@ func getSftpClient(user dataprovider.User, usePubKey bool) (*sftp.Client, error)
 			config.Auth = []ssh.AuthMethod{ssh.Password(defaultPassword)}
 		}
 	}
-	conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+	return ssh.Dial("tcp", sftpServerAddr, config)
+}
+
+// sshClientCloser closes the SSH connection too when the SFTP client is closed
+type sshClientCloser struct {
+	io.WriteCloser
+	client *ssh.Client
+}
+
+func (c *sshClientCloser) Close() error {
+	err := c.WriteCloser.Close()
+	c.client.Close()
+	return err
+}
+
+func getSftpClient(user dataprovider.User, usePubKey bool) (*sftp.Client, error) {
+	var sftpClient *sftp.Client
+	conn, err := getSSHClient(user, usePubKey)
+	if err != nil {
+		return sftpClient, err
+	}
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return sftpClient, err
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		conn.Close()
+		return sftpClient, err
+	}
+	pw, err := session.StdinPipe()
+	if err != nil {
+		conn.Close()
+		return sftpClient, err
+	}
+	pr, err := session.StdoutPipe()
 	if err != nil {
+		conn.Close()
 		return sftpClient, err
 	}
-	sftpClient, err = sftp.NewClient(conn)
+	sftpClient, err = sftp.NewClientPipe(pr, &sshClientCloser{WriteCloser: pw, client: conn})
 	return sftpClient, err
 }
 
+// requestNamedTunnel asks the server for a named tunnel and serves a fixed HTTP
+// response, echoing the requested path, for every forwarded connection
+func requestNamedTunnel(client *ssh.Client, bindAddr string) error {
+	chans := client.HandleChannelOpen("forwarded-tcpip")
+	if chans != nil {
+		go func() {
+			for newChannel := range chans {
+				ch, reqs, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go ssh.DiscardRequests(reqs)
+				go func(ch ssh.Channel) {
+					defer ch.Close()
+					req, err := http.ReadRequest(bufio.NewReader(ch))
+					if err != nil {
+						return
+					}
+					body := "tunnel path: " + req.URL.Path
+					fmt.Fprintf(ch, "HTTP/1.1 200 OK\r\nContent-Length: %v\r\nConnection: close\r\n\r\n%v", len(body), body)
+				}(ch)
+			}
+		}()
+	}
+	payload := ssh.Marshal(&struct {
+		BindAddr string
+		BindPort uint32
+	}{bindAddr, 80})
+	ok, _, err := client.SendRequest("tcpip-forward", true, payload)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("tcpip-forward request for %v rejected", bindAddr)
+	}
+	return nil
+}
+
+// freezableConn blocks reads once frozen, until unfreeze is closed
+type freezableConn struct {
+	net.Conn
+	frozen   int32
+	unfreeze chan struct{}
+}
+
+func (c *freezableConn) freeze() {
+	atomic.StoreInt32(&c.frozen, 1)
+}
+
+func (c *freezableConn) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.frozen) == 1 {
+		<-c.unfreeze
+	}
+	return c.Conn.Read(p)
+}
+
 func createTestFile(path string, size int64) error {
 	baseDir := filepath.Dir(path)
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
@@ -62,7 +62,8 @@ func SetWinsize(fd uintptr, w, h uint32) {
 	syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TIOCSWINSZ), uintptr(unsafe.Pointer(ws)))
 }
 
-func handleShell(req *ssh.Request, channel ssh.Channel, f, tty *os.File, homedir string) bool{
+func handleShell(req *ssh.Request, channel ssh.Channel, f, tty *os.File, homedir string, env []string,
+	agent *agentForwarding) bool{
 	// allocate a terminal for this channel
 	logger.Debug("shell", "creating pty...")
 
@@ -80,9 +81,14 @@ func handleShell(req *ssh.Request, channel ssh.Channel, f, tty *os.File, homedir
 	cmd := exec.Command(shell)
 	cmd.Dir = homedir
 	cmd.Env = append(os.Environ(), "TERM=xterm", fmt.Sprintf("HOME=%s", homedir))
+	cmd.Env = append(cmd.Env, env...)
 	err := PtyRun(cmd, tty)
 	if err != nil {
 		logger.Warn("", "%s", err)
+		return false
+	}
+	if agent != nil {
+		agent.addSession(cmd.Process.Pid)
 	}
 
 	// Teardown session
@@ -236,18 +242,27 @@ func handleWindowChanged(req *ssh.Request, fPty *os.File) {
 
 
 
-func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Connection, c *Configuration) {
+func handleSSHRequest(in <-chan *ssh.Request, channel *activeChannel, connection Connection, c *Configuration) {
 	var fPty *os.File = nil
 	var tty *os.File = nil
+	var agent *agentForwarding = nil
+	var env []string
 	for req := range in {
 		ok := false
 		logger.Debug(logSender,"--- req.Type: [%s] payload [%s]\n", req.Type, string(req.Payload))
 
+		if (req.Type == "subsystem" || req.Type == "exec" || req.Type == "shell") && isSessionStarted(channel) {
+			logger.Warn(logShell, "Denied %v request of user [%s], the session is already started",
+				req.Type, connection.User.Username)
+			req.Reply(false, nil)
+			continue
+		}
 		switch req.Type {
 		case "subsystem":
-			if string(req.Payload[4:]) == "sftp" {
+			if string(req.Payload[4:]) == "sftp" && connection.User.HasCapability(dataprovider.CapSFTP) {
 				ok = true
 				connection.protocol = protocolSFTP
+				setChannelType(channel, channelTypeSFTP, "")
 				go c.handleSftpConnection(channel, connection)
 			}
 		case "exec":
@@ -259,21 +274,20 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 				//fmt.Printf("------exec %s\n", name)
 				logger.Debug(logSender, "new exec command: %v args: %v user: %v, error: %v", name, execArgs,
 					connection.User.Username, err)
-				if c.IsSCPEnabled && err == nil && name == "scp" && len(execArgs) >= 2 {
+				if c.IsSCPEnabled && err == nil && name == "scp" && len(execArgs) >= 2 &&
+					connection.User.HasCapability(dataprovider.CapSCP) {
 					ok = true
 					connection.protocol = protocolSCP
-					scpCommand := scpCommand{
-						connection: connection,
-						args:       execArgs,
-						channel:    channel,
-					}
-					go scpCommand.handle()
+					setChannelType(channel, channelTypeSCP, msg.Command)
+					go c.handleSCPCommand(channel, connection, execArgs)
 				}else if err == nil {
 					// execute cmd
-					if connection.User.HasPerm(dataprovider.PermShell) {
+					if connection.User.HasCapability(dataprovider.CapExec) {
+						setChannelType(channel, channelTypeExec, msg.Command)
 						cmd := exec.Command(name, execArgs...)
 						cmd.Env = append(os.Environ(), "TERM=vt100",
 							fmt.Sprintf("HOME=%s", connection.User.HomeDir))
+						cmd.Env = append(cmd.Env, env...)
 						cmd.Dir = connection.User.HomeDir
 						err = handleExec(req, channel, cmd)
 						if err != nil {
@@ -282,23 +296,31 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 						}else {
 							logger.Info(logShell, "exec started.")
 							ok = true // 还是需要关闭连接
+							if agent != nil {
+								agent.addSession(cmd.Process.Pid)
+							}
 						}
-						req.Reply(ok, nil)
-						return // this will end the session
+					} else {
+						logger.Warn(logShell, "Denied exec of user [%s] capabilities:[%v]",
+							connection.User.Username, connection.User.Capabilities)
 					}
 				}else {
 					logger.Error(logShell, "parseCommandPayload failed: %v", err)
 				}
 			}
 		case "shell":
-			if fPty == nil {
+			if !connection.User.HasCapability(dataprovider.CapShell) {
+				logger.Warn(logShell, "Denied shell of user [%s] capabilities:[%v]",
+					connection.User.Username, connection.User.Capabilities)
+			} else if fPty == nil {
 				logger.Warn(logShell, "pty not open yet!")
 				ok = false
 			} else {
-				ok = handleShell(req, channel, fPty, tty, connection.User.HomeDir)
+				setChannelType(channel, channelTypeShell, "")
+				ok = handleShell(req, channel, fPty, tty, connection.User.HomeDir, env, agent)
 			}
 		case "pty-req":
-			if c.FullFunc && connection.User.HasPerm(dataprovider.PermShell) {
+			if connection.User.HasCapability(dataprovider.CapShell) || connection.User.HasCapability(dataprovider.CapExec) {
 				// Responding 'ok' here will let the client
 				// know we have a pty ready for input
 				ok = true
@@ -308,8 +330,25 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 				}
 			}else{
 				ok = false
-				logger.Warn(logShell, "Denied shell of user [%s] full_func:[%v] perms:[%v]",
-					connection.User.Username, c.FullFunc, connection.User.Permissions)
+				logger.Warn(logShell, "Denied pty of user [%s] capabilities:[%v]",
+					connection.User.Username, connection.User.Capabilities)
+			}
+		case "auth-agent-req@openssh.com":
+			if agent != nil {
+				ok = true
+			} else if connection.User.HasCapability(dataprovider.CapAgent) {
+				var err error
+				agent, err = startAgentForwarding(connection)
+				if err != nil {
+					logger.Warn(logShell, "unable to start agent forwarding for user [%s]: %v",
+						connection.User.Username, err)
+				} else {
+					ok = true
+					env = append(env, agent.env())
+				}
+			} else {
+				logger.Warn(logShell, "Denied agent forwarding of user [%s] capabilities:[%v]",
+					connection.User.Username, connection.User.Capabilities)
 			}
 		case "window-change":
 			if fPty == nil {
@@ -325,6 +364,11 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 		req.Reply(ok, nil)
 	}
 	logger.Debug(logSender, " --request process exited...")
+	// no more requests means the channel is closed
+	channel.release()
+	if agent != nil {
+		agent.close()
+	}
 	if fPty != nil {
 		fPty.Close()
 		tty.Close()
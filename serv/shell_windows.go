@@ -86,18 +86,25 @@ func handleWindowChanged(req *ssh.Request, pty *winpty.WinPTY) {
 }
 
 
-func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Connection, c *Configuration) {
+func handleSSHRequest(in <-chan *ssh.Request, channel *activeChannel, connection Connection, c *Configuration) {
 	var pty *winpty.WinPTY = nil
 	var payload_return []byte = nil
 	for req := range in {
 		ok := false
 		logger.Debug(logSender,"--- req.Type: [%s] payload [%s]\n", req.Type, string(req.Payload))
 
+		if (req.Type == "subsystem" || req.Type == "exec" || req.Type == "shell") && isSessionStarted(channel) {
+			logger.Warn(logShell, "Denied %v request of user [%s], the session is already started",
+				req.Type, connection.User.Username)
+			req.Reply(false, nil)
+			continue
+		}
 		switch req.Type {
 		case "subsystem":
-			if string(req.Payload[4:]) == "sftp" {
+			if string(req.Payload[4:]) == "sftp" && connection.User.HasCapability(dataprovider.CapSFTP) {
 				ok = true
 				connection.protocol = protocolSFTP
+				setChannelType(channel, channelTypeSFTP, "")
 				go c.handleSftpConnection(channel, connection)
 			}
 		case "exec":
@@ -107,18 +114,16 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 				//fmt.Printf("------exec %s\n", name)
 				logger.Debug(logSender, "new exec command: %v args: %v user: %v, error: %v", name, execArgs,
 					connection.User.Username, err)
-				if c.IsSCPEnabled && err == nil && name == "scp" && len(execArgs) >= 2 {
+				if c.IsSCPEnabled && err == nil && name == "scp" && len(execArgs) >= 2 &&
+					connection.User.HasCapability(dataprovider.CapSCP) {
 					ok = true
 					connection.protocol = protocolSCP
-					scpCommand := scpCommand{
-						connection: connection,
-						args:       execArgs,
-						channel:    channel,
-					}
-					go scpCommand.handle()
+					setChannelType(channel, channelTypeSCP, msg.Command)
+					go c.handleSCPCommand(channel, connection, execArgs)
 				}else if err == nil {
 					// execute cmd
-					if connection.User.HasPerm(dataprovider.PermShell) {
+					if connection.User.HasCapability(dataprovider.CapExec) {
+						setChannelType(channel, channelTypeExec, msg.Command)
 						cmd := exec.Command(name, execArgs...)
 						var outbuf, errbuf bytes.Buffer
 						cmd.Stdout = &outbuf
@@ -142,7 +147,7 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 				}
 			}
 		case "pty-req":
-			if connection.User.HasPerm(dataprovider.PermShell) {
+			if connection.User.HasCapability(dataprovider.CapShell) {
 				// Responding 'ok' here will let the client
 				// know we have a pty ready for input
 				ok = true
@@ -159,6 +164,7 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 				logger.Warn(logShell, "pty not open yet!")
 				ok = false
 			} else {
+				setChannelType(channel, channelTypeShell, "")
 				ok = handleShell(req, channel, pty)
 			}
 		case "window-change":
@@ -175,4 +181,6 @@ func handleSSHRequest(in <-chan *ssh.Request, channel ssh.Channel, connection Co
 		req.Reply(ok, payload_return)
 	}
 	logger.Debug(logSender, " --request process exited...")
+	// no more requests means the channel is closed
+	channel.release()
 }
@@ -0,0 +1,175 @@
+package serv
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/vfs"
+)
+
+const (
+	// name prefix for the scheduled snapshots, only these snapshots are deleted by the retention policy
+	scheduledSnapshotPrefix = "scheduled-"
+	// time format used for the snapshot names, the same used by HDFS for the default names
+	snapshotNameTimeFormat = "20060102-150405.000"
+	// users read from the data provider for each scheduled snapshots page
+	snapshotUsersPageSize = 100
+)
+
+var (
+	// ErrSnapshotsUnsupported is returned managing the snapshots of a user not stored on HDFS
+	ErrSnapshotsUnsupported = errors.New("snapshots are only supported for users stored on HDFS")
+	// ErrInvalidSnapshotName is returned if a snapshot name is not a valid path element
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// SnapshotPolicy defines the scheduled snapshots of the users' HDFS home dirs
+type SnapshotPolicy struct {
+	// Interval in minutes between two scheduled snapshots, 0 disables the scheduled snapshots
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Number of scheduled snapshots to keep for each user, the oldest are deleted.
+	// 0 means unlimited. The snapshots created using the REST API are never deleted
+	Retention int `json:"retention" mapstructure:"retention"`
+}
+
+// GetUserSnapshots returns the snapshots of the user's HDFS root dir, the oldest first
+func GetUserSnapshots(user dataprovider.User) ([]vfs.HdfsSnapshot, error) {
+	fs, err := getUserHdfsFs(user)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	return fs.GetSnapshots(user.GetRootDir())
+}
+
+// CreateUserSnapshot creates a snapshot of the user's HDFS home dir, a name based on the current
+// time is used if name is empty. The home dir is made snapshottable if needed
+func CreateUserSnapshot(user dataprovider.User, name string) (vfs.HdfsSnapshot, error) {
+	if name == "" {
+		name = "s" + time.Now().UTC().Format(snapshotNameTimeFormat)
+	}
+	if !isValidSnapshotName(name) {
+		return vfs.HdfsSnapshot{}, ErrInvalidSnapshotName
+	}
+	fs, err := getUserHdfsFs(user)
+	if err != nil {
+		return vfs.HdfsSnapshot{}, err
+	}
+	defer fs.Close()
+	snapshot, err := fs.CreateSnapshot(user.GetRootDir(), name, true)
+	logger.Debug(logSender, "snapshot %#v created for user %#v, error: %v", name, user.Username, err)
+	return snapshot, err
+}
+
+// DeleteUserSnapshot deletes a snapshot of the user's HDFS home dir
+func DeleteUserSnapshot(user dataprovider.User, name string) error {
+	if !isValidSnapshotName(name) {
+		return ErrInvalidSnapshotName
+	}
+	fs, err := getUserHdfsFs(user)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	err = fs.DeleteSnapshot(user.GetRootDir(), name)
+	logger.Debug(logSender, "snapshot %#v deleted for user %#v, error: %v", name, user.Username, err)
+	return err
+}
+
+func getUserHdfsFs(user dataprovider.User) (*vfs.HdfsFs, error) {
+	c := serverConf
+	if c == nil {
+		c = &Configuration{}
+	}
+	fs, err := c.newFs(Connection{ID: "snapshot", User: user})
+	if err != nil {
+		return nil, err
+	}
+	hdfsFs, ok := fs.(*vfs.HdfsFs)
+	if !ok {
+		fs.Close()
+		return nil, ErrSnapshotsUnsupported
+	}
+	return hdfsFs, nil
+}
+
+func isValidSnapshotName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// startSnapshotScheduler takes a snapshot of the HDFS home dir of each user at the configured interval.
+// Only the home dirs that allow snapshots are included: HDFS superuser privileges are required to
+// allow snapshots, the REST API does it creating the first snapshot of a user
+func startSnapshotScheduler(policy SnapshotPolicy) {
+	logger.Debug(logSender, "scheduled snapshots every %v minutes, retention: %v", policy.Interval, policy.Retention)
+	go func() {
+		for range time.Tick(time.Duration(policy.Interval) * time.Minute) {
+			takeScheduledSnapshots(policy)
+		}
+	}()
+}
+
+func takeScheduledSnapshots(policy SnapshotPolicy) {
+	name := scheduledSnapshotPrefix + time.Now().UTC().Format(snapshotNameTimeFormat)
+	for offset := 0; ; offset += snapshotUsersPageSize {
+		users, err := dataprovider.GetUsers(dataProvider, snapshotUsersPageSize, offset, "ASC", "")
+		if err != nil {
+			logger.Warn(logSender, "unable to get users for scheduled snapshots: %v", err)
+			return
+		}
+		for _, user := range users {
+			takeScheduledSnapshot(user, name, policy.Retention)
+		}
+		if len(users) < snapshotUsersPageSize {
+			return
+		}
+	}
+}
+
+func takeScheduledSnapshot(user dataprovider.User, name string, retention int) {
+	fs, err := getUserHdfsFs(user)
+	if err != nil {
+		if err != ErrSnapshotsUnsupported {
+			logger.Warn(logSender, "unable to take scheduled snapshot for user %#v: %v", user.Username, err)
+		}
+		return
+	}
+	defer fs.Close()
+	homeDir := user.GetRootDir()
+	_, err = fs.CreateSnapshot(homeDir, name, false)
+	if err == vfs.ErrHdfsNotSnapshottable {
+		return
+	}
+	if err != nil {
+		logger.Warn(logSender, "unable to take scheduled snapshot for user %#v: %v", user.Username, err)
+		return
+	}
+	logger.Debug(logSender, "scheduled snapshot %#v taken for user %#v", name, user.Username)
+	snapshots, err := fs.GetSnapshots(homeDir)
+	if err != nil {
+		logger.Warn(logSender, "unable to get snapshots for user %#v: %v", user.Username, err)
+		return
+	}
+	for _, expired := range getExpiredSnapshots(snapshots, retention) {
+		err = fs.DeleteSnapshot(homeDir, expired)
+		logger.Debug(logSender, "expired snapshot %#v deleted for user %#v, error: %v", expired, user.Username, err)
+	}
+}
+
+// getExpiredSnapshots returns the names of the scheduled snapshots exceeding the retention,
+// snapshots must be sorted from the oldest
+func getExpiredSnapshots(snapshots []vfs.HdfsSnapshot, retention int) []string {
+	var scheduled []string
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.Name, scheduledSnapshotPrefix) {
+			scheduled = append(scheduled, s.Name)
+		}
+	}
+	if retention <= 0 || len(scheduled) <= retention {
+		return nil
+	}
+	return scheduled[:len(scheduled)-retention]
+}
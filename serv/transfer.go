@@ -1,11 +1,13 @@
 package serv
 
 import (
-	"os"
+	"sync"
 	"time"
 
 	"github.com/lulugyf/sshserv/dataprovider"
 	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs"
 )
 
 const (
@@ -18,10 +20,29 @@ const (
 	uploadModeAtomic
 )
 
+const (
+	uploadTempFilePrefix = ".sftpgo-upload."
+	// an atomic upload temporary file older than this and not used by an active transfer
+	// was left by a crash or by another server and it can be removed
+	staleUploadMaxAge = 24 * time.Hour
+	// how often a dir is checked for stale atomic upload temporary files
+	staleUploadsCheckInterval = time.Hour
+	// maximum number of dir entries checked for stale atomic upload temporary files at each check
+	staleUploadsMaxEntries = 10 * dirListBatchSize
+	// size of the buffer used to copy a file on the server
+	copyBufferSize = 32 * 1024
+)
+
+var (
+	staleUploadsChecks = make(map[string]time.Time)
+	staleUploadsMutex  sync.Mutex
+)
+
 // Transfer contains the transfer details for an upload or a download.
 // It implements the io Reader and Writer interface to handle files downloads and uploads
 type Transfer struct {
-	file          *os.File
+	file          vfs.File
+	fs            vfs.Fs
 	path          string
 	start         time.Time
 	bytesSent     int64
@@ -32,6 +53,7 @@ type Transfer struct {
 	lastActivity  time.Time
 	isNewFile     bool
 	protocol      string
+	transferError error
 }
 
 // ReadAt reads len(p) bytes from the File to download starting at byte offset off and updates the bytes sent.
@@ -50,18 +72,57 @@ func (t *Transfer) WriteAt(p []byte, off int64) (n int, err error) {
 	t.lastActivity = time.Now()
 	written, e := t.file.WriteAt(p, off)
 	t.bytesReceived += int64(written)
+	if e != nil && t.transferError == nil {
+		t.transferError = e
+	}
 	t.handleThrottle()
 	return written, e
 }
 
+// TransferError is called if the transfer ends with an error, for example if the client disconnects
+// before closing the file. A failed atomic upload is removed when the transfer is closed
+func (t *Transfer) TransferError(err error) {
+	if t.transferError == nil {
+		t.transferError = err
+	}
+	logger.Warn(logSender, "unexpected error for transfer, path: %v, error: %v, bytes sent: %v, bytes received: %v",
+		t.path, err, t.bytesSent, t.bytesReceived)
+}
+
+// Sync flushes the uploaded file to stable storage, it handles fsync requests
+func (t *Transfer) Sync() error {
+	t.lastActivity = time.Now()
+	syncer, ok := t.file.(vfs.Syncer)
+	if !ok {
+		return sftp.ErrSshFxOpUnsupported
+	}
+	if err := syncer.Sync(); err != nil {
+		logger.Warn(logSender, "unable to sync file %v: %v", t.file.Name(), err)
+		return sftp.ErrSshFxFailure
+	}
+	return nil
+}
+
 // Close it is called when the transfer is completed.
 // It closes the underlying file, log the transfer info, update the user quota, for uploads, and execute any defined actions.
 func (t *Transfer) Close() error {
 	err := t.file.Close()
-	if t.transferType == transferUpload && t.file.Name() != t.path {
-		err = os.Rename(t.file.Name(), t.path)
-		logger.Debug(logSender, "atomic upload completed, rename: \"%v\" -> \"%v\", error: %v",
-			t.file.Name(), t.path, err)
+	if err != nil && t.transferError == nil {
+		t.transferError = err
+	}
+	isAtomicUpload := t.transferType == transferUpload && t.file.Name() != t.path
+	if isAtomicUpload {
+		if t.transferError == nil {
+			err = t.fs.Rename(t.file.Name(), t.path)
+			logger.Debug(logSender, "atomic upload completed, rename: \"%v\" -> \"%v\", error: %v",
+				t.file.Name(), t.path, err)
+		} else {
+			// the partial file must never be visible with the requested name
+			removeErr := t.fs.Remove(t.file.Name())
+			logger.Warn(logSender, "atomic upload not completed, temporary file \"%v\" removed, upload error: %v, remove error: %v",
+				t.file.Name(), t.transferError, removeErr)
+			err = t.transferError
+		}
 	}
 	elapsed := time.Since(t.start).Nanoseconds() / 1000000
 	if t.transferType == transferDownload {
@@ -69,15 +130,24 @@ func (t *Transfer) Close() error {
 		executeAction(operationDownload, t.user.Username, t.path, "")
 	} else {
 		logger.TransferLog(uploadLogSender, t.path, elapsed, t.bytesReceived, t.user.Username, t.connectionID, t.protocol)
-		executeAction(operationUpload, t.user.Username, t.path, "")
+		if !isAtomicUpload || t.transferError == nil {
+			executeAction(operationUpload, t.user.Username, t.path, "")
+		}
 	}
 	removeTransfer(t)
 	if t.transferType == transferUpload {
-		numFiles := 0
-		if t.isNewFile {
-			numFiles = 1
+		if isAtomicUpload && t.transferError != nil {
+			// an existing file was moved to the removed temporary file
+			if !t.isNewFile {
+				updateQuota(t.fs, t.user, t.path, -1, 0)
+			}
+		} else {
+			numFiles := 0
+			if t.isNewFile {
+				numFiles = 1
+			}
+			updateQuota(t.fs, t.user, t.path, numFiles, t.bytesReceived)
 		}
-		dataprovider.UpdateUserQuota(dataProvider, t.user, numFiles, t.bytesReceived, false)
 	}
 	return err
 }
@@ -0,0 +1,343 @@
+package serv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	logTunnel                = "tunnel"
+	defaultTunnelNameSuffix  = ".tunnel"
+	defaultTunnelIdleTimeout = 300
+)
+
+var (
+	tunnelsMutex   sync.RWMutex
+	activeTunnels  map[string]*namedTunnel
+	tunnelConf     TunnelConf
+	validTunnelRgx = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	// ErrTunnelNotFound is returned if a named tunnel is not active
+	ErrTunnelNotFound = errors.New("tunnel not found")
+	// ErrTunnelActive is returned if a named tunnel cannot be released because it is active
+	ErrTunnelActive = errors.New("tunnel is active")
+	errChannelTimeout = &channelTimeoutError{}
+)
+
+// TunnelConf defines the named tunnels mode for remote port forwarding.
+// If enabled, a tcpip-forward request whose bind address ends with NameSuffix does not open a TCP port,
+// the tunnel is instead reachable through the HTTP server as /tunnel/<name>/ or, if HostDomain is set,
+// using the <name>.<HostDomain> Host header
+type TunnelConf struct {
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Suffix that identifies a tunnel name inside the bind address, default ".tunnel".
+	// For example "ssh -R myapp.tunnel:80:localhost:3000" registers the tunnel "myapp"
+	NameSuffix string `json:"name_suffix" mapstructure:"name_suffix"`
+	// If not empty requests with Host header <name>.<HostDomain> are proxied to the tunnel <name>
+	HostDomain string `json:"host_domain" mapstructure:"host_domain"`
+	// A proxied connection is closed if a read or a write is blocked for more than this number of seconds.
+	// 0 means the default (300 seconds)
+	IdleTimeout int `json:"idle_timeout" mapstructure:"idle_timeout"`
+}
+
+// TunnelStatus defines an active named tunnel
+type TunnelStatus struct {
+	// Tunnel name
+	Name string `json:"name"`
+	// Username owning the tunnel
+	Username string `json:"username"`
+	// Connection that registered the tunnel
+	ConnectionID string `json:"connection_id"`
+	// Port requested by the client
+	BindPort uint32 `json:"bind_port"`
+	// Registration time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+}
+
+type namedTunnel struct {
+	name         string
+	username     string
+	connectionID string
+	bindAddr     string
+	bindPort     uint32
+	start        time.Time
+	sshConn      ssh.Conn
+}
+
+func init() {
+	activeTunnels = make(map[string]*namedTunnel)
+}
+
+func setTunnelConf(conf TunnelConf) {
+	tunnelsMutex.Lock()
+	defer tunnelsMutex.Unlock()
+	if conf.NameSuffix == "" {
+		conf.NameSuffix = defaultTunnelNameSuffix
+	}
+	conf.HostDomain = strings.Trim(strings.ToLower(conf.HostDomain), ".")
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = defaultTunnelIdleTimeout
+	}
+	tunnelConf = conf
+}
+
+// getTunnelName returns the tunnel name for the given bind address or an empty string
+// if the bind address does not identify a named tunnel
+func getTunnelName(bindAddr string) string {
+	tunnelsMutex.RLock()
+	defer tunnelsMutex.RUnlock()
+	if !tunnelConf.Enabled {
+		return ""
+	}
+	bindAddr = strings.ToLower(bindAddr)
+	if !strings.HasSuffix(bindAddr, tunnelConf.NameSuffix) {
+		return ""
+	}
+	return strings.TrimSuffix(bindAddr, tunnelConf.NameSuffix)
+}
+
+// addTunnel registers an active tunnel. The name must be owned by the tunnel user: the first user
+// that registers a name owns it, the owner is stored in the data provider
+func addTunnel(t *namedTunnel) error {
+	if !validTunnelRgx.MatchString(t.name) {
+		return fmt.Errorf("invalid tunnel name %#v", t.name)
+	}
+	if err := dataprovider.ClaimTunnelName(dataProvider, t.name, t.username); err != nil {
+		return err
+	}
+	tunnelsMutex.Lock()
+	defer tunnelsMutex.Unlock()
+	if existing, ok := activeTunnels[t.name]; ok {
+		if existing.username != t.username {
+			return fmt.Errorf("tunnel %#v is owned by another user", t.name)
+		}
+		return fmt.Errorf("tunnel %#v is already active, connection id: %v", t.name, existing.connectionID)
+	}
+	activeTunnels[t.name] = t
+	logger.Debug(logTunnel, "tunnel %#v added for user %v, num active tunnels: %v", t.name, t.username,
+		len(activeTunnels))
+	return nil
+}
+
+func removeTunnel(name, connectionID string) bool {
+	tunnelsMutex.Lock()
+	defer tunnelsMutex.Unlock()
+	if t, ok := activeTunnels[name]; ok && t.connectionID == connectionID {
+		delete(activeTunnels, name)
+		logger.Debug(logTunnel, "tunnel %#v removed, num active tunnels: %v", name, len(activeTunnels))
+		return true
+	}
+	return false
+}
+
+// ReleaseTunnelName releases the given tunnel name, so it can be claimed by any user again.
+// An active tunnel cannot be released, its connection must be closed first
+func ReleaseTunnelName(name string) error {
+	tunnelsMutex.RLock()
+	_, ok := activeTunnels[name]
+	tunnelsMutex.RUnlock()
+	if ok {
+		return ErrTunnelActive
+	}
+	return dataprovider.ReleaseTunnelName(dataProvider, name)
+}
+
+// GetTunnels returns the active named tunnels
+func GetTunnels() []TunnelStatus {
+	tunnelsMutex.RLock()
+	defer tunnelsMutex.RUnlock()
+	tunnels := []TunnelStatus{}
+	for _, t := range activeTunnels {
+		tunnels = append(tunnels, TunnelStatus{
+			Name:         t.name,
+			Username:     t.username,
+			ConnectionID: t.connectionID,
+			BindPort:     t.bindPort,
+			StartTime:    utils.GetTimeAsMsSinceEpoch(t.start),
+		})
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+	return tunnels
+}
+
+// GetTunnelNameForHost returns the tunnel name matching the given HTTP Host header
+// or an empty string if there is no match
+func GetTunnelNameForHost(host string) string {
+	tunnelsMutex.RLock()
+	defer tunnelsMutex.RUnlock()
+	if !tunnelConf.Enabled || tunnelConf.HostDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + tunnelConf.HostDomain
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	return strings.TrimSuffix(host, suffix)
+}
+
+// DialTunnel opens a forwarded-tcpip channel to the client owning the named tunnel.
+// originAddr is the address of the peer that originated the connection, as host:port
+func DialTunnel(name string, originAddr string) (net.Conn, error) {
+	tunnelsMutex.RLock()
+	t, ok := activeTunnels[name]
+	idleTimeout := time.Duration(tunnelConf.IdleTimeout) * time.Second
+	tunnelsMutex.RUnlock()
+	if !ok {
+		return nil, ErrTunnelNotFound
+	}
+	originHost, originPortStr, err := net.SplitHostPort(originAddr)
+	if err != nil {
+		originHost, originPortStr = "127.0.0.1", "0"
+	}
+	originPort, _ := strconv.Atoi(originPortStr)
+	payload := ssh.Marshal(&remoteForwardChannelData{
+		DestAddr:   t.bindAddr,
+		DestPort:   t.bindPort,
+		OriginAddr: originHost,
+		OriginPort: uint32(originPort),
+	})
+	ch, reqs, err := t.sshConn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		logger.Warn(logTunnel, "unable to open forwarded-tcpip channel for tunnel %#v: %v", name, err)
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return newChannelConn(newActiveChannel(ch, t.connectionID, channelTypeForwardedTCPIP, originAddr+" -> "+name),
+		&tunnelAddr{name: name}, &tunnelAddr{name: originAddr}, idleTimeout), nil
+}
+
+// channelConn adapts an SSH channel to the net.Conn interface.
+// An SSH channel cannot interrupt a pending read or write, so the channel is closed if the operation
+// is still pending when its deadline, or the idle timeout, expires
+type channelConn struct {
+	ssh.Channel
+	localAddr     net.Addr
+	remoteAddr    net.Addr
+	idleTimeout   time.Duration
+	readDeadline  channelDeadline
+	writeDeadline channelDeadline
+}
+
+func newChannelConn(ch ssh.Channel, localAddr, remoteAddr net.Addr, idleTimeout time.Duration) *channelConn {
+	return &channelConn{
+		Channel:     ch,
+		localAddr:   localAddr,
+		remoteAddr:  remoteAddr,
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (c *channelConn) Read(b []byte) (int, error) {
+	return c.readDeadline.do(c.idleTimeout, c.Channel.Close, func() (int, error) {
+		return c.Channel.Read(b)
+	})
+}
+
+func (c *channelConn) Write(b []byte) (int, error) {
+	return c.writeDeadline.do(c.idleTimeout, c.Channel.Close, func() (int, error) {
+		return c.Channel.Write(b)
+	})
+}
+
+func (c *channelConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *channelConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *channelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+type channelDeadline struct {
+	sync.Mutex
+	deadline time.Time
+}
+
+func (d *channelDeadline) set(t time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.deadline = t
+}
+
+// do runs op and calls closeFn if op is still pending when the deadline or the idle timeout, whichever
+// comes first, expires. A deadline changed while op is pending applies to the next operation
+func (d *channelDeadline) do(idleTimeout time.Duration, closeFn func() error, op func() (int, error)) (int, error) {
+	d.Lock()
+	deadline := d.deadline
+	d.Unlock()
+	if idleTimeout > 0 {
+		if idleDeadline := time.Now().Add(idleTimeout); deadline.IsZero() || idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+	}
+	if deadline.IsZero() {
+		return op()
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, errChannelTimeout
+	}
+	var expired int32
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&expired, 1)
+		closeFn()
+	})
+	n, err := op()
+	timer.Stop()
+	if atomic.LoadInt32(&expired) == 1 {
+		return n, errChannelTimeout
+	}
+	return n, err
+}
+
+// channelTimeoutError implements net.Error, like the errors returned by net.Conn after a deadline
+type channelTimeoutError struct{}
+
+func (e *channelTimeoutError) Error() string   { return "i/o timeout" }
+func (e *channelTimeoutError) Timeout() bool   { return true }
+func (e *channelTimeoutError) Temporary() bool { return true }
+
+type tunnelAddr struct {
+	name string
+}
+
+func (a *tunnelAddr) Network() string {
+	return "tunnel"
+}
+
+func (a *tunnelAddr) String() string {
+	return a.name
+}
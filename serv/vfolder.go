@@ -0,0 +1,138 @@
+package serv
+
+import (
+	"sync"
+
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/vfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+// folderScan is a scan in progress of a virtual folder with its own quota,
+// the connections that need the folder usage while it runs wait for its result
+type folderScan struct {
+	done     chan struct{}
+	numFiles int
+	size     int64
+	err      error
+}
+
+var (
+	folderScans  = make(map[string]*folderScan)
+	foldersMutex sync.Mutex
+)
+
+// getFolderUsage returns the used quota for the given folder. The usage is stored by the data provider:
+// the folder is scanned the first time its quota is needed, then the usage is updated by this server
+// on uploads and deletes, the quota scan REST API can be used to rescan it.
+// Backends that report the usage of a dir tree, such as HDFS, are asked instead, so the files added
+// without using this server are counted too. Their usage is cached for dirUsageCacheTTL
+func getFolderUsage(fs vfs.Fs, folder fsconfig.VirtualFolder) (int, int64, error) {
+	if _, ok := fs.(vfs.DirUsager); ok {
+		return getCachedDirUsage(getFolderDirUsageKey(folder.MappedPath), func() (int, int64, error) {
+			numFiles, size, err := vfs.GetDirUsage(fs, folder.MappedPath)
+			if err != nil && !fs.IsNotExist(err) {
+				logger.Warn(logSender, "error getting usage for virtual folder %#v: %v", folder.MappedPath, err)
+				return 0, 0, err
+			}
+			return numFiles, size, nil
+		})
+	}
+	quota, err := dataprovider.GetFolderQuota(dataProvider, folder.MappedPath)
+	if err == nil {
+		return quota.UsedQuotaFiles, quota.UsedQuotaSize, nil
+	}
+	if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+		logger.Warn(logSender, "unable to get used quota for virtual folder %#v: %v", folder.MappedPath, err)
+		return 0, 0, err
+	}
+	return scanFolder(fs, folder)
+}
+
+// scanFolder scans the given folder and stores its usage. The scan runs without holding foldersMutex,
+// concurrent requests for the same folder wait for the running scan instead of starting a new one
+func scanFolder(fs vfs.Fs, folder fsconfig.VirtualFolder) (int, int64, error) {
+	foldersMutex.Lock()
+	if scan, ok := folderScans[folder.MappedPath]; ok {
+		foldersMutex.Unlock()
+		<-scan.done
+		return scan.numFiles, scan.size, scan.err
+	}
+	scan := &folderScan{done: make(chan struct{})}
+	folderScans[folder.MappedPath] = scan
+	foldersMutex.Unlock()
+
+	scan.numFiles, scan.size, _, scan.err = vfs.ScanDirContents(fs, folder.MappedPath)
+	if scan.err != nil && fs.IsNotExist(scan.err) {
+		scan.err = nil
+	}
+	if scan.err == nil {
+		scan.err = dataprovider.UpdateFolderQuota(dataProvider, folder.MappedPath, scan.numFiles, scan.size, true)
+	}
+	if scan.err != nil {
+		logger.Warn(logSender, "error scanning virtual folder %#v: %v", folder.MappedPath, scan.err)
+		scan.numFiles, scan.size = 0, 0
+	}
+
+	foldersMutex.Lock()
+	delete(folderScans, folder.MappedPath)
+	foldersMutex.Unlock()
+	close(scan.done)
+	return scan.numFiles, scan.size, scan.err
+}
+
+// updateFolderUsage updates the stored usage of the given folder, if the folder was never scanned
+// its usage will include this update once scanned. The usage is not stored for the backends
+// that report it themselves, their cached usage is updated instead
+func updateFolderUsage(fs vfs.Fs, folder fsconfig.VirtualFolder, filesAdd int, sizeAdd int64) {
+	if _, ok := fs.(vfs.DirUsager); ok {
+		updateCachedDirUsage(getFolderDirUsageKey(folder.MappedPath), filesAdd, sizeAdd)
+		return
+	}
+	dataprovider.UpdateFolderQuota(dataProvider, folder.MappedPath, filesAdd, sizeAdd, false)
+}
+
+// ScanUserFolders rescans the user's virtual folders with their own quota and stores their usage.
+// Nothing is stored for the backends that report the usage themselves, their cached usage is removed
+func ScanUserFolders(user dataprovider.User) error {
+	c := serverConf
+	if c == nil {
+		c = &Configuration{}
+	}
+	fs, err := c.newFs(Connection{ID: "quota_scan", User: user})
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	if _, ok := fs.(vfs.DirUsager); ok {
+		for _, folder := range user.VirtualFolders {
+			if folder.HasQuota() {
+				removeCachedDirUsage(getFolderDirUsageKey(folder.MappedPath))
+			}
+		}
+		return nil
+	}
+	for _, folder := range user.VirtualFolders {
+		if !folder.HasQuota() {
+			continue
+		}
+		if _, _, err = scanFolder(fs, folder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateQuota updates the used quota for the given backend path: the virtual folder quota
+// if the path is inside a folder with its own quota, the user quota otherwise
+func updateQuota(fs vfs.Fs, user dataprovider.User, fsPath string, filesAdd int, sizeAdd int64) {
+	if folder, ok := user.GetVirtualFolderForPath(fs.GetRelativePath(fsPath)); ok && folder.HasQuota() {
+		updateFolderUsage(fs, folder, filesAdd, sizeAdd)
+		return
+	}
+	if _, ok := fs.(vfs.DirUsager); ok {
+		updateCachedDirUsage(getUserDirUsageKey(user.Username), filesAdd, sizeAdd)
+	}
+	dataprovider.UpdateUserQuota(dataProvider, user, filesAdd, sizeAdd, false)
+}
@@ -0,0 +1,132 @@
+package serv
+
+import (
+	"strings"
+
+	"github.com/lulugyf/sshserv/dataprovider"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs"
+)
+
+// only the attributes in the user namespace can be changed, the other namespaces are reserved
+// to the system, to the superuser or to the backend
+const userXattrPrefix = "user."
+
+// ListXattrs returns the names of the extended attributes of a file,
+// it handles the xattr-list SFTP extension
+func (c Connection) ListXattrs(request *sftp.Request) ([]string, error) {
+	updateConnectionActivity(c.ID)
+
+	xattrer, p, err := c.getXattrTarget(request, false)
+	if err != nil {
+		return nil, err
+	}
+	names, err := xattrer.ListXattrs(p)
+	if err != nil {
+		logger.Warn(logSender, "unable to list xattrs for path %#v: %v", p, err)
+		return nil, c.getXattrError(err)
+	}
+	return names, nil
+}
+
+// GetXattr returns the value of an extended attribute, it handles the xattr-get SFTP extension
+func (c Connection) GetXattr(request *sftp.Request, name string) (string, error) {
+	updateConnectionActivity(c.ID)
+
+	xattrer, p, err := c.getXattrTarget(request, false)
+	if err != nil {
+		return "", err
+	}
+	value, err := xattrer.GetXattr(p, name)
+	if err != nil {
+		logger.Debug(logSender, "unable to get xattr %#v for path %#v: %v", name, p, err)
+		return "", c.getXattrError(err)
+	}
+	return value, nil
+}
+
+// SetXattr creates or replaces an extended attribute in the user namespace,
+// it handles the xattr-set SFTP extension
+func (c Connection) SetXattr(request *sftp.Request, name, value string) error {
+	updateConnectionActivity(c.ID)
+
+	if !strings.HasPrefix(name, userXattrPrefix) {
+		logger.Warn(logSender, "setting xattr %#v outside the user namespace is not allowed, user: %v", name,
+			c.User.Username)
+		return sftp.ErrSshFxPermissionDenied
+	}
+	xattrer, p, err := c.getXattrTarget(request, true)
+	if err != nil {
+		return err
+	}
+	if err = xattrer.SetXattr(p, name, value); err != nil {
+		logger.Warn(logSender, "failed to set xattr %#v for path %#v: %v", name, p, err)
+		return c.getXattrError(err)
+	}
+	logger.CommandLog(setxattrLogSender, p, name, c.User.Username, c.ID, c.protocol)
+	return nil
+}
+
+// RemoveXattr removes an extended attribute in the user namespace,
+// it handles the xattr-remove SFTP extension
+func (c Connection) RemoveXattr(request *sftp.Request, name string) error {
+	updateConnectionActivity(c.ID)
+
+	if !strings.HasPrefix(name, userXattrPrefix) {
+		logger.Warn(logSender, "removing xattr %#v outside the user namespace is not allowed, user: %v", name,
+			c.User.Username)
+		return sftp.ErrSshFxPermissionDenied
+	}
+	xattrer, p, err := c.getXattrTarget(request, true)
+	if err != nil {
+		return err
+	}
+	if err = xattrer.RemoveXattr(p, name); err != nil {
+		logger.Warn(logSender, "failed to remove xattr %#v for path %#v: %v", name, p, err)
+		return c.getXattrError(err)
+	}
+	logger.CommandLog(rmxattrLogSender, p, name, c.User.Username, c.ID, c.protocol)
+	return nil
+}
+
+// GetACL returns the ACL entries of a file, it handles the acl-get SFTP extension
+func (c Connection) GetACL(request *sftp.Request) ([]string, error) {
+	updateConnectionActivity(c.ID)
+
+	xattrer, p, err := c.getXattrTarget(request, false)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := xattrer.GetACL(p)
+	if err != nil {
+		logger.Warn(logSender, "unable to get ACL for path %#v: %v", p, err)
+		return nil, c.getXattrError(err)
+	}
+	return entries, nil
+}
+
+// getXattrTarget checks the xattrs permission for the requested path and returns the backend path.
+// The attributes can be read, but not changed, inside the read only HDFS snapshots
+func (c Connection) getXattrTarget(request *sftp.Request, write bool) (vfs.Xattrer, string, error) {
+	xattrer, ok := c.fs.(vfs.Xattrer)
+	if !ok {
+		return nil, "", sftp.ErrSshFxOpUnsupported
+	}
+	p, err := c.buildPath(request.Filepath)
+	if err != nil {
+		return nil, "", sftp.ErrSshFxNoSuchFile
+	}
+	if write && !c.hasPerm(dataprovider.PermXattrs, p) ||
+		!write && !c.User.HasPermInPath(dataprovider.PermXattrs, c.fs.GetRelativePath(p)) {
+		return nil, "", sftp.ErrSshFxPermissionDenied
+	}
+	return xattrer, p, nil
+}
+
+func (c Connection) getXattrError(err error) error {
+	if err == vfs.ErrXattrNotFound {
+		return sftp.ErrSshFxNoSuchFile
+	}
+	return c.getSetstatError(err)
+}
@@ -638,7 +638,7 @@ func (c *Client) StatVFS(path string) (*StatVFS, error) {
 
 	// the resquest failed
 	case sshFxpStatus:
-		return nil, errors.New(fxp(sshFxpStatus).String())
+		return nil, normaliseError(unmarshalStatus(id, data))
 
 	default:
 		return nil, unimplementedPacketErr(typ)
@@ -744,6 +744,202 @@ func (c *Client) PosixRename(oldname, newname string) error {
 	}
 }
 
+// CheckFile returns the hash of a remote file using the check-file-name extension.
+// algorithms is a comma separated list of hash algorithms in order of preference,
+// length 0 means up to the end of the file and blockSize 0 means a single hash for the whole range.
+// The used algorithm and the hash, the hashes of all the blocks concatenated, are returned
+func (c *Client) CheckFile(path, algorithms string, offset, length uint64, blockSize uint32) (string, []byte, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(sshFxpExtendedPacketCheckFile{
+		ID:              id,
+		ExtendedRequest: "check-file-name",
+		Path:            path,
+		HashAlgorithms:  algorithms,
+		StartOffset:     offset,
+		Length:          length,
+		BlockSize:       blockSize,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		_, data = unmarshalUint32(data)
+		algorithm, data, err := unmarshalStringSafe(data)
+		if err != nil {
+			return "", nil, err
+		}
+		return algorithm, data, nil
+	case sshFxpStatus:
+		return "", nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return "", nil, unimplementedPacketErr(typ)
+	}
+}
+
+// Limits returns the limits advertised by the server using the limits@openssh.com extension
+func (c *Client) Limits() (*Limits, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(sshFxpExtendedPacketLimits{
+		ID:              id,
+		ExtendedRequest: "limits@openssh.com",
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		var response Limits
+		err = binary.Read(bytes.NewReader(data), binary.BigEndian, &response)
+		if err != nil {
+			return nil, errors.New("can not parse reply")
+		}
+		return &response, nil
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
+// CopyFile copies a remote file on the server using the copy-file extension.
+// If overwrite is false the copy fails if target already exists
+func (c *Client) CopyFile(source, target string, overwrite bool) error {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(sshFxpExtendedPacketCopyFile{
+		ID:              id,
+		ExtendedRequest: "copy-file",
+		Source:          source,
+		Target:          target,
+		Overwrite:       overwrite,
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// CopyData copies readLength bytes, or up to the end of the file if readLength is 0,
+// from src starting at readOffset to dst starting at writeOffset using the copy-data extension.
+// The data is copied on the server, src must be open for reading and dst for writing
+func (c *Client) CopyData(src *File, readOffset, readLength uint64, dst *File, writeOffset uint64) error {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(sshFxpExtendedPacketCopyData{
+		ID:              id,
+		ExtendedRequest: "copy-data",
+		ReadHandle:      src.handle,
+		ReadOffset:      readOffset,
+		ReadLength:      readLength,
+		WriteHandle:     dst.handle,
+		WriteOffset:     writeOffset,
+	})
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// ListXattrs returns the names of the extended attributes of a remote file using the xattr-list extension
+func (c *Client) ListXattrs(path string) ([]string, error) {
+	return c.getStringList(xattrListRequest, path)
+}
+
+// GetXattr returns the value of an extended attribute of a remote file using the xattr-get extension.
+// The name includes the namespace, for example user.checksum
+func (c *Client) GetXattr(path, name string) (string, error) {
+	id := c.nextID()
+	typ, data, err := c.sendPacket(sshFxpExtendedPacketXattr{
+		ID:              id,
+		ExtendedRequest: xattrGetRequest,
+		Path:            path,
+		Name:            name,
+	})
+	if err != nil {
+		return "", err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		_, data = unmarshalUint32(data)
+		value, _, err := unmarshalStringSafe(data)
+		return value, err
+	case sshFxpStatus:
+		return "", normaliseError(unmarshalStatus(id, data))
+	default:
+		return "", unimplementedPacketErr(typ)
+	}
+}
+
+// SetXattr creates or replaces an extended attribute of a remote file using the xattr-set extension
+func (c *Client) SetXattr(path, name, value string) error {
+	return c.sendXattrCmd(sshFxpExtendedPacketXattr{
+		ExtendedRequest: xattrSetRequest,
+		Path:            path,
+		Name:            name,
+		Value:           value,
+	})
+}
+
+// RemoveXattr removes an extended attribute of a remote file using the xattr-remove extension
+func (c *Client) RemoveXattr(path, name string) error {
+	return c.sendXattrCmd(sshFxpExtendedPacketXattr{
+		ExtendedRequest: xattrRemoveRequest,
+		Path:            path,
+		Name:            name,
+	})
+}
+
+// GetACL returns the ACL entries of a remote file, in the getfacl format, using the acl-get extension
+func (c *Client) GetACL(path string) ([]string, error) {
+	return c.getStringList(aclGetRequest, path)
+}
+
+func (c *Client) sendXattrCmd(pkt sshFxpExtendedPacketXattr) error {
+	id := c.nextID()
+	pkt.ID = id
+	typ, data, err := c.sendPacket(pkt)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case sshFxpStatus:
+		return normaliseError(unmarshalStatus(id, data))
+	default:
+		return unimplementedPacketErr(typ)
+	}
+}
+
+// getStringList sends an xattr-list or acl-get request and returns the strings in the reply
+func (c *Client) getStringList(extendedRequest, path string) ([]string, error) {
+	id := c.nextID()
+	var pkt idmarshaler = sshFxpExtendedPacketXattr{ID: id, ExtendedRequest: extendedRequest, Path: path}
+	if extendedRequest == aclGetRequest {
+		pkt = sshFxpExtendedPacketACL{ID: id, ExtendedRequest: extendedRequest, Path: path}
+	}
+	typ, data, err := c.sendPacket(pkt)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case sshFxpExtendedReply:
+		_, data = unmarshalUint32(data)
+		return unmarshalStringList(data)
+	case sshFxpStatus:
+		return nil, normaliseError(unmarshalStatus(id, data))
+	default:
+		return nil, unimplementedPacketErr(typ)
+	}
+}
+
 func (c *Client) realpath(path string) (string, error) {
 	id := c.nextID()
 	typ, data, err := c.sendPacket(sshFxpRealpathPacket{
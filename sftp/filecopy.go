@@ -0,0 +1,91 @@
+package sftp
+
+import (
+	"io"
+	"os"
+)
+
+// size of the buffer used to copy data between two handles
+const copyDataBufferSize = 32768
+
+// copyData copies readLength bytes, or up to the end of the file if readLength is 0,
+// from r starting at readOffset to w starting at writeOffset
+func copyData(r io.ReaderAt, readOffset, readLength int64, w io.WriterAt, writeOffset int64) (int64, error) {
+	if readOffset < 0 || readLength < 0 || writeOffset < 0 {
+		return 0, os.ErrInvalid
+	}
+	var copied int64
+	buf := make([]byte, copyDataBufferSize)
+	for readLength == 0 || copied < readLength {
+		toRead := int64(len(buf))
+		if readLength > 0 && readLength-copied < toRead {
+			toRead = readLength - copied
+		}
+		n, err := r.ReadAt(buf[:toRead], readOffset+copied)
+		if n > 0 {
+			if _, werr := w.WriteAt(buf[:n], writeOffset+copied); werr != nil {
+				return copied, werr
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// isCopyDataOverlapping returns true if source and destination ranges overlap inside the same file
+func isCopyDataOverlapping(readOffset, readLength, writeOffset uint64) bool {
+	if readLength == 0 {
+		return writeOffset >= readOffset
+	}
+	return writeOffset < readOffset+readLength && readOffset < writeOffset+readLength
+}
+
+func (p sshFxpExtendedPacketCopyFile) respond(svr *Server) responsePacket {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !p.Overwrite {
+		flags |= os.O_EXCL
+	}
+	src, err := os.Open(p.Source)
+	if err != nil {
+		return statusFromError(p, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return statusFromError(p, err)
+	}
+	if !info.Mode().IsRegular() {
+		return statusFromError(p, ErrSSHFxFailure)
+	}
+	dst, err := os.OpenFile(p.Target, flags, info.Mode().Perm())
+	if err != nil {
+		return statusFromError(p, err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return statusFromError(p, err)
+}
+
+func (p sshFxpExtendedPacketCopyData) respond(svr *Server) responsePacket {
+	r, ok := svr.getHandle(p.ReadHandle)
+	if !ok {
+		return statusFromError(p, EBADF)
+	}
+	w, ok := svr.getHandle(p.WriteHandle)
+	if !ok {
+		return statusFromError(p, EBADF)
+	}
+	if p.ReadHandle == p.WriteHandle && isCopyDataOverlapping(p.ReadOffset, p.ReadLength, p.WriteOffset) {
+		return statusFromError(p, os.ErrInvalid)
+	}
+	_, err := copyData(r, int64(p.ReadOffset), int64(p.ReadLength), w, int64(p.WriteOffset))
+	return statusFromError(p, err)
+}
@@ -0,0 +1,75 @@
+package sftp
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+type bufferWriterAt struct {
+	buf []byte
+}
+
+func (w *bufferWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, end-int64(len(w.buf)))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func TestCopyData(t *testing.T) {
+	data := make([]byte, 3*copyDataBufferSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	r := bytes.NewReader(data)
+
+	w := &bufferWriterAt{}
+	n, err := copyData(r, 0, 0, w, 0)
+	if err != nil || n != int64(len(data)) {
+		t.Errorf("unexpected copy result: %v, %v", n, err)
+	}
+	if !bytes.Equal(w.buf, data) {
+		t.Errorf("copied data mismatch")
+	}
+
+	w = &bufferWriterAt{}
+	n, err = copyData(r, 100, copyDataBufferSize+1, w, 10)
+	if err != nil || n != copyDataBufferSize+1 {
+		t.Errorf("unexpected copy result: %v, %v", n, err)
+	}
+	if !bytes.Equal(w.buf[10:], data[100:100+copyDataBufferSize+1]) {
+		t.Errorf("copied data mismatch")
+	}
+
+	// the read length is larger than the file
+	w = &bufferWriterAt{}
+	n, err = copyData(r, int64(len(data))-5, 100, w, 0)
+	if err != nil || n != 5 {
+		t.Errorf("unexpected copy result: %v, %v", n, err)
+	}
+
+	_, err = copyData(r, -1, 0, w, 0)
+	if err != os.ErrInvalid {
+		t.Errorf("negative offset must fail, got: %v", err)
+	}
+}
+
+func TestCopyDataOverlapping(t *testing.T) {
+	var tests = []struct {
+		readOffset, readLength, writeOffset uint64
+		overlapping                         bool
+	}{
+		{0, 10, 10, false},
+		{10, 10, 0, false},
+		{0, 10, 5, true},
+		{5, 10, 0, true},
+		{0, 0, 100, true},
+		{100, 0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := isCopyDataOverlapping(tt.readOffset, tt.readLength, tt.writeOffset); got != tt.overlapping {
+			t.Errorf("unexpected overlapping result for %+v: %v", tt, got)
+		}
+	}
+}
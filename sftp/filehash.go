@@ -0,0 +1,149 @@
+package sftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// minimum block size allowed by the check-file extension
+const minHashBlockSize = 256
+
+// FileHashRequest contains the parameters of a check-file or md5-hash request
+type FileHashRequest struct {
+	// hash algorithms accepted by the client, in order of preference
+	Algorithms []string
+	// start offset of the range to hash
+	Offset int64
+	// length of the range to hash, 0 means up to the end of the file
+	Length int64
+	// if not 0 a hash is computed for each block of this size
+	BlockSize uint32
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha224":
+		return sha256.New224()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	case "crc32":
+		return crc32.NewIEEE()
+	}
+	return nil
+}
+
+// IsHashAlgorithmSupported returns true if ComputeFileHash supports the given algorithm
+func IsHashAlgorithmSupported(algorithm string) bool {
+	return newHash(algorithm) != nil
+}
+
+// ComputeFileHash hashes the requested range of a file using the first supported algorithm.
+// size is the file size. If the request has a block size the hashes of all the blocks are
+// returned concatenated
+func ComputeFileHash(r io.ReaderAt, size int64, hr FileHashRequest) (string, []byte, error) {
+	var algorithm string
+	var h hash.Hash
+	for _, a := range hr.Algorithms {
+		a = strings.TrimSpace(a)
+		if h = newHash(a); h != nil {
+			algorithm = a
+			break
+		}
+	}
+	if h == nil {
+		return "", nil, ErrSSHFxOpUnsupported
+	}
+	if hr.Offset < 0 || hr.Length < 0 || (hr.BlockSize > 0 && hr.BlockSize < minHashBlockSize) {
+		return "", nil, os.ErrInvalid
+	}
+	end := size
+	if hr.Length > 0 && hr.Offset+hr.Length < size {
+		end = hr.Offset + hr.Length
+	}
+	if hr.Offset > end {
+		end = hr.Offset
+	}
+	reader := io.NewSectionReader(r, hr.Offset, end-hr.Offset)
+	if hr.BlockSize == 0 {
+		if _, err := io.Copy(h, reader); err != nil {
+			return "", nil, err
+		}
+		return algorithm, h.Sum(nil), nil
+	}
+	var result []byte
+	for {
+		h.Reset()
+		n, err := io.CopyN(h, reader, int64(hr.BlockSize))
+		if n > 0 {
+			result = h.Sum(result)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return algorithm, result, nil
+}
+
+// openFileForHash returns the file referenced by an extended packet, by name or by handle
+func (svr *Server) openFileForHash(nameOrHandle string, isHandle bool) (*os.File, func(), error) {
+	if isHandle {
+		f, ok := svr.getHandle(nameOrHandle)
+		if !ok {
+			return nil, nil, EBADF
+		}
+		return f, func() {}, nil
+	}
+	f, err := os.Open(nameOrHandle)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+func (svr *Server) computeFileHash(nameOrHandle string, isHandle bool, hr FileHashRequest) (string, []byte, error) {
+	f, closeFile, err := svr.openFileForHash(nameOrHandle, isHandle)
+	if err != nil {
+		return "", nil, err
+	}
+	defer closeFile()
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	return ComputeFileHash(f, info.Size(), hr)
+}
+
+func (p sshFxpExtendedPacketCheckFile) respond(svr *Server) responsePacket {
+	return p.response(svr.computeFileHash(p.Path, p.isHandle(), p.hashRequest()))
+}
+
+func (p sshFxpExtendedPacketMD5Hash) respond(svr *Server) responsePacket {
+	if p.QuickCheckHash != "" {
+		_, quickHash, err := svr.computeFileHash(p.Path, p.isHandle(), p.quickCheckRequest())
+		if err != nil {
+			return statusFromError(p, err)
+		}
+		if string(quickHash) != p.QuickCheckHash {
+			return p.response("md5", nil, nil)
+		}
+	}
+	return p.response(svr.computeFileHash(p.Path, p.isHandle(), p.hashRequest()))
+}
@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheckFilePacketRoundtrip(t *testing.T) {
+	p := sshFxpExtendedPacketCheckFile{
+		ID:              7,
+		ExtendedRequest: "check-file-name",
+		Path:            "/a/file",
+		HashAlgorithms:  "sha256,md5",
+		StartOffset:     10,
+		Length:          100,
+		BlockSize:       512,
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	var ext sshFxpExtendedPacket
+	if err := ext.UnmarshalBinary(b[1:]); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	got, ok := ext.SpecificPacket.(*sshFxpExtendedPacketCheckFile)
+	if !ok {
+		t.Fatalf("unexpected packet type: %T", ext.SpecificPacket)
+	}
+	if !reflect.DeepEqual(*got, p) {
+		t.Errorf("unexpected packet: want %+v, got %+v", p, *got)
+	}
+	hr := got.hashRequest()
+	if !reflect.DeepEqual(hr.Algorithms, []string{"sha256", "md5"}) || hr.Offset != 10 || hr.Length != 100 || hr.BlockSize != 512 {
+		t.Errorf("unexpected hash request: %+v", hr)
+	}
+}
+
+func TestMD5HashPacketRoundtrip(t *testing.T) {
+	p := sshFxpExtendedPacketMD5Hash{
+		ID:              8,
+		ExtendedRequest: "md5-hash-handle",
+		Path:            "1",
+		StartOffset:     0,
+		Length:          4096,
+		QuickCheckHash:  "quick",
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	var ext sshFxpExtendedPacket
+	if err := ext.UnmarshalBinary(b[1:]); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	got, ok := ext.SpecificPacket.(*sshFxpExtendedPacketMD5Hash)
+	if !ok {
+		t.Fatalf("unexpected packet type: %T", ext.SpecificPacket)
+	}
+	if !reflect.DeepEqual(*got, p) {
+		t.Errorf("unexpected packet: want %+v, got %+v", p, *got)
+	}
+	if !got.isHandle() {
+		t.Errorf("md5-hash-handle must refer to a handle")
+	}
+}
+
+func TestComputeFileHash(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	r := bytes.NewReader(data)
+	size := int64(len(data))
+
+	algorithm, hash, err := ComputeFileHash(r, size, FileHashRequest{Algorithms: []string{"unknown", "sha256", "md5"}})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	sha := sha256.Sum256(data)
+	if algorithm != "sha256" || !bytes.Equal(hash, sha[:]) {
+		t.Errorf("unexpected sha256 hash, algorithm: %v", algorithm)
+	}
+
+	_, hash, err = ComputeFileHash(r, size, FileHashRequest{Algorithms: []string{"md5"}, Offset: 100, Length: 200})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	sum := md5.Sum(data[100:300])
+	if !bytes.Equal(hash, sum[:]) {
+		t.Errorf("unexpected md5 hash for range")
+	}
+
+	_, hash, err = ComputeFileHash(r, size, FileHashRequest{Algorithms: []string{"md5"}, BlockSize: 512})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	first := md5.Sum(data[:512])
+	second := md5.Sum(data[512:])
+	if !bytes.Equal(hash, append(first[:], second[:]...)) {
+		t.Errorf("unexpected md5 block hashes")
+	}
+
+	_, _, err = ComputeFileHash(r, size, FileHashRequest{Algorithms: []string{"md5"}, BlockSize: 100})
+	if err != os.ErrInvalid {
+		t.Errorf("block size lower than 256 must fail, got: %v", err)
+	}
+	_, _, err = ComputeFileHash(r, size, FileHashRequest{Algorithms: []string{"unknown"}})
+	if err != ErrSSHFxOpUnsupported {
+		t.Errorf("unsupported algorithm must fail, got: %v", err)
+	}
+}
@@ -70,6 +70,8 @@ func (p sshFxpRenamePacket) notReadOnly()              {}
 func (p sshFxpSymlinkPacket) notReadOnly()             {}
 func (p sshFxpExtendedPacketPosixRename) notReadOnly() {}
 func (p sshFxpExtendedPacketHardlink) notReadOnly()    {}
+func (p sshFxpExtendedPacketCopyFile) notReadOnly()    {}
+func (p sshFxpExtendedPacketCopyData) notReadOnly()    {}
 
 // some packets with ID are missing id()
 func (p sshFxpDataPacket) id() uint32   { return p.ID }
@@ -78,6 +80,7 @@ func (p sshFxpStatResponse) id() uint32 { return p.ID }
 func (p sshFxpNamePacket) id() uint32   { return p.ID }
 func (p sshFxpHandlePacket) id() uint32 { return p.ID }
 func (p StatVFS) id() uint32            { return p.ID }
+func (p Limits) id() uint32             { return p.ID }
 func (p sshFxVersionPacket) id() uint32 { return 0 }
 
 // take raw incoming packet data and build packet objects
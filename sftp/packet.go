@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -982,6 +983,22 @@ func (p *sshFxpExtendedPacket) UnmarshalBinary(b []byte) error {
 		p.SpecificPacket = &sshFxpExtendedPacketPosixRename{}
 	case "hardlink@openssh.com":
 		p.SpecificPacket = &sshFxpExtendedPacketHardlink{}
+	case "check-file-name", "check-file-handle":
+		p.SpecificPacket = &sshFxpExtendedPacketCheckFile{}
+	case "md5-hash", "md5-hash-handle":
+		p.SpecificPacket = &sshFxpExtendedPacketMD5Hash{}
+	case "copy-file":
+		p.SpecificPacket = &sshFxpExtendedPacketCopyFile{}
+	case "copy-data":
+		p.SpecificPacket = &sshFxpExtendedPacketCopyData{}
+	case "limits@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketLimits{}
+	case "fsync@openssh.com":
+		p.SpecificPacket = &sshFxpExtendedPacketFsync{}
+	case xattrListRequest, xattrGetRequest, xattrSetRequest, xattrRemoveRequest:
+		p.SpecificPacket = &sshFxpExtendedPacketXattr{}
+	case aclGetRequest:
+		p.SpecificPacket = &sshFxpExtendedPacketACL{}
 	default:
 		return errors.Wrapf(errUnknownExtendedPacket, "packet type %v", p.SpecificPacket)
 	}
@@ -1065,3 +1082,383 @@ func (p sshFxpExtendedPacketHardlink) respond(s *Server) responsePacket {
 	err := os.Link(p.Oldpath, p.Newpath)
 	return statusFromError(p, err)
 }
+
+// https://tools.ietf.org/html/draft-ietf-secsh-filexfer-extensions-00#section-3
+type sshFxpExtendedPacketCheckFile struct {
+	ID              uint32
+	ExtendedRequest string
+	// file name for check-file-name, handle for check-file-handle
+	Path           string
+	HashAlgorithms string
+	StartOffset    uint64
+	Length         uint64
+	BlockSize      uint32
+}
+
+func (p sshFxpExtendedPacketCheckFile) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketCheckFile) readonly() bool { return true }
+func (p sshFxpExtendedPacketCheckFile) isHandle() bool { return p.ExtendedRequest == "check-file-handle" }
+
+func (p sshFxpExtendedPacketCheckFile) hashRequest() FileHashRequest {
+	return FileHashRequest{
+		Algorithms: strings.Split(p.HashAlgorithms, ","),
+		Offset:     int64(p.StartOffset),
+		Length:     int64(p.Length),
+		BlockSize:  p.BlockSize,
+	}
+}
+
+func (p sshFxpExtendedPacketCheckFile) response(algorithm string, hash []byte, err error) responsePacket {
+	if err != nil {
+		return statusFromError(p, err)
+	}
+	return &sshFxpCheckFileReplyPacket{ID: p.ID, Algorithm: algorithm, Hash: hash}
+}
+
+func (p sshFxpExtendedPacketCheckFile) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.Path) +
+		4 + len(p.HashAlgorithms) +
+		8 + 8 + 4
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.Path)
+	b = marshalString(b, p.HashAlgorithms)
+	b = marshalUint64(b, p.StartOffset)
+	b = marshalUint64(b, p.Length)
+	b = marshalUint32(b, p.BlockSize)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketCheckFile) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.HashAlgorithms, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.StartOffset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.BlockSize, _, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sshFxpCheckFileReplyPacket is the check-file reply, the hashes of all the blocks are concatenated
+type sshFxpCheckFileReplyPacket struct {
+	ID        uint32
+	Algorithm string
+	Hash      []byte
+}
+
+func (p sshFxpCheckFileReplyPacket) id() uint32 { return p.ID }
+
+func (p sshFxpCheckFileReplyPacket) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 1+4+4+len(p.Algorithm)+len(p.Hash))
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Algorithm)
+	b = append(b, p.Hash...)
+	return b, nil
+}
+
+// https://tools.ietf.org/html/draft-ietf-secsh-filexfer-09#section-9.1.1
+type sshFxpExtendedPacketMD5Hash struct {
+	ID              uint32
+	ExtendedRequest string
+	// file name for md5-hash, handle for md5-hash-handle
+	Path        string
+	StartOffset uint64
+	Length      uint64
+	// if not empty the hash is returned only if the MD5 of the first 2048 bytes matches it
+	QuickCheckHash string
+}
+
+func (p sshFxpExtendedPacketMD5Hash) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketMD5Hash) readonly() bool { return true }
+func (p sshFxpExtendedPacketMD5Hash) isHandle() bool { return p.ExtendedRequest == "md5-hash-handle" }
+
+func (p sshFxpExtendedPacketMD5Hash) hashRequest() FileHashRequest {
+	return FileHashRequest{
+		Algorithms: []string{"md5"},
+		Offset:     int64(p.StartOffset),
+		Length:     int64(p.Length),
+	}
+}
+
+// quickCheckRequest returns the request to compute the quick check hash, the MD5 of the first 2048 bytes
+func (p sshFxpExtendedPacketMD5Hash) quickCheckRequest() FileHashRequest {
+	hr := p.hashRequest()
+	if hr.Length == 0 || hr.Length > 2048 {
+		hr.Length = 2048
+	}
+	return hr
+}
+
+func (p sshFxpExtendedPacketMD5Hash) response(algorithm string, hash []byte, err error) responsePacket {
+	if err != nil {
+		return statusFromError(p, err)
+	}
+	return &sshFxpMD5HashReplyPacket{ID: p.ID, Hash: hash}
+}
+
+func (p sshFxpExtendedPacketMD5Hash) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.Path) +
+		8 + 8 +
+		4 + len(p.QuickCheckHash)
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.Path)
+	b = marshalUint64(b, p.StartOffset)
+	b = marshalUint64(b, p.Length)
+	b = marshalString(b, p.QuickCheckHash)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketMD5Hash) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.StartOffset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.Length, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.QuickCheckHash, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sshFxpMD5HashReplyPacket is the md5-hash reply, the hash is empty if the quick check failed
+type sshFxpMD5HashReplyPacket struct {
+	ID   uint32
+	Hash []byte
+}
+
+func (p sshFxpMD5HashReplyPacket) id() uint32 { return p.ID }
+
+func (p sshFxpMD5HashReplyPacket) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 1+4+4+len(p.Hash))
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, string(p.Hash))
+	return b, nil
+}
+
+// sshFxpExtendedPacketCopyFile is the copy-file extended request, it copies a file on the server
+type sshFxpExtendedPacketCopyFile struct {
+	ID              uint32
+	ExtendedRequest string
+	Source          string
+	Target          string
+	Overwrite       bool
+}
+
+func (p sshFxpExtendedPacketCopyFile) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketCopyFile) readonly() bool { return false }
+
+func (p sshFxpExtendedPacketCopyFile) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.Source) +
+		4 + len(p.Target) +
+		1
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.Source)
+	b = marshalString(b, p.Target)
+	if p.Overwrite {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketCopyFile) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Source, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Target, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	if len(b) < 1 {
+		return errShortPacket
+	}
+	p.Overwrite = b[0] != 0
+	return nil
+}
+
+// sshFxpExtendedPacketCopyData is the copy-data extended request, it copies data between two open handles
+type sshFxpExtendedPacketCopyData struct {
+	ID              uint32
+	ExtendedRequest string
+	ReadHandle      string
+	ReadOffset      uint64
+	// 0 means up to the end of the file
+	ReadLength  uint64
+	WriteHandle string
+	WriteOffset uint64
+}
+
+func (p sshFxpExtendedPacketCopyData) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketCopyData) readonly() bool { return false }
+
+func (p sshFxpExtendedPacketCopyData) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.ReadHandle) +
+		8 + 8 +
+		4 + len(p.WriteHandle) +
+		8
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.ReadHandle)
+	b = marshalUint64(b, p.ReadOffset)
+	b = marshalUint64(b, p.ReadLength)
+	b = marshalString(b, p.WriteHandle)
+	b = marshalUint64(b, p.WriteOffset)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketCopyData) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ReadHandle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.ReadOffset, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.ReadLength, b, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	} else if p.WriteHandle, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.WriteOffset, _, err = unmarshalUint64Safe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sshFxpExtendedPacketLimits is the limits@openssh.com extended request, it has no arguments
+type sshFxpExtendedPacketLimits struct {
+	ID              uint32
+	ExtendedRequest string
+}
+
+func (p sshFxpExtendedPacketLimits) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketLimits) readonly() bool { return true }
+
+func (p sshFxpExtendedPacketLimits) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 1+4+4+len(p.ExtendedRequest))
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketLimits) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p sshFxpExtendedPacketLimits) respond(svr *Server) responsePacket {
+	return newLimitsReply(p.ID)
+}
+
+// Limits contains the limits advertised by the server using the limits@openssh.com extension.
+// A value of 0 means that there is no limit
+type Limits struct {
+	ID uint32
+	// maximum length of a packet, including the length field
+	PacketLength uint64
+	// maximum length of the data returned by a read request
+	ReadLength uint64
+	// maximum length of the data accepted by a write request
+	WriteLength uint64
+	// maximum number of open handles
+	OpenHandles uint64
+}
+
+func newLimitsReply(id uint32) *Limits {
+	return &Limits{
+		ID:           id,
+		PacketLength: maxMsgLength,
+		ReadLength:   uint64(maxTxPacket),
+		// room for the write packet header
+		WriteLength: maxMsgLength - 1024,
+	}
+}
+
+// MarshalBinary converts to ssh_FXP_EXTENDED_REPLY packet binary format
+func (p *Limits) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{sshFxpExtendedReply})
+	err := binary.Write(&buf, binary.BigEndian, p)
+	return buf.Bytes(), err
+}
+
+// sshFxpExtendedPacketFsync is the fsync@openssh.com extended request, it flushes an open file to stable storage
+type sshFxpExtendedPacketFsync struct {
+	ID              uint32
+	ExtendedRequest string
+	Handle          string
+}
+
+func (p sshFxpExtendedPacketFsync) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketFsync) readonly() bool { return true }
+
+func (p *sshFxpExtendedPacketFsync) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Handle, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p sshFxpExtendedPacketFsync) respond(svr *Server) responsePacket {
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p, EBADF)
+	}
+	return statusFromError(p, f.Sync())
+}
@@ -266,6 +266,170 @@ func (fs *root) PosixRename(r *Request) error {
 	return fs.rename(r.Filepath, r.Target)
 }
 
+func (fs *root) Filecopy(r *Request, overwrite bool) error {
+	if fs.mockErr != nil {
+		return fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	src, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return err
+	}
+	if src.IsDir() {
+		return os.ErrInvalid
+	}
+
+	if dst, err := fs.fetch(r.Target); err == nil && dst == src {
+		return os.ErrInvalid
+	}
+
+	flags := uint32(sshFxfWrite | sshFxfCreat | sshFxfTrunc)
+	if !overwrite {
+		flags |= sshFxfExcl
+	}
+	dst, err := fs.openfile(r.Target, flags)
+	if err != nil {
+		return err
+	}
+
+	src.mu.RLock()
+	defer src.mu.RUnlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	dst.content = append([]byte(nil), src.content...)
+	dst.modtime = time.Now()
+
+	return nil
+}
+
+func (fs *root) ListXattrs(r *Request) ([]string, error) {
+	if fs.mockErr != nil {
+		return nil, fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	names := make([]string, 0, len(file.xattrs))
+	for name := range file.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *root) GetXattr(r *Request, name string) (string, error) {
+	if fs.mockErr != nil {
+		return "", fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return "", err
+	}
+
+	file.mu.RLock()
+	defer file.mu.RUnlock()
+	value, ok := file.xattrs[name]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return value, nil
+}
+
+func (fs *root) SetXattr(r *Request, name, value string) error {
+	if fs.mockErr != nil {
+		return fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if file.xattrs == nil {
+		file.xattrs = make(map[string]string)
+	}
+	file.xattrs[name] = value
+	return nil
+}
+
+func (fs *root) RemoveXattr(r *Request, name string) error {
+	if fs.mockErr != nil {
+		return fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return err
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if _, ok := file.xattrs[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(file.xattrs, name)
+	return nil
+}
+
+// GetACL returns the minimal ACL equivalent to the file mode, the in memory files have no ACL entries
+func (fs *root) GetACL(r *Request) ([]string, error) {
+	if fs.mockErr != nil {
+		return nil, fs.mockErr
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := fs.fetch(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	perm := file.Mode().Perm()
+	return []string{
+		"user::" + rwxString(perm>>6),
+		"group::" + rwxString(perm>>3),
+		"other::" + rwxString(perm),
+	}, nil
+}
+
+func rwxString(perm os.FileMode) string {
+	b := []byte("---")
+	if perm&4 != 0 {
+		b[0] = 'r'
+	}
+	if perm&2 != 0 {
+		b[1] = 'w'
+	}
+	if perm&1 != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
 func (fs *root) mkdir(pathname string) error {
 	dir := &memFile{
 		modtime: time.Now(),
@@ -549,6 +713,7 @@ type memFile struct {
 
 	mu      sync.RWMutex
 	content []byte
+	xattrs  map[string]string
 	err     error
 }
 
@@ -641,6 +806,10 @@ func (f *memFile) Truncate(size int64) error {
 	return nil
 }
 
+func (f *memFile) Sync() error {
+	return nil
+}
+
 func (f *memFile) TransferError(err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
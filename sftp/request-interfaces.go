@@ -63,6 +63,52 @@ type PosixRenameFileCmder interface {
 	PosixRename(*Request) error
 }
 
+// FileHasher is a FileCmder that implements the Filehash method.
+// If this interface is implemented check-file-name, check-file-handle, md5-hash and
+// md5-hash-handle requests will call it, otherwise they return an unsupported error.
+// Filehash returns the used algorithm and the hash, ComputeFileHash can be used to compute it.
+// Called for Methods: Hash
+type FileHasher interface {
+	FileCmder
+	Filehash(*Request, FileHashRequest) (string, []byte, error)
+}
+
+// FileCopier is a FileCmder that implements the Filecopy method.
+// If this interface is implemented copy-file requests will call it, otherwise they
+// return an unsupported error. Request.Filepath is the source and Request.Target the destination,
+// an existing destination must be replaced only if overwrite is true.
+// copy-data requests do not need this interface: they copy data between two open handles
+// using their io.ReaderAt and io.WriterAt.
+// Called for Methods: Copy
+type FileCopier interface {
+	FileCmder
+	Filecopy(request *Request, overwrite bool) error
+}
+
+// StatVFSFileCmder is a FileCmder that implements the StatVFS method.
+// If this interface is implemented statvfs@openssh.com requests will call it,
+// otherwise they return an unsupported error.
+// Called for Methods: StatVFS
+type StatVFSFileCmder interface {
+	FileCmder
+	StatVFS(*Request) (*StatVFS, error)
+}
+
+// XattrFileCmder is a FileCmder that implements the extended attributes and ACL methods.
+// If this interface is implemented xattr-list, xattr-get, xattr-set, xattr-remove and acl-get
+// requests will call it, otherwise they return an unsupported error.
+// Attribute names include the namespace, for example user.checksum. GetACL returns the ACL
+// entries in the getfacl format, for example user:alice:rw- or default:group::r-x
+// Called for Methods: Xattr, ACL
+type XattrFileCmder interface {
+	FileCmder
+	ListXattrs(*Request) ([]string, error)
+	GetXattr(request *Request, name string) (string, error)
+	SetXattr(request *Request, name, value string) error
+	RemoveXattr(request *Request, name string) error
+	GetACL(*Request) ([]string, error)
+}
+
 // FileLister should return an object that fulfils the ListerAt interface
 // Note in cases of an error, the error text will be sent to the client.
 // Called for Methods: List, Stat, Readlink
@@ -84,10 +130,18 @@ type LstatFileLister interface {
 // copied to how many could be copied (eg. n < len(ls) below).
 // The copy() builtin is best for the copying.
 // Note in cases of an error, the error text will be sent to the client.
+// ListAt is called with increasing offsets, so the entries can be streamed from the
+// underlying directory. If the ListerAt implements io.Closer it is closed with its handle.
 type ListerAt interface {
 	ListAt([]os.FileInfo, int64) (int, error)
 }
 
+// Syncer is an optional interface that writerAt can implement to handle
+// fsync@openssh.com requests, if it is not implemented they return an unsupported error
+type Syncer interface {
+	Sync() error
+}
+
 // TransferError is an optional interface that readerAt and writerAt
 // can implement to be notified about the error causing Serve() to exit
 // with the request still open
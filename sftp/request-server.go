@@ -3,6 +3,7 @@ package sftp
 import (
 	"context"
 	"io"
+	"os"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -237,6 +238,44 @@ func (rs *RequestServer) packetWorker(
 			request := NewRequest("PosixRename", pkt.Oldpath)
 			request.Target = pkt.Newpath
 			rpkt = request.call(rs.Handlers, pkt, rs.pktMgr.alloc, orderID)
+		case *sshFxpExtendedPacketCheckFile:
+			request, ok := rs.getExtendedRequest("Hash", pkt.Path, pkt.isHandle())
+			if !ok {
+				rpkt = statusFromError(pkt, EBADF)
+			} else {
+				rpkt = pkt.response(rs.filehash(request, pkt.hashRequest()))
+			}
+		case *sshFxpExtendedPacketMD5Hash:
+			request, ok := rs.getExtendedRequest("Hash", pkt.Path, pkt.isHandle())
+			if !ok {
+				rpkt = statusFromError(pkt, EBADF)
+			} else {
+				rpkt = rs.md5hash(request, pkt)
+			}
+		case *sshFxpExtendedPacketCopyFile:
+			request := NewRequest("Copy", pkt.Source)
+			request.Target = cleanPath(pkt.Target)
+			rpkt = statusFromError(pkt, rs.filecopy(request, pkt.Overwrite))
+		case *sshFxpExtendedPacketCopyData:
+			rpkt = statusFromError(pkt, rs.copyData(pkt))
+		case *sshFxpExtendedPacketStatVFS:
+			request := NewRequest("StatVFS", pkt.Path)
+			rpkt = rs.statVFS(request, pkt)
+		case *sshFxpExtendedPacketXattr:
+			request := NewRequest("Xattr", pkt.Path)
+			rpkt = rs.xattr(request, pkt)
+		case *sshFxpExtendedPacketACL:
+			request := NewRequest("ACL", pkt.Path)
+			rpkt = rs.acl(request, pkt)
+		case *sshFxpExtendedPacketLimits:
+			rpkt = newLimitsReply(pkt.ID)
+		case *sshFxpExtendedPacketFsync:
+			request, ok := rs.getRequest(pkt.Handle)
+			if !ok {
+				rpkt = statusFromError(pkt, EBADF)
+			} else {
+				rpkt = statusFromError(pkt, request.sync())
+			}
 		case hasHandle:
 			handle := pkt.getHandle()
 			request, ok := rs.getRequest(handle)
@@ -259,6 +298,120 @@ func (rs *RequestServer) packetWorker(
 	return nil
 }
 
+// getExtendedRequest returns a new request for an extended packet that references a file by name or by handle
+func (rs *RequestServer) getExtendedRequest(method, nameOrHandle string, isHandle bool) (*Request, bool) {
+	if !isHandle {
+		return NewRequest(method, nameOrHandle), true
+	}
+	request, ok := rs.getRequest(nameOrHandle)
+	if !ok {
+		return nil, false
+	}
+	return NewRequest(method, request.Filepath), true
+}
+
+func (rs *RequestServer) filehash(request *Request, hr FileHashRequest) (string, []byte, error) {
+	hasher, ok := rs.Handlers.FileCmd.(FileHasher)
+	if !ok {
+		return "", nil, ErrSSHFxOpUnsupported
+	}
+	return hasher.Filehash(request, hr)
+}
+
+func (rs *RequestServer) md5hash(request *Request, pkt *sshFxpExtendedPacketMD5Hash) responsePacket {
+	if pkt.QuickCheckHash != "" {
+		_, quickHash, err := rs.filehash(request, pkt.quickCheckRequest())
+		if err != nil {
+			return statusFromError(pkt, err)
+		}
+		if string(quickHash) != pkt.QuickCheckHash {
+			return pkt.response("md5", nil, nil)
+		}
+	}
+	return pkt.response(rs.filehash(request, pkt.hashRequest()))
+}
+
+func (rs *RequestServer) statVFS(request *Request, pkt *sshFxpExtendedPacketStatVFS) responsePacket {
+	statVFSCmder, ok := rs.Handlers.FileCmd.(StatVFSFileCmder)
+	if !ok {
+		return statusFromError(pkt, ErrSSHFxOpUnsupported)
+	}
+	stat, err := statVFSCmder.StatVFS(request)
+	if err != nil {
+		return statusFromError(pkt, err)
+	}
+	stat.ID = pkt.ID
+	return stat
+}
+
+func (rs *RequestServer) filecopy(request *Request, overwrite bool) error {
+	copier, ok := rs.Handlers.FileCmd.(FileCopier)
+	if !ok {
+		return ErrSSHFxOpUnsupported
+	}
+	return copier.Filecopy(request, overwrite)
+}
+
+func (rs *RequestServer) xattr(request *Request, pkt *sshFxpExtendedPacketXattr) responsePacket {
+	xattrCmder, ok := rs.Handlers.FileCmd.(XattrFileCmder)
+	if !ok {
+		return statusFromError(pkt, ErrSSHFxOpUnsupported)
+	}
+	switch pkt.ExtendedRequest {
+	case xattrListRequest:
+		names, err := xattrCmder.ListXattrs(request)
+		if err != nil {
+			return statusFromError(pkt, err)
+		}
+		return &sshFxpStringListReplyPacket{ID: pkt.ID, Values: names}
+	case xattrGetRequest:
+		value, err := xattrCmder.GetXattr(request, pkt.Name)
+		if err != nil {
+			return statusFromError(pkt, err)
+		}
+		return &sshFxpXattrValueReplyPacket{ID: pkt.ID, Value: value}
+	case xattrSetRequest:
+		return statusFromError(pkt, xattrCmder.SetXattr(request, pkt.Name, pkt.Value))
+	default:
+		return statusFromError(pkt, xattrCmder.RemoveXattr(request, pkt.Name))
+	}
+}
+
+func (rs *RequestServer) acl(request *Request, pkt *sshFxpExtendedPacketACL) responsePacket {
+	xattrCmder, ok := rs.Handlers.FileCmd.(XattrFileCmder)
+	if !ok {
+		return statusFromError(pkt, ErrSSHFxOpUnsupported)
+	}
+	entries, err := xattrCmder.GetACL(request)
+	if err != nil {
+		return statusFromError(pkt, err)
+	}
+	return &sshFxpStringListReplyPacket{ID: pkt.ID, Values: entries}
+}
+
+// copyData copies data between two open handles, the data goes through the handlers' reader
+// and writer so it is accounted as a download from the source and an upload to the destination
+func (rs *RequestServer) copyData(pkt *sshFxpExtendedPacketCopyData) error {
+	readRequest, ok := rs.getRequest(pkt.ReadHandle)
+	if !ok {
+		return EBADF
+	}
+	writeRequest, ok := rs.getRequest(pkt.WriteHandle)
+	if !ok {
+		return EBADF
+	}
+	reader := readRequest.getReader()
+	writer := writeRequest.getWriter()
+	if reader == nil || writer == nil {
+		return ErrSSHFxPermissionDenied
+	}
+	if pkt.ReadHandle == pkt.WriteHandle && isCopyDataOverlapping(pkt.ReadOffset, pkt.ReadLength, pkt.WriteOffset) {
+		return os.ErrInvalid
+	}
+	_, err := copyData(reader, int64(pkt.ReadOffset), int64(pkt.ReadLength), writer, int64(pkt.WriteOffset))
+	return err
+}
+
 // clean and return name packet for file
 func cleanPacketPath(pkt *sshFxpRealpathPacket) responsePacket {
 	path := cleanPath(pkt.getPath())
@@ -416,6 +416,119 @@ func TestRequestRenameFail(t *testing.T) {
 	checkRequestServerAllocator(t, p)
 }
 
+func TestRequestCopyFile(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	_, err = putTestFile(p.cli, "/bar", "world")
+	require.NoError(t, err)
+	err = p.cli.CopyFile("/foo", "/baz", false)
+	require.NoError(t, err)
+	r := p.testHandler()
+	f, err := r.fetch("/baz")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(f.content))
+	// the target exists and overwrite is not requested
+	err = p.cli.CopyFile("/foo", "/bar", false)
+	assert.Error(t, err)
+	err = p.cli.CopyFile("/foo", "/bar", true)
+	require.NoError(t, err)
+	f, err = r.fetch("/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(f.content))
+	err = p.cli.CopyFile("/foo", "/foo", true)
+	assert.Error(t, err)
+	err = p.cli.CopyFile("/missing", "/qux", false)
+	assert.Error(t, err)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestCopyData(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	_, err := putTestFile(p.cli, "/foo", "hello world")
+	require.NoError(t, err)
+	src, err := p.cli.Open("/foo")
+	require.NoError(t, err)
+	defer src.Close()
+	dst, err := p.cli.Create("/bar")
+	require.NoError(t, err)
+	defer dst.Close()
+	err = p.cli.CopyData(src, 6, 0, dst, 0)
+	require.NoError(t, err)
+	err = p.cli.CopyData(src, 0, 5, dst, 5)
+	require.NoError(t, err)
+	r := p.testHandler()
+	f, err := r.fetch("/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "worldhello", string(f.content))
+	// the source handle is not open for writing
+	err = p.cli.CopyData(dst, 0, 0, src, 0)
+	assert.Error(t, err)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestFsync(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	w, err := p.cli.Create("/foo")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.NoError(t, w.Sync())
+	require.NoError(t, w.Close())
+	// the reader does not implement Syncer
+	r, err := p.cli.Open("/foo")
+	require.NoError(t, err)
+	assert.Error(t, r.Sync())
+	require.NoError(t, r.Close())
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestLimits(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	limits, err := p.cli.Limits()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(maxMsgLength), limits.PacketLength)
+	assert.Equal(t, uint64(maxTxPacket), limits.ReadLength)
+	assert.True(t, limits.WriteLength > limits.ReadLength)
+	assert.Equal(t, uint64(0), limits.OpenHandles)
+	// the in memory handler does not implement StatVFSFileCmder
+	_, err = p.cli.StatVFS("/")
+	assert.Error(t, err)
+	checkRequestServerAllocator(t, p)
+}
+
+func TestRequestXattrs(t *testing.T) {
+	p := clientRequestServerPair(t)
+	defer p.Close()
+	_, err := putTestFile(p.cli, "/foo", "hello")
+	require.NoError(t, err)
+	names, err := p.cli.ListXattrs("/foo")
+	require.NoError(t, err)
+	assert.Len(t, names, 0)
+	require.NoError(t, p.cli.SetXattr("/foo", "user.b", "2"))
+	require.NoError(t, p.cli.SetXattr("/foo", "user.a", ""))
+	names, err = p.cli.ListXattrs("/foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.a", "user.b"}, names)
+	value, err := p.cli.GetXattr("/foo", "user.b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+	require.NoError(t, p.cli.RemoveXattr("/foo", "user.b"))
+	_, err = p.cli.GetXattr("/foo", "user.b")
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, os.IsNotExist(p.cli.RemoveXattr("/foo", "user.b")))
+	_, err = p.cli.ListXattrs("/missing")
+	assert.True(t, os.IsNotExist(err))
+	acl, err := p.cli.GetACL("/foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user::rw-", "group::r--", "other::r--"}, acl)
+	checkRequestServerAllocator(t, p)
+}
+
 func TestRequestStat(t *testing.T) {
 	p := clientRequestServerPair(t)
 	defer p.Close()
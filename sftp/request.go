@@ -135,6 +135,34 @@ func (r *Request) getLister() ListerAt {
 	return r.state.listerAt
 }
 
+// getReader returns the reader for a handle opened for reading, nil otherwise
+func (r *Request) getReader() io.ReaderAt {
+	r.state.RLock()
+	defer r.state.RUnlock()
+	if r.state.writerReaderAt != nil {
+		return r.state.writerReaderAt
+	}
+	return r.state.readerAt
+}
+
+// getWriter returns the writer for a handle opened for writing, nil otherwise
+func (r *Request) getWriter() io.WriterAt {
+	r.state.RLock()
+	defer r.state.RUnlock()
+	if r.state.writerReaderAt != nil {
+		return r.state.writerReaderAt
+	}
+	return r.state.writerAt
+}
+
+// sync flushes the file opened for writing if the writer implements Syncer
+func (r *Request) sync() error {
+	if syncer, ok := r.getWriter().(Syncer); ok {
+		return syncer.Sync()
+	}
+	return ErrSSHFxOpUnsupported
+}
+
 // Close reader/writer if possible
 func (r *Request) close() error {
 	defer func() {
@@ -147,10 +175,16 @@ func (r *Request) close() error {
 	wr := r.state.writerAt
 	rd := r.state.readerAt
 	rw := r.state.writerReaderAt
+	la := r.state.listerAt
 	r.state.RUnlock()
 
 	var err error
 
+	// listers reading a directory handle in batches must release it
+	if c, ok := la.(io.Closer); ok {
+		err = c.Close()
+	}
+
 	// Close errors on a Writer are far more likely to be the important one.
 	// As they can be information that there was a loss of data.
 	if c, ok := wr.(io.Closer); ok {
@@ -90,6 +90,18 @@ var (
 	supportedSFTPExtensions = []sshExtensionPair{
 		{"hardlink@openssh.com", "1"},
 		{"posix-rename@openssh.com", "1"},
+		{"check-file", "1"},
+		{"md5-hash", "1"},
+		{"copy-file", "1"},
+		{"copy-data", "1"},
+		{"statvfs@openssh.com", "2"},
+		{"limits@openssh.com", "1"},
+		{"fsync@openssh.com", "1"},
+		{"xattr-list", "1"},
+		{"xattr-get", "1"},
+		{"xattr-set", "1"},
+		{"xattr-remove", "1"},
+		{"acl-get", "1"},
 	}
 	sftpExtensions = supportedSFTPExtensions
 )
@@ -0,0 +1,170 @@
+package sftp
+
+// extended requests for the extended attributes and the ACLs
+const (
+	xattrListRequest   = "xattr-list"
+	xattrGetRequest    = "xattr-get"
+	xattrSetRequest    = "xattr-set"
+	xattrRemoveRequest = "xattr-remove"
+	aclGetRequest      = "acl-get"
+)
+
+// sshFxpExtendedPacketXattr is an xattr-list, xattr-get, xattr-set or xattr-remove extended request.
+// xattr-list has only the path, xattr-get and xattr-remove have the attribute name too and
+// xattr-set has the path, the name and the value
+type sshFxpExtendedPacketXattr struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+	Name            string
+	Value           string
+}
+
+func (p sshFxpExtendedPacketXattr) id() uint32 { return p.ID }
+func (p sshFxpExtendedPacketXattr) readonly() bool {
+	return p.ExtendedRequest != xattrSetRequest && p.ExtendedRequest != xattrRemoveRequest
+}
+
+func (p sshFxpExtendedPacketXattr) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.Path) +
+		4 + len(p.Name) +
+		4 + len(p.Value)
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.Path)
+	if p.ExtendedRequest != xattrListRequest {
+		b = marshalString(b, p.Name)
+	}
+	if p.ExtendedRequest == xattrSetRequest {
+		b = marshalString(b, p.Value)
+	}
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketXattr) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	if p.ExtendedRequest == xattrListRequest {
+		return nil
+	}
+	if p.Name, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	if p.ExtendedRequest == xattrSetRequest {
+		if p.Value, _, err = unmarshalStringSafe(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the extended attributes are only available using the request server handlers
+func (p sshFxpExtendedPacketXattr) respond(svr *Server) responsePacket {
+	return statusFromError(p, ErrSSHFxOpUnsupported)
+}
+
+// sshFxpExtendedPacketACL is the acl-get extended request, it returns the ACL entries of a file
+type sshFxpExtendedPacketACL struct {
+	ID              uint32
+	ExtendedRequest string
+	Path            string
+}
+
+func (p sshFxpExtendedPacketACL) id() uint32     { return p.ID }
+func (p sshFxpExtendedPacketACL) readonly() bool { return true }
+
+func (p sshFxpExtendedPacketACL) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + // type(byte) + uint32
+		4 + len(p.ExtendedRequest) +
+		4 + len(p.Path)
+
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtended)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.ExtendedRequest)
+	b = marshalString(b, p.Path)
+	return b, nil
+}
+
+func (p *sshFxpExtendedPacketACL) UnmarshalBinary(b []byte) error {
+	var err error
+	if p.ID, b, err = unmarshalUint32Safe(b); err != nil {
+		return err
+	} else if p.ExtendedRequest, b, err = unmarshalStringSafe(b); err != nil {
+		return err
+	} else if p.Path, _, err = unmarshalStringSafe(b); err != nil {
+		return err
+	}
+	return nil
+}
+
+// the ACLs are only available using the request server handlers
+func (p sshFxpExtendedPacketACL) respond(svr *Server) responsePacket {
+	return statusFromError(p, ErrSSHFxOpUnsupported)
+}
+
+// sshFxpStringListReplyPacket is the xattr-list and acl-get reply, a count followed by the strings
+type sshFxpStringListReplyPacket struct {
+	ID     uint32
+	Values []string
+}
+
+func (p sshFxpStringListReplyPacket) id() uint32 { return p.ID }
+
+func (p sshFxpStringListReplyPacket) MarshalBinary() ([]byte, error) {
+	l := 1 + 4 + 4
+	for _, v := range p.Values {
+		l += 4 + len(v)
+	}
+	b := make([]byte, 0, l)
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalUint32(b, uint32(len(p.Values)))
+	for _, v := range p.Values {
+		b = marshalString(b, v)
+	}
+	return b, nil
+}
+
+// sshFxpXattrValueReplyPacket is the xattr-get reply
+type sshFxpXattrValueReplyPacket struct {
+	ID    uint32
+	Value string
+}
+
+func (p sshFxpXattrValueReplyPacket) id() uint32 { return p.ID }
+
+func (p sshFxpXattrValueReplyPacket) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 1+4+4+len(p.Value))
+	b = append(b, sshFxpExtendedReply)
+	b = marshalUint32(b, p.ID)
+	b = marshalString(b, p.Value)
+	return b, nil
+}
+
+func unmarshalStringList(b []byte) ([]string, error) {
+	count, b, err := unmarshalUint32Safe(b)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for i := uint32(0); i < count; i++ {
+		var v string
+		if v, b, err = unmarshalStringSafe(b); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
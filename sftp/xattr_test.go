@@ -0,0 +1,65 @@
+package sftp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestXattrPacketRoundtrip(t *testing.T) {
+	packets := []sshFxpExtendedPacketXattr{
+		{ID: 1, ExtendedRequest: xattrListRequest, Path: "/a/file"},
+		{ID: 2, ExtendedRequest: xattrGetRequest, Path: "/a/file", Name: "user.a"},
+		{ID: 3, ExtendedRequest: xattrSetRequest, Path: "/a/file", Name: "user.a", Value: "\x00value"},
+		{ID: 4, ExtendedRequest: xattrRemoveRequest, Path: "/a/file", Name: "user.a"},
+	}
+	for _, p := range packets {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal error: %v", err)
+		}
+		var ext sshFxpExtendedPacket
+		if err := ext.UnmarshalBinary(b[1:]); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		got, ok := ext.SpecificPacket.(*sshFxpExtendedPacketXattr)
+		if !ok {
+			t.Fatalf("unexpected packet type: %T", ext.SpecificPacket)
+		}
+		if !reflect.DeepEqual(*got, p) {
+			t.Errorf("unexpected packet: want %+v, got %+v", p, *got)
+		}
+		readonly := p.ExtendedRequest == xattrListRequest || p.ExtendedRequest == xattrGetRequest
+		if ext.readonly() != readonly {
+			t.Errorf("unexpected readonly value for %v", p.ExtendedRequest)
+		}
+	}
+
+	acl := sshFxpExtendedPacketACL{ID: 5, ExtendedRequest: aclGetRequest, Path: "/a/dir"}
+	b, err := acl.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	var ext sshFxpExtendedPacket
+	if err := ext.UnmarshalBinary(b[1:]); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+	if got, ok := ext.SpecificPacket.(*sshFxpExtendedPacketACL); !ok || !reflect.DeepEqual(*got, acl) {
+		t.Errorf("unexpected acl packet: %+v", ext.SpecificPacket)
+	}
+}
+
+func TestStringListReply(t *testing.T) {
+	p := sshFxpStringListReplyPacket{ID: 9, Values: []string{"user::rwx", "", "other::r--"}}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+	// skip the packet type and the id
+	values, err := unmarshalStringList(b[5:])
+	if err != nil || !reflect.DeepEqual(values, p.Values) {
+		t.Errorf("unexpected values: %v, err: %v", values, err)
+	}
+	if _, err = unmarshalStringList(b[5 : len(b)-1]); err == nil {
+		t.Errorf("a truncated reply must fail")
+	}
+}
@@ -0,0 +1,6 @@
+BEGIN;
+--
+-- Add field filesystem to user, users without filesystem use the server default storage
+--
+ALTER TABLE `users` ADD COLUMN `filesystem` longtext NULL;
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+--
+-- Add field dir_permissions to user
+--
+ALTER TABLE `users` ADD COLUMN `dir_permissions` longtext NULL;
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+--
+-- Create model Tunnel, the owner of each tunnel name
+--
+CREATE TABLE "tunnels" ("name" varchar(63) NOT NULL PRIMARY KEY, "username" varchar(255) NOT NULL, "created_at" bigint NOT NULL);
+CREATE INDEX "tunnels_username_idx" ON "tunnels" ("username");
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+--
+-- Add field max_session_duration to user
+--
+ALTER TABLE "users" ADD COLUMN "max_session_duration" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "users" ALTER COLUMN "max_session_duration" DROP DEFAULT;
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+--
+-- Add field capabilities to user and persist the capabilities mapped from the legacy permissions
+--
+ALTER TABLE "users" ADD COLUMN "capabilities" text NULL;
+UPDATE "users" SET "capabilities" = CASE
+ WHEN "permissions" LIKE '%"*"%' THEN '["sftp","scp","shell","exec","local-forward","remote-forward"]'
+ WHEN "permissions" LIKE '%"shell"%' AND "permissions" LIKE '%"tcpforward"%' THEN '["sftp","scp","shell","exec","local-forward","remote-forward"]'
+ WHEN "permissions" LIKE '%"shell"%' THEN '["sftp","scp","shell","exec"]'
+ WHEN "permissions" LIKE '%"tcpforward"%' THEN '["sftp","scp","local-forward","remote-forward"]'
+ ELSE '["sftp","scp"]' END WHERE "capabilities" IS NULL;
+--
+-- Add field member_of to user, the groups the user belongs to
+--
+ALTER TABLE "users" ADD COLUMN "member_of" text NULL;
+--
+-- Create model Group, a named set of capabilities
+--
+CREATE TABLE "user_groups" ("name" varchar(255) NOT NULL PRIMARY KEY, "capabilities" text NOT NULL);
+COMMIT;
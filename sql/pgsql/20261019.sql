@@ -0,0 +1,6 @@
+BEGIN;
+--
+-- Add field file_patterns to user
+--
+ALTER TABLE "users" ADD COLUMN "file_patterns" text NULL;
+COMMIT;
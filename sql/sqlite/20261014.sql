@@ -0,0 +1,6 @@
+BEGIN;
+--
+-- Add field max_session_duration to user
+--
+ALTER TABLE "users" ADD COLUMN "max_session_duration" bigint DEFAULT 0 NOT NULL;
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+--
+-- Add field virtual_folders to user
+--
+ALTER TABLE "users" ADD COLUMN "virtual_folders" text NULL;
+--
+-- Create model Folder, the used quota of the virtual folders with their own quota
+--
+CREATE TABLE "folders" ("mapped_path" varchar(512) NOT NULL PRIMARY KEY, "used_quota_size" bigint NOT NULL, "used_quota_files" integer NOT NULL, "last_quota_update" bigint NOT NULL);
+COMMIT;
@@ -0,0 +1,84 @@
+package fsconfig
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lulugyf/sshserv/utils"
+)
+
+// VirtualFolder maps a virtual path, as seen by SFTP/SCP users, to a path outside the user's home.
+// The mapped path belongs to the same backend as the user's home: a local directory or an HDFS path
+type VirtualFolder struct {
+	// absolute path as seen by SFTP/SCP users, for example /shared/models
+	VirtualPath string `json:"virtual_path"`
+	// absolute backend path
+	MappedPath string `json:"mapped_path"`
+	// permissions granted inside the folder, if empty the user's permissions apply
+	Permissions []string `json:"permissions,omitempty"`
+	// maximum size allowed as bytes inside the folder
+	QuotaSize int64 `json:"quota_size"`
+	// maximum number of files allowed inside the folder.
+	// If both quota_size and quota_files are 0 the folder files count against the user's quota
+	QuotaFiles int `json:"quota_files"`
+}
+
+// HasQuota returns true if the folder has its own quota
+func (v *VirtualFolder) HasQuota() bool {
+	return v.QuotaSize > 0 || v.QuotaFiles > 0
+}
+
+// IsVirtualPathInside returns true if sftpPath is the virtual path itself or a path inside it
+func IsVirtualPathInside(sftpPath, virtualPath string) bool {
+	if virtualPath == "/" {
+		return true
+	}
+	return sftpPath == virtualPath || strings.HasPrefix(sftpPath, virtualPath+"/")
+}
+
+// GetVirtualFolderForPath returns the virtual folder that contains the given SFTP path, if any
+func GetVirtualFolderForPath(folders []VirtualFolder, sftpPath string) (VirtualFolder, bool) {
+	sftpPath = CleanSFTPPath(sftpPath)
+	for _, v := range folders {
+		if IsVirtualPathInside(sftpPath, v.VirtualPath) {
+			return v, true
+		}
+	}
+	return VirtualFolder{}, false
+}
+
+// IsVirtualFolderParent returns true if sftpPath is a virtual folder or one of its parent dirs.
+// These paths cannot be renamed or removed
+func IsVirtualFolderParent(folders []VirtualFolder, sftpPath string) bool {
+	sftpPath = CleanSFTPPath(sftpPath)
+	for _, v := range folders {
+		if IsVirtualPathInside(v.VirtualPath, sftpPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetVirtualDirsForPath returns the names of the virtual dirs to show listing the given SFTP path:
+// the virtual folders directly inside the path and the intermediate dirs leading to nested ones
+func GetVirtualDirsForPath(folders []VirtualFolder, sftpPath string) []string {
+	var result []string
+	sftpPath = CleanSFTPPath(sftpPath)
+	for _, v := range folders {
+		if v.VirtualPath == sftpPath || !IsVirtualPathInside(v.VirtualPath, sftpPath) {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(v.VirtualPath, sftpPath), "/")
+		name = strings.Split(name, "/")[0]
+		if !utils.IsStringInSlice(name, result) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// CleanSFTPPath returns the shortest absolute SFTP path equivalent to the given one
+func CleanSFTPPath(sftpPath string) string {
+	return path.Clean("/" + filepath.ToSlash(sftpPath))
+}
@@ -0,0 +1,29 @@
+package fsconfig
+
+import "testing"
+
+func TestVirtualFolders(t *testing.T) {
+	folders := []VirtualFolder{
+		{VirtualPath: "/shared/vdir", MappedPath: "/data/mapped"},
+		{VirtualPath: "/vdir2", MappedPath: "/data/mapped2"},
+	}
+	folder, ok := GetVirtualFolderForPath(folders, "shared/vdir/../vdir/file")
+	if !ok || folder.MappedPath != "/data/mapped" {
+		t.Errorf("unexpected virtual folder: %+v, found: %v", folder, ok)
+	}
+	if _, ok = GetVirtualFolderForPath(folders, "/shared/vdirfile"); ok {
+		t.Errorf("a path with the virtual folder as prefix must not be inside it")
+	}
+	if !IsVirtualFolderParent(folders, "/shared") || !IsVirtualFolderParent(folders, "/") ||
+		IsVirtualFolderParent(folders, "/shared/vdir/sub") {
+		t.Errorf("unexpected virtual folder parent check")
+	}
+	dirs := GetVirtualDirsForPath(folders, "/")
+	if len(dirs) != 2 || dirs[0] != "shared" || dirs[1] != "vdir2" {
+		t.Errorf("unexpected virtual dirs for root: %v", dirs)
+	}
+	dirs = GetVirtualDirsForPath(folders, "/shared/vdir")
+	if len(dirs) != 0 {
+		t.Errorf("unexpected virtual dirs inside a virtual folder: %v", dirs)
+	}
+}
@@ -0,0 +1,41 @@
+// Package fsconfig defines the filesystem settings shared by the data provider and the
+// filesystem backends: the HDFS configuration and the virtual folders of the users.
+// It does not depend on any backend, so storing users does not pull in the backend clients.
+package fsconfig
+
+// HdfsFsConfig defines the configuration for the HDFS backend
+type HdfsFsConfig struct {
+	// Comma separated namenode addresses or the path of a Hadoop configuration dir
+	Namenodes string `json:"namenodes,omitempty"`
+	// The user used to access HDFS
+	User string `json:"user,omitempty"`
+	// The user that authenticates and accesses HDFS on behalf of User, using the Hadoop proxy
+	// user mechanism (doAs). With Kerberos the principal is the real user and this field is ignored
+	RealUser string `json:"real_user,omitempty"`
+	// Kerberos credentials, required for kerberized clusters. It is always serialized, so
+	// a null value removes the credentials updating a user
+	Kerberos *HdfsKerberosConfig `json:"kerberos"`
+	// Host name to address mapping, used to reach namenodes and datanodes by address
+	Hosts map[string]string `json:"hosts,omitempty"`
+	// HDFS path used as the user's root. If empty the user's home dir is used
+	BasePath string `json:"base_path,omitempty"`
+}
+
+// HdfsKerberosConfig defines the Kerberos credentials used to access a kerberized HDFS cluster
+type HdfsKerberosConfig struct {
+	// Principal used to authenticate, for example sftp/host.example.com@EXAMPLE.COM.
+	// If the realm is omitted the default realm in the Kerberos configuration is used
+	Principal string `json:"principal,omitempty" mapstructure:"principal"`
+	// Path of the keytab with the principal's keys
+	Keytab string `json:"keytab,omitempty" mapstructure:"keytab"`
+	// Path of the Kerberos configuration, /etc/krb5.conf if empty
+	Krb5Conf string `json:"krb5_conf,omitempty" mapstructure:"krb5_conf"`
+	// Service principal of the namenodes, for example nn/_HOST@EXAMPLE.COM. If empty
+	// dfs.namenode.kerberos.principal is read from the Hadoop configuration
+	NamenodePrincipal string `json:"namenode_principal,omitempty" mapstructure:"namenode_principal"`
+}
+
+// IsEnabled returns true if Kerberos credentials are configured
+func (c *HdfsKerberosConfig) IsEnabled() bool {
+	return c != nil && c.Principal != ""
+}
@@ -0,0 +1,746 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lulugyf/sshserv/hdfs"
+	"github.com/lulugyf/sshserv/hdfs/hadoopconf"
+	"github.com/lulugyf/sshserv/hdfs/intrnl/protocol/hadoop_hdfs"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+const (
+	hdfsFsName = "hdfsfs"
+	// HdfsChecksumAlgorithm is the check-file algorithm name for the HDFS MD5MD5CRC32C checksum,
+	// it is the value printed by "hadoop fs -checksum" and not a hash of the file contents
+	HdfsChecksumAlgorithm = "md5md5crc32c@hadoop.apache.org"
+)
+
+const (
+	// maximum number of symlinks followed resolving a path, the same limit used by Linux
+	hdfsMaxSymlinks = 40
+	// maximum size of the writes received ahead of the current file offset and kept
+	// in memory until the missing data arrives
+	hdfsMaxReorderBuffer = 8 * 1024 * 1024
+)
+
+var (
+	// ErrHdfsRandomWrite is returned writing before the current offset of an HDFS file,
+	// or too far ahead of it. HDFS files can only be written sequentially
+	ErrHdfsRandomWrite = errors.New("random writes are not supported for HDFS files, files must be written sequentially")
+	// ErrHdfsIncompleteWrite is returned closing an HDFS file if some data received out of order
+	// was never written because the data before it is missing
+	ErrHdfsIncompleteWrite = errors.New("incomplete HDFS file write, some data is missing")
+)
+
+// HdfsClient defines the HDFS client methods used by HdfsFs. The clients created for a
+// cluster wrap *hdfs.Client, other implementations can be used with NewHdfsFsWithClient
+type HdfsClient interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Open(name string) (HdfsFileReader, error)
+	Create(name string) (HdfsFileWriter, error)
+	Append(name string) (HdfsFileWriter, error)
+	Rename(oldpath, newpath string) error
+	CreateSymlink(target, link string, createParent bool) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Mkdir(dirname string, perm os.FileMode) error
+	Chmod(name string, perm os.FileMode) error
+	Chown(name string, user, group string) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	Walk(root string, walkFn filepath.WalkFunc) error
+	StatFs() (hdfs.FsInfo, error)
+	GetContentSummary(name string) (HdfsContentSummary, error)
+	SetQuota(dir string, nameQuota, spaceQuota int64) error
+	DefaultReplication() (int, error)
+	AllowSnapshots(dir string) error
+	CreateSnapshot(dir, name string) (string, error)
+	DeleteSnapshot(dir, name string) error
+	ListXAttrs(name string) (map[string]string, error)
+	GetXAttrs(name string, keys ...string) (map[string]string, error)
+	SetXAttr(name, key, value string) error
+	RemoveXAttr(name, key string) error
+	GetAclStatus(name string) (*hdfs.AclStatus, error)
+	Close() error
+}
+
+// HdfsFileReader defines the methods used to read HDFS files and dirs, *hdfs.FileReader implements it
+type HdfsFileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Stat() os.FileInfo
+	Readdir(n int) ([]os.FileInfo, error)
+	Checksum() ([]byte, error)
+}
+
+// HdfsFileWriter defines the methods used to write HDFS files, *hdfs.FileWriter implements it
+type HdfsFileWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// HdfsContentSummary defines the dir usage reported by the namenode, *hdfs.ContentSummary implements it
+type HdfsContentSummary interface {
+	Size() int64
+	FileCount() int
+	DirectoryCount() int
+}
+
+// hdfsClientWrapper adapts *hdfs.Client to HdfsClient
+type hdfsClientWrapper struct {
+	*hdfs.Client
+	// Kerberos credentials used by the client, nil if Kerberos is not used
+	kerberos *fsconfig.HdfsKerberosConfig
+}
+
+// Close closes the client and releases the Kerberos client it uses, if any
+func (c hdfsClientWrapper) Close() error {
+	err := c.Client.Close()
+	if c.kerberos != nil {
+		releaseHdfsKerberosClient(*c.kerberos)
+	}
+	return err
+}
+
+func (c hdfsClientWrapper) Open(name string) (HdfsFileReader, error) {
+	r, err := c.Client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c hdfsClientWrapper) Create(name string) (HdfsFileWriter, error) {
+	w, err := c.Client.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c hdfsClientWrapper) Append(name string) (HdfsFileWriter, error) {
+	w, err := c.Client.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c hdfsClientWrapper) GetContentSummary(name string) (HdfsContentSummary, error) {
+	cs, err := c.Client.GetContentSummary(name)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// HdfsFs is a Fs implementation for the Hadoop Distributed File System.
+type HdfsFs struct {
+	connectionID   string
+	rootDir        string
+	virtualFolders []fsconfig.VirtualFolder
+	config         fsconfig.HdfsFsConfig
+	client         HdfsClient
+	pooled         *pooledHdfsClient
+}
+
+// NewHdfsFs returns an HdfsFs object that allows to interact with an HDFS cluster,
+// rootDir is the user's home dir, it is used as HDFS root path if the config has no base path.
+// virtualFolders are mapped to HDFS paths outside the root path
+func NewHdfsFs(connectionID, rootDir string, virtualFolders []fsconfig.VirtualFolder,
+	config fsconfig.HdfsFsConfig) (Fs, error) {
+	if config.BasePath != "" {
+		rootDir = config.BasePath
+	}
+	fs := &HdfsFs{
+		connectionID:   connectionID,
+		rootDir:        path.Clean(filepath.ToSlash(rootDir)),
+		virtualFolders: virtualFolders,
+		config:         config,
+	}
+	if err := fs.connect(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// NewHdfsFsWithClient returns an HdfsFs object that uses the given client instead of a pooled
+// client for a cluster, the client is not closed by the returned Fs
+func NewHdfsFsWithClient(connectionID, rootDir string, virtualFolders []fsconfig.VirtualFolder,
+	client HdfsClient) Fs {
+	return &HdfsFs{
+		connectionID:   connectionID,
+		rootDir:        path.Clean(filepath.ToSlash(rootDir)),
+		virtualFolders: virtualFolders,
+		client:         client,
+	}
+}
+
+func (fs *HdfsFs) connect() error {
+	pc, err := hdfsPool.get(fs.config)
+	if err != nil {
+		return err
+	}
+	fs.pooled = pc
+	fs.client = pc.client
+	return nil
+}
+
+// newHdfsClient creates a client for the cluster and user in the given config, the namenodes
+// and the Kerberos and data transfer settings are read from the Hadoop configuration if
+// config.Namenodes is a dir
+func newHdfsClient(config fsconfig.HdfsFsConfig) (HdfsClient, error) {
+	var options hdfs.ClientOptions
+	if _, err := os.Stat(config.Namenodes); os.IsNotExist(err) {
+		// not a file, presume it is a namenode string
+		options.Addresses = strings.Split(config.Namenodes, ",")
+	} else {
+		hadoopConf, err := hadoopconf.Load(config.Namenodes)
+		if err != nil {
+			return nil, fmt.Errorf("can not load hadoop conf: %v", err)
+		}
+		options = hdfs.ClientOptionsFromConf(hadoopConf)
+		options.Addresses = resolveVar(hadoopConf.Namenodes(), hadoopConf)
+	}
+	options.User = config.User
+	options.RealUser = config.RealUser
+	var kerberos *fsconfig.HdfsKerberosConfig
+	if config.Kerberos.IsEnabled() {
+		client, err := getHdfsKerberosClient(*config.Kerberos)
+		if err != nil {
+			return nil, err
+		}
+		kerberos = config.Kerberos
+		options.KerberosClient = client
+		if config.Kerberos.NamenodePrincipal != "" {
+			options.KerberosServicePrincipleName = strings.Split(config.Kerberos.NamenodePrincipal, "@")[0]
+		}
+	}
+	dial := getHdfsDialFunc(config.Hosts)
+	options.NamenodeDialFunc = dial
+	options.DatanodeDialFunc = dial
+	client, err := hdfs.NewClient(options)
+	if err != nil {
+		if kerberos != nil {
+			releaseHdfsKerberosClient(*kerberos)
+		}
+		return nil, err
+	}
+	return hdfsClientWrapper{Client: client, kerberos: kerberos}, nil
+}
+
+// getHdfsDialFunc returns a dial function that resolves host names using the given hosts mapping
+func getHdfsDialFunc(hosts map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if len(addr) > 0 && (addr[0] > '9' || addr[0] < '0') {
+			p := strings.LastIndex(addr, ":")
+			if host, ok := hosts[addr[:p]]; ok {
+				addr = fmt.Sprintf("%s:%s", host, addr[p+1:])
+			}
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+}
+
+func _resolveVar(v string, conf map[string]string) string {
+	for strings.Index(v, "${") >= 0 && strings.Index(v, "}") > strings.Index(v, "${") {
+		vname := v[strings.Index(v, "${")+2 : strings.Index(v, "}")]
+		v = strings.ReplaceAll(v, fmt.Sprintf("${%s}", vname), conf[vname])
+	}
+	return v
+}
+
+func resolveVar(namenodes []string, conf map[string]string) []string {
+	r := make([]string, len(namenodes))
+	for i, v := range namenodes {
+		r[i] = _resolveVar(v, conf)
+	}
+	return r
+}
+
+// Name returns the name for the Fs implementation
+func (fs *HdfsFs) Name() string {
+	return hdfsFsName
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs *HdfsFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *HdfsFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := fs.client.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return newHdfsFileInfo(fi), nil
+}
+
+// Lstat returns a FileInfo describing the named file, if the file is a symlink
+// the returned FileInfo describes the symlink
+func (fs *HdfsFs) Lstat(name string) (os.FileInfo, error) {
+	fi, err := fs.client.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return newHdfsFileInfo(fi), nil
+}
+
+// Open opens the named file for reading
+func (fs *HdfsFs) Open(name string) (File, error) {
+	r, err := fs.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &hdfsFile{name: name, reader: r}, nil
+}
+
+// Create creates the named file for writing. HDFS files can only be written sequentially,
+// if flag is 0 or contains os.O_TRUNC an existing file is removed before creating the new one,
+// otherwise an existing file is opened for append and the writes must start at its size
+func (fs *HdfsFs) Create(name string, flag int) (File, error) {
+	if flag != 0 && flag&os.O_TRUNC == 0 {
+		fi, err := fs.client.Stat(name)
+		if err == nil {
+			w, err := fs.client.Append(name)
+			if err != nil {
+				return nil, err
+			}
+			return newHdfsWriteFile(name, w, fi.Size()), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if err := fs.client.Remove(name); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	w, err := fs.client.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return newHdfsWriteFile(name, w, 0), nil
+}
+
+// Rename renames (moves) source to target
+func (fs *HdfsFs) Rename(source, target string) error {
+	return fs.client.Rename(source, target)
+}
+
+// Remove removes the named file or (empty) directory
+func (fs *HdfsFs) Remove(name string) error {
+	return fs.client.Remove(name)
+}
+
+// RemoveAll removes name and any children it contains
+func (fs *HdfsFs) RemoveAll(name string) error {
+	return fs.client.RemoveAll(name)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *HdfsFs) Mkdir(name string) error {
+	return fs.client.Mkdir(name, 0755)
+}
+
+// Symlink creates target as a symbolic link to source, symlinks are disabled
+// on most HDFS clusters and in this case an error is returned
+func (fs *HdfsFs) Symlink(source, target string) error {
+	err := fs.client.CreateSymlink(source, target, false)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENOTSUP {
+		return ErrVfsUnsupported
+	}
+	return err
+}
+
+// Readlink returns the destination of the named symbolic link
+func (fs *HdfsFs) Readlink(name string) (string, error) {
+	fi, err := fs.client.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return getHdfsSymlinkTarget(fi), nil
+}
+
+// Link is not supported for HDFS, it has no hard links
+func (fs *HdfsFs) Link(source, target string) error {
+	return ErrVfsUnsupported
+}
+
+// Chown changes the owner and group of the named file. HDFS owners are names and not numeric ids,
+// the names of the local accounts with the given uid and gid are used
+func (fs *HdfsFs) Chown(name string, uid int, gid int) error {
+	owner, group, err := getHdfsOwnerNames(uid, gid)
+	if err != nil {
+		return fmt.Errorf("unable to map uid %v and gid %v to HDFS owner and group: %v", uid, gid, err)
+	}
+	return fs.client.Chown(name, owner, group)
+}
+
+// Chmod changes the mode of the named file to mode
+func (fs *HdfsFs) Chmod(name string, mode os.FileMode) error {
+	return fs.client.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file
+func (fs *HdfsFs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.client.Chtimes(name, atime, mtime)
+}
+
+// ReadDir reads the directory named by dirname and returns a list of directory entries
+func (fs *HdfsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	files, err := fs.client.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(files))
+	for _, fi := range files {
+		result = append(result, newHdfsFileInfo(fi))
+	}
+	return result, nil
+}
+
+// OpenDir opens the named directory, the entries are read using the namenode listing cursor
+func (fs *HdfsFs) OpenDir(dirname string) (DirLister, error) {
+	r, err := fs.client.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &hdfsDirLister{reader: r}, nil
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or directory
+func (fs *HdfsFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.client.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if info != nil {
+			info = newHdfsFileInfo(info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// Statvfs returns the capacity of the whole HDFS cluster
+func (fs *HdfsFs) Statvfs(name string) (*sftp.StatVFS, error) {
+	fsInfo, err := fs.client.StatFs()
+	if err != nil {
+		return nil, err
+	}
+	const blockSize = 4096
+	return &sftp.StatVFS{
+		Bsize:   blockSize,
+		Frsize:  blockSize,
+		Blocks:  fsInfo.Capacity / blockSize,
+		Bfree:   fsInfo.Remaining / blockSize,
+		Bavail:  fsInfo.Remaining / blockSize,
+		Namemax: 255,
+	}, nil
+}
+
+// ChecksumAlgorithm returns the name of the algorithm used by Checksum
+func (fs *HdfsFs) ChecksumAlgorithm() string {
+	return HdfsChecksumAlgorithm
+}
+
+// Checksum returns the HDFS checksum for the named file, it is computed by the datanodes
+// from the stored block CRCs so the file contents are not transferred
+func (fs *HdfsFs) Checksum(name string) ([]byte, error) {
+	r, err := fs.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.Checksum()
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *HdfsFs) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *HdfsFs) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+// Close releases the client to the pool, it is closed by the pool when it is no longer used
+func (fs *HdfsFs) Close() error {
+	if fs.pooled != nil {
+		hdfsPool.put(fs.pooled)
+		fs.pooled = nil
+	}
+	fs.client = nil
+	return nil
+}
+
+// GetRelativePath returns the path for a file relative to the user's root or virtual folder.
+// This is the path as seen by SFTP users
+func (fs *HdfsFs) GetRelativePath(name string) string {
+	return getVirtualRelativePath(name, fs.rootDir, fs.virtualFolders)
+}
+
+// ResolvePath returns the HDFS path for the given SFTP path, the SFTP path is
+// cleaned as an absolute path first so it cannot escape from the user's root
+// or from the virtual folder that contains it. The symlinks inside the path are
+// followed too and an error is returned if any of them points outside.
+// The returned path has the symlinks resolved, so a symlink changed after the
+// check cannot be followed outside
+func (fs *HdfsFs) ResolvePath(sftpPath string) (string, error) {
+	sftpPath = fsconfig.CleanSFTPPath(sftpPath)
+	baseDir := fs.rootDir
+	if folder, ok := fsconfig.GetVirtualFolderForPath(fs.virtualFolders, sftpPath); ok {
+		baseDir = path.Clean(folder.MappedPath)
+		sftpPath = "/" + strings.TrimPrefix(strings.TrimPrefix(sftpPath, folder.VirtualPath), "/")
+	}
+	r, err := fs.resolveSymlinks(path.Join(baseDir, sftpPath), baseDir)
+	if err != nil {
+		logger.Warn(logSender, "invalid path resolution for %#v, base dir: %v, err: %v", sftpPath, baseDir, err)
+		return "", err
+	}
+	return r, nil
+}
+
+// resolveSymlinks follows the symlinks inside name, a path inside baseDir, and returns the
+// resolved path. An error is returned if a symlink points outside baseDir.
+// The path components after the first missing one are returned as they are
+func (fs *HdfsFs) resolveSymlinks(name, baseDir string) (string, error) {
+	resolved := baseDir
+	remaining := splitHdfsPath(strings.TrimPrefix(name, baseDir))
+	links := 0
+	for len(remaining) > 0 {
+		next := path.Join(resolved, remaining[0])
+		remaining = remaining[1:]
+		fi, err := fs.client.Lstat(next)
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return path.Join(append([]string{next}, remaining...)...), nil
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > hdfsMaxSymlinks {
+			return "", fmt.Errorf("too many symlinks resolving %v", name)
+		}
+		target := getHdfsSymlinkTarget(fi)
+		if !path.IsAbs(target) {
+			target = path.Join(resolved, target)
+		}
+		target = path.Clean(target)
+		if !fsconfig.IsVirtualPathInside(target, baseDir) {
+			return "", fmt.Errorf("symlink %v points outside %v: %v", next, baseDir, target)
+		}
+		resolved = baseDir
+		remaining = append(splitHdfsPath(strings.TrimPrefix(target, baseDir)), remaining...)
+	}
+	return resolved, nil
+}
+
+// splitHdfsPath returns the non empty components of the given path
+func splitHdfsPath(name string) []string {
+	var result []string
+	for _, c := range strings.Split(name, "/") {
+		if c != "" {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// getHdfsSymlinkTarget returns the path the given symlink points to,
+// a target with a scheme such as hdfs://namenode/path is reduced to its path
+func getHdfsSymlinkTarget(fi os.FileInfo) string {
+	var target string
+	if f, ok := fi.(*hdfsFileInfo); ok {
+		fi = f.FileInfo
+	}
+	if l, ok := fi.(interface{ SymlinkTarget() string }); ok {
+		target = l.SymlinkTarget()
+	}
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" {
+		target = u.Path
+	}
+	return target
+}
+
+// hdfsFile wraps an HDFS reader or writer so it can be used as a File.
+// SFTP clients can send pipelined writes out of order, the writes ahead of the current
+// offset are kept in memory, up to hdfsMaxReorderBuffer bytes, until they can be appended
+type hdfsFile struct {
+	sync.Mutex
+	name        string
+	reader      HdfsFileReader
+	writer      io.WriteCloser
+	offset      int64
+	pending     map[int64][]byte
+	pendingSize int
+}
+
+func newHdfsWriteFile(name string, writer io.WriteCloser, offset int64) *hdfsFile {
+	return &hdfsFile{
+		name:    name,
+		writer:  writer,
+		offset:  offset,
+		pending: make(map[int64][]byte),
+	}
+}
+
+func (f *hdfsFile) Name() string {
+	return f.name
+}
+
+func (f *hdfsFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.reader == nil {
+		return 0, errors.New("file not open for reading")
+	}
+	if off >= f.reader.Stat().Size() {
+		return 0, io.EOF
+	}
+	n, err := f.reader.ReadAt(p, off)
+	if err == io.EOF && n > 0 {
+		return n, nil
+	}
+	return n, err
+}
+
+// WriteAt appends p to the file if off is the current offset, HDFS files can only be written
+// sequentially. A write ahead of the current offset is buffered until the data before it is received
+func (f *hdfsFile) WriteAt(p []byte, off int64) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.writer == nil {
+		return 0, errors.New("file not open for writing")
+	}
+	if off < f.offset {
+		return 0, ErrHdfsRandomWrite
+	}
+	if off > f.offset {
+		if _, ok := f.pending[off]; ok || f.pendingSize+len(p) > hdfsMaxReorderBuffer {
+			return 0, ErrHdfsRandomWrite
+		}
+		buf := make([]byte, len(p))
+		copy(buf, p)
+		f.pending[off] = buf
+		f.pendingSize += len(buf)
+		return len(p), nil
+	}
+	n, err := f.writer.Write(p)
+	f.offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+	for {
+		buf, ok := f.pending[f.offset]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.offset)
+		f.pendingSize -= len(buf)
+		written, err := f.writer.Write(buf)
+		f.offset += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Sync flushes the written data to the datanodes, so it is visible to new readers
+func (f *hdfsFile) Sync() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if w, ok := f.writer.(interface{ Flush() error }); ok {
+		return w.Flush()
+	}
+	return nil
+}
+
+func (f *hdfsFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	var err error
+	if f.reader != nil {
+		err = f.reader.Close()
+		f.reader = nil
+	}
+	if f.writer != nil {
+		err = f.writer.Close()
+		f.writer = nil
+		if err == nil && len(f.pending) > 0 {
+			err = ErrHdfsIncompleteWrite
+		}
+		f.pending = nil
+	}
+	return err
+}
+
+// hdfsDirLister reads a directory in batches, the entries are converted to hdfsFileInfo
+type hdfsDirLister struct {
+	reader HdfsFileReader
+}
+
+func (l *hdfsDirLister) Readdir(n int) ([]os.FileInfo, error) {
+	files, err := l.reader.Readdir(n)
+	result := make([]os.FileInfo, 0, len(files))
+	for _, fi := range files {
+		result = append(result, newHdfsFileInfo(fi))
+	}
+	return result, err
+}
+
+func (l *hdfsDirLister) Close() error {
+	return l.reader.Close()
+}
+
+// hdfsFileInfo converts the HDFS file status, so ls can show owner and group properly.
+// The ls -l format is built in sftp/server_unix.go: runLs()
+type hdfsFileInfo struct {
+	os.FileInfo
+}
+
+func newHdfsFileInfo(fi os.FileInfo) os.FileInfo {
+	return &hdfsFileInfo{fi}
+}
+
+// Sys returns the owner and group names, the numeric ids are the ones of the local
+// accounts with the same names
+func (fi *hdfsFileInfo) Sys() interface{} {
+	attr := &sftp.SftpFileAttr{
+		Uid:   hdfsNobodyID,
+		Gid:   hdfsNobodyID,
+		Nlink: 1,
+	}
+	if st, ok := fi.FileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto); ok {
+		attr.Uname = st.GetOwner()
+		attr.Gname = st.GetGroup()
+		attr.Uid = hdfsUIDs.get(attr.Uname)
+		attr.Gid = hdfsGIDs.get(attr.Gname)
+	}
+	return attr
+}
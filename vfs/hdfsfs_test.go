@@ -0,0 +1,478 @@
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lulugyf/sshserv/hdfs"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+	"github.com/lulugyf/sshserv/vfs/vfstest"
+)
+
+// fakeHdfsClient adapts the in memory client shared with the serv tests to HdfsClient
+type fakeHdfsClient struct {
+	*vfstest.HdfsClient
+}
+
+func newFakeHdfsClient(dirs ...string) fakeHdfsClient {
+	return fakeHdfsClient{HdfsClient: vfstest.NewHdfsClient(dirs...)}
+}
+
+func (c fakeHdfsClient) Open(name string) (HdfsFileReader, error) {
+	r, err := c.HdfsClient.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c fakeHdfsClient) Create(name string) (HdfsFileWriter, error) {
+	w, err := c.HdfsClient.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c fakeHdfsClient) Append(name string) (HdfsFileWriter, error) {
+	w, err := c.HdfsClient.Append(name)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (c fakeHdfsClient) GetContentSummary(name string) (HdfsContentSummary, error) {
+	cs, err := c.HdfsClient.GetContentSummary(name)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func TestHdfsFsSymlinkJail(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddDir("/user/test")
+	client.AddDir("/user/test/dir")
+	client.AddFile("/user/test/dir/file.txt", nil)
+	client.AddDir("/user/other")
+	client.AddDir("/shared")
+	client.AddSymlink("/user/test/inside", "dir")
+	client.AddSymlink("/user/test/inside_abs", "/user/test/dir/file.txt")
+	client.AddSymlink("/user/test/outside", "/user/other")
+	client.AddSymlink("/user/test/dir/up", "../../other")
+	client.AddSymlink("/user/test/uri", "hdfs://namenode:8020/etc")
+	client.AddSymlink("/user/test/chain", "inside")
+	client.AddSymlink("/user/test/chain_out", "dir/up")
+	client.AddSymlink("/user/test/loop1", "loop2")
+	client.AddSymlink("/user/test/loop2", "loop1")
+	client.AddSymlink("/shared/escape", "/user/test")
+	fs := &HdfsFs{
+		rootDir:        "/user/test",
+		virtualFolders: []fsconfig.VirtualFolder{{VirtualPath: "/shared", MappedPath: "/shared"}},
+		client:         client,
+	}
+	for sftpPath, expected := range map[string]string{
+		"/inside/file.txt":     "/user/test/dir/file.txt",
+		"/inside_abs":          "/user/test/dir/file.txt",
+		"/chain/missing/a.txt": "/user/test/dir/missing/a.txt",
+		"/missing/../dir":      "/user/test/dir",
+		"/shared/file.txt":     "/shared/file.txt",
+	} {
+		p, err := fs.ResolvePath(sftpPath)
+		if err != nil {
+			t.Errorf("unexpected error resolving path %#v: %v", sftpPath, err)
+		}
+		if p != expected {
+			t.Errorf("path %#v resolved to %#v, expected: %#v", sftpPath, p, expected)
+		}
+	}
+	for _, sftpPath := range []string{"/outside", "/outside/file.txt", "/dir/up", "/dir/up/missing",
+		"/uri", "/chain_out/file", "/loop1", "/shared/escape/dir"} {
+		if _, err := fs.ResolvePath(sftpPath); err == nil {
+			t.Errorf("path %#v must not resolve", sftpPath)
+		}
+	}
+	target, err := fs.Readlink("/user/test/uri")
+	if err != nil || target != "/etc" {
+		t.Errorf("unexpected readlink result: %v, %v", target, err)
+	}
+	if _, err := fs.Readlink("/user/test/dir"); err == nil {
+		t.Errorf("readlink on a dir must fail")
+	}
+	fi, err := fs.Lstat("/user/test/inside")
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("lstat must not follow symlinks: %v", err)
+	}
+	fi, err = fs.Stat("/user/test/inside")
+	if err != nil || !fi.IsDir() {
+		t.Errorf("stat must follow symlinks: %v", err)
+	}
+}
+
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (w *nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestHdfsFileOutOfOrderWrites(t *testing.T) {
+	w := &nopWriteCloser{}
+	f := newHdfsWriteFile("/file", w, 0)
+	for _, chunk := range []struct {
+		data   string
+		offset int64
+	}{{"cd", 2}, {"ef", 4}, {"ab", 0}, {"gh", 6}} {
+		n, err := f.WriteAt([]byte(chunk.data), chunk.offset)
+		if err != nil || n != len(chunk.data) {
+			t.Errorf("unexpected write result at offset %v: %v, %v", chunk.offset, n, err)
+		}
+	}
+	if w.String() != "abcdefgh" {
+		t.Errorf("unexpected file contents: %#v", w.String())
+	}
+	if _, err := f.WriteAt([]byte("x"), 3); err != ErrHdfsRandomWrite {
+		t.Errorf("a write before the current offset must fail, got: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 8+hdfsMaxReorderBuffer); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, hdfsMaxReorderBuffer), 10); err != ErrHdfsRandomWrite {
+		t.Errorf("a write exceeding the reorder buffer must fail, got: %v", err)
+	}
+	if err := f.Close(); err != ErrHdfsIncompleteWrite {
+		t.Errorf("closing a file with missing data must fail, got: %v", err)
+	}
+
+	// a resumed upload starts at the current file size
+	w = &nopWriteCloser{}
+	f = newHdfsWriteFile("/file", w, 100)
+	if _, err := f.WriteAt([]byte("data"), 0); err != ErrHdfsRandomWrite {
+		t.Errorf("a resumed upload must start at the file size, got: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("data"), 100); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := f.Close(); err != nil || w.String() != "data" {
+		t.Errorf("unexpected close result: %v, contents: %#v", err, w.String())
+	}
+}
+
+func TestHdfsFsCreateResume(t *testing.T) {
+	client := newFakeHdfsClient("/user/test")
+	client.AddFile("/user/test/file.txt", nil)
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  client,
+	}
+	if !IsUploadResumeSupported(fs) {
+		t.Errorf("HDFS must support upload resume")
+	}
+	if IsUploadResumeSupported(NewOsFs("", "", nil)) {
+		t.Errorf("local filesystem must not support upload resume")
+	}
+	f, err := fs.Create("/user/test/file.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil || len(client.Appended) != 1 || client.Appended[0] != "/user/test/file.txt" {
+		t.Errorf("an existing file opened without truncate must be appended, err: %v", err)
+	}
+	if err == nil {
+		f.Close()
+	}
+	f, err = fs.Create("/user/test/file.txt", os.O_WRONLY|os.O_TRUNC)
+	if err != nil || len(client.Appended) != 1 {
+		t.Errorf("an existing file opened with truncate must not be appended, err: %v", err)
+	}
+	if err == nil {
+		f.Close()
+	}
+}
+
+func TestHdfsFsMkdirAll(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddFile("/user/file.txt", nil)
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  client,
+	}
+	if err := MkdirAll(fs, "/user/test/sub"); err != nil {
+		t.Errorf("unable to create dirs: %v", err)
+	}
+	for _, name := range []string{"/user/test", "/user/test/sub"} {
+		if info, err := client.Stat(name); err != nil || !info.IsDir() {
+			t.Errorf("dir %#v must be created on HDFS, err: %v", name, err)
+		}
+	}
+	if err := MkdirAll(fs, "/user/test/sub"); err != nil {
+		t.Errorf("creating existing dirs must not fail: %v", err)
+	}
+	if err := MkdirAll(fs, "/user/file.txt/sub"); err == nil {
+		t.Errorf("creating a dir inside a file must fail")
+	}
+}
+
+func TestHdfsFsOwnerAndSymlinks(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddDir("/user/test")
+	client.AddFile("/user/test/file.txt", nil)
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  client,
+	}
+	if err := fs.Chown("/user/test/file.txt", 0, 0); err != nil {
+		t.Errorf("unexpected chown error: %v", err)
+	}
+	fi, err := client.Lstat("/user/test/file.txt")
+	if err != nil {
+		t.Fatalf("unexpected lstat error: %v", err)
+	}
+	if e := fi.(vfstest.HdfsFileInfo); e.Owner() != "root" || e.Group() == "" {
+		t.Errorf("unexpected owner: %v, group: %v", e.Owner(), e.Group())
+	}
+	if err := fs.Chown("/user/test/file.txt", 4294967, 4294967); err == nil {
+		t.Errorf("chown with ids without a local account must fail")
+	}
+	if err := fs.Symlink("/user/test/file.txt", "/user/test/link"); err != nil {
+		t.Errorf("unexpected symlink error: %v", err)
+	}
+	target, err := fs.Readlink("/user/test/link")
+	if err != nil || target != "/user/test/file.txt" {
+		t.Errorf("unexpected readlink result: %v, %v", target, err)
+	}
+	if hdfsUIDs.get("root") != 0 || hdfsUIDs.get("missing-hdfs-user") != hdfsNobodyID || hdfsGIDs.get("") != hdfsNobodyID {
+		t.Errorf("unexpected owner ids")
+	}
+}
+
+func TestHdfsClientPool(t *testing.T) {
+	var created []fakeHdfsClient
+	var createErr error
+	pool := newHdfsClientPool(func(config fsconfig.HdfsFsConfig) (HdfsClient, error) {
+		if createErr != nil {
+			return nil, createErr
+		}
+		client := newFakeHdfsClient()
+		created = append(created, client)
+		return client, nil
+	})
+	config := fsconfig.HdfsFsConfig{Namenodes: "nn1:8020", User: "test"}
+	pc1, err := pool.get(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pool.evictionStop == nil {
+		t.Errorf("the eviction must start with the first client")
+	}
+	pc2, err := pool.get(config)
+	if err != nil || pc2 != pc1 || len(created) != 1 {
+		t.Errorf("the client must be shared for the same cluster and user, err: %v", err)
+	}
+	pc3, err := pool.get(fsconfig.HdfsFsConfig{Namenodes: "nn1:8020", User: "other"})
+	if err != nil || pc3 == pc1 || len(created) != 2 {
+		t.Errorf("a different user must get a different client, err: %v", err)
+	}
+	pool.put(pc3)
+	// a broken client is replaced and closed once released by all the sessions
+	created[0].StatErr = errors.New("connection refused")
+	pc1.lastCheck = time.Time{}
+	pc4, err := pool.get(config)
+	if err != nil || pc4 == pc1 || len(created) != 3 {
+		t.Errorf("a broken client must be replaced, err: %v", err)
+	}
+	pool.put(pc1)
+	if created[0].Closed != 0 {
+		t.Errorf("a broken client must not be closed while in use")
+	}
+	pool.put(pc2)
+	if created[0].Closed != 1 {
+		t.Errorf("a broken client must be closed when released")
+	}
+	// the namenode answering with not exist means the client is healthy
+	created[2].StatErr = os.ErrNotExist
+	pc4.lastCheck = time.Time{}
+	pc5, err := pool.get(config)
+	if err != nil || pc5 != pc4 {
+		t.Errorf("a not exist error must not mark the client as broken, err: %v", err)
+	}
+	pool.put(pc4)
+	pool.put(pc5)
+	pool.evictIdle(time.Now())
+	if created[1].Closed != 0 || created[2].Closed != 0 {
+		t.Errorf("recently used clients must not be evicted")
+	}
+	pool.evictIdle(time.Now().Add(2 * hdfsPoolIdleTimeout))
+	if created[1].Closed != 1 || created[2].Closed != 1 || len(pool.clients) != 0 {
+		t.Errorf("idle clients must be evicted")
+	}
+	if pool.evictionStop != nil {
+		t.Errorf("the eviction must stop once the pool is empty")
+	}
+	createErr = errors.New("namenode unreachable")
+	_, err = pool.get(config)
+	if err != createErr {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHdfsFsSnapshots(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddDir("/user/test")
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  client,
+	}
+	snapshots, err := fs.GetSnapshots("/user/test")
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("a dir that does not allow snapshots must have no snapshots: %v, %v", snapshots, err)
+	}
+	if _, err = fs.GetSnapshots("/user/missing"); !os.IsNotExist(err) {
+		t.Errorf("unexpected error for a missing dir: %v", err)
+	}
+	if _, err = fs.CreateSnapshot("/user/test", "s1", false); err != ErrHdfsNotSnapshottable {
+		t.Errorf("unexpected error creating a snapshot of a dir that does not allow snapshots: %v", err)
+	}
+	snapshot, err := fs.CreateSnapshot("/user/test", "s1", true)
+	if err != nil || snapshot.Name != "s1" || snapshot.Path != "/user/test/.snapshot/s1" || snapshot.CreatedAt == 0 {
+		t.Errorf("unexpected snapshot: %+v, err: %v", snapshot, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err = fs.CreateSnapshot("/user/test", "s2", false); err != nil {
+		t.Errorf("unable to create snapshot: %v", err)
+	}
+	if _, err = fs.CreateSnapshot("/user/test", "s1", false); !os.IsExist(err) {
+		t.Errorf("snapshot names must be unique, err: %v", err)
+	}
+	snapshots, err = fs.GetSnapshots("/user/test")
+	if err != nil || len(snapshots) != 2 || snapshots[0].Name != "s1" || snapshots[1].Name != "s2" {
+		t.Errorf("unexpected snapshots: %+v, err: %v", snapshots, err)
+	}
+	if err = fs.DeleteSnapshot("/user/test", "s1"); err != nil {
+		t.Errorf("unable to delete snapshot: %v", err)
+	}
+	if err = fs.DeleteSnapshot("/user/test", "s1"); !os.IsNotExist(err) {
+		t.Errorf("unexpected error deleting a missing snapshot: %v", err)
+	}
+	snapshots, err = fs.GetSnapshots("/user/test")
+	if err != nil || len(snapshots) != 1 || snapshots[0].Name != "s2" {
+		t.Errorf("unexpected snapshots: %+v, err: %v", snapshots, err)
+	}
+	if !IsSnapshotPath(fs, "/dir/.snapshot/s2/file.txt") || !IsSnapshotPath(fs, "/.snapshot") ||
+		IsSnapshotPath(fs, "/dir/.snapshots/file.txt") {
+		t.Errorf("unexpected snapshot path detection")
+	}
+	if IsSnapshotPath(NewOsFs("", os.TempDir(), nil), "/dir/.snapshot/s2") {
+		t.Errorf("only HDFS snapshots are read only")
+	}
+}
+
+func TestHdfsFsQuota(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddDir("/user/test")
+	client.Replication = 3
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  client,
+	}
+	if _, _, err := GetDirUsage(fs, "/user/missing"); !os.IsNotExist(err) {
+		t.Errorf("unexpected error getting the usage of a missing dir: %v", err)
+	}
+	// the content summary is used instead of walking the dir
+	client.AddFile("/user/test/file.txt", []byte("data"))
+	numFiles, size, err := GetDirUsage(fs, "/user/test")
+	if err != nil || numFiles != 1 || size != 4 || client.Summaries != 2 {
+		t.Errorf("the content summary must be used for HDFS dirs: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+	if err := fs.SetDirQuota("/user/test", 0, 1000); err != nil {
+		t.Errorf("unable to set quota: %v", err)
+	}
+	if q := client.Quotas["/user/test"]; q[0] != 0 || q[1] != 3000 {
+		t.Errorf("the space quota must include the replicas: %v", q)
+	}
+	if err := fs.SetDirQuota("/user/test", 10, 0); err != nil {
+		t.Errorf("unable to set quota: %v", err)
+	}
+	if q := client.Quotas["/user/test"]; q[0] != 11 || q[1] != 0 {
+		t.Errorf("the name quota must include the dirs: %v", q)
+	}
+	if err := fs.SetDirQuota("/user/test", 0, 0); err != nil {
+		t.Errorf("unable to remove quota: %v", err)
+	}
+	if q := client.Quotas["/user/test"]; q[0] != 0 || q[1] != 0 {
+		t.Errorf("unexpected quota after removal: %v", q)
+	}
+
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	numFiles, size, err = GetDirUsage(NewOsFs("", dir, nil), dir)
+	if err != nil || numFiles != 1 || size != 4 {
+		t.Errorf("unexpected local dir usage: %v files, %v bytes, err: %v", numFiles, size, err)
+	}
+}
+
+func TestHdfsFsXattrs(t *testing.T) {
+	client := newFakeHdfsClient()
+	client.AddDir("/user")
+	client.AddFile("/user/file", nil)
+	fs := &HdfsFs{
+		rootDir: "/user",
+		client:  client,
+	}
+	if err := fs.SetXattr("/user/file", "user.b", "2"); err != nil {
+		t.Errorf("unable to set xattr: %v", err)
+	}
+	if err := fs.SetXattr("/user/file", "user.a", "1"); err != nil {
+		t.Errorf("unable to set xattr: %v", err)
+	}
+	names, err := fs.ListXattrs("/user/file")
+	if err != nil || len(names) != 2 || names[0] != "user.a" || names[1] != "user.b" {
+		t.Errorf("unexpected xattrs: %v, err: %v", names, err)
+	}
+	if value, err := fs.GetXattr("/user/file", "user.b"); err != nil || value != "2" {
+		t.Errorf("unexpected xattr value: %#v, err: %v", value, err)
+	}
+	if _, err := fs.GetXattr("/user/file", "user.missing"); err != ErrXattrNotFound {
+		t.Errorf("getting a missing xattr must fail with ErrXattrNotFound, err: %v", err)
+	}
+	if err := fs.RemoveXattr("/user/file", "user.a"); err != nil {
+		t.Errorf("unable to remove xattr: %v", err)
+	}
+	if err := fs.RemoveXattr("/user/file", "user.a"); err != ErrXattrNotFound {
+		t.Errorf("removing a missing xattr must fail with ErrXattrNotFound, err: %v", err)
+	}
+	if _, err := fs.ListXattrs("/user/missing"); !os.IsNotExist(err) {
+		t.Errorf("unexpected error listing the xattrs of a missing file: %v", err)
+	}
+
+	acl, err := fs.GetACL("/user/file")
+	if err != nil || strings.Join(acl, ",") != "user::rw-,group::r--,other::r--" {
+		t.Errorf("unexpected ACL: %v, err: %v", acl, err)
+	}
+	client.Acls = map[string][]hdfs.AclEntry{"/user": {
+		{Type: "user", Name: "alice", Perm: 7},
+		{Type: "group", Perm: 5},
+		{Default: true, Type: "user", Name: "bob", Perm: 6},
+	}}
+	acl, err = fs.GetACL("/user")
+	expected := "user::rwx,user:alice:rwx,group::r-x,mask::r-x,other::r-x,default:user:bob:rw-"
+	if err != nil || strings.Join(acl, ",") != expected {
+		t.Errorf("unexpected extended ACL: %v, err: %v", acl, err)
+	}
+}
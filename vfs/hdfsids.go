@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// uid and gid reported for the HDFS owners and groups without a local account
+	hdfsNobodyID = 65534
+	// how long a name to id mapping is cached
+	hdfsIDCacheTTL = 5 * time.Minute
+)
+
+// HDFS owners and groups are names, SFTP clients use numeric ids: they are mapped
+// using the local accounts with the same names
+var (
+	hdfsUIDs = newHdfsIDCache(func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	hdfsGIDs = newHdfsIDCache(func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+)
+
+type hdfsIDEntry struct {
+	id      uint32
+	expires time.Time
+}
+
+type hdfsIDCache struct {
+	sync.Mutex
+	ids    map[string]hdfsIDEntry
+	lookup func(name string) (string, error)
+}
+
+func newHdfsIDCache(lookup func(name string) (string, error)) *hdfsIDCache {
+	return &hdfsIDCache{
+		ids:    make(map[string]hdfsIDEntry),
+		lookup: lookup,
+	}
+}
+
+// get returns the id for the given name, hdfsNobodyID if there is no local account with this name
+func (c *hdfsIDCache) get(name string) uint32 {
+	if name == "" {
+		return hdfsNobodyID
+	}
+	now := time.Now()
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.ids[name]; ok && now.Before(e.expires) {
+		return e.id
+	}
+	id := uint32(hdfsNobodyID)
+	if value, err := c.lookup(name); err == nil {
+		if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+			id = uint32(n)
+		}
+	}
+	c.ids[name] = hdfsIDEntry{id: id, expires: now.Add(hdfsIDCacheTTL)}
+	return id
+}
+
+// getHdfsOwnerNames returns the local user and group names for the given uid and gid,
+// they are used as HDFS owner and group
+func getHdfsOwnerNames(uid, gid int) (string, string, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", "", err
+	}
+	g, err := user.LookupGroupId(strconv.Itoa(gid))
+	if err != nil {
+		return "", "", err
+	}
+	return u.Username, g.Name, nil
+}
@@ -0,0 +1,138 @@
+package vfs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+// default path of the Kerberos configuration
+const defaultKrb5Conf = "/etc/krb5.conf"
+
+// how often the Kerberos clients check that they still have a valid ticket granting ticket
+var hdfsKerberosRenewInterval = time.Minute
+
+// sharedKerberosClient is a logged in client shared by the HDFS clients using the same credentials
+type sharedKerberosClient struct {
+	client *krb.Client
+	refs   int
+	// closed to stop the goroutine that keeps the client logged in
+	stop chan struct{}
+}
+
+// hdfsKerberosClients holds a logged in client for each set of credentials,
+// it is shared by all the HDFS clients using these credentials
+var hdfsKerberosClients = struct {
+	sync.Mutex
+	clients map[string]*sharedKerberosClient
+}{
+	clients: make(map[string]*sharedKerberosClient),
+}
+
+// getHdfsKerberosClient returns a logged in Kerberos client for the given credentials.
+// The first time a set of credentials is used a goroutine that keeps the client logged in is started.
+// The returned client must be released using releaseHdfsKerberosClient
+func getHdfsKerberosClient(config fsconfig.HdfsKerberosConfig) (*krb.Client, error) {
+	key := getHdfsKerberosKey(config)
+	hdfsKerberosClients.Lock()
+	defer hdfsKerberosClients.Unlock()
+	if shared, ok := hdfsKerberosClients.clients[key]; ok {
+		shared.refs++
+		return shared.client, nil
+	}
+	client, err := newHdfsKerberosClient(config)
+	if err != nil {
+		logger.Warn(logSender, "unable to login to Kerberos as %#v using keytab %#v: %v", config.Principal,
+			config.Keytab, err)
+		return nil, err
+	}
+	logger.Debug(logSender, "logged in to Kerberos as %#v", config.Principal)
+	shared := &sharedKerberosClient{
+		client: client,
+		refs:   1,
+		stop:   make(chan struct{}),
+	}
+	hdfsKerberosClients.clients[key] = shared
+	go renewHdfsKerberosClient(config.Principal, client, hdfsKerberosRenewInterval, shared.stop)
+	return client, nil
+}
+
+// releaseHdfsKerberosClient releases a client returned by getHdfsKerberosClient, the client is
+// logged out and removed once no HDFS client uses it
+func releaseHdfsKerberosClient(config fsconfig.HdfsKerberosConfig) {
+	key := getHdfsKerberosKey(config)
+	hdfsKerberosClients.Lock()
+	defer hdfsKerberosClients.Unlock()
+	shared, ok := hdfsKerberosClients.clients[key]
+	if !ok {
+		return
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+	delete(hdfsKerberosClients.clients, key)
+	close(shared.stop)
+	shared.client.Destroy()
+	logger.Debug(logSender, "Kerberos client for %#v closed", config.Principal)
+}
+
+func getHdfsKerberosKey(config fsconfig.HdfsKerberosConfig) string {
+	return fmt.Sprintf("%v|%v|%v", config.Principal, config.Keytab, config.Krb5Conf)
+}
+
+func newHdfsKerberosClient(config fsconfig.HdfsKerberosConfig) (*krb.Client, error) {
+	krb5Conf := config.Krb5Conf
+	if krb5Conf == "" {
+		krb5Conf = defaultKrb5Conf
+	}
+	cfg, err := krbconfig.Load(krb5Conf)
+	if err != nil {
+		return nil, fmt.Errorf("can not load Kerberos configuration %#v: %v", krb5Conf, err)
+	}
+	kt, err := keytab.Load(config.Keytab)
+	if err != nil {
+		return nil, fmt.Errorf("can not load keytab %#v: %v", config.Keytab, err)
+	}
+	username, realm := splitKerberosPrincipal(config.Principal, cfg.LibDefaults.DefaultRealm)
+	client := krb.NewWithKeytab(username, realm, kt, cfg, krb.DisablePAFXFAST(true))
+	if err := client.Login(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// renewHdfsKerberosClient keeps the client logged in. gokrb5 renews the ticket granting ticket
+// before it expires, but it gives up if the KDC cannot be reached when the ticket expires:
+// in this case a new ticket is requested using the keytab as soon as the KDC is back.
+// It returns when stop is closed
+func renewHdfsKerberosClient(principal string, client *krb.Client, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := client.AffirmLogin(); err != nil {
+				logger.Warn(logSender, "unable to renew the Kerberos ticket for %#v: %v", principal, err)
+			}
+		}
+	}
+}
+
+// splitKerberosPrincipal returns the user and the realm for the given principal,
+// defaultRealm is used if the principal has no realm
+func splitKerberosPrincipal(principal, defaultRealm string) (string, string) {
+	if idx := strings.LastIndex(principal, "@"); idx >= 0 {
+		return principal[:idx], principal[idx+1:]
+	}
+	return principal, defaultRealm
+}
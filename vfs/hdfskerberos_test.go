@@ -0,0 +1,352 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+const testKerberosRealm = "TEST.LOCAL"
+
+// testKDC is a stand-in KDC, over TCP, that issues tickets for the principals in its keytab
+// without pre-authentication
+type testKDC struct {
+	listener net.Listener
+	keytab   *keytab.Keytab
+	lifetime time.Duration
+	mu       sync.Mutex
+	down     bool
+	asReqs   int
+	tgsReqs  int
+}
+
+func newTestKDC(t *testing.T, lifetime time.Duration, principals ...string) *testKDC {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	kdc := &testKDC{
+		listener: listener,
+		keytab:   keytab.New(),
+		lifetime: lifetime,
+	}
+	for _, p := range append(principals, "krbtgt/"+testKerberosRealm) {
+		if err := kdc.keytab.AddEntry(p, testKerberosRealm, "secret-"+p, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("unable to add keytab entry: %v", err)
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go kdc.handle(conn)
+		}
+	}()
+	return kdc
+}
+
+func (k *testKDC) close() {
+	k.listener.Close()
+}
+
+func (k *testKDC) setDown(down bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.down = down
+}
+
+func (k *testKDC) getRequests() (int, int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.asReqs, k.tgsReqs
+}
+
+// writeKrb5Conf writes a Kerberos configuration that points to this KDC
+func (k *testKDC) writeKrb5Conf(t *testing.T, dir string) string {
+	conf := fmt.Sprintf(`[libdefaults]
+  default_realm = %v
+  udp_preference_limit = 1
+  dns_lookup_kdc = false
+  dns_lookup_realm = false
+
+[realms]
+  %v = {
+    kdc = %v
+  }
+`, testKerberosRealm, testKerberosRealm, k.listener.Addr().String())
+	name := filepath.Join(dir, "krb5.conf")
+	if err := ioutil.WriteFile(name, []byte(conf), 0644); err != nil {
+		t.Fatalf("unable to write krb5.conf: %v", err)
+	}
+	return name
+}
+
+// writeKeytab writes a keytab with the keys of the given principal
+func (k *testKDC) writeKeytab(t *testing.T, dir, principal string) string {
+	kt := keytab.New()
+	if err := kt.AddEntry(principal, testKerberosRealm, "secret-"+principal, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatalf("unable to add keytab entry: %v", err)
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("unable to marshal keytab: %v", err)
+	}
+	name := filepath.Join(dir, strings.Replace(principal, "/", "_", -1)+".keytab")
+	if err := ioutil.WriteFile(name, b, 0600); err != nil {
+		t.Fatalf("unable to write keytab: %v", err)
+	}
+	return name
+}
+
+func (k *testKDC) handle(conn net.Conn) {
+	defer conn.Close()
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return
+	}
+	req := make([]byte, size)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	k.mu.Lock()
+	down := k.down
+	k.mu.Unlock()
+	if down {
+		return
+	}
+	var rep []byte
+	var asReq messages.ASReq
+	var tgsReq messages.TGSReq
+	var err error
+	if asReq.Unmarshal(req) == nil {
+		rep, err = k.handleAS(asReq)
+	} else if tgsReq.Unmarshal(req) == nil {
+		rep, err = k.handleTGS(tgsReq)
+	} else {
+		return
+	}
+	if err != nil {
+		return
+	}
+	binary.Write(conn, binary.BigEndian, uint32(len(rep)))
+	conn.Write(rep)
+}
+
+func (k *testKDC) handleAS(req messages.ASReq) ([]byte, error) {
+	k.mu.Lock()
+	k.asReqs++
+	k.mu.Unlock()
+	clientKey, kvno, err := k.keytab.GetEncryptionKey(req.ReqBody.CName, testKerberosRealm, 0, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := k.newReply(msgtype.KRB_AS_REP, req.ReqBody.CName, req.ReqBody.SName, req.ReqBody.Nonce, clientKey,
+		keyusage.AS_REP_ENCPART, kvno)
+	if err != nil {
+		return nil, err
+	}
+	asRep := messages.ASRep{KDCRepFields: rep}
+	return asRep.Marshal()
+}
+
+func (k *testKDC) handleTGS(req messages.TGSReq) ([]byte, error) {
+	k.mu.Lock()
+	k.tgsReqs++
+	k.mu.Unlock()
+	for _, pa := range req.PAData {
+		if pa.PADataType != patype.PA_TGS_REQ {
+			continue
+		}
+		var apReq messages.APReq
+		if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+			return nil, err
+		}
+		krbtgt := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+testKerberosRealm)
+		if err := apReq.Ticket.DecryptEncPart(k.keytab, &krbtgt); err != nil {
+			return nil, err
+		}
+		rep, err := k.newReply(msgtype.KRB_TGS_REP, apReq.Ticket.DecryptedEncPart.CName, req.ReqBody.SName,
+			req.ReqBody.Nonce, apReq.Ticket.DecryptedEncPart.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY, 0)
+		if err != nil {
+			return nil, err
+		}
+		tgsRep := messages.TGSRep{KDCRepFields: rep}
+		return tgsRep.Marshal()
+	}
+	return nil, fmt.Errorf("missing TGT")
+}
+
+func (k *testKDC) newReply(msgType int, cname, sname types.PrincipalName, nonce int, key types.EncryptionKey,
+	usage uint32, kvno int) (messages.KDCRepFields, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	endTime := now.Add(k.lifetime)
+	ticket, sessionKey, err := messages.NewTicket(cname, testKerberosRealm, sname, testKerberosRealm, types.NewKrbFlags(),
+		k.keytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, endTime, time.Time{})
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+	encPart := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{},
+		Nonce:     nonce,
+		Flags:     types.NewKrbFlags(),
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   endTime,
+		SRealm:    testKerberosRealm,
+		SName:     sname,
+	}
+	b, err := encPart.Marshal()
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+	encData, err := crypto.GetEncryptedData(b, key, usage, kvno)
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+	return messages.KDCRepFields{
+		PVNO:    iana.PVNO,
+		MsgType: msgType,
+		CRealm:  testKerberosRealm,
+		CName:   cname,
+		Ticket:  ticket,
+		EncPart: encData,
+	}, nil
+}
+
+func TestHdfsKerberosLogin(t *testing.T) {
+	kdc := newTestKDC(t, time.Hour, "sftp/localhost", "nn/localhost")
+	defer kdc.close()
+	dir, err := ioutil.TempDir("", "hdfskrb")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	config := fsconfig.HdfsKerberosConfig{
+		Principal: "sftp/localhost@" + testKerberosRealm,
+		Keytab:    kdc.writeKeytab(t, dir, "sftp/localhost"),
+		Krb5Conf:  kdc.writeKrb5Conf(t, dir),
+	}
+	client, err := getHdfsKerberosClient(config)
+	if err != nil {
+		t.Fatalf("unable to login: %v", err)
+	}
+	client1, err := getHdfsKerberosClient(config)
+	if err != nil || client1 != client {
+		t.Errorf("the Kerberos client must be shared, err: %v", err)
+	}
+	if asReqs, _ := kdc.getRequests(); asReqs != 1 {
+		t.Errorf("unexpected number of logins: %v", asReqs)
+	}
+	if _, _, err = client.GetServiceTicket("nn/localhost"); err != nil {
+		t.Errorf("unable to get a namenode ticket: %v", err)
+	}
+	if _, tgsReqs := kdc.getRequests(); tgsReqs != 1 {
+		t.Errorf("unexpected number of service ticket requests: %v", tgsReqs)
+	}
+
+	_, err = getHdfsKerberosClient(fsconfig.HdfsKerberosConfig{
+		Principal: "missing/localhost",
+		Keytab:    filepath.Join(dir, "missing.keytab"),
+		Krb5Conf:  config.Krb5Conf,
+	})
+	if err == nil {
+		t.Errorf("login with a missing keytab must fail")
+	}
+	// the namenode principal is required and it cannot be read from a Hadoop configuration here
+	_, err = newHdfsClient(fsconfig.HdfsFsConfig{
+		Namenodes: "127.0.0.1:1",
+		User:      "test",
+		Kerberos:  &config,
+	})
+	if err == nil || !strings.Contains(err.Error(), "SPN") {
+		t.Errorf("a Kerberos client without namenode principal must fail, err: %v", err)
+	}
+
+	fsConfig := fsconfig.HdfsFsConfig{Namenodes: "nn1:8020", User: "test"}
+	proxyConfig := fsconfig.HdfsFsConfig{Namenodes: "nn1:8020", User: "test", RealUser: "sftp"}
+	krbConfig := fsconfig.HdfsFsConfig{Namenodes: "nn1:8020", User: "test", Kerberos: &config}
+	if getHdfsPoolKey(fsConfig) == getHdfsPoolKey(proxyConfig) || getHdfsPoolKey(fsConfig) == getHdfsPoolKey(krbConfig) ||
+		getHdfsPoolKey(proxyConfig) == getHdfsPoolKey(krbConfig) {
+		t.Errorf("clients with different real users must not be shared")
+	}
+
+	key := getHdfsKerberosKey(config)
+	releaseHdfsKerberosClient(config)
+	hdfsKerberosClients.Lock()
+	_, ok := hdfsKerberosClients.clients[key]
+	hdfsKerberosClients.Unlock()
+	if !ok {
+		t.Errorf("a Kerberos client still in use must not be removed")
+	}
+	releaseHdfsKerberosClient(config)
+	hdfsKerberosClients.Lock()
+	_, ok = hdfsKerberosClients.clients[key]
+	hdfsKerberosClients.Unlock()
+	if ok {
+		t.Errorf("a Kerberos client no longer used must be removed")
+	}
+}
+
+func TestHdfsKerberosRenewal(t *testing.T) {
+	lifetime := 2 * time.Second
+	kdc := newTestKDC(t, lifetime, "renew/localhost", "nn/localhost")
+	defer kdc.close()
+	dir, err := ioutil.TempDir("", "hdfskrb")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	renewInterval := hdfsKerberosRenewInterval
+	hdfsKerberosRenewInterval = 100 * time.Millisecond
+	defer func() {
+		hdfsKerberosRenewInterval = renewInterval
+	}()
+	client, err := getHdfsKerberosClient(fsconfig.HdfsKerberosConfig{
+		Principal: "renew/localhost",
+		Keytab:    kdc.writeKeytab(t, dir, "renew/localhost"),
+		Krb5Conf:  kdc.writeKrb5Conf(t, dir),
+	})
+	if err != nil {
+		t.Fatalf("unable to login: %v", err)
+	}
+	// the ticket expires while the KDC is down, a new one must be requested when it is back
+	kdc.setDown(true)
+	time.Sleep(lifetime + 500*time.Millisecond)
+	kdc.setDown(false)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if asReqs, _ := kdc.getRequests(); asReqs >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the Kerberos ticket was not renewed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, _, err = client.GetServiceTicket("nn/localhost"); err != nil {
+		t.Errorf("unable to get a namenode ticket after the renewal: %v", err)
+	}
+}
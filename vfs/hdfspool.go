@@ -0,0 +1,188 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+const (
+	// a pooled client not used or checked for this time is checked before it is reused
+	hdfsPoolCheckInterval = 30 * time.Second
+	// a pooled client not used by any session for this time is closed
+	hdfsPoolIdleTimeout = 5 * time.Minute
+	// how often the idle clients are evicted
+	hdfsPoolEvictionInterval = time.Minute
+)
+
+// hdfsPool is the process wide pool of HDFS clients, a client is shared by all the sessions
+// for the same cluster and effective user
+var hdfsPool = newHdfsClientPool(newHdfsClient)
+
+type pooledHdfsClient struct {
+	key       string
+	client    HdfsClient
+	refs      int
+	lastUsed  time.Time
+	lastCheck time.Time
+	broken    bool
+}
+
+type hdfsClientPool struct {
+	sync.Mutex
+	clients   map[string]*pooledHdfsClient
+	newClient func(config fsconfig.HdfsFsConfig) (HdfsClient, error)
+	// closed to stop the goroutine that evicts the idle clients, nil if it is not running.
+	// The goroutine is started with the first pooled client and stopped once the pool is empty
+	evictionStop chan struct{}
+}
+
+func newHdfsClientPool(newClient func(config fsconfig.HdfsFsConfig) (HdfsClient, error)) *hdfsClientPool {
+	return &hdfsClientPool{
+		clients:   make(map[string]*pooledHdfsClient),
+		newClient: newClient,
+	}
+}
+
+// getHdfsPoolKey returns the pool key for the given config: the cluster, the effective user,
+// the user or the Kerberos credentials that authenticate and the hosts mapping used to reach it
+func getHdfsPoolKey(config fsconfig.HdfsFsConfig) string {
+	hosts := make([]string, 0, len(config.Hosts))
+	for host, addr := range config.Hosts {
+		hosts = append(hosts, host+"="+addr)
+	}
+	sort.Strings(hosts)
+	realUser := config.RealUser
+	if config.Kerberos.IsEnabled() {
+		realUser = "krb:" + getHdfsKerberosKey(*config.Kerberos) + "|" + config.Kerberos.NamenodePrincipal
+	}
+	return fmt.Sprintf("%v|%v|%v|%v", config.Namenodes, config.User, realUser, strings.Join(hosts, ","))
+}
+
+// get returns a healthy client for the given config, a new client is created if the pool has none.
+// The returned client must be released using put
+func (p *hdfsClientPool) get(config fsconfig.HdfsFsConfig) (*pooledHdfsClient, error) {
+	key := getHdfsPoolKey(config)
+	p.Lock()
+	pc, ok := p.clients[key]
+	if ok {
+		pc.refs++
+	}
+	p.Unlock()
+	if ok {
+		if err := p.check(pc); err == nil {
+			return pc, nil
+		}
+		p.put(pc)
+	}
+
+	client, err := p.newClient(config)
+	if err != nil {
+		logger.Warn(logSender, "unable to create HDFS client for user %#v, namenodes %#v: %v", config.User,
+			config.Namenodes, err)
+		return nil, err
+	}
+	pc = &pooledHdfsClient{
+		key:      key,
+		client:   client,
+		refs:     1,
+		lastUsed: time.Now(),
+	}
+	p.Lock()
+	if existing, ok := p.clients[key]; ok {
+		// another session created a client for the same key meanwhile
+		existing.refs++
+		p.Unlock()
+		client.Close()
+		return existing, nil
+	}
+	p.clients[key] = pc
+	if p.evictionStop == nil {
+		p.evictionStop = make(chan struct{})
+		go p.evictionLoop(p.evictionStop)
+	}
+	p.Unlock()
+	if err := p.check(pc); err != nil {
+		p.put(pc)
+		return nil, err
+	}
+	return pc, nil
+}
+
+// evictionLoop evicts the idle clients every hdfsPoolEvictionInterval until stop is closed
+func (p *hdfsClientPool) evictionLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(hdfsPoolEvictionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			p.evictIdle(now)
+		}
+	}
+}
+
+// check verifies that the namenode is reachable using the given client, a broken client is
+// removed from the pool so the next sessions get a new one, this way a session started after a
+// namenode failover or a network error does not use a stale connection
+func (p *hdfsClientPool) check(pc *pooledHdfsClient) error {
+	p.Lock()
+	needsCheck := pc.lastCheck.IsZero() || time.Since(pc.lastCheck) > hdfsPoolCheckInterval
+	p.Unlock()
+	if !needsCheck {
+		return nil
+	}
+	_, err := pc.client.Stat("/")
+	if err != nil && (os.IsNotExist(err) || os.IsPermission(err)) {
+		// the namenode answered
+		err = nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if err != nil {
+		logger.Warn(logSender, "HDFS client health check failed, the client will be replaced: %v", err)
+		pc.broken = true
+		if p.clients[pc.key] == pc {
+			delete(p.clients, pc.key)
+		}
+		return err
+	}
+	pc.lastCheck = time.Now()
+	return nil
+}
+
+// put releases a client returned by get, a broken client is closed when it is no longer used
+func (p *hdfsClientPool) put(pc *pooledHdfsClient) {
+	p.Lock()
+	defer p.Unlock()
+	pc.refs--
+	pc.lastUsed = time.Now()
+	if pc.broken && pc.refs <= 0 {
+		pc.client.Close()
+	}
+}
+
+// evictIdle closes the clients not used by any session for more than hdfsPoolIdleTimeout,
+// the eviction goroutine is stopped if the pool is empty
+func (p *hdfsClientPool) evictIdle(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	for key, pc := range p.clients {
+		if pc.refs <= 0 && now.Sub(pc.lastUsed) > hdfsPoolIdleTimeout {
+			delete(p.clients, key)
+			err := pc.client.Close()
+			logger.Debug(logSender, "idle HDFS client closed, key: %#v, error: %v", key, err)
+		}
+	}
+	if len(p.clients) == 0 && p.evictionStop != nil {
+		close(p.evictionStop)
+		p.evictionStop = nil
+	}
+}
@@ -0,0 +1,42 @@
+package vfs
+
+import (
+	"github.com/lulugyf/sshserv/logger"
+)
+
+// GetDirUsage returns the number of files inside the named dir and their size as reported
+// by the namenode content summary, so the dir tree is not walked
+func (fs *HdfsFs) GetDirUsage(dirname string) (int, int64, error) {
+	cs, err := fs.client.GetContentSummary(dirname)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cs.FileCount(), cs.Size(), nil
+}
+
+// SetDirQuota sets the HDFS name and space quotas of the named dir from the given files and size
+// limits, 0 removes the quota. The namenode counts the dirs in the name quota and the replicated
+// bytes in the space quota, so the current number of dirs is added to quotaFiles and quotaSize is
+// multiplied by the cluster default replication. This requires HDFS superuser privileges
+func (fs *HdfsFs) SetDirQuota(dirname string, quotaFiles int, quotaSize int64) error {
+	var nameQuota, spaceQuota int64
+	if quotaFiles > 0 {
+		cs, err := fs.client.GetContentSummary(dirname)
+		if err != nil {
+			return err
+		}
+		nameQuota = int64(quotaFiles + cs.DirectoryCount())
+	}
+	if quotaSize > 0 {
+		replication, err := fs.client.DefaultReplication()
+		if err != nil {
+			return err
+		}
+		if replication < 1 {
+			replication = 1
+		}
+		spaceQuota = quotaSize * int64(replication)
+	}
+	logger.Debug(logSender, "set quota for dir %#v, name quota: %v, space quota: %v", dirname, nameQuota, spaceQuota)
+	return fs.client.SetQuota(dirname, nameQuota, spaceQuota)
+}
@@ -0,0 +1,105 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lulugyf/sshserv/hdfs"
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/utils"
+)
+
+// HdfsSnapshotDirName is the name of the read only dir that contains the snapshots of a snapshottable
+// HDFS dir. It is not listed but it can be opened, for example <dir>/.snapshot/<name>/file.txt
+const HdfsSnapshotDirName = ".snapshot"
+
+// ErrHdfsNotSnapshottable is returned creating a snapshot of a dir that does not allow snapshots
+var ErrHdfsNotSnapshottable = errors.New("snapshots are not allowed for this directory")
+
+// HdfsSnapshot defines a snapshot of an HDFS dir
+type HdfsSnapshot struct {
+	// snapshot name
+	Name string `json:"name"`
+	// HDFS path of the snapshot
+	Path string `json:"path"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+}
+
+// GetSnapshots returns the snapshots of the given dir, the oldest first.
+// A dir that does not allow snapshots has no snapshots
+func (fs *HdfsFs) GetSnapshots(dir string) ([]HdfsSnapshot, error) {
+	snapshots := []HdfsSnapshot{}
+	snapshotDir := path.Join(dir, HdfsSnapshotDirName)
+	files, err := fs.client.ReadDir(snapshotDir)
+	if err != nil {
+		if os.IsNotExist(err) || isHdfsNotSnapshottableError(err) {
+			if _, statErr := fs.client.Stat(dir); statErr != nil {
+				return snapshots, statErr
+			}
+			return snapshots, nil
+		}
+		return snapshots, err
+	}
+	for _, fi := range files {
+		snapshots = append(snapshots, HdfsSnapshot{
+			Name:      fi.Name(),
+			Path:      path.Join(snapshotDir, fi.Name()),
+			CreatedAt: utils.GetTimeAsMsSinceEpoch(fi.ModTime()),
+		})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt < snapshots[j].CreatedAt
+	})
+	return snapshots, nil
+}
+
+// CreateSnapshot creates a snapshot of the given dir. If allowSnapshots is true and the dir does
+// not allow snapshots it is made snapshottable, this requires HDFS superuser privileges
+func (fs *HdfsFs) CreateSnapshot(dir, name string, allowSnapshots bool) (HdfsSnapshot, error) {
+	snapshotPath, err := fs.client.CreateSnapshot(dir, name)
+	if err != nil && isHdfsNotSnapshottableError(err) {
+		if !allowSnapshots {
+			return HdfsSnapshot{}, ErrHdfsNotSnapshottable
+		}
+		logger.Debug(logSender, "allow snapshots for dir %#v", dir)
+		if err = fs.client.AllowSnapshots(dir); err != nil {
+			return HdfsSnapshot{}, err
+		}
+		snapshotPath, err = fs.client.CreateSnapshot(dir, name)
+	}
+	if err != nil {
+		return HdfsSnapshot{}, err
+	}
+	snapshot := HdfsSnapshot{
+		Name: name,
+		Path: snapshotPath,
+	}
+	if fi, err := fs.client.Stat(snapshotPath); err == nil {
+		snapshot.CreatedAt = utils.GetTimeAsMsSinceEpoch(fi.ModTime())
+	}
+	return snapshot, nil
+}
+
+// DeleteSnapshot deletes the snapshot of the given dir with the given name
+func (fs *HdfsFs) DeleteSnapshot(dir, name string) error {
+	if _, err := fs.client.Stat(path.Join(dir, HdfsSnapshotDirName, name)); err != nil {
+		return err
+	}
+	return fs.client.DeleteSnapshot(dir, name)
+}
+
+// isHdfsNotSnapshottableError returns true if the error is the namenode refusing a snapshot
+// operation on a dir that does not allow snapshots
+func isHdfsNotSnapshottableError(err error) bool {
+	if err == ErrHdfsNotSnapshottable {
+		return true
+	}
+	if remoteErr, ok := err.(hdfs.Error); ok {
+		return strings.Contains(remoteErr.Message(), "not a snapshottable")
+	}
+	return false
+}
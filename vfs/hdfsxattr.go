@@ -0,0 +1,66 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/lulugyf/sshserv/hdfs"
+)
+
+// ListXattrs returns the names of the extended attributes of the named file, sorted
+func (fs *HdfsFs) ListXattrs(name string) ([]string, error) {
+	xattrs, err := fs.client.ListXAttrs(name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(xattrs))
+	for attr := range xattrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetXattr returns the value of the extended attribute attr of the named file
+func (fs *HdfsFs) GetXattr(name, attr string) (string, error) {
+	xattrs, err := fs.client.GetXAttrs(name, attr)
+	if err != nil {
+		return "", convertHdfsXattrError(err)
+	}
+	value, ok := xattrs[attr]
+	if !ok {
+		return "", ErrXattrNotFound
+	}
+	return value, nil
+}
+
+// SetXattr creates or replaces the extended attribute attr of the named file
+func (fs *HdfsFs) SetXattr(name, attr, value string) error {
+	return fs.client.SetXAttr(name, attr, value)
+}
+
+// RemoveXattr removes the extended attribute attr of the named file
+func (fs *HdfsFs) RemoveXattr(name, attr string) error {
+	return convertHdfsXattrError(fs.client.RemoveXAttr(name, attr))
+}
+
+// GetACL returns the ACL of the named file, the owner, group and other entries included
+func (fs *HdfsFs) GetACL(name string) ([]string, error) {
+	status, err := fs.client.GetAclStatus(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for _, entry := range status.AllEntries() {
+		entries = append(entries, entry.String())
+	}
+	return entries, nil
+}
+
+func convertHdfsXattrError(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok && errors.Is(pathErr.Err, hdfs.ErrXAttrNotFound) {
+		return ErrXattrNotFound
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package vfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lulugyf/sshserv/logger"
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+const osFsName = "osfs"
+
+// OsFs is a Fs implementation that uses functions provided by the os package.
+type OsFs struct {
+	connectionID   string
+	rootDir        string
+	virtualFolders []fsconfig.VirtualFolder
+}
+
+// NewOsFs returns an OsFs object that allows to interact with the local filesystem,
+// rootDir is the user's home dir, virtualFolders are mapped to local dirs outside it
+func NewOsFs(connectionID, rootDir string, virtualFolders []fsconfig.VirtualFolder) Fs {
+	return &OsFs{
+		connectionID:   connectionID,
+		rootDir:        rootDir,
+		virtualFolders: virtualFolders,
+	}
+}
+
+// Name returns the name for the Fs implementation
+func (fs *OsFs) Name() string {
+	return osFsName
+}
+
+// ConnectionID returns the SSH connection ID associated to this Fs implementation
+func (fs *OsFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *OsFs) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns a FileInfo describing the named file, symlinks are not followed
+func (fs *OsFs) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// Open opens the named file for reading
+func (fs *OsFs) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+// Create creates or opens the named file for writing
+func (fs *OsFs) Create(name string, flag int) (File, error) {
+	if flag == 0 {
+		return os.Create(name)
+	}
+	// we use 0666 so the umask is applied
+	return os.OpenFile(name, flag, 0666)
+}
+
+// Rename renames (moves) source to target
+func (fs *OsFs) Rename(source, target string) error {
+	return os.Rename(source, target)
+}
+
+// Remove removes the named file or (empty) directory
+func (fs *OsFs) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll removes name and any children it contains
+func (fs *OsFs) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *OsFs) Mkdir(name string) error {
+	return os.Mkdir(name, 0777)
+}
+
+// Symlink creates source as a symbolic link to target
+func (fs *OsFs) Symlink(source, target string) error {
+	return os.Symlink(source, target)
+}
+
+// Readlink returns the destination of the named symbolic link
+func (fs *OsFs) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// Link creates target as a hard link to the source file
+func (fs *OsFs) Link(source, target string) error {
+	return os.Link(source, target)
+}
+
+// Chown changes the numeric uid and gid of the named file
+func (fs *OsFs) Chown(name string, uid int, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+// Chmod changes the mode of the named file to mode
+func (fs *OsFs) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file
+func (fs *OsFs) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// ReadDir reads the directory named by dirname and returns a list of directory entries
+func (fs *OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+// OpenDir opens the named directory to read its entries in batches
+func (fs *OsFs) OpenDir(dirname string) (DirLister, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or directory
+func (fs *OsFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+// Statvfs returns information about the filesystem that contains the named path
+func (fs *OsFs) Statvfs(name string) (*sftp.StatVFS, error) {
+	return statvfs(name)
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *OsFs) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *OsFs) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+// Close does nothing for the local filesystem
+func (fs *OsFs) Close() error {
+	return nil
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir or virtual folder.
+// This is the path as seen by SFTP users
+func (fs *OsFs) GetRelativePath(name string) string {
+	return getVirtualRelativePath(name, fs.rootDir, fs.virtualFolders)
+}
+
+// ResolvePath normalizes a path we get from an SFTP request to ensure the user is not able to escape
+// from their data directory or from the virtual folder that contains the path. After normalization
+// if the path is still within their home or virtual folder it is returned. If they managed to "escape"
+// an error will be returned.
+func (fs *OsFs) ResolvePath(sftpPath string) (string, error) {
+	sftpPath = fsconfig.CleanSFTPPath(sftpPath)
+	baseDir := fs.rootDir
+	if folder, ok := fsconfig.GetVirtualFolderForPath(fs.virtualFolders, sftpPath); ok {
+		baseDir = folder.MappedPath
+		sftpPath = "/" + strings.TrimPrefix(strings.TrimPrefix(sftpPath, folder.VirtualPath), "/")
+	}
+	r := filepath.Clean(filepath.Join(baseDir, filepath.FromSlash(sftpPath)))
+	p, err := filepath.EvalSymlinks(r)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	} else if os.IsNotExist(err) {
+		// The requested path doesn't exist, so at this point we need to iterate up the
+		// path chain until we hit a directory that _does_ exist and can be validated.
+		_, err = fs.findFirstExistingDir(r, baseDir)
+		if err != nil {
+			logger.Warn(logSender, "error resolving not existent path: %v", err)
+		}
+		return r, err
+	}
+
+	err = fs.isSubDir(p, baseDir)
+	if err != nil {
+		logger.Warn(logSender, "Invalid path resolution, dir: %v outside user home: %v err: %v", p, baseDir, err)
+	}
+	return r, err
+}
+
+// iterate up the path chain until we hit a directory that does exist and can be validated.
+// all nonexistent directories will be returned
+func (fs *OsFs) findNonexistentDirs(path, baseDir string) ([]string, error) {
+	results := []string{}
+	cleanPath := filepath.Clean(path)
+	parent := filepath.Dir(cleanPath)
+	_, err := os.Stat(parent)
+
+	for os.IsNotExist(err) {
+		results = append(results, parent)
+		parent = filepath.Dir(parent)
+		_, err = os.Stat(parent)
+	}
+	if err != nil {
+		return results, err
+	}
+	p, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return results, err
+	}
+	err = fs.isSubDir(p, baseDir)
+	if err != nil {
+		logger.Warn(logSender, "Error finding non existing dir: %v", err)
+	}
+	return results, err
+}
+
+// iterate up the path chain until we hit a directory that does exist and can be validated.
+func (fs *OsFs) findFirstExistingDir(path, baseDir string) (string, error) {
+	results, err := fs.findNonexistentDirs(path, baseDir)
+	if err != nil {
+		logger.Warn(logSender, "unable to find non existent dirs: %v", err)
+		return "", err
+	}
+	var parent string
+	if len(results) > 0 {
+		lastMissingDir := results[len(results)-1]
+		parent = filepath.Dir(lastMissingDir)
+	} else {
+		parent = baseDir
+	}
+	p, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	fileInfo, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if !fileInfo.IsDir() {
+		return "", fmt.Errorf("resolved path is not a dir: %v", p)
+	}
+	err = fs.isSubDir(p, baseDir)
+	return p, err
+}
+
+// checks if sub is a subpath of baseDir, the user home dir or a virtual folder.
+// EvalSymlink must be used on sub before calling this method
+func (fs *OsFs) isSubDir(sub, baseDir string) error {
+	// base dir must exist and it is already a validated absolute path
+	parent, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		logger.Warn(logSender, "invalid base dir %v: %v", baseDir, err)
+		return err
+	}
+	if !strings.HasPrefix(sub, parent) {
+		logger.Warn(logSender, "dir %v is not inside: %v ", sub, parent)
+		return fmt.Errorf("dir %v is not inside: %v", sub, parent)
+	}
+	return nil
+}
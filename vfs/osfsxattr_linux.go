@@ -0,0 +1,197 @@
+// +build linux
+
+package vfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// POSIX ACLs are stored by the kernel in these extended attributes
+const (
+	posixACLAccess  = "system.posix_acl_access"
+	posixACLDefault = "system.posix_acl_default"
+)
+
+// POSIX ACL xattr format: a version header followed by the entries, see linux/posix_acl_xattr.h
+const (
+	posixACLVersion   = 2
+	posixACLHeaderLen = 4
+	posixACLEntryLen  = 8
+	aclUserObj        = 0x01
+	aclUser           = 0x02
+	aclGroupObj       = 0x04
+	aclGroup          = 0x08
+	aclMask           = 0x10
+	aclOther          = 0x20
+)
+
+// ListXattrs returns the names of the extended attributes of the named file, sorted
+func (fs *OsFs) ListXattrs(name string) ([]string, error) {
+	size, err := syscall.Listxattr(name, nil)
+	if err != nil {
+		return nil, convertXattrError("listxattr", name, err)
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(name, buf)
+	if err != nil {
+		return nil, convertXattrError("listxattr", name, err)
+	}
+	var names []string
+	for _, attr := range strings.Split(string(buf[:size]), "\x00") {
+		if attr != "" {
+			names = append(names, attr)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetXattr returns the value of the extended attribute attr of the named file
+func (fs *OsFs) GetXattr(name, attr string) (string, error) {
+	value, err := getxattr(name, attr)
+	if err != nil {
+		return "", convertXattrError("getxattr", name, err)
+	}
+	return string(value), nil
+}
+
+// SetXattr creates or replaces the extended attribute attr of the named file
+func (fs *OsFs) SetXattr(name, attr, value string) error {
+	if err := syscall.Setxattr(name, attr, []byte(value), 0); err != nil {
+		return convertXattrError("setxattr", name, err)
+	}
+	return nil
+}
+
+// RemoveXattr removes the extended attribute attr of the named file
+func (fs *OsFs) RemoveXattr(name, attr string) error {
+	if err := syscall.Removexattr(name, attr); err != nil {
+		return convertXattrError("removexattr", name, err)
+	}
+	return nil
+}
+
+// GetACL returns the POSIX ACL of the named file. Files without an extended ACL, or on
+// filesystems without ACL support, get the owner, group and other entries from their mode
+func (fs *OsFs) GetACL(name string) ([]string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	data, err := getxattr(name, posixACLAccess)
+	if err == nil {
+		if entries, err = parsePosixACL(data, ""); err != nil {
+			return nil, err
+		}
+	} else if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		perm := info.Mode().Perm()
+		entries = []string{
+			"user::" + rwxString(uint16(perm>>6)),
+			"group::" + rwxString(uint16(perm>>3)),
+			"other::" + rwxString(uint16(perm)),
+		}
+	} else {
+		return nil, convertXattrError("getxattr", name, err)
+	}
+	if !info.IsDir() {
+		return entries, nil
+	}
+	data, err = getxattr(name, posixACLDefault)
+	if err == nil {
+		defaults, err := parsePosixACL(data, "default:")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, defaults...)
+	} else if err != syscall.ENODATA && err != syscall.ENOTSUP {
+		return nil, convertXattrError("getxattr", name, err)
+	}
+	return entries, nil
+}
+
+func getxattr(name, attr string) ([]byte, error) {
+	size, err := syscall.Getxattr(name, attr, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(name, attr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+func convertXattrError(op, name string, err error) error {
+	switch err {
+	case syscall.ENODATA:
+		return ErrXattrNotFound
+	case syscall.ENOTSUP:
+		return ErrVfsUnsupported
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// parsePosixACL converts the value of a POSIX ACL xattr to entries in the getfacl format,
+// prefix is added to every entry
+func parsePosixACL(data []byte, prefix string) ([]string, error) {
+	if len(data) < posixACLHeaderLen || (len(data)-posixACLHeaderLen)%posixACLEntryLen != 0 {
+		return nil, fmt.Errorf("invalid POSIX ACL size: %v", len(data))
+	}
+	if version := binary.LittleEndian.Uint32(data); version != posixACLVersion {
+		return nil, fmt.Errorf("unsupported POSIX ACL version: %v", version)
+	}
+	var entries []string
+	for b := data[posixACLHeaderLen:]; len(b) > 0; b = b[posixACLEntryLen:] {
+		tag := binary.LittleEndian.Uint16(b)
+		perm := rwxString(binary.LittleEndian.Uint16(b[2:]))
+		id := strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[4:])), 10)
+		var entry string
+		switch tag {
+		case aclUserObj:
+			entry = "user::" + perm
+		case aclUser:
+			if u, err := user.LookupId(id); err == nil {
+				id = u.Username
+			}
+			entry = "user:" + id + ":" + perm
+		case aclGroupObj:
+			entry = "group::" + perm
+		case aclGroup:
+			if g, err := user.LookupGroupId(id); err == nil {
+				id = g.Name
+			}
+			entry = "group:" + id + ":" + perm
+		case aclMask:
+			entry = "mask::" + perm
+		case aclOther:
+			entry = "other::" + perm
+		default:
+			return nil, fmt.Errorf("unknown POSIX ACL tag: %v", tag)
+		}
+		entries = append(entries, prefix+entry)
+	}
+	return entries, nil
+}
+
+func rwxString(perm uint16) string {
+	b := []byte("---")
+	if perm&4 != 0 {
+		b[0] = 'r'
+	}
+	if perm&2 != 0 {
+		b[1] = 'w'
+	}
+	if perm&1 != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
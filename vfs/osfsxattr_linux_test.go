@@ -0,0 +1,81 @@
+// +build linux
+
+package vfs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePosixACL(t *testing.T) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, posixACLVersion)
+	for _, e := range [][3]uint32{
+		{aclUserObj, 6, 0xffffffff},
+		{aclUser, 4, 0},
+		{aclGroupObj, 4, 0xffffffff},
+		{aclMask, 5, 0xffffffff},
+		{aclOther, 0, 0xffffffff},
+	} {
+		entry := make([]byte, 8)
+		binary.LittleEndian.PutUint16(entry, uint16(e[0]))
+		binary.LittleEndian.PutUint16(entry[2:], uint16(e[1]))
+		binary.LittleEndian.PutUint32(entry[4:], e[2])
+		data = append(data, entry...)
+	}
+	entries, err := parsePosixACL(data, "default:")
+	if err != nil {
+		t.Fatalf("unable to parse ACL: %v", err)
+	}
+	expected := "default:user::rw-,default:user:root:r--,default:group::r--,default:mask::r-x,default:other::---"
+	if strings.Join(entries, ",") != expected {
+		t.Errorf("unexpected ACL entries: %v", entries)
+	}
+	if _, err = parsePosixACL(data[:10], ""); err == nil {
+		t.Errorf("parsing a truncated ACL must fail")
+	}
+	binary.LittleEndian.PutUint32(data, 1)
+	if _, err = parsePosixACL(data, ""); err == nil {
+		t.Errorf("parsing an ACL with an unknown version must fail")
+	}
+}
+
+func TestOsFsXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xattr")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "file.txt")
+	if err = ioutil.WriteFile(name, []byte("data"), 0640); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	fs := NewOsFs("", dir, nil).(*OsFs)
+	if err = fs.SetXattr(name, "user.origin", "test"); err != nil {
+		t.Skipf("user extended attributes are not supported: %v", err)
+	}
+	names, err := fs.ListXattrs(name)
+	if err != nil || len(names) != 1 || names[0] != "user.origin" {
+		t.Errorf("unexpected xattrs: %v, err: %v", names, err)
+	}
+	if value, err := fs.GetXattr(name, "user.origin"); err != nil || value != "test" {
+		t.Errorf("unexpected xattr value: %#v, err: %v", value, err)
+	}
+	if err = fs.RemoveXattr(name, "user.origin"); err != nil {
+		t.Errorf("unable to remove xattr: %v", err)
+	}
+	if _, err = fs.GetXattr(name, "user.origin"); err != ErrXattrNotFound {
+		t.Errorf("getting a removed xattr must fail with ErrXattrNotFound, err: %v", err)
+	}
+	acl, err := fs.GetACL(name)
+	if err != nil || strings.Join(acl, ",") != "user::rw-,group::r--,other::---" {
+		t.Errorf("unexpected ACL: %v, err: %v", acl, err)
+	}
+	if _, err = fs.ListXattrs(filepath.Join(dir, "missing")); !fs.IsNotExist(err) {
+		t.Errorf("unexpected error listing the xattrs of a missing file: %v", err)
+	}
+}
@@ -0,0 +1,11 @@
+// +build windows
+
+package vfs
+
+import (
+	"github.com/lulugyf/sshserv/sftp"
+)
+
+func statvfs(name string) (*sftp.StatVFS, error) {
+	return nil, ErrVfsUnsupported
+}
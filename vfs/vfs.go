@@ -0,0 +1,290 @@
+// Package vfs provides the filesystem backends used by the SFTP and SCP handlers.
+// Every backend implements the Fs interface, so permissions, quotas, throttling,
+// actions and logging are handled only once, in the protocol layer.
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lulugyf/sshserv/sftp"
+	"github.com/lulugyf/sshserv/utils"
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+const logSender = "vfs"
+
+// ErrVfsUnsupported defines the error for an unsupported VFS operation
+var ErrVfsUnsupported = errors.New("Not supported")
+
+// ErrXattrNotFound defines the error for an extended attribute that does not exist
+var ErrXattrNotFound = errors.New("Extended attribute not found")
+
+// Fs defines the interface for filesystem backends
+type Fs interface {
+	// Name returns the backend name, it is used for logging
+	Name() string
+	// ConnectionID returns the ID of the connection that owns this filesystem
+	ConnectionID() string
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	// Open opens the named file for reading
+	Open(name string) (File, error)
+	// Create opens the named file for writing, flag has the same meaning as in os.OpenFile.
+	// If flag is 0 the file is created or truncated
+	Create(name string, flag int) (File, error)
+	Rename(source, target string) error
+	Remove(name string) error
+	// RemoveAll removes name and any children it contains
+	RemoveAll(name string) error
+	Mkdir(name string) error
+	Symlink(source, target string) error
+	// Readlink returns the destination of the named symbolic link
+	Readlink(name string) (string, error)
+	// Link creates target as a hard link to the source file
+	Link(source, target string) error
+	Chown(name string, uid int, gid int) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	// OpenDir opens the named directory to read its entries in batches
+	OpenDir(dirname string) (DirLister, error)
+	// Walk walks the file tree rooted at root, calling walkFn for each file or directory
+	Walk(root string, walkFn filepath.WalkFunc) error
+	Statvfs(name string) (*sftp.StatVFS, error)
+	IsNotExist(err error) bool
+	IsPermission(err error) bool
+	// ResolvePath returns the backend path for the given SFTP path.
+	// An error is returned if the resolved path is outside the user's root
+	ResolvePath(sftpPath string) (string, error)
+	// GetRelativePath returns the SFTP path for the given backend path
+	GetRelativePath(name string) string
+	// Close releases any resource held by the filesystem
+	Close() error
+}
+
+// File defines the interface for files opened by a backend.
+// *os.File implements this interface
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string
+}
+
+// DirLister reads the entries of a directory in batches, the entries are not sorted.
+// *os.File implements this interface
+type DirLister interface {
+	// Readdir returns at most n entries and io.EOF at the end of the directory
+	Readdir(n int) ([]os.FileInfo, error)
+	Close() error
+}
+
+// Syncer is implemented by the files that can be flushed to stable storage
+type Syncer interface {
+	Sync() error
+}
+
+// Checksummer is implemented by backends that can compute a file checksum without reading the file.
+// The checksum is backend specific, so it is returned only to clients that request its algorithm
+type Checksummer interface {
+	// ChecksumAlgorithm returns the name of the checksum algorithm
+	ChecksumAlgorithm() string
+	// Checksum returns the checksum for the named file
+	Checksum(name string) ([]byte, error)
+}
+
+// DirUsager is implemented by backends that can report the usage of a directory tree without walking it
+type DirUsager interface {
+	// GetDirUsage returns the number of files inside the named directory, subdirectories included, and their size
+	GetDirUsage(dirname string) (int, int64, error)
+}
+
+// Xattrer is implemented by backends that support extended attributes and ACLs.
+// Attribute names include the namespace, for example user.origin
+type Xattrer interface {
+	// ListXattrs returns the names of the extended attributes of the named file
+	ListXattrs(name string) ([]string, error)
+	// GetXattr returns the value of the extended attribute attr, ErrXattrNotFound if it does not exist
+	GetXattr(name, attr string) (string, error)
+	// SetXattr creates or replaces the extended attribute attr
+	SetXattr(name, attr, value string) error
+	// RemoveXattr removes the extended attribute attr, ErrXattrNotFound if it does not exist
+	RemoveXattr(name, attr string) error
+	// GetACL returns the ACL entries of the named file in the getfacl format, for example user:alice:rw-
+	GetACL(name string) ([]string, error)
+}
+
+// IsLocalOsFs returns true if fs is the local filesystem implementation
+func IsLocalOsFs(fs Fs) bool {
+	return fs.Name() == osFsName
+}
+
+// IsUploadResumeSupported returns true if uploads to existing files can be resumed
+// opening them without the truncate flag, only HDFS files can be appended for now
+func IsUploadResumeSupported(fs Fs) bool {
+	return fs.Name() == hdfsFsName
+}
+
+// IsSnapshotPath returns true if the given path is inside an HDFS snapshot, snapshots are read only
+func IsSnapshotPath(fs Fs, name string) bool {
+	if fs == nil || fs.Name() != hdfsFsName {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == HdfsSnapshotDirName {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPathPermissions changes the ownership of the given path,
+// it only applies to the local filesystem
+func SetPathPermissions(fs Fs, path string, uid int, gid int) {
+	if IsLocalOsFs(fs) {
+		utils.SetPathPermissions(path, uid, gid)
+	}
+}
+
+// MkdirAll creates the named directory along with any missing parent, using the given backend.
+// It does nothing if the directory already exists
+func MkdirAll(fs Fs, name string) error {
+	info, err := fs.Stat(name)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	parent := path.Dir(name)
+	if IsLocalOsFs(fs) {
+		parent = filepath.Dir(name)
+	}
+	if parent != name {
+		if err = MkdirAll(fs, parent); err != nil {
+			return err
+		}
+	}
+	return fs.Mkdir(name)
+}
+
+// ScanDirContents returns the number of files contained in a directory, their size and a slice with the file paths
+func ScanDirContents(fs Fs, path string) (int, int64, []string, error) {
+	var numFiles int
+	var size int64
+	var fileList []string
+	stat, err := fs.Stat(path)
+	if err != nil || !stat.IsDir() {
+		return numFiles, size, fileList, err
+	}
+	err = fs.Walk(path, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info != nil && info.Mode().IsRegular() {
+			size += info.Size()
+			numFiles++
+			fileList = append(fileList, walkedPath)
+		}
+		return err
+	})
+	return numFiles, size, fileList, err
+}
+
+// GetDirUsage returns the number of files contained in a directory and their size. The backend
+// reports the usage itself if it implements DirUsager, otherwise the directory is walked
+func GetDirUsage(fs Fs, path string) (int, int64, error) {
+	if usager, ok := fs.(DirUsager); ok {
+		return usager.GetDirUsage(path)
+	}
+	numFiles, size, _, err := ScanDirContents(fs, path)
+	return numFiles, size, err
+}
+
+// getRelativePath returns the SFTP path for the given backend path, rootDir is mapped to "/".
+// An empty string is returned if the path is not inside rootDir
+func getRelativePath(name, rootDir string) string {
+	rel, err := filepath.Rel(rootDir, filepath.Clean(name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// getVirtualRelativePath returns the SFTP path for a backend path inside a virtual folder or the user's root
+func getVirtualRelativePath(name, rootDir string, folders []fsconfig.VirtualFolder) string {
+	for _, v := range folders {
+		if rel := getRelativePath(name, v.MappedPath); rel != "" {
+			return path.Join(v.VirtualPath, rel)
+		}
+	}
+	return getRelativePath(name, rootDir)
+}
+
+// virtualDirInfo describes a virtual dir that does not exist in the backend
+type virtualDirInfo struct {
+	name string
+}
+
+// NewVirtualDirInfo returns a FileInfo for a virtual dir that does not exist in the backend
+func NewVirtualDirInfo(name string) os.FileInfo {
+	return &virtualDirInfo{name: name}
+}
+
+func (fi *virtualDirInfo) Name() string {
+	return fi.name
+}
+
+func (fi *virtualDirInfo) Size() int64 {
+	return 0
+}
+
+func (fi *virtualDirInfo) Mode() os.FileMode {
+	return os.ModeDir | 0755
+}
+
+func (fi *virtualDirInfo) ModTime() time.Time {
+	return time.Now()
+}
+
+func (fi *virtualDirInfo) IsDir() bool {
+	return true
+}
+
+func (fi *virtualDirInfo) Sys() interface{} {
+	return &sftp.SftpFileAttr{
+		Nlink: 1,
+	}
+}
+
+// AddVirtualDirs adds the virtual dirs for the given SFTP path to a dir listing,
+// existing entries with the same name are replaced
+func AddVirtualDirs(folders []fsconfig.VirtualFolder, sftpPath string, files []os.FileInfo) []os.FileInfo {
+	virtualDirs := fsconfig.GetVirtualDirsForPath(folders, sftpPath)
+	if len(virtualDirs) == 0 {
+		return files
+	}
+	result := make([]os.FileInfo, 0, len(files)+len(virtualDirs))
+	for _, fi := range files {
+		if !utils.IsStringInSlice(fi.Name(), virtualDirs) {
+			result = append(result, fi)
+		}
+	}
+	for _, name := range virtualDirs {
+		result = append(result, NewVirtualDirInfo(name))
+	}
+	return result
+}
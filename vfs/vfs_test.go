@@ -0,0 +1,111 @@
+package vfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lulugyf/sshserv/vfs/fsconfig"
+)
+
+func TestOsFsResolvePath(t *testing.T) {
+	root, err := ioutil.TempDir("", "vfs_test")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	fs := NewOsFs("", root, nil)
+	p, err := fs.ResolvePath("/dir/../file.txt")
+	if err != nil {
+		t.Errorf("unexpected error resolving path: %v", err)
+	}
+	if p != filepath.Join(root, "file.txt") {
+		t.Errorf("unexpected resolved path: %v", p)
+	}
+	if fs.GetRelativePath(p) != "/file.txt" {
+		t.Errorf("unexpected relative path: %v", fs.GetRelativePath(p))
+	}
+	outside, err := ioutil.TempDir("", "vfs_test_outside")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(outside)
+	err = os.Symlink(outside, filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatalf("unable to create symlink: %v", err)
+	}
+	_, err = fs.ResolvePath("/link")
+	if err == nil {
+		t.Errorf("a symlink pointing outside the root must not resolve")
+	}
+	_, err = fs.ResolvePath("/link/missing/file.txt")
+	if err == nil {
+		t.Errorf("a missing path inside a symlink pointing outside the root must not resolve")
+	}
+	_, err = NewOsFs("", "home_rel_path", nil).ResolvePath("dir_rel_path")
+	if err == nil {
+		t.Errorf("tested path is not a home subdir")
+	}
+}
+
+func TestHdfsFsResolvePath(t *testing.T) {
+	fs := &HdfsFs{
+		rootDir: "/user/test",
+		client:  newFakeHdfsClient(),
+	}
+	for sftpPath, expected := range map[string]string{
+		"/":                 "/user/test",
+		"/file.txt":         "/user/test/file.txt",
+		"/../../etc/passwd": "/user/test/etc/passwd",
+		"dir/../../other":   "/user/test/other",
+	} {
+		p, err := fs.ResolvePath(sftpPath)
+		if err != nil {
+			t.Errorf("unexpected error resolving path %#v: %v", sftpPath, err)
+		}
+		if p != expected {
+			t.Errorf("path %#v resolved to %#v, expected: %#v", sftpPath, p, expected)
+		}
+	}
+	if fs.GetRelativePath("/user/test/dir/file.txt") != "/dir/file.txt" {
+		t.Errorf("unexpected relative path: %v", fs.GetRelativePath("/user/test/dir/file.txt"))
+	}
+	if fs.GetRelativePath("/user/test") != "/" {
+		t.Errorf("unexpected relative path for the root dir")
+	}
+	if fs.GetRelativePath("/user/test2/file.txt") != "" {
+		t.Errorf("a path outside the root must not have a relative path")
+	}
+}
+
+func TestVirtualFolders(t *testing.T) {
+	folders := []fsconfig.VirtualFolder{
+		{VirtualPath: "/shared/vdir", MappedPath: "/data/mapped"},
+		{VirtualPath: "/vdir2", MappedPath: "/data/mapped2"},
+	}
+	fs := &HdfsFs{
+		rootDir:        "/user/test",
+		virtualFolders: folders,
+		client:         newFakeHdfsClient(),
+	}
+	for sftpPath, expected := range map[string]string{
+		"/shared":                  "/user/test/shared",
+		"/shared/vdir":             "/data/mapped",
+		"/shared/vdir/../../file":  "/user/test/file",
+		"/shared/vdir/sub/file":    "/data/mapped/sub/file",
+		"/shared/vdirfile":         "/user/test/shared/vdirfile",
+		"/vdir2/../../../etc/file": "/user/test/etc/file",
+	} {
+		p, err := fs.ResolvePath(sftpPath)
+		if err != nil {
+			t.Errorf("unexpected error resolving path %#v: %v", sftpPath, err)
+		}
+		if p != expected {
+			t.Errorf("path %#v resolved to %#v, expected: %#v", sftpPath, p, expected)
+		}
+	}
+	if fs.GetRelativePath("/data/mapped/sub/file") != "/shared/vdir/sub/file" {
+		t.Errorf("unexpected relative path: %v", fs.GetRelativePath("/data/mapped/sub/file"))
+	}
+}
@@ -0,0 +1,658 @@
+// Package vfstest provides an in memory HDFS client, it allows to test the HDFS backend and the
+// SFTP handlers that use it without a cluster.
+// It does not depend on the vfs package, so the vfs tests can use it too: the methods returning
+// the vfs reader, writer and content summary interfaces return the concrete types instead and
+// the tests adapt them.
+package vfstest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lulugyf/sshserv/hdfs"
+)
+
+// snapshotDirName is the name of the read only dir that contains the snapshots of a dir
+const snapshotDirName = ".snapshot"
+
+// ErrUnsupported is returned for the operations the fake client does not implement
+var ErrUnsupported = errors.New("Not supported")
+
+// HdfsFileInfo describes a dir, a file or a symlink inside the in memory namespace
+type HdfsFileInfo struct {
+	name    string
+	isDir   bool
+	target  string
+	data    []byte
+	owner   string
+	group   string
+	modTime time.Time
+}
+
+// Name returns the base name
+func (f HdfsFileInfo) Name() string {
+	return f.name
+}
+
+// Size returns the length of the file contents
+func (f HdfsFileInfo) Size() int64 {
+	return int64(len(f.data))
+}
+
+// Mode returns the file mode bits
+func (f HdfsFileInfo) Mode() os.FileMode {
+	if f.isDir {
+		return os.ModeDir | 0755
+	}
+	if f.target != "" {
+		return os.ModeSymlink | 0777
+	}
+	return 0644
+}
+
+// ModTime returns the modification time
+func (f HdfsFileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+// IsDir returns true for dirs
+func (f HdfsFileInfo) IsDir() bool {
+	return f.isDir
+}
+
+// Sys returns nil
+func (f HdfsFileInfo) Sys() interface{} {
+	return nil
+}
+
+// SymlinkTarget returns the target of a symlink, an empty string for dirs and files
+func (f HdfsFileInfo) SymlinkTarget() string {
+	return f.target
+}
+
+// Owner returns the owner set using Chown
+func (f HdfsFileInfo) Owner() string {
+	return f.owner
+}
+
+// Group returns the group set using Chown
+func (f HdfsFileInfo) Group() string {
+	return f.group
+}
+
+// Data returns the file contents
+func (f HdfsFileInfo) Data() []byte {
+	return f.data
+}
+
+// HdfsFileReader reads a file or lists a dir
+type HdfsFileReader struct {
+	*bytes.Reader
+	file    HdfsFileInfo
+	entries []os.FileInfo
+}
+
+// Stat returns the info of the opened file
+func (r *HdfsFileReader) Stat() os.FileInfo {
+	return r.file
+}
+
+// Readdir returns the next n entries of the opened dir, all the remaining ones if n <= 0
+func (r *HdfsFileReader) Readdir(n int) ([]os.FileInfo, error) {
+	if n <= 0 || n > len(r.entries) {
+		n = len(r.entries)
+	}
+	if n == 0 {
+		return nil, io.EOF
+	}
+	files := r.entries[:n]
+	r.entries = r.entries[n:]
+	return files, nil
+}
+
+// Checksum is not supported
+func (r *HdfsFileReader) Checksum() ([]byte, error) {
+	return nil, ErrUnsupported
+}
+
+// Close does nothing
+func (r *HdfsFileReader) Close() error {
+	return nil
+}
+
+// HdfsFileWriter buffers the written data, the file contents are replaced on close
+type HdfsFileWriter struct {
+	bytes.Buffer
+	client *HdfsClient
+	name   string
+}
+
+// Flush does nothing
+func (w *HdfsFileWriter) Flush() error {
+	return nil
+}
+
+// Close stores the written data as the file contents
+func (w *HdfsFileWriter) Close() error {
+	w.client.Lock()
+	defer w.client.Unlock()
+	w.client.files[w.name] = HdfsFileInfo{name: path.Base(w.name), data: w.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+// HdfsContentSummary is the usage of a dir, the dir itself is counted as the namenode does
+type HdfsContentSummary struct {
+	files int
+	dirs  int
+	size  int64
+}
+
+// Size returns the size of the files inside the dir
+func (cs HdfsContentSummary) Size() int64 {
+	return cs.size
+}
+
+// FileCount returns the number of files inside the dir
+func (cs HdfsContentSummary) FileCount() int {
+	return cs.files
+}
+
+// DirectoryCount returns the number of dirs, including the dir itself
+func (cs HdfsContentSummary) DirectoryCount() int {
+	return cs.dirs
+}
+
+// remoteError mimics the errors returned by the namenode
+type remoteError struct {
+	method    string
+	exception string
+	message   string
+}
+
+func (e remoteError) Error() string {
+	return e.message
+}
+func (e remoteError) Method() string {
+	return e.method
+}
+func (e remoteError) Desc() string {
+	return "ERROR_APPLICATION"
+}
+func (e remoteError) Exception() string {
+	return e.exception
+}
+func (e remoteError) Message() string {
+	return e.message
+}
+
+// HdfsClient is an in memory HDFS namespace with dirs, files and symlinks
+type HdfsClient struct {
+	sync.Mutex
+	files map[string]HdfsFileInfo
+	// snapshottable dirs
+	snapshottable map[string]bool
+	// extended attributes by path
+	xattrs map[string]map[string]string
+	// StatErr, if set, is returned by Stat, it simulates an unreachable namenode
+	StatErr error
+	// Closed is the number of Close calls
+	Closed int
+	// Appended lists the files opened for append
+	Appended []string
+	// Summaries is the number of content summary requests
+	Summaries int
+	// Replication is returned as the default replication
+	Replication int
+	// Quotas has the name and space quotas by dir
+	Quotas map[string][2]int64
+	// Acls has the extended ACL entries by path
+	Acls map[string][]hdfs.AclEntry
+}
+
+// NewHdfsClient returns a client with the given dirs, and their parents, already created
+func NewHdfsClient(dirs ...string) *HdfsClient {
+	c := &HdfsClient{
+		files: map[string]HdfsFileInfo{"/": {name: "/", isDir: true}},
+	}
+	for _, dir := range dirs {
+		for p := dir; p != "/"; p = path.Dir(p) {
+			c.files[p] = HdfsFileInfo{name: path.Base(p), isDir: true, modTime: time.Now()}
+		}
+	}
+	return c
+}
+
+// AddDir adds a dir, the parent dirs are not checked
+func (c *HdfsClient) AddDir(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.files[name] = HdfsFileInfo{name: path.Base(name), isDir: true, modTime: time.Now()}
+}
+
+// AddFile adds a file with the given contents, the parent dirs are not checked
+func (c *HdfsClient) AddFile(name string, data []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.files[name] = HdfsFileInfo{name: path.Base(name), data: data, modTime: time.Now()}
+}
+
+// AddSymlink adds a symlink to target, the parent dirs are not checked
+func (c *HdfsClient) AddSymlink(name, target string) {
+	c.Lock()
+	defer c.Unlock()
+	c.files[name] = HdfsFileInfo{name: path.Base(name), target: target}
+}
+
+func (c *HdfsClient) children(dirname string) []os.FileInfo {
+	var files []os.FileInfo
+	for name, f := range c.files {
+		if name != "/" && path.Dir(name) == dirname {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files
+}
+
+func (c *HdfsClient) isInside(name, dirname string) bool {
+	return name == dirname || strings.HasPrefix(name, strings.TrimSuffix(dirname, "/")+"/")
+}
+
+func (c *HdfsClient) stat(name string) (HdfsFileInfo, error) {
+	for links := 0; links < 10; links++ {
+		f, ok := c.files[name]
+		if !ok {
+			return f, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+		if f.target == "" {
+			return f, nil
+		}
+		if path.IsAbs(f.target) {
+			name = f.target
+		} else {
+			name = path.Join(path.Dir(name), f.target)
+		}
+	}
+	return HdfsFileInfo{}, &os.PathError{Op: "stat", Path: name, Err: syscall.ELOOP}
+}
+
+func (c *HdfsClient) notSnapshottable(method, dir string) error {
+	return remoteError{
+		method:    method,
+		exception: "org.apache.hadoop.hdfs.protocol.SnapshotException",
+		message:   "Directory is not a snapshottable directory: " + dir,
+	}
+}
+
+// Stat returns the info of the given path, the symlinks are followed
+func (c *HdfsClient) Stat(name string) (os.FileInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.StatErr != nil {
+		return nil, c.StatErr
+	}
+	f, err := c.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lstat returns the info of the given path, the symlinks are not followed
+func (c *HdfsClient) Lstat(name string) (os.FileInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.files[name]; ok {
+		return f, nil
+	}
+	return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+// Open opens a file for reading or a dir for listing
+func (c *HdfsClient) Open(name string) (*HdfsFileReader, error) {
+	c.Lock()
+	defer c.Unlock()
+	f, err := c.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &HdfsFileReader{Reader: bytes.NewReader(f.data), file: f}
+	if f.isDir {
+		r.entries = c.children(name)
+	}
+	return r, nil
+}
+
+// Create creates a new file, it fails if the file exists or the parent dir does not exist
+func (c *HdfsClient) Create(name string) (*HdfsFileWriter, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[name]; ok {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+	if parent, ok := c.files[path.Dir(name)]; !ok || !parent.isDir {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrNotExist}
+	}
+	c.files[name] = HdfsFileInfo{name: path.Base(name), modTime: time.Now()}
+	return &HdfsFileWriter{client: c, name: name}, nil
+}
+
+// Append opens an existing file for appending
+func (c *HdfsClient) Append(name string) (*HdfsFileWriter, error) {
+	c.Lock()
+	defer c.Unlock()
+	f, ok := c.files[name]
+	if !ok || f.isDir {
+		return nil, &os.PathError{Op: "append", Path: name, Err: os.ErrNotExist}
+	}
+	c.Appended = append(c.Appended, name)
+	w := &HdfsFileWriter{client: c, name: name}
+	w.Write(f.data)
+	return w, nil
+}
+
+// Rename moves oldpath, and its contents if it is a dir, to newpath
+func (c *HdfsClient) Rename(oldpath, newpath string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[oldpath]; !ok {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+	if _, ok := c.files[newpath]; ok {
+		return &os.PathError{Op: "rename", Path: newpath, Err: os.ErrExist}
+	}
+	for name, f := range c.files {
+		if c.isInside(name, oldpath) {
+			delete(c.files, name)
+			newName := newpath + strings.TrimPrefix(name, oldpath)
+			f.name = path.Base(newName)
+			c.files[newName] = f
+		}
+	}
+	return nil
+}
+
+// CreateSymlink creates link pointing to target
+func (c *HdfsClient) CreateSymlink(target, link string, createParent bool) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[link]; ok {
+		return &os.PathError{Op: "symlink", Path: link, Err: os.ErrExist}
+	}
+	c.files[link] = HdfsFileInfo{name: path.Base(link), target: target}
+	return nil
+}
+
+// Remove removes a file or an empty dir
+func (c *HdfsClient) Remove(name string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if len(c.children(name)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(c.files, name)
+	return nil
+}
+
+// RemoveAll removes name and its contents
+func (c *HdfsClient) RemoveAll(name string) error {
+	c.Lock()
+	defer c.Unlock()
+	for p := range c.files {
+		if c.isInside(p, name) {
+			delete(c.files, p)
+		}
+	}
+	return nil
+}
+
+// Mkdir creates a dir, it fails if the path exists
+func (c *HdfsClient) Mkdir(dirname string, perm os.FileMode) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[dirname]; ok {
+		return &os.PathError{Op: "mkdir", Path: dirname, Err: os.ErrExist}
+	}
+	c.files[dirname] = HdfsFileInfo{name: path.Base(dirname), isDir: true, modTime: time.Now()}
+	return nil
+}
+
+// Chmod only checks that the path exists
+func (c *HdfsClient) Chmod(name string, perm os.FileMode) error {
+	_, err := c.Stat(name)
+	return err
+}
+
+// Chown sets the owner and the group of the given path
+func (c *HdfsClient) Chown(name string, user, group string) error {
+	c.Lock()
+	defer c.Unlock()
+	f, ok := c.files[name]
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: os.ErrNotExist}
+	}
+	f.owner = user
+	f.group = group
+	c.files[name] = f
+	return nil
+}
+
+// Chtimes only checks that the path exists
+func (c *HdfsClient) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_, err := c.Stat(name)
+	return err
+}
+
+// ReadDir returns the entries of the given dir sorted by name
+func (c *HdfsClient) ReadDir(dirname string) ([]os.FileInfo, error) {
+	c.Lock()
+	defer c.Unlock()
+	if path.Base(dirname) == snapshotDirName && !c.snapshottable[path.Dir(dirname)] {
+		return nil, c.notSnapshottable("getListing", path.Dir(dirname))
+	}
+	if _, ok := c.files[dirname]; !ok {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: os.ErrNotExist}
+	}
+	return c.children(dirname), nil
+}
+
+// Walk walks the tree rooted at root in lexical order, the symlinks are not followed
+func (c *HdfsClient) Walk(root string, walkFn filepath.WalkFunc) error {
+	c.Lock()
+	var names []string
+	for name := range c.files {
+		if c.isInside(name, root) {
+			names = append(names, name)
+		}
+	}
+	c.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		fi, err := c.Lstat(name)
+		if err = walkFn(name, fi, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatFs is not supported
+func (c *HdfsClient) StatFs() (hdfs.FsInfo, error) {
+	return hdfs.FsInfo{}, ErrUnsupported
+}
+
+// GetContentSummary returns the usage of the given dir
+func (c *HdfsClient) GetContentSummary(name string) (HdfsContentSummary, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.Summaries++
+	var cs HdfsContentSummary
+	if _, ok := c.files[name]; !ok {
+		return cs, &os.PathError{Op: "content summary", Path: name, Err: os.ErrNotExist}
+	}
+	for p, f := range c.files {
+		if !c.isInside(p, name) {
+			continue
+		}
+		if f.isDir {
+			cs.dirs++
+		} else {
+			cs.files++
+			cs.size += f.Size()
+		}
+	}
+	return cs, nil
+}
+
+// SetQuota stores the quotas for the given dir in Quotas
+func (c *HdfsClient) SetQuota(dir string, nameQuota, spaceQuota int64) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, err := c.stat(dir); err != nil {
+		return err
+	}
+	if c.Quotas == nil {
+		c.Quotas = make(map[string][2]int64)
+	}
+	c.Quotas[dir] = [2]int64{nameQuota, spaceQuota}
+	return nil
+}
+
+// DefaultReplication returns Replication
+func (c *HdfsClient) DefaultReplication() (int, error) {
+	return c.Replication, nil
+}
+
+// AllowSnapshots makes the given dir snapshottable
+func (c *HdfsClient) AllowSnapshots(dir string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.files[dir]; !ok {
+		return &os.PathError{Op: "allow snapshots", Path: dir, Err: os.ErrNotExist}
+	}
+	if c.snapshottable == nil {
+		c.snapshottable = make(map[string]bool)
+	}
+	c.snapshottable[dir] = true
+	snapshotDir := path.Join(dir, snapshotDirName)
+	c.files[snapshotDir] = HdfsFileInfo{name: snapshotDirName, isDir: true, modTime: time.Now()}
+	return nil
+}
+
+// CreateSnapshot creates a snapshot of a snapshottable dir and returns its path
+func (c *HdfsClient) CreateSnapshot(dir, name string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.snapshottable[dir] {
+		return "", c.notSnapshottable("createSnapshot", dir)
+	}
+	snapshotPath := path.Join(dir, snapshotDirName, name)
+	if _, ok := c.files[snapshotPath]; ok {
+		return "", &os.PathError{Op: "create snapshot", Path: snapshotPath, Err: os.ErrExist}
+	}
+	c.files[snapshotPath] = HdfsFileInfo{name: name, isDir: true, modTime: time.Now()}
+	return snapshotPath, nil
+}
+
+// DeleteSnapshot deletes the snapshot of the given dir with the given name
+func (c *HdfsClient) DeleteSnapshot(dir, name string) error {
+	c.Lock()
+	defer c.Unlock()
+	snapshotPath := path.Join(dir, snapshotDirName, name)
+	if _, ok := c.files[snapshotPath]; !ok {
+		return &os.PathError{Op: "delete snapshot", Path: snapshotPath, Err: os.ErrNotExist}
+	}
+	delete(c.files, snapshotPath)
+	return nil
+}
+
+// ListXAttrs returns the extended attributes of the given path
+func (c *HdfsClient) ListXAttrs(name string) (map[string]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, err := c.stat(name); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for k, v := range c.xattrs[name] {
+		xattrs[k] = v
+	}
+	return xattrs, nil
+}
+
+// GetXAttrs returns the requested extended attributes, it fails if any of them is missing
+func (c *HdfsClient) GetXAttrs(name string, keys ...string) (map[string]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, err := c.stat(name); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for _, k := range keys {
+		v, ok := c.xattrs[name][k]
+		if !ok {
+			return nil, &os.PathError{Op: "get xattrs", Path: name, Err: hdfs.ErrXAttrNotFound}
+		}
+		xattrs[k] = v
+	}
+	return xattrs, nil
+}
+
+// SetXAttr sets an extended attribute
+func (c *HdfsClient) SetXAttr(name, key, value string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, err := c.stat(name); err != nil {
+		return err
+	}
+	if c.xattrs == nil {
+		c.xattrs = make(map[string]map[string]string)
+	}
+	if c.xattrs[name] == nil {
+		c.xattrs[name] = make(map[string]string)
+	}
+	c.xattrs[name][key] = value
+	return nil
+}
+
+// RemoveXAttr removes an extended attribute
+func (c *HdfsClient) RemoveXAttr(name, key string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.xattrs[name][key]; !ok {
+		return &os.PathError{Op: "remove xattr", Path: name, Err: hdfs.ErrXAttrNotFound}
+	}
+	delete(c.xattrs[name], key)
+	return nil
+}
+
+// GetAclStatus returns the permissions and the extended ACL entries of the given path
+func (c *HdfsClient) GetAclStatus(name string) (*hdfs.AclStatus, error) {
+	c.Lock()
+	defer c.Unlock()
+	f, err := c.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &hdfs.AclStatus{Permission: f.Mode().Perm(), Entries: c.Acls[name]}, nil
+}
+
+// Close counts the calls in Closed
+func (c *HdfsClient) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.Closed++
+	return nil
+}